@@ -0,0 +1,208 @@
+// Package backup exports the RSA and ECDSA key shares of a node into an encrypted bundle, and restores them into the
+// config of a node, checking each key share against its key meta before accepting it.
+package backup
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// Bundle is the content of a backup. It has the committed key shares of the exported keys, without their pending
+// refreshes or ECDSA presignatures, and never the CURVE keys of the node. Key shares keep their lifecycle state, so a restored key share
+// pending deletion is still deleted when its retention period ends, and a disabled one stays disabled.
+type Bundle struct {
+	Node    string                   // CURVE public key of the node the key shares were exported from.
+	Created string                   // Time the bundle was created, in RFC 3339 format.
+	RSA     []*config.RSAKeyConfig   // Exported RSA key shares.
+	ECDSA   []*config.ECDSAKeyConfig // Exported ECDSA key shares.
+}
+
+// NewBundle returns a bundle with the RSA and ECDSA key shares of the node with the IDs provided, or all of them if
+// there are no IDs. It returns an error if a key ID is not found.
+func NewBundle(conf *config.Config, ids []string) (*Bundle, error) {
+	if conf.Client == nil {
+		return nil, fmt.Errorf("missing client in config")
+	}
+	bundle := &Bundle{
+		Node:    conf.PublicKey,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		RSA:     make([]*config.RSAKeyConfig, 0),
+		ECDSA:   make([]*config.ECDSAKeyConfig, 0),
+	}
+	selected := make(map[string]bool)
+	for _, id := range ids {
+		selected[id] = false
+	}
+	for _, key := range conf.Client.RSA.Keys {
+		if _, ok := selected[key.ID]; ok || len(ids) == 0 {
+			selected[key.ID] = true
+			bundle.RSA = append(bundle.RSA, &config.RSAKeyConfig{
+				ID:               key.ID,
+				KeyShare:         key.KeyShare,
+				KeyMetaInfo:      key.KeyMetaInfo,
+				Verify:           key.Verify,
+				VerifySampleRate: key.VerifySampleRate,
+				Epoch:            key.Epoch,
+				State:            key.State,
+				DeleteAfter:      key.DeleteAfter,
+				Usage:            key.Usage,
+				RequiresApproval: key.RequiresApproval,
+			})
+		}
+	}
+	for _, key := range conf.Client.ECDSA.Keys {
+		if _, ok := selected[key.ID]; ok || len(ids) == 0 {
+			selected[key.ID] = true
+			bundle.ECDSA = append(bundle.ECDSA, &config.ECDSAKeyConfig{
+				ID:               key.ID,
+				KeyShare:         key.KeyShare,
+				KeyMetaInfo:      key.KeyMetaInfo,
+				Epoch:            key.Epoch,
+				State:            key.State,
+				DeleteAfter:      key.DeleteAfter,
+				Usage:            key.Usage,
+				RequiresApproval: key.RequiresApproval,
+			})
+		}
+	}
+	for _, id := range ids {
+		if !selected[id] {
+			return nil, fmt.Errorf("RSA or ECDSA key %s not found", id)
+		}
+	}
+	return bundle, nil
+}
+
+// Check returns an error if any key share of the bundle cannot be decoded, or does not match the verification key of
+// its index in its key meta.
+func (bundle *Bundle) Check() error {
+	for _, key := range bundle.RSA {
+		if err := checkRSAKey(key); err != nil {
+			return fmt.Errorf("invalid RSA key %s: %s", key.ID, err)
+		}
+	}
+	for _, key := range bundle.ECDSA {
+		if err := checkECDSAKey(key); err != nil {
+			return fmt.Errorf("invalid ECDSA key %s: %s", key.ID, err)
+		}
+	}
+	return nil
+}
+
+// Restore checks the key shares of the bundle and adds them to the config of a node. If the node already has a key
+// with the same ID, it returns an error, unless replace is true, in which case the old key share is archived.
+func Restore(conf *config.Config, bundle *Bundle, replace bool) error {
+	if conf.Client == nil {
+		return fmt.Errorf("missing client in config")
+	}
+	if err := bundle.Check(); err != nil {
+		return err
+	}
+	archivedAt := time.Now().UTC().Format(time.RFC3339)
+	rsaConf := &conf.Client.RSA
+	for _, key := range bundle.RSA {
+		for i, old := range rsaConf.Keys {
+			if old.ID != key.ID {
+				continue
+			}
+			if !replace {
+				return fmt.Errorf("RSA key %s already exists", key.ID)
+			}
+			old.ArchivedAt = archivedAt
+			rsaConf.ArchivedKeys = append(rsaConf.ArchivedKeys, old)
+			rsaConf.Keys = append(rsaConf.Keys[:i], rsaConf.Keys[i+1:]...)
+			break
+		}
+		rsaConf.Keys = append(rsaConf.Keys, key)
+	}
+	ecdsaConf := &conf.Client.ECDSA
+	for _, key := range bundle.ECDSA {
+		for i, old := range ecdsaConf.Keys {
+			if old.ID != key.ID {
+				continue
+			}
+			if !replace {
+				return fmt.Errorf("ECDSA key %s already exists", key.ID)
+			}
+			old.ArchivedAt = archivedAt
+			ecdsaConf.ArchivedKeys = append(ecdsaConf.ArchivedKeys, old)
+			ecdsaConf.Keys = append(ecdsaConf.Keys[:i], ecdsaConf.Keys[i+1:]...)
+			break
+		}
+		ecdsaConf.Keys = append(ecdsaConf.Keys, key)
+	}
+	return nil
+}
+
+// checkRSAKey returns an error if an RSA key share does not match the verification key of its ID.
+func checkRSAKey(key *config.RSAKeyConfig) error {
+	shareBytes, err := base64.StdEncoding.DecodeString(key.KeyShare)
+	if err != nil {
+		return err
+	}
+	share, err := message.DecodeRSAKeyShare(shareBytes)
+	if err != nil {
+		return err
+	}
+	metaBytes, err := base64.StdEncoding.DecodeString(key.KeyMetaInfo)
+	if err != nil {
+		return err
+	}
+	meta, err := message.DecodeRSAKeyMeta(metaBytes)
+	if err != nil {
+		return err
+	}
+	if meta.PublicKey == nil || meta.VerificationKey == nil || len(meta.VerificationKey.I) != int(meta.L) {
+		return fmt.Errorf("incomplete key meta")
+	}
+	if share.Id < 1 || share.Id > meta.L {
+		return fmt.Errorf("invalid key share ID %d", share.Id)
+	}
+	n := meta.PublicKey.N
+	v := new(big.Int).SetBytes(meta.VerificationKey.V)
+	si := new(big.Int).SetBytes(share.Si)
+	vki := new(big.Int).SetBytes(meta.VerificationKey.I[share.Id-1])
+	if new(big.Int).Exp(v, si, n).Cmp(vki) != 0 {
+		return fmt.Errorf("key share does not match its verification key")
+	}
+	return nil
+}
+
+// checkECDSAKey returns an error if the threshold Paillier share of an ECDSA key share does not match the verification
+// key of its index.
+func checkECDSAKey(key *config.ECDSAKeyConfig) error {
+	shareBytes, err := base64.StdEncoding.DecodeString(key.KeyShare)
+	if err != nil {
+		return err
+	}
+	share, err := message.DecodeECDSAKeyShare(shareBytes)
+	if err != nil {
+		return err
+	}
+	metaBytes, err := base64.StdEncoding.DecodeString(key.KeyMetaInfo)
+	if err != nil {
+		return err
+	}
+	meta, err := message.DecodeECDSAKeyMeta(metaBytes)
+	if err != nil {
+		return err
+	}
+	if meta.PubKey == nil || meta.Paillier == nil || meta.Paillier.Delta == nil || len(meta.Paillier.Vi) != int(meta.Paillier.L) {
+		return fmt.Errorf("incomplete key meta")
+	}
+	paillier := meta.Paillier
+	if share.PaillierShare == nil || share.PaillierShare.Si == nil ||
+		share.PaillierShare.Index < 1 || share.PaillierShare.Index > paillier.L {
+		return fmt.Errorf("invalid key share")
+	}
+	exp := new(big.Int).Mul(paillier.Delta, share.PaillierShare.Si)
+	if new(big.Int).Exp(paillier.V, exp, paillier.Cache().NToSPlusOne).Cmp(paillier.Vi[share.PaillierShare.Index-1]) != 0 {
+		return fmt.Errorf("key share does not match its verification key")
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package backup
+
+import (
+	"encoding/base64"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/tcecdsa"
+	"github.com/niclabs/tcecdsa/l2fhe"
+	"github.com/niclabs/tcpaillier"
+	"github.com/niclabs/tcrsa"
+)
+
+var (
+	testKeysOnce sync.Once
+	testRSA      []*config.RSAKeyConfig
+	testECDSA    []*config.ECDSAKeyConfig
+	testKeysErr  error
+)
+
+// testKeys returns the configs of the three shares of a small 2-of-3 RSA key, and of the three shares of an ECDSA key
+// holding only a small threshold Paillier key, which is the only part of the key checked. Creating a whole tcecdsa key
+// takes minutes.
+func testKeys(t *testing.T) ([]*config.RSAKeyConfig, []*config.ECDSAKeyConfig) {
+	testKeysOnce.Do(func() {
+		testRSA, testECDSA, testKeysErr = newTestKeys()
+	})
+	if testKeysErr != nil {
+		t.Fatal(testKeysErr)
+	}
+	return testRSA, testECDSA
+}
+
+func newTestKeys() ([]*config.RSAKeyConfig, []*config.ECDSAKeyConfig, error) {
+	rsaShares, rsaMeta, err := tcrsa.NewKey(512, 2, 3, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	rsaKeys := make([]*config.RSAKeyConfig, len(rsaShares))
+	for i, share := range rsaShares {
+		if rsaKeys[i], err = rsaKeyConfig("rsa", share, rsaMeta); err != nil {
+			return nil, nil, err
+		}
+	}
+	paillierShares, paillier, err := tcpaillier.NewKey(256, 1, 3, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	ecdsaMeta := &tcecdsa.KeyMeta{
+		PubKey:      &l2fhe.PubKey{Paillier: paillier, MaxMessageModule: big.NewInt(7919)},
+		ZKProofMeta: &tcecdsa.ZKProofMeta{NTilde: big.NewInt(7), H1: big.NewInt(2), H2: big.NewInt(3)},
+		CurveName:   "P-256",
+	}
+	ecdsaKeys := make([]*config.ECDSAKeyConfig, len(paillierShares))
+	for i, share := range paillierShares {
+		keyShare := &tcecdsa.KeyShare{Index: uint8(i), PaillierShare: share}
+		if ecdsaKeys[i], err = ecdsaKeyConfig("ecdsa", keyShare, ecdsaMeta); err != nil {
+			return nil, nil, err
+		}
+	}
+	return rsaKeys, ecdsaKeys, nil
+}
+
+func rsaKeyConfig(id string, share *tcrsa.KeyShare, meta *tcrsa.KeyMeta) (*config.RSAKeyConfig, error) {
+	shareBytes, err := message.EncodeRSAKeyShare(share)
+	if err != nil {
+		return nil, err
+	}
+	metaBytes, err := message.EncodeRSAKeyMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	return &config.RSAKeyConfig{
+		ID:          id,
+		KeyShare:    base64.StdEncoding.EncodeToString(shareBytes),
+		KeyMetaInfo: base64.StdEncoding.EncodeToString(metaBytes),
+	}, nil
+}
+
+func ecdsaKeyConfig(id string, share *tcecdsa.KeyShare, meta *tcecdsa.KeyMeta) (*config.ECDSAKeyConfig, error) {
+	shareBytes, err := message.EncodeECDSAKeyShare(share)
+	if err != nil {
+		return nil, err
+	}
+	metaBytes, err := message.EncodeECDSAKeyMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	return &config.ECDSAKeyConfig{
+		ID:          id,
+		KeyShare:    base64.StdEncoding.EncodeToString(shareBytes),
+		KeyMetaInfo: base64.StdEncoding.EncodeToString(metaBytes),
+	}, nil
+}
+
+// testConfig returns the config of a node with the first share of the test keys, with the IDs provided.
+func testConfig(t *testing.T, rsaIDs, ecdsaIDs []string) *config.Config {
+	rsaKeys, ecdsaKeys := testKeys(t)
+	conf := &config.Config{PublicKey: "node", Client: &config.ClientConfig{}}
+	for _, id := range rsaIDs {
+		key := *rsaKeys[0]
+		key.ID = id
+		conf.Client.RSA.Keys = append(conf.Client.RSA.Keys, &key)
+	}
+	for _, id := range ecdsaIDs {
+		key := *ecdsaKeys[0]
+		key.ID = id
+		conf.Client.ECDSA.Keys = append(conf.Client.ECDSA.Keys, &key)
+	}
+	return conf
+}
+
+func TestCheckRSAKey(t *testing.T) {
+	rsaKeys, _ := testKeys(t)
+	share, err := decodeRSAShare(rsaKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := decodeRSAMeta(rsaKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// with returns the config of the first key share with the share and meta changed by the function provided.
+	with := func(f func(share *tcrsa.KeyShare, meta *tcrsa.KeyMeta)) *config.RSAKeyConfig {
+		s := &tcrsa.KeyShare{Si: append([]byte(nil), share.Si...), Id: share.Id}
+		m := *meta
+		vk := *meta.VerificationKey
+		vk.I = append([][]byte(nil), vk.I...)
+		m.VerificationKey = &vk
+		f(s, &m)
+		key, err := rsaKeyConfig("rsa", s, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	tests := []struct {
+		name string
+		key  *config.RSAKeyConfig
+		ok   bool
+	}{
+		{"share 1", rsaKeys[0], true},
+		{"share 2", rsaKeys[1], true},
+		{"share 3", rsaKeys[2], true},
+		{"wrong ID", with(func(s *tcrsa.KeyShare, m *tcrsa.KeyMeta) { s.Id = 2 }), false},
+		{"zero ID", with(func(s *tcrsa.KeyShare, m *tcrsa.KeyMeta) { s.Id = 0 }), false},
+		{"ID over L", with(func(s *tcrsa.KeyShare, m *tcrsa.KeyMeta) { s.Id = 4 }), false},
+		{"corrupted share", with(func(s *tcrsa.KeyShare, m *tcrsa.KeyMeta) { s.Si[0] ^= 1 }), false},
+		{"corrupted verification key", with(func(s *tcrsa.KeyShare, m *tcrsa.KeyMeta) {
+			m.VerificationKey.I[0] = m.VerificationKey.I[1]
+		}), false},
+		{"missing verification key", with(func(s *tcrsa.KeyShare, m *tcrsa.KeyMeta) {
+			m.VerificationKey.I = m.VerificationKey.I[:2]
+		}), false},
+		{"missing public key", with(func(s *tcrsa.KeyShare, m *tcrsa.KeyMeta) { m.PublicKey = nil }), false},
+		{"share not in base64", &config.RSAKeyConfig{KeyShare: "%", KeyMetaInfo: rsaKeys[0].KeyMetaInfo}, false},
+		{"meta not in base64", &config.RSAKeyConfig{KeyShare: rsaKeys[0].KeyShare, KeyMetaInfo: "%"}, false},
+		{"share not encoded", &config.RSAKeyConfig{KeyShare: "AAAA", KeyMetaInfo: rsaKeys[0].KeyMetaInfo}, false},
+		{"meta not encoded", &config.RSAKeyConfig{KeyShare: rsaKeys[0].KeyShare, KeyMetaInfo: "AAAA"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkRSAKey(test.key)
+			if test.ok && err != nil {
+				t.Errorf("valid key share rejected: %s", err)
+			}
+			if !test.ok && err == nil {
+				t.Errorf("invalid key share accepted")
+			}
+		})
+	}
+}
+
+func TestCheckECDSAKey(t *testing.T) {
+	_, ecdsaKeys := testKeys(t)
+	share, err := decodeECDSAShare(ecdsaKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := decodeECDSAMeta(ecdsaKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// with returns the config of the first key share with the share and meta changed by the function provided.
+	with := func(f func(share *tcpaillier.KeyShare, paillier *tcpaillier.PubKey)) *config.ECDSAKeyConfig {
+		paillier := *meta.Paillier
+		paillier.Vi = append([]*big.Int(nil), paillier.Vi...)
+		paillierShare := *share.PaillierShare
+		paillierShare.Si = new(big.Int).Set(paillierShare.Si)
+		f(&paillierShare, &paillier)
+		m := *meta
+		m.PubKey = &l2fhe.PubKey{Paillier: &paillier, MaxMessageModule: meta.MaxMessageModule}
+		s := *share
+		s.PaillierShare = &paillierShare
+		key, err := ecdsaKeyConfig("ecdsa", &s, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	tests := []struct {
+		name string
+		key  *config.ECDSAKeyConfig
+		ok   bool
+	}{
+		{"share 1", ecdsaKeys[0], true},
+		{"share 2", ecdsaKeys[1], true},
+		{"share 3", ecdsaKeys[2], true},
+		{"wrong index", with(func(s *tcpaillier.KeyShare, p *tcpaillier.PubKey) { s.Index = 2 }), false},
+		{"zero index", with(func(s *tcpaillier.KeyShare, p *tcpaillier.PubKey) { s.Index = 0 }), false},
+		{"index over L", with(func(s *tcpaillier.KeyShare, p *tcpaillier.PubKey) { s.Index = 4 }), false},
+		{"corrupted share", with(func(s *tcpaillier.KeyShare, p *tcpaillier.PubKey) { s.Si.Add(s.Si, big.NewInt(1)) }), false},
+		{"corrupted verification key", with(func(s *tcpaillier.KeyShare, p *tcpaillier.PubKey) { p.Vi[0] = p.Vi[1] }), false},
+		{"missing verification key", with(func(s *tcpaillier.KeyShare, p *tcpaillier.PubKey) { p.Vi = p.Vi[:2] }), false},
+		{"missing delta", with(func(s *tcpaillier.KeyShare, p *tcpaillier.PubKey) { p.Delta = nil }), false},
+		{"share not in base64", &config.ECDSAKeyConfig{KeyShare: "%", KeyMetaInfo: ecdsaKeys[0].KeyMetaInfo}, false},
+		{"meta not in base64", &config.ECDSAKeyConfig{KeyShare: ecdsaKeys[0].KeyShare, KeyMetaInfo: "%"}, false},
+		{"share not encoded", &config.ECDSAKeyConfig{KeyShare: "AAAA", KeyMetaInfo: ecdsaKeys[0].KeyMetaInfo}, false},
+		{"meta not encoded", &config.ECDSAKeyConfig{KeyShare: ecdsaKeys[0].KeyShare, KeyMetaInfo: "AAAA"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkECDSAKey(test.key)
+			if test.ok && err != nil {
+				t.Errorf("valid key share rejected: %s", err)
+			}
+			if !test.ok && err == nil {
+				t.Errorf("invalid key share accepted")
+			}
+		})
+	}
+}
+
+func TestNewBundle(t *testing.T) {
+	conf := testConfig(t, []string{"r1", "r2"}, []string{"e1"})
+	conf.Client.RSA.Keys[0].PendingKeyShare = "pending"
+	tests := []struct {
+		name       string
+		ids        []string
+		rsa, ecdsa []string
+		ok         bool
+	}{
+		{"every key", nil, []string{"r1", "r2"}, []string{"e1"}, true},
+		{"selected keys", []string{"r2", "e1"}, []string{"r2"}, []string{"e1"}, true},
+		{"one RSA key", []string{"r1"}, []string{"r1"}, nil, true},
+		{"missing key", []string{"r1", "x"}, nil, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle, err := NewBundle(conf, test.ids)
+			if !test.ok {
+				if err == nil {
+					t.Errorf("bundle created with a missing key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bundle.Node != conf.PublicKey {
+				t.Errorf("bundle from node %s instead of %s", bundle.Node, conf.PublicKey)
+			}
+			if len(bundle.RSA) != len(test.rsa) || len(bundle.ECDSA) != len(test.ecdsa) {
+				t.Fatalf("bundle has %d RSA and %d ECDSA keys, but it should have %d and %d",
+					len(bundle.RSA), len(bundle.ECDSA), len(test.rsa), len(test.ecdsa))
+			}
+			for i, id := range test.rsa {
+				if bundle.RSA[i].ID != id {
+					t.Errorf("bundle has RSA key %s instead of %s", bundle.RSA[i].ID, id)
+				}
+				if bundle.RSA[i].PendingKeyShare != "" {
+					t.Errorf("bundle has the pending refresh of RSA key %s", id)
+				}
+			}
+			for i, id := range test.ecdsa {
+				if bundle.ECDSA[i].ID != id {
+					t.Errorf("bundle has ECDSA key %s instead of %s", bundle.ECDSA[i].ID, id)
+				}
+			}
+			if err := bundle.Check(); err != nil {
+				t.Errorf("bundle does not pass its check: %s", err)
+			}
+		})
+	}
+	if _, err := NewBundle(&config.Config{}, nil); err == nil {
+		t.Errorf("bundle created from a config without a client")
+	}
+}
+
+func TestBundleKeepsLifecycle(t *testing.T) {
+	conf := testConfig(t, []string{"r1"}, []string{"e1"})
+	deleteAfter := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	conf.Client.RSA.Keys[0].State = string(message.KeyDisabled)
+	conf.Client.ECDSA.Keys[0].State = string(message.KeyPendingDeletion)
+	conf.Client.ECDSA.Keys[0].DeleteAfter = deleteAfter
+	bundle, err := NewBundle(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := testConfig(t, nil, nil)
+	if err := Restore(restored, bundle, false); err != nil {
+		t.Fatal(err)
+	}
+	if key := restored.Client.RSA.Keys[0]; key.State != string(message.KeyDisabled) {
+		t.Errorf("disabled RSA key restored with state %q", key.State)
+	}
+	if key := restored.Client.ECDSA.Keys[0]; key.State != string(message.KeyPendingDeletion) || key.DeleteAfter != deleteAfter {
+		t.Errorf("ECDSA key pending deletion restored with state %q and deletion time %q", key.State, key.DeleteAfter)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	rsaKeys, _ := testKeys(t)
+	bundle, err := NewBundle(testConfig(t, []string{"r1"}, []string{"e1"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := &Bundle{RSA: []*config.RSAKeyConfig{{ID: "r1", KeyShare: rsaKeys[1].KeyShare, KeyMetaInfo: "AAAA"}}}
+	tests := []struct {
+		name               string
+		conf               *config.Config
+		bundle             *Bundle
+		replace            bool
+		ok                 bool
+		archived           int
+		rsaKeys, ecdsaKeys int
+	}{
+		{"empty node", testConfig(t, nil, nil), bundle, false, true, 0, 1, 1},
+		{"other keys", testConfig(t, []string{"r2"}, []string{"e2"}), bundle, false, true, 0, 2, 2},
+		{"existing key", testConfig(t, []string{"r1"}, nil), bundle, false, false, 0, 0, 0},
+		{"replaced key", testConfig(t, []string{"r1", "r2"}, []string{"e1"}), bundle, true, true, 2, 2, 1},
+		{"corrupted bundle", testConfig(t, nil, nil), corrupted, false, false, 0, 0, 0},
+		{"missing client", &config.Config{}, bundle, false, false, 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Restore(test.conf, test.bundle, test.replace)
+			if !test.ok {
+				if err == nil {
+					t.Errorf("invalid restore accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsaConf, ecdsaConf := test.conf.Client.RSA, test.conf.Client.ECDSA
+			if len(rsaConf.Keys) != test.rsaKeys || len(ecdsaConf.Keys) != test.ecdsaKeys {
+				t.Errorf("node has %d RSA and %d ECDSA keys, but it should have %d and %d",
+					len(rsaConf.Keys), len(ecdsaConf.Keys), test.rsaKeys, test.ecdsaKeys)
+			}
+			if archived := len(rsaConf.ArchivedKeys) + len(ecdsaConf.ArchivedKeys); archived != test.archived {
+				t.Errorf("node archived %d keys instead of %d", archived, test.archived)
+			}
+			for _, key := range rsaConf.ArchivedKeys {
+				if key.ArchivedAt == "" {
+					t.Errorf("archived RSA key %s has no archive time", key.ID)
+				}
+			}
+		})
+	}
+}
+
+func decodeRSAShare(key *config.RSAKeyConfig) (*tcrsa.KeyShare, error) {
+	b, err := base64.StdEncoding.DecodeString(key.KeyShare)
+	if err != nil {
+		return nil, err
+	}
+	return message.DecodeRSAKeyShare(b)
+}
+
+func decodeRSAMeta(key *config.RSAKeyConfig) (*tcrsa.KeyMeta, error) {
+	b, err := base64.StdEncoding.DecodeString(key.KeyMetaInfo)
+	if err != nil {
+		return nil, err
+	}
+	return message.DecodeRSAKeyMeta(b)
+}
+
+func decodeECDSAShare(key *config.ECDSAKeyConfig) (*tcecdsa.KeyShare, error) {
+	b, err := base64.StdEncoding.DecodeString(key.KeyShare)
+	if err != nil {
+		return nil, err
+	}
+	return message.DecodeECDSAKeyShare(b)
+}
+
+func decodeECDSAMeta(key *config.ECDSAKeyConfig) (*tcecdsa.KeyMeta, error) {
+	b, err := base64.StdEncoding.DecodeString(key.KeyMetaInfo)
+	if err != nil {
+		return nil, err
+	}
+	return message.DecodeECDSAKeyMeta(b)
+}
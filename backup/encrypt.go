@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/niclabs/dtcnode/v3/seal"
+	"github.com/pebbe/zmq4"
+)
+
+// Format identifies the files created by Encrypt.
+const Format = "dtcnode-key-backup"
+
+// Version is the version of the format of the files created by Encrypt.
+const Version = 1
+
+// Iterations is the number of PBKDF2 iterations used to derive the key of a bundle protected by a passphrase.
+const Iterations = 600000
+
+// Protection modes of a bundle.
+const (
+	passphraseMode = "passphrase" // AES-GCM with a key derived from a passphrase with PBKDF2-SHA256.
+	curveMode      = "curve"      // Sealed for the node with a CURVE public key.
+)
+
+// envelope is the file created by Encrypt. Every field except the ciphertext is authenticated as additional data, so
+// the parameters cannot be changed without failing the decryption.
+type envelope struct {
+	Format     string
+	Version    int
+	Mode       string
+	Salt       []byte `json:",omitempty"`
+	Iterations int    `json:",omitempty"`
+	Recipient  string `json:",omitempty"`
+	Ciphertext []byte `json:",omitempty"`
+}
+
+// Protection says how a bundle is encrypted. Exactly one of its fields must be set.
+type Protection struct {
+	Passphrase []byte // Passphrase the bundle key is derived from.
+	Recipient  string // CURVE public key of the node the bundle is encrypted for, in Z85 form.
+}
+
+// Encrypt encodes and encrypts a bundle with the protection provided.
+func Encrypt(bundle *Bundle, protection *Protection) ([]byte, error) {
+	plaintext, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	env := &envelope{
+		Format:  Format,
+		Version: Version,
+	}
+	switch {
+	case len(protection.Passphrase) > 0 && protection.Recipient == "":
+		env.Mode = passphraseMode
+		env.Salt = make([]byte, 16)
+		if _, err := rand.Read(env.Salt); err != nil {
+			return nil, err
+		}
+		env.Iterations = Iterations
+		aead, err := passphraseAEAD(protection.Passphrase, env.Salt, env.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		ad, err := env.additionalData()
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		env.Ciphertext = aead.Seal(nonce, nonce, plaintext, ad)
+	case len(protection.Passphrase) == 0 && protection.Recipient != "":
+		env.Mode = curveMode
+		env.Recipient = protection.Recipient
+		publicKey, err := decodeCurveKey(protection.Recipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient: %s", err)
+		}
+		ad, err := env.additionalData()
+		if err != nil {
+			return nil, err
+		}
+		env.Ciphertext, err = seal.Seal(publicKey, plaintext, ad)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("a backup needs either a passphrase or a recipient")
+	}
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// Decrypt decrypts and decodes a bundle encrypted by Encrypt. Bundles protected by a passphrase need the passphrase,
+// and bundles encrypted for a node need the CURVE private key of the node, in Z85 form.
+func Decrypt(data, passphrase []byte, privateKey string) (*Bundle, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("cannot decode backup: %s", err)
+	}
+	if env.Format != Format || env.Version != Version {
+		return nil, fmt.Errorf("unsupported backup format %q version %d", env.Format, env.Version)
+	}
+	ciphertext := env.Ciphertext
+	ad, err := env.additionalData()
+	if err != nil {
+		return nil, err
+	}
+	var plaintext []byte
+	switch env.Mode {
+	case passphraseMode:
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("the backup is protected by a passphrase")
+		}
+		aead, err := passphraseAEAD(passphrase, env.Salt, env.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		if len(ciphertext) < aead.NonceSize() {
+			return nil, fmt.Errorf("ciphertext too short")
+		}
+		plaintext, err = aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], ad)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt backup: wrong passphrase or modified file")
+		}
+	case curveMode:
+		key, err := decodeCurveKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node private key: %s", err)
+		}
+		plaintext, err = seal.Open(key, ciphertext, ad)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt backup: it is encrypted for node %s, or it was modified", env.Recipient)
+		}
+	default:
+		return nil, fmt.Errorf("unknown backup protection mode %q", env.Mode)
+	}
+	var bundle Bundle
+	if err := json.Unmarshal(plaintext, &bundle); err != nil {
+		return nil, fmt.Errorf("cannot decode backup content: %s", err)
+	}
+	return &bundle, nil
+}
+
+// additionalData returns the fields of the envelope that are authenticated with its ciphertext.
+func (env *envelope) additionalData() ([]byte, error) {
+	header := *env
+	header.Ciphertext = nil
+	return json.Marshal(&header)
+}
+
+// passphraseAEAD derives the AES-256-GCM cipher of a bundle from its passphrase.
+func passphraseAEAD(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	if len(salt) == 0 || iterations < 1 {
+		return nil, fmt.Errorf("invalid key derivation parameters")
+	}
+	block, err := aes.NewCipher(pbkdf2SHA256(passphrase, salt, iterations))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a 32 byte key from a passphrase with PBKDF2 (RFC 8018) using HMAC-SHA256. A single block is
+// enough, because the key has the same length as the hash.
+func pbkdf2SHA256(passphrase, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	block := make([]byte, 4)
+	binary.BigEndian.PutUint32(block, 1)
+	prf.Write(salt)
+	prf.Write(block)
+	u := prf.Sum(nil)
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// decodeCurveKey returns the binary form of a CURVE key in Z85 form.
+func decodeCurveKey(key string) ([]byte, error) {
+	if len(key) != 40 {
+		return nil, fmt.Errorf("a CURVE key must have 40 Z85 characters")
+	}
+	return []byte(zmq4.Z85decode(key)), nil
+}
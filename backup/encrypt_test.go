@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	tests := []struct {
+		passphrase, salt string
+		iterations       int
+		key              string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+	for _, test := range tests {
+		key := hex.EncodeToString(pbkdf2SHA256([]byte(test.passphrase), []byte(test.salt), test.iterations))
+		if key != test.key {
+			t.Errorf("PBKDF2(%q, %q, %d) = %s, but it should be %s", test.passphrase, test.salt, test.iterations, key, test.key)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	bundle, err := NewBundle(testConfig(t, []string{"r1"}, []string{"e1"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := []byte("correct horse battery staple")
+	data, err := Encrypt(bundle, &Protection{Passphrase: passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(bundle.RSA[0].KeyShare)) {
+		t.Fatalf("the backup has the key share in plaintext")
+	}
+	// change returns a copy of the backup with its envelope changed by the function provided.
+	change := func(f func(env *envelope)) []byte {
+		var env envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatal(err)
+		}
+		env.Ciphertext = append([]byte(nil), env.Ciphertext...)
+		f(&env)
+		changed, err := json.Marshal(&env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changed
+	}
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase []byte
+		ok         bool
+	}{
+		{"right passphrase", data, passphrase, true},
+		{"wrong passphrase", data, []byte("wrong horse battery staple"), false},
+		{"missing passphrase", data, nil, false},
+		{"modified ciphertext", change(func(env *envelope) { env.Ciphertext[len(env.Ciphertext)-1] ^= 1 }), passphrase, false},
+		{"modified nonce", change(func(env *envelope) { env.Ciphertext[0] ^= 1 }), passphrase, false},
+		{"truncated ciphertext", change(func(env *envelope) { env.Ciphertext = env.Ciphertext[:4] }), passphrase, false},
+		{"modified salt", change(func(env *envelope) { env.Salt[0] ^= 1 }), passphrase, false},
+		{"fewer iterations", change(func(env *envelope) { env.Iterations = 1 }), passphrase, false},
+		{"no iterations", change(func(env *envelope) { env.Iterations = 0 }), passphrase, false},
+		{"added recipient", change(func(env *envelope) { env.Recipient = "node" }), passphrase, false},
+		{"unknown version", change(func(env *envelope) { env.Version = Version + 1 }), passphrase, false},
+		{"unknown format", change(func(env *envelope) { env.Format = "other" }), passphrase, false},
+		{"unknown mode", change(func(env *envelope) { env.Mode = "other" }), passphrase, false},
+		{"not a backup", []byte("RSA:\n  Keys: []\n"), passphrase, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decrypted, err := Decrypt(test.data, test.passphrase, "")
+			if !test.ok {
+				if err == nil {
+					t.Errorf("invalid backup decrypted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decrypted.Node != bundle.Node || decrypted.Created != bundle.Created ||
+				len(decrypted.RSA) != 1 || len(decrypted.ECDSA) != 1 ||
+				decrypted.RSA[0].KeyShare != bundle.RSA[0].KeyShare || decrypted.ECDSA[0].KeyShare != bundle.ECDSA[0].KeyShare {
+				t.Errorf("decrypted bundle does not match the encrypted one")
+			}
+			if err := decrypted.Check(); err != nil {
+				t.Errorf("decrypted bundle does not pass its check: %s", err)
+			}
+		})
+	}
+}
+
+func TestEncryptProtection(t *testing.T) {
+	bundle, err := NewBundle(testConfig(t, []string{"r1"}, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		protection *Protection
+	}{
+		{"no protection", &Protection{}},
+		{"passphrase and recipient", &Protection{Passphrase: []byte("passphrase"), Recipient: "node"}},
+		{"invalid recipient", &Protection{Recipient: "node"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Encrypt(bundle, test.protection); err == nil {
+				t.Errorf("bundle encrypted with an invalid protection")
+			}
+		})
+	}
+}
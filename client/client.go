@@ -0,0 +1,398 @@
+// Package client implements the client side of the dtcnode protocol.
+// It connects to one or more nodes using ZMQ CURVE authentication, sends them key shares and drives the RSA, ECDSA and
+// EdDSA threshold signing processes, combining the partial results of the nodes into standard signatures.
+package client
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/pebbe/zmq4"
+)
+
+// The domain of the ZMQ connection. This value must be the same in the nodes, or it will not work.
+const TchsmDomain = "tchsm"
+
+// The protocol used for the ZMQ connection.
+const TchsmProtocol = "tcp"
+
+// DefaultTimeout is the time the client waits for a node response if no timeout is configured.
+const DefaultTimeout = 10 * time.Second
+
+// DefaultPollInterval is the time between polls for a request held for approval if no interval is configured.
+const DefaultPollInterval = 2 * time.Second
+
+// Config represents the configuration of a client.
+type Config struct {
+	PublicKey  string        // Client public key, used in ZMQ CURVE Auth.
+	PrivateKey string        // Client private key, used in ZMQ CURVE Auth.
+	Nodes      []*NodeConfig // List of nodes. The order of the nodes defines the key share each one receives.
+	Timeout    time.Duration // Time the client waits for a node response.
+	Retries    int           // Number of times a message is sent again to a node that did not answer in time.
+	// Time the client polls a node that holds a request until an operator approves it. Zero returns the
+	// message.ApprovalPendingError at once.
+	ApprovalTimeout time.Duration
+	PollInterval    time.Duration // Time between polls for a request held for approval.
+}
+
+// NodeConfig represents the configuration of a node the client connects to.
+type NodeConfig struct {
+	PublicKey string // Node public key
+	Host      string // Node hostname or IP
+	Port      uint16 // Node port
+}
+
+// Client represents a connection with a set of nodes.
+type Client struct {
+	ID       string        // Client ID, used as sender of the messages.
+	privKey  string        // The private key of the client, used in ZMQ CURVE Auth.
+	pubKey   string        // The public key of the client, used in ZMQ CURVE Auth.
+	timeout  time.Duration // Time the client waits for a node response.
+	retries  int           // Number of times a message is sent again to a node that did not answer in time.
+	approval time.Duration // Time the client polls a node that holds a request until an operator approves it.
+	poll     time.Duration // Time between polls for a request held for approval.
+	context  *zmq4.Context // The context used by zmq connections.
+	nodes    []*Node       // The nodes the client is connected to.
+	mutex    sync.Mutex    // A mutex used to run only one operation at a time.
+}
+
+// Node represents the connection of the client with a node.
+type Node struct {
+	index   int          // Index of the node in the client config. It is also the index of its key share.
+	pubKey  string       // Public key of the node, used in ZMQ CURVE Auth.
+	host    string       // Hostname or IP of the node.
+	port    uint16       // Port of the node.
+	client  *Client      // A pointer to the client this connection belongs to.
+	socket  *zmq4.Socket // The REQ socket connected to the node.
+	lastErr error        // The last error produced by the node.
+	mutex   sync.Mutex   // A mutex used to send only one message at a time through the socket.
+}
+
+// result represents the response of a node to a message, or the error produced while asking for it.
+type result struct {
+	node *Node
+	msg  *message.Message
+	err  error
+}
+
+// New connects a client to the nodes defined in the configuration. ZMQ authentication must be started
+// (using zmq4.AuthStart) before calling this function.
+func New(conf *Config) (*Client, error) {
+	if conf.PublicKey == "" || conf.PrivateKey == "" || len(conf.Nodes) == 0 {
+		return nil, fmt.Errorf("missing fields in client config")
+	}
+	context, err := zmq4.NewContext()
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		ID:       conf.PublicKey,
+		pubKey:   conf.PublicKey,
+		privKey:  conf.PrivateKey,
+		timeout:  conf.Timeout,
+		retries:  conf.Retries,
+		approval: conf.ApprovalTimeout,
+		poll:     conf.PollInterval,
+		context:  context,
+	}
+	if client.timeout == 0 {
+		client.timeout = DefaultTimeout
+	}
+	if client.poll == 0 {
+		client.poll = DefaultPollInterval
+	}
+	for i, nodeConf := range conf.Nodes {
+		node := &Node{
+			index:  i,
+			pubKey: nodeConf.PublicKey,
+			host:   nodeConf.Host,
+			port:   nodeConf.Port,
+			client: client,
+		}
+		if err := node.connect(); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("cannot connect to node %s: %s", node.GetConnString(), err)
+		}
+		client.nodes = append(client.nodes, node)
+	}
+	return client, nil
+}
+
+// Close closes the connections with the nodes.
+func (client *Client) Close() {
+	for _, node := range client.nodes {
+		node.mutex.Lock()
+		node.close()
+		node.mutex.Unlock()
+	}
+	client.nodes = nil
+	_ = client.context.Term()
+}
+
+// Nodes returns the list of nodes the client is connected to.
+func (client *Client) Nodes() []*Node {
+	return client.nodes
+}
+
+// Algorithms returns the algorithms every node allows the client to create keys with. Algorithms with curves are
+// listed once per curve, as name:curve.
+func (client *Client) Algorithms() ([]string, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	results := client.askAll(client.nodes, message.GetAlgorithms, func(node *Node) ([][]byte, error) {
+		return [][]byte{}, nil
+	})
+	responses, err := collect(results, len(client.nodes), len(client.nodes))
+	if err != nil {
+		return nil, err
+	}
+	count := make(map[string]int)
+	for _, res := range responses {
+		list, err := message.DecodeAlgorithmList(res.msg.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode algorithm list from node %s: %s", res.node.GetConnString(), err)
+		}
+		for _, name := range list {
+			count[name]++
+		}
+	}
+	algorithms := make([]string, 0)
+	for name, n := range count {
+		if n == len(responses) {
+			algorithms = append(algorithms, name)
+		}
+	}
+	sort.Strings(algorithms)
+	return algorithms, nil
+}
+
+// Health returns the result of the last self-test of the key shares of each node, in the order of the nodes in the
+// client config. If selfTest is true, the nodes run the self-test again before answering.
+func (client *Client) Health(selfTest bool) ([]*message.Health, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	rType := message.GetHealth
+	if selfTest {
+		rType = message.RunSelfTest
+	}
+	results := client.askAll(client.nodes, rType, func(node *Node) ([][]byte, error) {
+		return [][]byte{}, nil
+	})
+	responses, err := collect(results, len(client.nodes), len(client.nodes))
+	if err != nil {
+		return nil, err
+	}
+	sortByIndex(responses)
+	health := make([]*message.Health, len(responses))
+	for i, res := range responses {
+		health[i], err = message.DecodeHealth(res.msg.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode health from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	return health, nil
+}
+
+// SetKeyState moves the key shares of a key in every node to a lifecycle state. The retention period is only used with
+// message.KeyPendingDeletion, and is the time the nodes keep the key shares before deleting them.
+func (client *Client) SetKeyState(algorithm, keyID string, state message.KeyState, retention time.Duration) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	results := client.askAll(client.nodes, message.SetKeyState, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(algorithm), []byte(keyID), []byte(state), message.EncodeRetention(retention)}, nil
+	})
+	_, err := collect(results, len(client.nodes), len(client.nodes))
+	return err
+}
+
+// deleteKeyShares asks all the nodes to delete the key share with the ID provided with a message of the type provided,
+// and returns the report of the wipe of each node, in the order of the nodes in the client config.
+func (client *Client) deleteKeyShares(rType message.Type, keyID string) ([]*message.Wipe, error) {
+	results := client.askAll(client.nodes, rType, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID)}, nil
+	})
+	responses, err := collect(results, len(client.nodes), len(client.nodes))
+	if err != nil {
+		return nil, err
+	}
+	sortByIndex(responses)
+	wipes := make([]*message.Wipe, len(responses))
+	for i, res := range responses {
+		wipes[i], err = message.DecodeWipe(res.msg.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode wipe report from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	return wipes, nil
+}
+
+// askAll sends a message of the type provided to the nodes in parallel and returns a channel where their results are
+// sent. The data of each message is returned by the data function, called with the node as argument.
+func (client *Client) askAll(nodes []*Node, rType message.Type, data func(node *Node) ([][]byte, error)) <-chan *result {
+	results := make(chan *result, len(nodes))
+	for _, node := range nodes {
+		go func(node *Node) {
+			res := &result{node: node}
+			nodeData, err := data(node)
+			if err != nil {
+				res.err = err
+			} else {
+				res.msg, res.err = node.ask(rType, nodeData...)
+			}
+			results <- res
+		}(node)
+	}
+	return results
+}
+
+// collect waits for the results of n nodes and returns the successful ones. It stops waiting when it has needed
+// successful results, and returns an error if that number cannot be reached.
+func collect(results <-chan *result, n, needed int) ([]*result, error) {
+	ok := make([]*result, 0)
+	errs := make([]error, 0)
+	for i := 0; i < n && len(ok) < needed; i++ {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		ok = append(ok, res)
+	}
+	if len(ok) < needed {
+		return nil, fmt.Errorf("quorum not reached: got %d responses, needed %d: %v", len(ok), needed, errs)
+	}
+	return ok, nil
+}
+
+// GetIndex returns the index of the node in the client config.
+func (node *Node) GetIndex() int {
+	return node.index
+}
+
+// GetConnString returns the string used to connect to the node.
+func (node *Node) GetConnString() string {
+	return fmt.Sprintf("%s://%s:%d", TchsmProtocol, node.host, node.port)
+}
+
+// LastError returns the error produced by the last message sent to the node, or nil if it was successful.
+func (node *Node) LastError() error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.lastErr
+}
+
+func (node *Node) connect() error {
+	s, err := node.client.context.NewSocket(zmq4.REQ)
+	if err != nil {
+		return err
+	}
+	node.socket = s
+	if err := s.SetIpv6(true); err != nil {
+		return err
+	}
+	if err := s.ClientAuthCurve(node.pubKey, node.client.pubKey, node.client.privKey); err != nil {
+		return err
+	}
+	if err := s.SetRcvtimeo(node.client.timeout); err != nil {
+		return err
+	}
+	if err := s.SetSndtimeo(node.client.timeout); err != nil {
+		return err
+	}
+	if err := s.SetLinger(0); err != nil {
+		return err
+	}
+	return s.Connect(node.GetConnString())
+}
+
+func (node *Node) close() {
+	if node.socket != nil {
+		_ = node.socket.Close()
+		node.socket = nil
+	}
+}
+
+// reconnect replaces the socket of the node. It is used when a response never came, because a REQ socket cannot send a
+// new message until it receives the response of the last one.
+func (node *Node) reconnect() error {
+	node.close()
+	return node.connect()
+}
+
+// ask sends a message to the node and waits for its response. If the node holds the message until an operator
+// approves it, ask sends it again to poll for the result until the approval timeout of the client. It returns an error
+// if the node does not answer in time or if the response does not match the message sent.
+func (node *Node) ask(rType message.Type, data ...[]byte) (resp *message.Message, err error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	defer func() {
+		node.lastErr = err
+	}()
+	msg, err := message.NewMessage(rType, node.client.ID, data...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = node.exchange(msg)
+	deadline := time.Now().Add(node.client.approval)
+	for err == nil && resp.Error == message.ApprovalPendingError && time.Now().Before(deadline) {
+		log.Printf("%s message %s is waiting for the approval of an operator of node %s", rType, msg.ID, node.GetConnString())
+		time.Sleep(node.client.poll)
+		resp, err = node.exchange(msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.ResponseOK(msg); err != nil {
+		return nil, fmt.Errorf("bad %s response from node %s: %s", rType, node.GetConnString(), err)
+	}
+	return resp, nil
+}
+
+// exchange sends a message to the node, retrying it if the response does not come in time, and returns the parsed
+// response.
+func (node *Node) exchange(msg *message.Message) (*message.Message, error) {
+	var rawResp [][]byte
+	var err error
+	for try := 0; try <= node.client.retries; try++ {
+		if try > 0 {
+			log.Printf("retrying %s message %s to node %s (%d/%d)", msg.Type, msg.ID, node.GetConnString(), try, node.client.retries)
+		}
+		rawResp, err = node.send(msg)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp, err := message.FromBytes(rawResp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s response from node %s: %s", msg.Type, node.GetConnString(), err)
+	}
+	return resp, nil
+}
+
+// send sends a message to the node and returns the raw response. If the response does not come in time, the socket is
+// replaced, so the same message can be sent again. Nodes answer a retried message with the same response they sent
+// the first time, without executing it again.
+func (node *Node) send(msg *message.Message) ([][]byte, error) {
+	if node.socket == nil {
+		if err := node.connect(); err != nil {
+			return nil, fmt.Errorf("cannot connect to node %s: %s", node.GetConnString(), err)
+		}
+	}
+	if _, err := node.socket.SendMessage(msg.GetBytesLists()...); err != nil {
+		_ = node.reconnect()
+		return nil, fmt.Errorf("cannot send %s message to node %s: %s", msg.Type, node.GetConnString(), err)
+	}
+	rawResp, err := node.socket.RecvMessageBytes(0)
+	if err != nil {
+		_ = node.reconnect()
+		return nil, fmt.Errorf("cannot receive %s response from node %s: %s", msg.Type, node.GetConnString(), err)
+	}
+	return rawResp, nil
+}
@@ -0,0 +1,348 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/refresh"
+	"github.com/niclabs/tcecdsa"
+)
+
+// SendECDSAKeyShares sends a key share to each node, using the order of the nodes in the client config, and initializes
+// the key with the key init messages of all of them. It returns the public key of the distributed key, or an error if
+// any of the nodes fails, for example if it already has a key with the same ID.
+func (client *Client) SendECDSAKeyShares(keyID string, keyShares []*tcecdsa.KeyShare, keyMeta *tcecdsa.KeyMeta) (*ecdsa.PublicKey, error) {
+	return client.sendECDSAKeyShares(message.SendECDSAKeyShare, keyID, keyShares, keyMeta)
+}
+
+// ReplaceECDSAKeyShares works like SendECDSAKeyShares, but replacing the key share the nodes have with the same ID.
+// The nodes archive the replaced key shares.
+func (client *Client) ReplaceECDSAKeyShares(keyID string, keyShares []*tcecdsa.KeyShare, keyMeta *tcecdsa.KeyMeta) (*ecdsa.PublicKey, error) {
+	return client.sendECDSAKeyShares(message.ReplaceECDSAKeyShare, keyID, keyShares, keyMeta)
+}
+
+func (client *Client) sendECDSAKeyShares(rType message.Type, keyID string, keyShares []*tcecdsa.KeyShare, keyMeta *tcecdsa.KeyMeta) (*ecdsa.PublicKey, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(keyShares) != len(client.nodes) {
+		return nil, fmt.Errorf("number of key shares (%d) is not equal to the number of nodes (%d)", len(keyShares), len(client.nodes))
+	}
+	encodedMeta, err := message.EncodeECDSAKeyMeta(keyMeta)
+	if err != nil {
+		return nil, err
+	}
+	results := client.askAll(client.nodes, rType, func(node *Node) ([][]byte, error) {
+		encodedShare, err := message.EncodeECDSAKeyShare(keyShares[node.index])
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{[]byte(keyID), encodedShare, encodedMeta}, nil
+	})
+	responses, err := collect(results, len(client.nodes), len(client.nodes))
+	if err != nil {
+		return nil, err
+	}
+	sortByIndex(responses)
+	keyInitMessages := make(tcecdsa.KeyInitMessageList, 0)
+	for _, res := range responses {
+		keyInitMessage, err := message.DecodeECDSAKeyInitMessage(res.msg.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode key init message from node %s: %s", res.node.GetConnString(), err)
+		}
+		keyInitMessages = append(keyInitMessages, keyInitMessage)
+	}
+	pk, err := keyMeta.GetPublicKey(keyInitMessages)
+	if err != nil {
+		return nil, err
+	}
+	encodedKeyInit, err := message.EncodeECDSAKeyInitMessageList(keyInitMessages)
+	if err != nil {
+		return nil, err
+	}
+	results = client.askAll(client.nodes, message.ECDSAInitKeys, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), encodedKeyInit}, nil
+	})
+	if _, err := collect(results, len(client.nodes), len(client.nodes)); err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
+
+// SignECDSA runs the ECDSA threshold signing protocol over a document hash. The first K nodes that answer the first
+// round are the ones that take part in the following rounds, so all of them must answer until the end.
+// It returns the r and s values of the signature, or an error if the quorum is not reached before the timeout.
+func (client *Client) SignECDSA(keyID string, hash []byte, keyMeta *tcecdsa.KeyMeta) (r, s *big.Int, err error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	k := int(keyMeta.Paillier.K)
+	results := client.askAll(client.nodes, message.ECDSARound1, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), hash}, nil
+	})
+	responses, err := collect(results, len(client.nodes), k)
+	if err != nil {
+		return nil, nil, fmt.Errorf("round 1: %s", err)
+	}
+	signers := make([]*Node, len(responses))
+	round1Messages := make(tcecdsa.Round1MessageList, len(responses))
+	for i, res := range responses {
+		signers[i] = res.node
+		round1Messages[i], err = message.DecodeECDSARound1Message(res.msg.Data[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot decode round 1 message from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	encodedRound1, err := message.EncodeECDSARound1MessageList(round1Messages)
+	if err != nil {
+		return nil, nil, err
+	}
+	results = client.askAll(signers, message.ECDSARound2, func(node *Node) ([][]byte, error) {
+		return [][]byte{encodedRound1}, nil
+	})
+	responses, err = collect(results, len(signers), len(signers))
+	if err != nil {
+		return nil, nil, fmt.Errorf("round 2: %s", err)
+	}
+	round2Messages := make(tcecdsa.Round2MessageList, len(responses))
+	for i, res := range responses {
+		round2Messages[i], err = message.DecodeECDSARound2Message(res.msg.Data[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot decode round 2 message from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	encodedRound2, err := message.EncodeECDSARound2MessageList(round2Messages)
+	if err != nil {
+		return nil, nil, err
+	}
+	results = client.askAll(signers, message.ECDSARound3, func(node *Node) ([][]byte, error) {
+		return [][]byte{encodedRound2}, nil
+	})
+	responses, err = collect(results, len(signers), len(signers))
+	if err != nil {
+		return nil, nil, fmt.Errorf("round 3: %s", err)
+	}
+	round3Messages := make(tcecdsa.Round3MessageList, len(responses))
+	for i, res := range responses {
+		round3Messages[i], err = message.DecodeECDSARound3Message(res.msg.Data[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot decode round 3 message from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	encodedRound3, err := message.EncodeECDSARound3MessageList(round3Messages)
+	if err != nil {
+		return nil, nil, err
+	}
+	results = client.askAll(signers, message.ECDSAGetSignature, func(node *Node) ([][]byte, error) {
+		return [][]byte{encodedRound3}, nil
+	})
+	responses, err = collect(results, len(signers), 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get signature: %s", err)
+	}
+	return message.DecodeECDSASignature(responses[0].msg.Data[0])
+}
+
+// ECDSAPresignature represents a presignature created by a set of nodes, which they keep until it is used to sign a
+// hash with SignECDSAPresigned. Each presignature can only be used once.
+type ECDSAPresignature struct {
+	ID    string   // Presignature ID.
+	R     *big.Int // r value of the signature created with the presignature.
+	Nodes []int    // Indexes of the nodes that keep the presignature.
+}
+
+// PresignECDSA runs the rounds of the ECDSA threshold signing protocol that do not depend on the document with the
+// first K nodes that answer. Each of them adds the presignature to the pool of the key, so a later signature only
+// needs one round. It returns an error if the quorum is not reached before the timeout.
+func (client *Client) PresignECDSA(keyID string, keyMeta *tcecdsa.KeyMeta) (*ECDSAPresignature, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	id, err := message.GetRandomHexString(16)
+	if err != nil {
+		return nil, err
+	}
+	k := int(keyMeta.Paillier.K)
+	results := client.askAll(client.nodes, message.ECDSAPresignRound1, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), []byte(id)}, nil
+	})
+	responses, err := collect(results, len(client.nodes), k)
+	if err != nil {
+		return nil, fmt.Errorf("presign round 1: %s", err)
+	}
+	presignature := &ECDSAPresignature{ID: id, Nodes: make([]int, len(responses))}
+	signers := make([]*Node, len(responses))
+	round1Messages := make(tcecdsa.Round1MessageList, len(responses))
+	for i, res := range responses {
+		signers[i] = res.node
+		presignature.Nodes[i] = res.node.index
+		round1Messages[i], err = message.DecodeECDSARound1Message(res.msg.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode round 1 message from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	R, _, _, _, err := round1Messages.Join(keyMeta)
+	if err != nil {
+		return nil, fmt.Errorf("presign round 1: %s", err)
+	}
+	presignature.R = R.X
+	encodedRound1, err := message.EncodeECDSARound1MessageList(round1Messages)
+	if err != nil {
+		return nil, err
+	}
+	results = client.askAll(signers, message.ECDSAPresignRound2, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), []byte(id), encodedRound1}, nil
+	})
+	responses, err = collect(results, len(signers), len(signers))
+	if err != nil {
+		return nil, fmt.Errorf("presign round 2: %s", err)
+	}
+	round2Messages := make(tcecdsa.Round2MessageList, len(responses))
+	for i, res := range responses {
+		round2Messages[i], err = message.DecodeECDSARound2Message(res.msg.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode round 2 message from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	encodedRound2, err := message.EncodeECDSARound2MessageList(round2Messages)
+	if err != nil {
+		return nil, err
+	}
+	results = client.askAll(signers, message.ECDSAPresignRound3, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), []byte(id), encodedRound2}, nil
+	})
+	if _, err := collect(results, len(signers), len(signers)); err != nil {
+		return nil, fmt.Errorf("presign round 3: %s", err)
+	}
+	return presignature, nil
+}
+
+// SignECDSAPresigned signs a document hash with a presignature created by PresignECDSA, in a single round with the
+// nodes that keep it. The nodes drop the presignature before answering, so it cannot be used again even if the
+// signature fails. It returns the r and s values of the signature.
+func (client *Client) SignECDSAPresigned(keyID string, presignature *ECDSAPresignature, hash []byte, keyMeta *tcecdsa.KeyMeta) (r, s *big.Int, err error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	signers := make([]*Node, 0, len(presignature.Nodes))
+	for _, index := range presignature.Nodes {
+		if index < 0 || index >= len(client.nodes) {
+			return nil, nil, fmt.Errorf("presignature node %d not found", index)
+		}
+		signers = append(signers, client.nodes[index])
+	}
+	results := client.askAll(signers, message.ECDSASignPresigned, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), []byte(presignature.ID), hash}, nil
+	})
+	responses, err := collect(results, len(signers), len(signers))
+	if err != nil {
+		return nil, nil, fmt.Errorf("sign with presignature: %s", err)
+	}
+	// Every node computes the same encrypted s value from public values, so a node sending a different one is lying.
+	encodedSigma := responses[0].msg.Data[1]
+	round3Messages := make(tcecdsa.Round3MessageList, len(responses))
+	for i, res := range responses {
+		if !bytes.Equal(res.msg.Data[1], encodedSigma) {
+			return nil, nil, fmt.Errorf("node %s does not agree on the encrypted signature", res.node.GetConnString())
+		}
+		round3Messages[i], err = message.DecodeECDSARound3Message(res.msg.Data[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot decode round 3 message from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	sigma, err := message.DecodeECDSASigma(encodedSigma)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err = round3Messages.Join(keyMeta, sigma)
+	if err != nil {
+		return nil, nil, err
+	}
+	return presignature.R, s, nil
+}
+
+// DeleteECDSAKeyShares asks all the nodes to delete the key share with the ID provided, and returns the report of the
+// wipe of each node, in the order of the nodes in the client config.
+// It returns an error if any of the nodes fails to delete it.
+func (client *Client) DeleteECDSAKeyShares(keyID string) ([]*message.Wipe, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.deleteKeyShares(message.DeleteECDSAKeyShare, keyID)
+}
+
+// RefreshECDSAKeyShares works like RefreshRSAKeyShares, but with ECDSA keys. Only the threshold Paillier shares that
+// protect the secret key are refreshed, so the key does not need to be initialized again.
+func (client *Client) RefreshECDSAKeyShares(keyID string, epoch uint64, keyMeta *tcecdsa.KeyMeta) (*tcecdsa.KeyMeta, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	deltas, newMeta, err := refresh.NewECDSARefresh(keyMeta)
+	if err != nil {
+		return nil, err
+	}
+	encodedMeta, err := message.EncodeECDSAKeyMeta(newMeta)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.refreshKeyShares(ecdsaRefreshTypes, keyID, epoch, deltas, encodedMeta); err != nil {
+		if _, ok := err.(*commitError); ok {
+			return newMeta, err
+		}
+		return nil, err
+	}
+	return newMeta, nil
+}
+
+// CommitECDSAKeyShareRefresh asks the nodes again to commit the refresh of a key share to the epoch provided.
+func (client *Client) CommitECDSAKeyShareRefresh(keyID string, epoch uint64) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.commitRefresh(ecdsaRefreshTypes, keyID, epoch)
+}
+
+// ReshareECDSAKeyShares works like ReshareRSAKeyShares, but with ECDSA keys. Only the threshold Paillier shares that
+// protect the secret key are reshared, so the new nodes do not need to initialize the key, and the key can be reshared
+// to any number of key shares.
+func (client *Client) ReshareECDSAKeyShares(keyID string, epoch uint64, keyMeta *tcecdsa.KeyMeta, dealers []int, k int, recipients *Client) (*tcecdsa.KeyMeta, error) {
+	unlock := client.lockClients(recipients)
+	defer unlock()
+	responses, dealings, err := client.dealKeyShares(message.DealECDSAKeyShare, keyID, dealers, k, recipients)
+	if err != nil {
+		return nil, err
+	}
+	newMeta, err := refresh.NewECDSAResharedMeta(keyMeta, dealings, k, len(recipients.nodes))
+	if err != nil {
+		return nil, err
+	}
+	encodedMeta, err := message.EncodeECDSAKeyMeta(keyMeta)
+	if err != nil {
+		return nil, err
+	}
+	encodedNewMeta, err := message.EncodeECDSAKeyMeta(newMeta)
+	if err != nil {
+		return nil, err
+	}
+	encodedDealings, err := message.EncodeDealingList(dealings)
+	if err != nil {
+		return nil, err
+	}
+	// The public share has the encrypted secret and the public key, which are the same in every node.
+	encodedPublicShare := responses[0].msg.Data[1]
+	encodedEpoch := message.EncodeEpoch(epoch)
+	err = recipients.sendPendingShares(ecdsaRefreshTypes, message.ReshareECDSAKeyShare, keyID, epoch, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), encodedEpoch, message.EncodeShareIndex(node.index + 1), encodedMeta, encodedNewMeta, encodedDealings, encodedPublicShare}, nil
+	})
+	if err != nil {
+		if _, ok := err.(*commitError); ok {
+			return newMeta, err
+		}
+		return nil, err
+	}
+	return newMeta, nil
+}
+
+// sortByIndex sorts a list of results using the index of the nodes that sent them.
+func sortByIndex(results []*result) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].node.index < results[j].node.index
+	})
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/tceddsa"
+)
+
+// SendEdDSAKeyShares sends a key share to each node, using the order of the nodes in the client config.
+// It returns an error if any of the nodes fails to save its key share, for example if it already has a key with the
+// same ID.
+func (client *Client) SendEdDSAKeyShares(keyID string, keyShares []*tceddsa.KeyShare, keyMeta *tceddsa.KeyMeta) error {
+	return client.sendEdDSAKeyShares(message.SendEdDSAKeyShare, keyID, keyShares, keyMeta)
+}
+
+// ReplaceEdDSAKeyShares sends a key share to each node, replacing the key share they have with the same ID. The nodes
+// archive the replaced key shares.
+func (client *Client) ReplaceEdDSAKeyShares(keyID string, keyShares []*tceddsa.KeyShare, keyMeta *tceddsa.KeyMeta) error {
+	return client.sendEdDSAKeyShares(message.ReplaceEdDSAKeyShare, keyID, keyShares, keyMeta)
+}
+
+func (client *Client) sendEdDSAKeyShares(rType message.Type, keyID string, keyShares []*tceddsa.KeyShare, keyMeta *tceddsa.KeyMeta) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(keyShares) != len(client.nodes) {
+		return fmt.Errorf("number of key shares (%d) is not equal to the number of nodes (%d)", len(keyShares), len(client.nodes))
+	}
+	encodedMeta, err := message.EncodeEdDSAKeyMeta(keyMeta)
+	if err != nil {
+		return err
+	}
+	results := client.askAll(client.nodes, rType, func(node *Node) ([][]byte, error) {
+		encodedShare, err := message.EncodeEdDSAKeyShare(keyShares[node.index])
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{[]byte(keyID), encodedShare, encodedMeta}, nil
+	})
+	_, err = collect(results, len(client.nodes), len(client.nodes))
+	return err
+}
+
+// SignEdDSA runs the EdDSA threshold signing protocol over a message, and returns an Ed25519 signature that verifies
+// with crypto/ed25519. The first K nodes that answer the first round are the ones that sign in the second round, so
+// all of them must answer until the end.
+func (client *Client) SignEdDSA(keyID string, msg []byte, keyMeta *tceddsa.KeyMeta) ([]byte, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	k := int(keyMeta.K)
+	results := client.askAll(client.nodes, message.EdDSARound1, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID)}, nil
+	})
+	responses, err := collect(results, len(client.nodes), k)
+	if err != nil {
+		return nil, fmt.Errorf("round 1: %s", err)
+	}
+	sortByIndex(responses)
+	signers := make([]*Node, len(responses))
+	commitments := make(tceddsa.CommitmentList, len(responses))
+	for i, res := range responses {
+		signers[i] = res.node
+		commitments[i], err = message.DecodeEdDSACommitment(res.msg.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode commitment from node %s: %s", res.node.GetConnString(), err)
+		}
+		if int(commitments[i].Index) != res.node.index+1 {
+			return nil, fmt.Errorf("node %s sent a commitment for key share %d", res.node.GetConnString(), commitments[i].Index)
+		}
+	}
+	encodedCommitments, err := message.EncodeEdDSACommitmentList(commitments)
+	if err != nil {
+		return nil, err
+	}
+	results = client.askAll(signers, message.EdDSARound2, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), msg, encodedCommitments}, nil
+	})
+	responses, err = collect(results, len(signers), len(signers))
+	if err != nil {
+		return nil, fmt.Errorf("round 2: %s", err)
+	}
+	sigShares := make(tceddsa.SigShareList, len(responses))
+	for i, res := range responses {
+		sigShares[i], err = message.DecodeEdDSASigShare(res.msg.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode sig share from node %s: %s", res.node.GetConnString(), err)
+		}
+		if err := sigShares[i].Verify(msg, commitments, keyMeta); err != nil {
+			return nil, fmt.Errorf("invalid sig share from node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	return sigShares.Join(msg, commitments, keyMeta)
+}
+
+// DeleteEdDSAKeyShares asks all the nodes to delete the key share with the ID provided, and returns the report of the
+// wipe of each node, in the order of the nodes in the client config.
+// It returns an error if any of the nodes fails to delete it.
+func (client *Client) DeleteEdDSAKeyShares(keyID string) ([]*message.Wipe, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.deleteKeyShares(message.DeleteEdDSAKeyShare, keyID)
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/tcrsa"
+)
+
+// PSSSaltLengthEqualsHash makes EncodeRSAPSS use a salt as long as the hash, as crypto/rsa does with
+// rsa.PSSSaltLengthEqualsHash.
+const PSSSaltLengthEqualsHash = -1
+
+// EncodeRSAPSS encodes a hash with EMSA-PSS, as defined in RFC 8017, section 9.1.1, so it can be signed by the nodes
+// using one of the RSA PSS mechanisms. The signature built with the result verifies with rsa.VerifyPSS.
+func EncodeRSAPSS(mechanism message.RSAMechanism, hash []byte, saltLength int, keyMeta *tcrsa.KeyMeta) ([]byte, error) {
+	if !mechanism.IsPSS() {
+		return nil, fmt.Errorf("%s is not a PSS mechanism", mechanism)
+	}
+	hashFunc := mechanism.Hash()
+	hLen := hashFunc.Size()
+	if len(hash) != hLen {
+		return nil, fmt.Errorf("hash length is %d, but it should be %d for %s", len(hash), hLen, mechanism)
+	}
+	if saltLength == PSSSaltLengthEqualsHash {
+		saltLength = hLen
+	}
+	emBits := keyMeta.PublicKey.N.BitLen() - 1
+	emLen := (emBits + 7) / 8
+	if saltLength < 0 || emLen < hLen+saltLength+2 {
+		return nil, fmt.Errorf("key is too small for PSS with hash length %d and salt length %d", hLen, saltLength)
+	}
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	// H = Hash(0x00 * 8 || mHash || salt)
+	h := hashFunc.New()
+	h.Write(make([]byte, 8))
+	h.Write(hash)
+	h.Write(salt)
+	hPrime := h.Sum(nil)
+
+	// DB = PS || 0x01 || salt, masked with MGF1(H)
+	em := make([]byte, emLen)
+	db := em[:emLen-hLen-1]
+	db[emLen-saltLength-hLen-2] = 0x01
+	copy(db[emLen-saltLength-hLen-1:], salt)
+	mgf1XOR(db, hashFunc, hPrime)
+	db[0] &= 0xff >> uint(8*emLen-emBits)
+
+	// EM = maskedDB || H || 0xbc
+	copy(em[emLen-hLen-1:], hPrime)
+	em[emLen-1] = 0xbc
+	return em, nil
+}
+
+// mgf1XOR xors the bytes of out with the mask generated by MGF1 with the hash function and seed provided.
+func mgf1XOR(out []byte, hashFunc crypto.Hash, seed []byte) {
+	var counter [4]byte
+	h := hashFunc.New()
+	done := 0
+	for done < len(out) {
+		h.Reset()
+		h.Write(seed)
+		h.Write(counter[:])
+		digest := h.Sum(nil)
+		for i := 0; i < len(digest) && done < len(out); i++ {
+			out[done] ^= digest[i]
+			done++
+		}
+		binary.BigEndian.PutUint32(counter[:], binary.BigEndian.Uint32(counter[:])+1)
+	}
+}
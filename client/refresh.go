@@ -0,0 +1,68 @@
+package client
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// refreshTypes groups the message types of the key share refresh of a threshold scheme.
+type refreshTypes struct {
+	refresh, commit, abort message.Type
+}
+
+var (
+	rsaRefreshTypes   = refreshTypes{message.RefreshRSAKeyShare, message.CommitRSAKeyShareRefresh, message.AbortRSAKeyShareRefresh}
+	ecdsaRefreshTypes = refreshTypes{message.RefreshECDSAKeyShare, message.CommitECDSAKeyShareRefresh, message.AbortECDSAKeyShareRefresh}
+)
+
+// refreshKeyShares sends each node the delta of its key share and the new key meta, and commits the refresh if all of
+// them save the refreshed share.
+func (client *Client) refreshKeyShares(types refreshTypes, keyID string, epoch uint64, deltas []*big.Int, encodedMeta []byte) error {
+	if len(deltas) != len(client.nodes) {
+		return fmt.Errorf("number of key shares (%d) is not equal to the number of nodes (%d)", len(deltas), len(client.nodes))
+	}
+	encodedEpoch := message.EncodeEpoch(epoch)
+	return client.sendPendingShares(types, types.refresh, keyID, epoch, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), encodedEpoch, deltas[node.index].Bytes(), encodedMeta}, nil
+	})
+}
+
+// sendPendingShares sends each node a message of the type provided, with which it creates a new key share for the
+// epoch provided. If all of them save their new share, it asks them to commit it, and if any of them fails, it asks
+// them to abort it and keep the old share.
+func (client *Client) sendPendingShares(types refreshTypes, rType message.Type, keyID string, epoch uint64, data func(node *Node) ([][]byte, error)) error {
+	results := client.askAll(client.nodes, rType, data)
+	if _, err := collect(results, len(client.nodes), len(client.nodes)); err != nil {
+		encodedEpoch := message.EncodeEpoch(epoch)
+		results = client.askAll(client.nodes, types.abort, func(node *Node) ([][]byte, error) {
+			return [][]byte{[]byte(keyID), encodedEpoch}, nil
+		})
+		if _, abortErr := collect(results, len(client.nodes), len(client.nodes)); abortErr != nil {
+			return fmt.Errorf("refresh failed: %s, and it could not be aborted: %s", err, abortErr)
+		}
+		return fmt.Errorf("refresh aborted: %s", err)
+	}
+	return client.commitRefresh(types, keyID, epoch)
+}
+
+// commitError is returned when the nodes saved a refreshed key share, but some of them did not commit it.
+type commitError struct {
+	err error
+}
+
+func (err *commitError) Error() string {
+	return fmt.Sprintf("refresh not committed by all the nodes: %s", err.err)
+}
+
+// commitRefresh asks all the nodes to commit the refresh of a key share to the epoch provided.
+func (client *Client) commitRefresh(types refreshTypes, keyID string, epoch uint64) error {
+	results := client.askAll(client.nodes, types.commit, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), message.EncodeEpoch(epoch)}, nil
+	})
+	if _, err := collect(results, len(client.nodes), len(client.nodes)); err != nil {
+		return &commitError{err}
+	}
+	return nil
+}
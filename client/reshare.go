@@ -0,0 +1,68 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/refresh"
+	"github.com/pebbe/zmq4"
+)
+
+// dealKeyShares asks the dealer nodes, identified by their index in the client config, to deal their shares of the
+// key with the ID provided as k-of-n new key shares, where n is the number of nodes of the recipients client. It
+// returns the responses of the dealers and their dealings.
+func (client *Client) dealKeyShares(rType message.Type, keyID string, dealers []int, k int, recipients *Client) ([]*result, []*refresh.Dealing, error) {
+	nodes := make([]*Node, len(dealers))
+	indexes := make([]int, len(dealers))
+	for i, dealer := range dealers {
+		if dealer < 0 || dealer >= len(client.nodes) {
+			return nil, nil, fmt.Errorf("invalid dealer node index %d", dealer)
+		}
+		nodes[i] = client.nodes[dealer]
+		indexes[i] = dealer + 1
+	}
+	// The sub-shares are encrypted with the Curve25519 keys the recipient nodes use in ZMQ CURVE Auth.
+	publicKeys := make([][]byte, len(recipients.nodes))
+	for i, node := range recipients.nodes {
+		publicKeys[i] = []byte(zmq4.Z85decode(node.pubKey))
+	}
+	encodedReshare, err := message.EncodeReshare(&message.Reshare{
+		Dealers:    indexes,
+		K:          k,
+		L:          len(recipients.nodes),
+		Recipients: publicKeys,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	results := client.askAll(nodes, rType, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), encodedReshare}, nil
+	})
+	responses, err := collect(results, len(nodes), len(nodes))
+	if err != nil {
+		return nil, nil, err
+	}
+	dealings := make([]*refresh.Dealing, len(responses))
+	for i, res := range responses {
+		dealings[i], err = message.DecodeDealing(res.msg.Data[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot decode the dealing of node %s: %s", res.node.GetConnString(), err)
+		}
+	}
+	return responses, dealings, nil
+}
+
+// lockClients locks the client and the recipients client, if they are different, and returns a function that unlocks
+// them.
+func (client *Client) lockClients(recipients *Client) func() {
+	client.mutex.Lock()
+	if recipients != client {
+		recipients.mutex.Lock()
+	}
+	return func() {
+		if recipients != client {
+			recipients.mutex.Unlock()
+		}
+		client.mutex.Unlock()
+	}
+}
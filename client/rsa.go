@@ -0,0 +1,286 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/refresh"
+	"github.com/niclabs/tcrsa"
+)
+
+// SendRSAKeyShares sends a key share to each node, using the order of the nodes in the client config.
+// It returns an error if any of the nodes fails to save its key share, for example if it already has a key with the
+// same ID.
+func (client *Client) SendRSAKeyShares(keyID string, keyShares tcrsa.KeyShareList, keyMeta *tcrsa.KeyMeta) error {
+	return client.sendRSAKeyShares(message.SendRSAKeyShare, keyID, keyShares, keyMeta)
+}
+
+// ReplaceRSAKeyShares sends a key share to each node, replacing the key share they have with the same ID. The nodes
+// archive the replaced key shares.
+func (client *Client) ReplaceRSAKeyShares(keyID string, keyShares tcrsa.KeyShareList, keyMeta *tcrsa.KeyMeta) error {
+	return client.sendRSAKeyShares(message.ReplaceRSAKeyShare, keyID, keyShares, keyMeta)
+}
+
+func (client *Client) sendRSAKeyShares(rType message.Type, keyID string, keyShares tcrsa.KeyShareList, keyMeta *tcrsa.KeyMeta) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(keyShares) != len(client.nodes) {
+		return fmt.Errorf("number of key shares (%d) is not equal to the number of nodes (%d)", len(keyShares), len(client.nodes))
+	}
+	encodedMeta, err := message.EncodeRSAKeyMeta(keyMeta)
+	if err != nil {
+		return err
+	}
+	results := client.askAll(client.nodes, rType, func(node *Node) ([][]byte, error) {
+		encodedShare, err := message.EncodeRSAKeyShare(keyShares[node.index])
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{[]byte(keyID), encodedShare, encodedMeta}, nil
+	})
+	_, err = collect(results, len(client.nodes), len(client.nodes))
+	return err
+}
+
+// SignRSA asks the nodes for signature shares of a document and joins them. The document must be encoded as the
+// mechanism expects: a hash for the PKCS#1 v1.5 mechanisms with a hash function, a DigestInfo structure for RSAPKCS1v15
+// and an already padded document for RSARaw. It uses the first K valid signature shares, and returns an error if it
+// cannot get K of them before the timeout.
+func (client *Client) SignRSA(keyID string, mechanism message.RSAMechanism, hash []byte, keyMeta *tcrsa.KeyMeta) (tcrsa.Signature, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	doc, err := mechanism.Encode(hash, keyMeta)
+	if err != nil {
+		return nil, err
+	}
+	results := client.askAll(client.nodes, message.GetRSASigShare, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), hash, mechanism.Bytes()}, nil
+	})
+	sig, err := joinRSAShares(results, len(client.nodes), doc, keyMeta)
+	if err != nil {
+		return nil, err
+	}
+	return tcrsa.Signature(sig), nil
+}
+
+// SignRSABatch works like SignRSA, but it asks the nodes for the signature shares of a list of hashes in a single
+// request per node. It returns a signature and an error for each hash, in the same order, so a hash that cannot be
+// signed does not fail the whole batch. The last value is an error only if the batch cannot be sent.
+func (client *Client) SignRSABatch(keyID string, mechanism message.RSAMechanism, hashes [][]byte, keyMeta *tcrsa.KeyMeta) ([]tcrsa.Signature, []error, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(hashes) == 0 || len(hashes) > message.MaxRSABatchSize {
+		return nil, nil, fmt.Errorf("batch size is %d, but it should be between 1 and %d", len(hashes), message.MaxRSABatchSize)
+	}
+	k := int(keyMeta.K)
+	sigs := make([]tcrsa.Signature, len(hashes))
+	errs := make([]error, len(hashes))
+	docs := make([][]byte, len(hashes))
+	shareErrs := make([][]error, len(hashes))
+	sigShares := make([]tcrsa.SigShareList, len(hashes))
+	for i, hash := range hashes {
+		docs[i], errs[i] = mechanism.Encode(hash, keyMeta)
+	}
+	encodedHashes, err := message.EncodeRSAHashList(hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+	results := client.askAll(client.nodes, message.GetRSASigShareBatch, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), encodedHashes, mechanism.Bytes()}, nil
+	})
+	nodeErrs := make([]error, 0)
+	pending := len(hashes)
+	for i := 0; i < len(client.nodes) && pending > 0; i++ {
+		res := <-results
+		if res.err != nil {
+			nodeErrs = append(nodeErrs, res.err)
+			continue
+		}
+		batch, err := message.DecodeRSABatchSigShares(res.msg.Data[0])
+		if err != nil || len(batch) != len(hashes) {
+			nodeErrs = append(nodeErrs, fmt.Errorf("cannot decode sig shares from node %s", res.node.GetConnString()))
+			continue
+		}
+		pending = 0
+		for j, item := range batch {
+			if errs[j] != nil || len(sigShares[j]) >= k {
+				continue
+			}
+			if item.Error != message.Ok || item.SigShare == nil {
+				shareErrs[j] = append(shareErrs[j], fmt.Errorf("node %s: %s", res.node.GetConnString(), item.Error))
+			} else if err := item.SigShare.Verify(docs[j], keyMeta); err != nil {
+				shareErrs[j] = append(shareErrs[j], fmt.Errorf("invalid sig share from node %s: %s", res.node.GetConnString(), err))
+			} else {
+				sigShares[j] = append(sigShares[j], item.SigShare)
+			}
+			if len(sigShares[j]) < k {
+				pending++
+			}
+		}
+	}
+	if len(nodeErrs) > len(client.nodes)-k {
+		return nil, nil, fmt.Errorf("quorum not reached: %d nodes failed: %v", len(nodeErrs), nodeErrs)
+	}
+	for i := range hashes {
+		if errs[i] != nil {
+			continue
+		}
+		if len(sigShares[i]) < k {
+			errs[i] = fmt.Errorf("quorum not reached: got %d sig shares, needed %d: %v", len(sigShares[i]), k, shareErrs[i])
+			continue
+		}
+		sig, err := sigShares[i].Join(docs[i], keyMeta)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		sigs[i] = leftPad(sig, keyMeta.PublicKey.Size())
+	}
+	return sigs, errs, nil
+}
+
+// DecryptRSA asks the nodes for decryption shares of a ciphertext and joins them. It returns the message representative
+// of the plaintext, as long as the modulus and still padded, so the caller must remove the padding used to encrypt it.
+// It uses the first K valid decryption shares, and returns an error if it cannot get K of them before the timeout.
+func (client *Client) DecryptRSA(keyID string, ciphertext []byte, keyMeta *tcrsa.KeyMeta) ([]byte, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(ciphertext) != keyMeta.PublicKey.Size() {
+		return nil, fmt.Errorf("ciphertext length is %d, but it should be %d", len(ciphertext), keyMeta.PublicKey.Size())
+	}
+	results := client.askAll(client.nodes, message.GetRSADecryptShare, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), ciphertext}, nil
+	})
+	return joinRSAShares(results, len(client.nodes), ciphertext, keyMeta)
+}
+
+// joinRSAShares reads the shares of a document sent by n nodes, verifies them and joins the first K valid ones. The
+// result is padded with zeros to the length of the modulus.
+func joinRSAShares(results <-chan *result, n int, doc []byte, keyMeta *tcrsa.KeyMeta) ([]byte, error) {
+	shares := make(tcrsa.SigShareList, 0)
+	errs := make([]error, 0)
+	for i := 0; i < n && len(shares) < int(keyMeta.K); i++ {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		share, err := message.DecodeRSASigShare(res.msg.Data[0])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot decode share from node %s: %s", res.node.GetConnString(), err))
+			continue
+		}
+		if err := share.Verify(doc, keyMeta); err != nil {
+			errs = append(errs, fmt.Errorf("invalid share from node %s: %s", res.node.GetConnString(), err))
+			continue
+		}
+		shares = append(shares, share)
+	}
+	if len(shares) < int(keyMeta.K) {
+		return nil, fmt.Errorf("quorum not reached: got %d shares, needed %d: %v", len(shares), keyMeta.K, errs)
+	}
+	joined, err := shares.Join(doc, keyMeta)
+	if err != nil {
+		return nil, err
+	}
+	return leftPad(joined, keyMeta.PublicKey.Size()), nil
+}
+
+// leftPad returns the value provided with zeros to its left, so it has the length provided. It is needed because
+// tcrsa does not pad joined shares, and crypto/rsa expects them to be as long as the modulus.
+func leftPad(value []byte, length int) []byte {
+	if len(value) >= length {
+		return value
+	}
+	padded := make([]byte, length)
+	copy(padded[length-len(value):], value)
+	return padded
+}
+
+// DeleteRSAKeyShares asks all the nodes to delete the key share with the ID provided, and returns the report of the
+// wipe of each node, in the order of the nodes in the client config.
+// It returns an error if any of the nodes fails to delete it.
+func (client *Client) DeleteRSAKeyShares(keyID string) ([]*message.Wipe, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.deleteKeyShares(message.DeleteRSAKeyShare, keyID)
+}
+
+// RefreshRSAKeyShares refreshes the key shares the nodes have with the ID provided, without changing the public key.
+// The epoch is the number of refreshes of the key, including this one, so the first refresh has epoch 1. It returns the
+// key meta with the verification keys of the refreshed shares, which must be used from then on.
+// If a node cannot refresh its share, the refresh is aborted and the nodes keep their old shares. If a node cannot
+// commit the refresh, the new key meta is returned with the error, and the commit must be retried with
+// CommitRSAKeyShareRefresh, because the nodes that committed it cannot sign with the ones that did not.
+func (client *Client) RefreshRSAKeyShares(keyID string, epoch uint64, keyMeta *tcrsa.KeyMeta) (*tcrsa.KeyMeta, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	deltas, newMeta, err := refresh.NewRSARefresh(keyMeta)
+	if err != nil {
+		return nil, err
+	}
+	encodedMeta, err := message.EncodeRSAKeyMeta(newMeta)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.refreshKeyShares(rsaRefreshTypes, keyID, epoch, deltas, encodedMeta); err != nil {
+		if _, ok := err.(*commitError); ok {
+			return newMeta, err
+		}
+		return nil, err
+	}
+	return newMeta, nil
+}
+
+// CommitRSAKeyShareRefresh asks the nodes again to commit the refresh of a key share to the epoch provided.
+func (client *Client) CommitRSAKeyShareRefresh(keyID string, epoch uint64) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.commitRefresh(rsaRefreshTypes, keyID, epoch)
+}
+
+// ReshareRSAKeyShares moves the key with the ID provided from the nodes of the client to the nodes of the recipients
+// client, as k-of-n key shares, where n is the number of recipient nodes, without changing the public key. Both
+// clients can be the same, to change only the threshold. The dealers are the indexes in the client config of K nodes
+// holding key shares, and the epoch must follow the epoch of their key shares.
+// tcrsa keys cannot be reshared to more key shares than they have, and the dealers 0..K-1 can always reshare them to
+// the same or a smaller number of shares.
+// It returns the key meta of the new key shares. If a recipient cannot save its share, the resharing is aborted. If a
+// recipient cannot commit it, the new key meta is returned with the error, and the commit must be retried with
+// CommitRSAKeyShareRefresh on the recipients client. The nodes of the client that are not recipients keep their old
+// key shares until they are deleted with DeleteRSAKeyShares.
+func (client *Client) ReshareRSAKeyShares(keyID string, epoch uint64, keyMeta *tcrsa.KeyMeta, dealers []int, k int, recipients *Client) (*tcrsa.KeyMeta, error) {
+	unlock := client.lockClients(recipients)
+	defer unlock()
+	_, dealings, err := client.dealKeyShares(message.DealRSAKeyShare, keyID, dealers, k, recipients)
+	if err != nil {
+		return nil, err
+	}
+	newMeta, err := refresh.NewRSAResharedMeta(keyMeta, dealings, k, len(recipients.nodes))
+	if err != nil {
+		return nil, err
+	}
+	encodedMeta, err := message.EncodeRSAKeyMeta(keyMeta)
+	if err != nil {
+		return nil, err
+	}
+	encodedNewMeta, err := message.EncodeRSAKeyMeta(newMeta)
+	if err != nil {
+		return nil, err
+	}
+	encodedDealings, err := message.EncodeDealingList(dealings)
+	if err != nil {
+		return nil, err
+	}
+	encodedEpoch := message.EncodeEpoch(epoch)
+	err = recipients.sendPendingShares(rsaRefreshTypes, message.ReshareRSAKeyShare, keyID, epoch, func(node *Node) ([][]byte, error) {
+		return [][]byte{[]byte(keyID), encodedEpoch, message.EncodeShareIndex(node.index + 1), encodedMeta, encodedNewMeta, encodedDealings}, nil
+	})
+	if err != nil {
+		if _, ok := err.(*commitError); ok {
+			return newMeta, err
+		}
+		return nil, err
+	}
+	return newMeta, nil
+}
@@ -4,43 +4,123 @@ import "net"
 
 // Config represents the main config of a node.
 type Config struct {
-	PublicKey  string        // Node public key
-	PrivateKey string        // Node private key
-	Host       string        // Node host
-	Port       uint16        // Node port
-	Client     *ClientConfig // List of servers
+	PublicKey       string         // Node public key
+	PrivateKey      string         // Node private key
+	Host            string         // Node host
+	Port            uint16         // Node port
+	ReplyCacheSize  int            // Number of responses saved to answer retried requests
+	MaxClockSkew    int            // Maximum difference in seconds between the node clock and the timestamp of a request
+	AuditLog        string         // File where the audit log is appended. Empty writes it to the standard error.
+	Hardened        bool           // Locks the memory of the process and disables core dumps. Only supported on Linux.
+	ApprovalDir     string         // Directory where the requests waiting for operator approval are saved. Empty uses the approvals directory next to the config file.
+	ApprovalTimeout int            // Seconds a request waits for operator approval before it is rejected (default 3600).
+	Sandbox         *SandboxConfig // Restrictions the node applies to itself when it starts. Nil disables them.
+	AdminSocket     string         // Unix socket where the node answers the admin API. Empty disables it.
+	Client          *ClientConfig  // List of servers
+}
+
+// SandboxConfig represents the restrictions a node applies to itself when it starts. Only supported on Linux.
+type SandboxConfig struct {
+	User    string   // User the node switches to after binding its socket and loading its config. Empty keeps the current user.
+	Paths   []string // Files and directories the node can read and write besides the config file directory and the audit log.
+	Seccomp bool     // Denies the system calls the node never needs, like execve, ptrace or mount.
 }
 
 // ClientConfig represents a client configuration.
 type ClientConfig struct {
-	PublicKey string      // Client public key
-	Host      string      // Client hostname or IP
-	RSA       RSAConfig   // Client RSA Configuration
-	ECDSA     ECDSAConfig // Client ECDSA Configuration
+	PublicKey  string          // Client public key
+	Host       string          // Client hostname or IP
+	RSA        RSAConfig       // Client RSA Configuration
+	ECDSA      ECDSAConfig     // Client ECDSA Configuration
+	EdDSA      EdDSAConfig     // Client EdDSA Configuration
+	Policies   []*PolicyConfig // Rules restricting what the client can do with each key. Empty allows everything but decryption.
+	Algorithms []string        // Algorithms the client can create keys with: rsa, ecdsa and eddsa. Empty allows all of them.
+}
+
+// PolicyConfig represents the rules a client must follow to use a key.
+type PolicyConfig struct {
+	Key         string   // Key ID the policy applies to. "*" applies to every key without its own policy.
+	Operations  []string // Allowed operations: sign, decrypt, delete, overwrite, refresh, reshare and lifecycle.
+	HashLengths []int    // Allowed lengths in bytes of the hashes to sign. Empty allows any length.
+	RateLimit   int      // Maximum number of operations per minute. Zero means no limit.
+	TimeWindows []string // Time ranges in UTC, as HH:MM-HH:MM, when the key can be used. Empty means any time.
 }
 
 // RSAConfig represents RSA specific configuration.
 type RSAConfig struct {
-	Keys []*RSAKeyConfig // List of RSA Keys.
+	Keys         []*RSAKeyConfig // List of RSA Keys.
+	ArchivedKeys []*RSAKeyConfig // List of RSA Keys replaced by newer key shares.
+	ReceivedKeys []*RSAKeyConfig // List of RSA Keys received by resharing, waiting for the client to commit them.
 }
 
 // ECDSAConfig represents ECDSA specific configuration.
 type ECDSAConfig struct {
-	Keys []*ECDSAKeyConfig // List of ECDSA Keys.
+	Keys         []*ECDSAKeyConfig // List of ECDSA Keys.
+	ArchivedKeys []*ECDSAKeyConfig // List of ECDSA Keys replaced by newer key shares.
+	ReceivedKeys []*ECDSAKeyConfig // List of ECDSA Keys received by resharing, waiting for the client to commit them.
+	Curves       []string          // Curves the client can create keys with. Empty allows every supported curve.
+}
+
+// EdDSAConfig represents EdDSA specific configuration.
+type EdDSAConfig struct {
+	Keys         []*EdDSAKeyConfig // List of EdDSA Keys.
+	ArchivedKeys []*EdDSAKeyConfig // List of EdDSA Keys replaced by newer key shares.
 }
 
 // RSAKeyConfig represents an RSA key share on the node.
 type RSAKeyConfig struct {
-	ID          string // Key UUID
-	KeyShare    string // Keyshare
-	KeyMetaInfo string // Key Metainformation
+	ID               string      // Key UUID
+	KeyShare         string      // Keyshare
+	KeyMetaInfo      string      // Key Metainformation
+	ArchivedAt       string      // Time the key share was replaced, in RFC 3339 format. Empty if it is not archived.
+	Verify           string      // Which sig shares are verified before sending them: always (default), never or sample.
+	VerifySampleRate int         // With the sample verify mode, one of every VerifySampleRate sig shares is verified.
+	Epoch            uint64      // Number of refreshes applied to the key share.
+	PendingEpoch     uint64      // Epoch of the refreshed key share waiting for the client to commit it. Zero if there is none.
+	PendingKeyShare  string      // Refreshed key share waiting for the client to commit it.
+	PendingKeyMeta   string      // Key Metainformation of the refreshed key share.
+	State            string      // Lifecycle state: pending-init, active (default), disabled or pending-deletion.
+	DeleteAfter      string      // With the pending-deletion state, time the key share is deleted, in RFC 3339 format.
+	Usage            UsageConfig // Signature counters and limits of the key share.
+	RequiresApproval bool        // Signing requests wait until an operator of the node approves them.
 }
 
 // ECDSAKeyConfig represents an ECDSA key share on the node.
 type ECDSAKeyConfig struct {
-	ID          string // Key UUID
-	KeyShare    string // Keyshare
-	KeyMetaInfo string // Key Metainformation
+	ID               string      // Key UUID
+	KeyShare         string      // Keyshare
+	KeyMetaInfo      string      // Key Metainformation
+	ArchivedAt       string      // Time the key share was replaced, in RFC 3339 format. Empty if it is not archived.
+	Epoch            uint64      // Number of refreshes applied to the key share.
+	PendingEpoch     uint64      // Epoch of the refreshed key share waiting for the client to commit it. Zero if there is none.
+	PendingKeyShare  string      // Refreshed key share waiting for the client to commit it.
+	PendingKeyMeta   string      // Key Metainformation of the refreshed key share.
+	State            string      // Lifecycle state: pending-init, active (default), disabled or pending-deletion.
+	DeleteAfter      string      // With the pending-deletion state, time the key share is deleted, in RFC 3339 format.
+	Usage            UsageConfig // Signature counters and limits of the key share.
+	RequiresApproval bool        // Signing requests wait until an operator of the node approves them.
+	Presignatures    []string    // One-time presignatures of the key share. Each one is removed when it is used.
+}
+
+// EdDSAKeyConfig represents an EdDSA key share on the node.
+type EdDSAKeyConfig struct {
+	ID          string      // Key UUID
+	KeyShare    string      // Keyshare
+	KeyMetaInfo string      // Key Metainformation
+	ArchivedAt  string      // Time the key share was replaced, in RFC 3339 format. Empty if it is not archived.
+	State       string      // Lifecycle state: active (default), disabled or pending-deletion.
+	DeleteAfter string      // With the pending-deletion state, time the key share is deleted, in RFC 3339 format.
+	Usage       UsageConfig // Signature counters and limits of the key share.
+}
+
+// UsageConfig represents the signature counters of a key share and its signature limits.
+type UsageConfig struct {
+	MaxSignatures        uint64 // Maximum number of signatures of the key share. Zero means no limit.
+	MaxSignaturesPerHour uint64 // Maximum number of signatures per clock hour (UTC). Zero means no limit.
+	Signatures           uint64 // Signatures made with the key share.
+	Hour                 string // Start of the hour of HourSignatures, in RFC 3339 format.
+	HourSignatures       uint64 // Signatures made in the hour that starts at Hour.
+	LastUsed             string // Time of the last signature, in RFC 3339 format.
 }
 
 // Returns a client, given its ID.
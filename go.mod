@@ -1,10 +1,27 @@
 module github.com/niclabs/dtcnode/v3
 
-go 1.12
+go 1.20
 
 require (
+	filippo.io/edwards25519 v1.0.0
 	github.com/niclabs/tcecdsa v0.0.7
+	github.com/niclabs/tcpaillier v0.0.7
 	github.com/niclabs/tcrsa v0.0.4
 	github.com/pebbe/zmq4 v1.2.2
 	github.com/spf13/viper v1.4.0
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a
+)
+
+require (
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
+	github.com/magiconair/properties v1.8.0 // indirect
+	github.com/mitchellh/mapstructure v1.1.2 // indirect
+	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/spf13/afero v1.1.2 // indirect
+	github.com/spf13/cast v1.3.0 // indirect
+	github.com/spf13/jwalterweatherman v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	golang.org/x/text v0.3.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
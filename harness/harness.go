@@ -0,0 +1,456 @@
+// Package harness runs a set of dtcnodes inside a single process and acts as their DTC client.
+// It generates RSA, ECDSA and EdDSA threshold keys, sends the key shares to the nodes over ZMQ CURVE connections on
+// loopback, asks them to sign a document and checks that the combined signatures verify with the standard library.
+package harness
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/client"
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/server"
+	"github.com/niclabs/dtcnode/v3/tceddsa"
+	"github.com/niclabs/tcecdsa"
+	"github.com/niclabs/tcrsa"
+	"github.com/pebbe/zmq4"
+	"github.com/spf13/viper"
+)
+
+// Host is the loopback address used by the nodes and the client.
+const Host = "127.0.0.1"
+
+// Config represents the parameters of a harness run.
+type Config struct {
+	Nodes      uint8         // Number of nodes started.
+	Threshold  uint8         // Number of nodes needed to sign.
+	BasePort   uint16        // Port of the first node. The following nodes use the next ports.
+	RSABitSize int           // Bit size of the RSA key.
+	ECDSACurve string        // Name of the curve of the ECDSA key.
+	Timeout    time.Duration // Time the client waits for a response of a node.
+	Document   []byte        // Document signed.
+}
+
+// DefaultConfig returns a configuration for a 3-of-5 run.
+func DefaultConfig() *Config {
+	return &Config{
+		Nodes:      5,
+		Threshold:  3,
+		BasePort:   29870,
+		RSABitSize: 1024,
+		ECDSACurve: "P-256",
+		Timeout:    2 * time.Minute,
+		Document:   []byte("dtcnode harness"),
+	}
+}
+
+// rsaMechanisms are the mechanisms used to check RSA signatures.
+var rsaMechanisms = []message.RSAMechanism{
+	message.RSAPKCS1v15SHA1,
+	message.RSAPKCS1v15SHA256,
+	message.RSAPKCS1v15SHA384,
+	message.RSAPKCS1v15SHA512,
+}
+
+// Harness represents a set of nodes running on this process and the client connected to them.
+type Harness struct {
+	conf       *Config
+	dir        string         // Directory where the config files of the nodes are saved.
+	pubKey     string         // Public key of the client.
+	privKey    string         // Private key of the client.
+	nodes      []*server.Node // Nodes running on this process.
+	nodeConfig []*config.Config
+	client     *client.Client // Client connected to the nodes.
+}
+
+// Run starts the nodes, checks RSA, ECDSA and EdDSA signatures with them and stops the harness.
+func Run(conf *Config) error {
+	if err := zmq4.AuthStart(); err != nil {
+		return fmt.Errorf("error starting auth: %s", err)
+	}
+	defer zmq4.AuthStop()
+	h, err := Start(conf)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	if err := h.CheckRSA(); err != nil {
+		return fmt.Errorf("rsa check failed: %s", err)
+	}
+	if err := h.CheckECDSA(); err != nil {
+		return fmt.Errorf("ecdsa check failed: %s", err)
+	}
+	if err := h.CheckEdDSA(); err != nil {
+		return fmt.Errorf("eddsa check failed: %s", err)
+	}
+	return nil
+}
+
+// Start creates the node configurations, starts the nodes and connects the client to them.
+// ZMQ authentication must be started before calling this function.
+func Start(conf *Config) (*Harness, error) {
+	if conf.Threshold == 0 || conf.Threshold > conf.Nodes {
+		return nil, fmt.Errorf("threshold should be between 1 and %d, but it is %d", conf.Nodes, conf.Threshold)
+	}
+	dir, err := ioutil.TempDir("", "dtcnode-harness")
+	if err != nil {
+		return nil, err
+	}
+	pubKey, privKey, err := zmq4.NewCurveKeypair()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate client curve key pair: %s", err)
+	}
+	h := &Harness{
+		conf:    conf,
+		dir:     dir,
+		pubKey:  pubKey,
+		privKey: privKey,
+	}
+	clientConf := &client.Config{
+		PublicKey:  pubKey,
+		PrivateKey: privKey,
+		Timeout:    conf.Timeout,
+	}
+	for i := 0; i < int(conf.Nodes); i++ {
+		if err := h.startNode(i); err != nil {
+			h.Close()
+			return nil, fmt.Errorf("cannot start node %d: %s", i, err)
+		}
+		clientConf.Nodes = append(clientConf.Nodes, &client.NodeConfig{
+			PublicKey: h.nodeConfig[i].PublicKey,
+			Host:      h.nodeConfig[i].Host,
+			Port:      h.nodeConfig[i].Port,
+		})
+	}
+	h.client, err = client.New(clientConf)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Close closes the client connections, stops the nodes and removes their config files.
+func (h *Harness) Close() {
+	if h.client != nil {
+		h.client.Close()
+		h.client = nil
+	}
+	for i, node := range h.nodes {
+		if err := node.Close(); err != nil {
+			log.Printf("harness: cannot stop node %d: %s", i, err)
+		}
+	}
+	h.nodes = nil
+	_ = os.RemoveAll(h.dir)
+}
+
+// Client returns the client connected to the nodes of the harness.
+func (h *Harness) Client() *client.Client {
+	return h.client
+}
+
+func (h *Harness) startNode(i int) error {
+	nodePK, nodeSK, err := zmq4.NewCurveKeypair()
+	if err != nil {
+		return fmt.Errorf("could not generate curve key pair: %s", err)
+	}
+	conf := &config.Config{
+		PublicKey:  nodePK,
+		PrivateKey: nodeSK,
+		Host:       Host,
+		Port:       h.conf.BasePort + uint16(i),
+		Client: &config.ClientConfig{
+			PublicKey: h.pubKey,
+			Host:      Host,
+			// Decryption is only allowed by a policy that lists it.
+			Policies: []*config.PolicyConfig{{
+				Key:        "*",
+				Operations: []string{"sign", "decrypt", "delete", "overwrite", "refresh", "reshare", "lifecycle"},
+			}},
+		},
+	}
+	v := viper.New()
+	v.SetConfigFile(filepath.Join(h.dir, fmt.Sprintf("dtcnode-config-%d.yaml", i)))
+	v.Set("config", conf)
+	if err := v.WriteConfig(); err != nil {
+		return fmt.Errorf("cannot write config file: %s", err)
+	}
+	node, err := server.InitNodeWithViper(conf, v)
+	if err != nil {
+		return err
+	}
+	go node.Listen()
+	h.nodes = append(h.nodes, node)
+	h.nodeConfig = append(h.nodeConfig, conf)
+	return nil
+}
+
+// CheckRSA generates an RSA threshold key, sends its shares to the nodes and checks that the signature built with the
+// sig shares of the nodes verifies with the public key, and that the nodes can decrypt a ciphertext.
+func (h *Harness) CheckRSA() error {
+	keyID := "harness-rsa"
+	log.Printf("harness: generating %d bit RSA key shares", h.conf.RSABitSize)
+	keyShares, keyMeta, err := tcrsa.NewKey(h.conf.RSABitSize, uint16(h.conf.Threshold), uint16(h.conf.Nodes), nil)
+	if err != nil {
+		return err
+	}
+	if err := h.client.SendRSAKeyShares(keyID, keyShares, keyMeta); err != nil {
+		return err
+	}
+	for _, mechanism := range rsaMechanisms {
+		hashFunc := mechanism.Hash().New()
+		hashFunc.Write(h.conf.Document)
+		hash := hashFunc.Sum(nil)
+		sig, err := h.client.SignRSA(keyID, mechanism, hash, keyMeta)
+		if err != nil {
+			return fmt.Errorf("%s: %s", mechanism, err)
+		}
+		if err := rsa.VerifyPKCS1v15(keyMeta.PublicKey, mechanism.Hash(), hash, sig); err != nil {
+			return fmt.Errorf("%s: %s", mechanism, err)
+		}
+		log.Printf("harness: RSA signature using %s verified", mechanism)
+	}
+	hash := sha256.Sum256(h.conf.Document)
+	em, err := client.EncodeRSAPSS(message.RSAPSSSHA256, hash[:], client.PSSSaltLengthEqualsHash, keyMeta)
+	if err != nil {
+		return err
+	}
+	sig, err := h.client.SignRSA(keyID, message.RSAPSSSHA256, em, keyMeta)
+	if err != nil {
+		return fmt.Errorf("%s: %s", message.RSAPSSSHA256, err)
+	}
+	pssOpts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+	if err := rsa.VerifyPSS(keyMeta.PublicKey, crypto.SHA256, hash[:], sig, pssOpts); err != nil {
+		return fmt.Errorf("%s: %s", message.RSAPSSSHA256, err)
+	}
+	log.Printf("harness: RSA signature using %s verified", message.RSAPSSSHA256)
+	if err := h.checkRSABatch(keyID, keyMeta); err != nil {
+		return err
+	}
+	if err := h.checkRSADecrypt(keyID, keyMeta); err != nil {
+		return err
+	}
+	newMeta, err := h.checkRSARefresh(keyID, keyMeta)
+	if err != nil {
+		return err
+	}
+	if err := h.checkRSAReshare(keyID, newMeta); err != nil {
+		return err
+	}
+	if err := h.checkHealth(); err != nil {
+		return err
+	}
+	return checkWipe(h.client.DeleteRSAKeyShares(keyID))
+}
+
+// checkHealth asks the nodes to run the self-test of their key shares, and checks that none of them failed.
+func (h *Harness) checkHealth() error {
+	health, err := h.client.Health(true)
+	if err != nil {
+		return fmt.Errorf("self-test: %s", err)
+	}
+	for i, nodeHealth := range health {
+		for _, key := range nodeHealth.Keys {
+			if key.Error != "" {
+				return fmt.Errorf("self-test: %s key %s of node %d: %s", key.Algorithm, key.ID, i, key.Error)
+			}
+		}
+	}
+	log.Printf("harness: self-test of the key shares of %d nodes passed", len(health))
+	return nil
+}
+
+// checkRSARefresh refreshes the key shares and checks that the refreshed shares create signatures that verify with the
+// same public key.
+func (h *Harness) checkRSARefresh(keyID string, keyMeta *tcrsa.KeyMeta) (*tcrsa.KeyMeta, error) {
+	newMeta, err := h.client.RefreshRSAKeyShares(keyID, 1, keyMeta)
+	if err != nil {
+		return nil, fmt.Errorf("refresh: %s", err)
+	}
+	hash := sha256.Sum256(h.conf.Document)
+	sig, err := h.client.SignRSA(keyID, message.RSAPKCS1v15SHA256, hash[:], newMeta)
+	if err != nil {
+		return nil, fmt.Errorf("refresh: %s", err)
+	}
+	if err := rsa.VerifyPKCS1v15(keyMeta.PublicKey, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, fmt.Errorf("refresh: %s", err)
+	}
+	log.Printf("harness: RSA signature with refreshed key shares verified")
+	return newMeta, nil
+}
+
+// checkRSAReshare reshares the refreshed key shares among the same nodes, dealt by the first K of them, and checks
+// that the reshared shares create signatures that verify with the same public key. Keys with a threshold of 1 cannot
+// be reshared, so they are not checked.
+func (h *Harness) checkRSAReshare(keyID string, keyMeta *tcrsa.KeyMeta) error {
+	if h.conf.Threshold < 2 {
+		return nil
+	}
+	dealers := make([]int, h.conf.Threshold)
+	for i := range dealers {
+		dealers[i] = i
+	}
+	newMeta, err := h.client.ReshareRSAKeyShares(keyID, 2, keyMeta, dealers, int(h.conf.Threshold), h.client)
+	if err != nil {
+		return fmt.Errorf("reshare: %s", err)
+	}
+	hash := sha256.Sum256(h.conf.Document)
+	sig, err := h.client.SignRSA(keyID, message.RSAPKCS1v15SHA256, hash[:], newMeta)
+	if err != nil {
+		return fmt.Errorf("reshare: %s", err)
+	}
+	if err := rsa.VerifyPKCS1v15(keyMeta.PublicKey, crypto.SHA256, hash[:], sig); err != nil {
+		return fmt.Errorf("reshare: %s", err)
+	}
+	log.Printf("harness: RSA signature with reshared key shares verified")
+	return nil
+}
+
+// checkRSABatch signs a batch of hashes of different documents in a single request and verifies every signature.
+func (h *Harness) checkRSABatch(keyID string, keyMeta *tcrsa.KeyMeta) error {
+	hashes := make([][]byte, 8)
+	for i := range hashes {
+		hash := sha256.Sum256(append([]byte(fmt.Sprintf("%d ", i)), h.conf.Document...))
+		hashes[i] = hash[:]
+	}
+	sigs, errs, err := h.client.SignRSABatch(keyID, message.RSAPKCS1v15SHA256, hashes, keyMeta)
+	if err != nil {
+		return fmt.Errorf("batch: %s", err)
+	}
+	for i := range hashes {
+		if errs[i] != nil {
+			return fmt.Errorf("batch item %d: %s", i, errs[i])
+		}
+		if err := rsa.VerifyPKCS1v15(keyMeta.PublicKey, crypto.SHA256, hashes[i], sigs[i]); err != nil {
+			return fmt.Errorf("batch item %d: %s", i, err)
+		}
+	}
+	log.Printf("harness: batch of %d RSA signatures verified", len(hashes))
+	return nil
+}
+
+// checkRSADecrypt encrypts a random message representative with the public key and checks that the nodes decrypt it.
+func (h *Harness) checkRSADecrypt(keyID string, keyMeta *tcrsa.KeyMeta) error {
+	pk := keyMeta.PublicKey
+	m, err := rand.Int(rand.Reader, pk.N)
+	if err != nil {
+		return err
+	}
+	c := new(big.Int).Exp(m, big.NewInt(int64(pk.E)), pk.N)
+	ciphertext := make([]byte, pk.Size())
+	cBytes := c.Bytes()
+	copy(ciphertext[len(ciphertext)-len(cBytes):], cBytes)
+	plaintext, err := h.client.DecryptRSA(keyID, ciphertext, keyMeta)
+	if err != nil {
+		return fmt.Errorf("decrypt: %s", err)
+	}
+	if new(big.Int).SetBytes(plaintext).Cmp(m) != 0 {
+		return fmt.Errorf("decrypted message does not match the encrypted one")
+	}
+	log.Printf("harness: RSA decryption verified")
+	return nil
+}
+
+// CheckECDSA generates an ECDSA threshold key, initializes it on the nodes and checks that the signatures created by
+// the nodes, with the four rounds and with a presignature, verify with the public key.
+func (h *Harness) CheckECDSA() error {
+	keyID := "harness-ecdsa"
+	log.Printf("harness: generating ECDSA key shares on curve %s", h.conf.ECDSACurve)
+	keyShares, keyMeta, err := tcecdsa.NewKey(h.conf.Nodes, h.conf.Threshold, h.conf.ECDSACurve, nil)
+	if err != nil {
+		return err
+	}
+	pk, err := h.client.SendECDSAKeyShares(keyID, keyShares, keyMeta)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(h.conf.Document)
+	r, s, err := h.client.SignECDSA(keyID, hash[:], keyMeta)
+	if err != nil {
+		return err
+	}
+	if !ecdsa.Verify(pk, hash[:], r, s) {
+		return fmt.Errorf("ECDSA signature does not verify")
+	}
+	log.Printf("harness: ECDSA signature verified")
+	presignature, err := h.client.PresignECDSA(keyID, keyMeta)
+	if err != nil {
+		return fmt.Errorf("presign: %s", err)
+	}
+	r, s, err = h.client.SignECDSAPresigned(keyID, presignature, hash[:], keyMeta)
+	if err != nil {
+		return fmt.Errorf("presign: %s", err)
+	}
+	if !ecdsa.Verify(pk, hash[:], r, s) {
+		return fmt.Errorf("ECDSA signature with a presignature does not verify")
+	}
+	if _, _, err := h.client.SignECDSAPresigned(keyID, presignature, hash[:], keyMeta); err == nil {
+		return fmt.Errorf("ECDSA presignature was used twice")
+	}
+	log.Printf("harness: ECDSA signature with a presignature verified")
+	newMeta, err := h.client.RefreshECDSAKeyShares(keyID, 1, keyMeta)
+	if err != nil {
+		return fmt.Errorf("refresh: %s", err)
+	}
+	r, s, err = h.client.SignECDSA(keyID, hash[:], newMeta)
+	if err != nil {
+		return fmt.Errorf("refresh: %s", err)
+	}
+	if !ecdsa.Verify(pk, hash[:], r, s) {
+		return fmt.Errorf("ECDSA signature with refreshed key shares does not verify")
+	}
+	log.Printf("harness: ECDSA signature with refreshed key shares verified")
+	return checkWipe(h.client.DeleteECDSAKeyShares(keyID))
+}
+
+// CheckEdDSA generates an EdDSA threshold key, sends its shares to the nodes and checks that the signature created by
+// the nodes verifies with crypto/ed25519.
+func (h *Harness) CheckEdDSA() error {
+	keyID := "harness-eddsa"
+	log.Printf("harness: generating EdDSA key shares")
+	keyShares, keyMeta, err := tceddsa.NewKey(h.conf.Nodes, h.conf.Threshold)
+	if err != nil {
+		return err
+	}
+	if err := h.client.SendEdDSAKeyShares(keyID, keyShares, keyMeta); err != nil {
+		return err
+	}
+	sig, err := h.client.SignEdDSA(keyID, h.conf.Document, keyMeta)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(keyMeta.PublicKey, h.conf.Document, sig) {
+		return fmt.Errorf("EdDSA signature does not verify")
+	}
+	log.Printf("harness: EdDSA signature verified")
+	return checkWipe(h.client.DeleteEdDSAKeyShares(keyID))
+}
+
+// checkWipe checks that every node overwrote its key share with zeros when it deleted it.
+func checkWipe(wipes []*message.Wipe, err error) error {
+	if err != nil {
+		return err
+	}
+	for i, wipe := range wipes {
+		if wipe.Shares == 0 {
+			return fmt.Errorf("node %d did not overwrite its key share", i)
+		}
+		log.Printf("harness: node %d deleted its key share: %s", i, wipe)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package harness
+
+import (
+	"testing"
+
+	"github.com/pebbe/zmq4"
+)
+
+func TestHarness(t *testing.T) {
+	if !zmq4.HasCurve() {
+		t.Skip("libzmq is missing or was built without CURVE support")
+	}
+	if err := zmq4.AuthStart(); err != nil {
+		t.Fatalf("cannot start ZMQ authentication: %s", err)
+	}
+	defer zmq4.AuthStop()
+	conf := DefaultConfig()
+	conf.Nodes = 3
+	conf.Threshold = 2
+	conf.BasePort = 29970
+	conf.RSABitSize = 512
+	h, err := Start(conf)
+	if err != nil {
+		t.Fatalf("cannot start harness: %s", err)
+	}
+	defer h.Close()
+	checks := []struct {
+		name  string
+		check func() error
+		slow  bool // Checks that generate ECDSA keys, which takes minutes.
+	}{
+		{"rsa", h.CheckRSA, false},
+		{"ecdsa", h.CheckECDSA, true},
+		{"eddsa", h.CheckEdDSA, false},
+	}
+	for _, c := range checks {
+		if c.slow && testing.Short() {
+			t.Logf("%s check skipped in short mode", c.name)
+			continue
+		}
+		if err := c.check(); err != nil {
+			t.Errorf("%s check failed: %s", c.name, err)
+		}
+	}
+}
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"github.com/niclabs/dtcnode/v3/backup"
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/harness"
+	"github.com/niclabs/dtcnode/v3/message"
 	"github.com/niclabs/dtcnode/v3/server"
 	"github.com/spf13/viper"
+	"io/ioutil"
 	"log"
+	"net/rpc"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 func init() {
@@ -15,14 +27,424 @@ func init() {
 var Log *log.Logger
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "harness" {
+		if err := runHarness(os.Args[2:]); err != nil {
+			Log.Printf("Error: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	readConfig()
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:]); err != nil {
+			Log.Printf("Error: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "approvals" {
+		if err := runApprovals(os.Args[2:]); err != nil {
+			Log.Printf("Error: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := runAdmin(os.Args[2:]); err != nil {
+			Log.Printf("Error: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := server.Serve(); err != nil {
+		Log.Printf("Error: %s", err)
+		os.Exit(1)
+	}
+}
+
+// readConfig reads the config file of the node into viper.
+func readConfig() {
 	viper.SetConfigName("dtcnode-config")
 	viper.AddConfigPath("/etc/dtcnode/")
 	viper.AddConfigPath("./")
 	if err := viper.ReadInConfig(); err != nil {
 		panic(fmt.Errorf("config file not found! %v", err))
 	}
-	if err := server.Serve(); err != nil {
-		Log.Printf("Error: %s", err)
-		os.Exit(1)
+}
+
+// runKeys lists the key shares of the node, exports them into an encrypted backup, imports them from one, or changes
+// the lifecycle state of a key. The node must be stopped while importing or changing states, because a running node
+// overwrites the config file with the keys it has in memory.
+func runKeys(args []string) error {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "export" && args[0] != "import" && args[0] != "state") {
+		return fmt.Errorf("usage: dtcnode keys list|export|import|state [options]")
+	}
+	var conf config.Config
+	if err := viper.UnmarshalKey("config", &conf); err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	if args[0] == "list" {
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		return listKeys(&conf)
+	}
+	if args[0] == "state" {
+		alg := flags.String("alg", "rsa", "algorithm of the key: rsa, ecdsa or eddsa")
+		keyID := flags.String("key", "", "ID of the key")
+		state := flags.String("state", "", "new lifecycle state: active, disabled or pending-deletion")
+		retention := flags.Duration("retention", 0, "time the key share is kept before deleting it, with the pending-deletion state")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		return setKeyState(&conf, *alg, *keyID, message.KeyState(*state), *retention)
+	}
+	file := flags.String("f", "", "backup file")
+	passphraseFile := flags.String("passphrase-file", "", "file with the backup passphrase (default $DTCNODE_BACKUP_PASSPHRASE)")
+	if args[0] == "export" {
+		ids := flags.String("keys", "", "comma separated IDs of the exported keys (default all the RSA and ECDSA keys)")
+		recipient := flags.String("recipient", "", "CURVE public key of the node the backup is encrypted for, instead of a passphrase")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		var keyIDs []string
+		if *ids != "" {
+			keyIDs = strings.Split(*ids, ",")
+		}
+		protection := &backup.Protection{Recipient: *recipient}
+		if *recipient == "" {
+			passphrase, err := readPassphrase(*passphraseFile)
+			if err != nil {
+				return err
+			}
+			protection.Passphrase = passphrase
+		}
+		return exportKeys(&conf, *file, keyIDs, protection)
+	}
+	replace := flags.Bool("replace", false, "archive the key shares with the same IDs instead of failing")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		return err
+	}
+	return importKeys(&conf, *file, passphrase, *replace)
+}
+
+// exportKeys writes a backup of the keys with the IDs provided into a file, which must not exist.
+func exportKeys(conf *config.Config, path string, ids []string, protection *backup.Protection) error {
+	if path == "" {
+		return fmt.Errorf("missing backup file")
+	}
+	bundle, err := backup.NewBundle(conf, ids)
+	if err != nil {
+		return err
+	}
+	data, err := backup.Encrypt(bundle, protection)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	Log.Printf("%d RSA and %d ECDSA key shares exported to %s", len(bundle.RSA), len(bundle.ECDSA), path)
+	return nil
+}
+
+// importKeys restores the keys of a backup file into the config file of the node.
+func importKeys(conf *config.Config, path string, passphrase []byte, replace bool) error {
+	if path == "" {
+		return fmt.Errorf("missing backup file")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	bundle, err := backup.Decrypt(data, passphrase, conf.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if err := backup.Restore(conf, bundle, replace); err != nil {
+		return err
+	}
+	viper.Set("config", conf)
+	if err := server.WriteConfig(viper.GetViper()); err != nil {
+		return err
+	}
+	Log.Printf("%d RSA and %d ECDSA key shares exported from node %s on %s imported", len(bundle.RSA), len(bundle.ECDSA), bundle.Node, bundle.Created)
+	return nil
+}
+
+// listKeys prints the inventory of the key shares of the client of the node, with their signature counters and limits.
+func listKeys(conf *config.Config) error {
+	if conf.Client == nil {
+		return fmt.Errorf("missing client in config")
+	}
+	keys, err := server.Inventory(conf.Client)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ALGORITHM\tID\tSTATE\tEPOCH\tSIGNATURES\tTHIS HOUR\tLAST USED")
+	for _, key := range keys {
+		lastUsed := "never"
+		if !key.LastUsed.IsZero() {
+			lastUsed = key.LastUsed.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", key.Algorithm, key.ID, key.State, key.Epoch,
+			formatLimit(key.Signatures, key.MaxSignatures), formatLimit(key.HourSignatures, key.MaxSignaturesPerHour), lastUsed)
+	}
+	return w.Flush()
+}
+
+// formatLimit returns a signature counter, followed by its limit if it has one.
+func formatLimit(count, limit uint64) string {
+	if limit == 0 {
+		return strconv.FormatUint(count, 10)
+	}
+	return fmt.Sprintf("%d/%d", count, limit)
+}
+
+// setKeyState changes the lifecycle state of a key of the client of the node and saves the config file.
+func setKeyState(conf *config.Config, alg, keyID string, state message.KeyState, retention time.Duration) error {
+	if conf.Client == nil {
+		return fmt.Errorf("missing client in config")
+	}
+	if keyID == "" {
+		return fmt.Errorf("missing key ID")
+	}
+	if err := server.SetConfigKeyState(conf.Client, alg, keyID, state, retention); err != nil {
+		return err
+	}
+	viper.Set("config", conf)
+	if err := server.WriteConfig(viper.GetViper()); err != nil {
+		return err
+	}
+	Log.Printf("%s key %s moved to state %s", alg, keyID, state)
+	return nil
+}
+
+// runApprovals lists the requests the node holds until an operator approves them, or approves or rejects one of them.
+// It works while the node runs, which executes or rejects the request when the client polls for it again.
+func runApprovals(args []string) error {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "approve" && args[0] != "reject") {
+		return fmt.Errorf("usage: dtcnode approvals list|approve|reject [options]")
+	}
+	var conf config.Config
+	if err := viper.UnmarshalKey("config", &conf); err != nil {
+		return err
+	}
+	dir := server.ApprovalDir(&conf, viper.ConfigFileUsed())
+	flags := flag.NewFlagSet("approvals "+args[0], flag.ExitOnError)
+	if args[0] == "list" {
+		all := flags.Bool("all", false, "also list the requests already approved or rejected")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		return listApprovals(dir, *all)
+	}
+	id := flags.String("id", "", "ID of the request")
+	digest := flags.String("digest", "", "digest of the request, as listed (required to approve it)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	approval, err := server.DecideApproval(dir, *id, *digest, args[0] == "approve")
+	if err != nil {
+		return err
+	}
+	Log.Printf("Request %s with %s key %s %s", approval.ID, approval.Algorithm, approval.Key, approval.Status)
+	return nil
+}
+
+// listApprovals prints the requests held by the node. Requests already decided are only printed if all is true.
+func listApprovals(dir string, all bool) error {
+	approvals, err := server.ListApprovals(dir)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tTYPE\tALGORITHM\tKEY\tRECEIVED\tEXPIRES\tDIGEST\tDATA")
+	for _, approval := range approvals {
+		if approval.Status != server.ApprovalPending && !all {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", approval.ID, approval.Status, approval.Type, approval.Algorithm,
+			approval.Key, approval.Received.Format(time.RFC3339), approval.Expires.Format(time.RFC3339), approval.Digest,
+			strings.Join(approval.Data, ","))
+	}
+	return w.Flush()
+}
+
+// runAdmin calls the admin API of the running node through its admin socket, and prints the result.
+func runAdmin(args []string) error {
+	commands := map[string]bool{"clients": true, "keys": true, "sessions": true, "disable": true, "enable": true,
+		"loglevel": true, "selftest": true, "counters": true}
+	if len(args) == 0 || !commands[args[0]] {
+		return fmt.Errorf("usage: dtcnode admin clients|keys|sessions|disable|enable|loglevel|selftest|counters [options]")
+	}
+	var conf config.Config
+	if err := viper.UnmarshalKey("config", &conf); err != nil {
+		return err
+	}
+	var adminArgs server.AdminArgs
+	flags := flag.NewFlagSet("admin "+args[0], flag.ExitOnError)
+	socket := flags.String("socket", conf.AdminSocket, "path of the admin socket of the node")
+	flags.StringVar(&adminArgs.Client, "client", "", "public key or host of the client")
+	switch args[0] {
+	case "disable", "enable":
+		flags.StringVar(&adminArgs.Algorithm, "alg", "", "algorithm of the key: rsa, ecdsa or eddsa")
+		flags.StringVar(&adminArgs.Key, "id", "", "ID of the key")
+	case "loglevel":
+		flags.StringVar(&adminArgs.Level, "level", "", "new log level: debug, info or silent")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *socket == "" {
+		return fmt.Errorf("missing admin socket, set adminsocket in the config or use -socket")
+	}
+	admin, err := rpc.Dial("unix", *socket)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	switch args[0] {
+	case "clients":
+		var clients []*server.AdminClient
+		if err := admin.Call(server.AdminName+".Clients", &adminArgs, &clients); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "HOST\tID\tKEYS\tQUARANTINED\tHELD\tTESTED AT")
+		for _, client := range clients {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", client.Host, client.ID, client.Keys, client.Unhealthy, client.Held,
+				client.HealthyAt.Format(time.RFC3339))
+		}
+	case "keys":
+		var keys []*server.AdminKey
+		if err := admin.Call(server.AdminName+".Keys", &adminArgs, &keys); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "CLIENT\tALGORITHM\tID\tSTATE\tEPOCH\tSIGNATURES\tTHIS HOUR\tQUARANTINE")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", key.Client, key.Algorithm, key.ID, key.State, key.Epoch,
+				formatLimit(key.Signatures, key.MaxSignatures), formatLimit(key.HourSignatures, key.MaxSignaturesPerHour), key.Quarantine)
+		}
+	case "sessions":
+		var sessions []*server.AdminSession
+		if err := admin.Call(server.AdminName+".Sessions", &adminArgs, &sessions); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "CLIENT\tKEY\tSTARTED\tROUND")
+		for _, session := range sessions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", session.Client, session.Key, session.Started.Format(time.RFC3339), session.Round)
+		}
+	case "disable", "enable":
+		adminArgs.State = message.KeyDisabled
+		if args[0] == "enable" {
+			adminArgs.State = message.KeyActive
+		}
+		var key server.KeyInfo
+		if err := admin.Call(server.AdminName+".SetKeyState", &adminArgs, &key); err != nil {
+			return err
+		}
+		Log.Printf("%s key %s moved to state %s", key.Algorithm, key.ID, key.State)
+	case "loglevel":
+		var level string
+		if err := admin.Call(server.AdminName+".SetLogLevel", &adminArgs, &level); err != nil {
+			return err
+		}
+		Log.Printf("Log level changed to %s", level)
+	case "selftest":
+		var results []*message.Health
+		if err := admin.Call(server.AdminName+".SelfTest", &adminArgs, &results); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "TESTED AT\tALGORITHM\tID\tRESULT")
+		for _, health := range results {
+			for _, key := range health.Keys {
+				result := "ok"
+				if key.Error != "" {
+					result = key.Error
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", health.TestedAt.Format(time.RFC3339), key.Algorithm, key.ID, result)
+			}
+		}
+	case "counters":
+		var counters []*server.AdminCounters
+		if err := admin.Call(server.AdminName+".Counters", &adminArgs, &counters); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "CLIENT\tCOUNTER\tNAME\tVALUE")
+		for _, c := range counters {
+			fmt.Fprintf(w, "%s\tstarted\t\t%s\n", c.Client, c.Started.Format(time.RFC3339))
+			for _, name := range sortedNames(c.Requests) {
+				fmt.Fprintf(w, "%s\trequests\t%s\t%d\n", c.Client, name, c.Requests[name])
+			}
+			for _, name := range sortedNames(c.Errors) {
+				fmt.Fprintf(w, "%s\terrors\t%s\t%d\n", c.Client, name, c.Errors[name])
+			}
+			for _, m := range c.Verification {
+				fmt.Fprintf(w, "%s\tverify\t%s\t%s: %d created, %d verified, %d failed, avg %s, max %s\n", c.Client, m.Key,
+					m.Mode, m.Created, m.Verified, m.Failed, m.Average, m.Max)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// sortedNames returns the names of a map of counters in alphabetical order.
+func sortedNames(counts map[string]uint64) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readPassphrase returns the passphrase of a backup, read from a file without its trailing newline, or from the
+// DTCNODE_BACKUP_PASSPHRASE environment variable if there is no file.
+func readPassphrase(path string) ([]byte, error) {
+	if path == "" {
+		return []byte(os.Getenv("DTCNODE_BACKUP_PASSPHRASE")), nil
+	}
+	passphrase, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(passphrase), "\r\n")), nil
+}
+
+// runHarness starts a set of nodes on this process and checks that they produce valid RSA, ECDSA and EdDSA signatures.
+func runHarness(args []string) error {
+	conf := harness.DefaultConfig()
+	flags := flag.NewFlagSet("harness", flag.ExitOnError)
+	nodes := flags.Uint("n", uint(conf.Nodes), "number of nodes")
+	threshold := flags.Uint("t", uint(conf.Threshold), "number of nodes needed to sign")
+	port := flags.Uint("p", uint(conf.BasePort), "port of the first node")
+	flags.IntVar(&conf.RSABitSize, "rsa-bits", conf.RSABitSize, "bit size of the RSA key")
+	flags.StringVar(&conf.ECDSACurve, "curve", conf.ECDSACurve, "curve of the ECDSA key")
+	flags.DurationVar(&conf.Timeout, "timeout", conf.Timeout, "time to wait for each node response")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	conf.Nodes = uint8(*nodes)
+	conf.Threshold = uint8(*threshold)
+	conf.BasePort = uint16(*port)
+	if err := harness.Run(conf); err != nil {
+		return err
 	}
+	Log.Printf("RSA, ECDSA and EdDSA signatures verified with %d-of-%d nodes", conf.Threshold, conf.Nodes)
+	return nil
 }
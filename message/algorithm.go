@@ -0,0 +1,27 @@
+package message
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// EncodeAlgorithmList encodes a list of algorithm names into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the list.
+func EncodeAlgorithmList(value []string) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DecodeAlgorithmList decodes an array of bytes into a list of algorithm names, using the golang gob decoder. It returns an error if it cannot decode the list.
+func DecodeAlgorithmList(byteValue []byte) ([]string, error) {
+	var value []string
+	buffer := bytes.NewBuffer(byteValue)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
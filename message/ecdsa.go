@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"github.com/niclabs/tcecdsa"
+	"github.com/niclabs/tcecdsa/l2fhe"
 	"math/big"
 )
 
@@ -11,6 +12,14 @@ type Signature struct {
 	R, S *big.Int
 }
 
+// ECDSAPresignature is the result of the signing rounds that do not depend on the document, kept by a node until it
+// is used to sign a hash. It must be used only once: two signatures with the same presignature reveal the secret key.
+type ECDSAPresignature struct {
+	ID   string             // Presignature ID, chosen by the client.
+	R    *big.Int           // r value of the signature.
+	VHat *l2fhe.EncryptedL1 // Encrypted inverse of the nonce of the signature.
+}
+
 // EncodeECDSAKeyShare encodes a keyshare struct into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
 func EncodeECDSAKeyShare(share *tcecdsa.KeyShare) ([]byte, error) {
 	var buffer bytes.Buffer
@@ -121,6 +130,26 @@ func EncodeECDSASignature(r, s *big.Int) ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
+// EncodeECDSAPresignature encodes a Presignature struct into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeECDSAPresignature(presignature *ECDSAPresignature) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(presignature); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeECDSASigma encodes the encrypted s value of a signature into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeECDSASigma(sigma *l2fhe.EncryptedL2) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(sigma); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
 // DecodeECDSAKeyShare decodes an array of bytes into a keyshare struct, using the golang gob decode. It returns an error if it cannot decode the struct.
 func DecodeECDSAKeyShare(byteShare []byte) (*tcecdsa.KeyShare, error) {
 	var keyShare *tcecdsa.KeyShare
@@ -241,3 +270,25 @@ func DecodeECDSASignature(byteShare []byte) (*big.Int, *big.Int, error) {
 	}
 	return sig.R, sig.S, nil
 }
+
+// DecodeECDSAPresignature decodes an array of bytes into a Presignature struct, using the golang gob decode. It returns an error if it cannot decode the struct.
+func DecodeECDSAPresignature(byteShare []byte) (*ECDSAPresignature, error) {
+	var presignature ECDSAPresignature
+	buffer := bytes.NewBuffer(byteShare)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&presignature); err != nil {
+		return nil, err
+	}
+	return &presignature, nil
+}
+
+// DecodeECDSASigma decodes an array of bytes into the encrypted s value of a signature, using the golang gob decode. It returns an error if it cannot decode the struct.
+func DecodeECDSASigma(byteShare []byte) (*l2fhe.EncryptedL2, error) {
+	var sigma l2fhe.EncryptedL2
+	buffer := bytes.NewBuffer(byteShare)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&sigma); err != nil {
+		return nil, err
+	}
+	return &sigma, nil
+}
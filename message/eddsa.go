@@ -0,0 +1,113 @@
+package message
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/niclabs/dtcnode/v3/tceddsa"
+)
+
+// EncodeEdDSAKeyShare encodes a keyshare struct into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeEdDSAKeyShare(value *tceddsa.KeyShare) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeEdDSAKeyMeta encodes a keymeta struct into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeEdDSAKeyMeta(value *tceddsa.KeyMeta) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeEdDSACommitment encodes a commitment struct into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeEdDSACommitment(value *tceddsa.Commitment) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeEdDSACommitmentList encodes a CommitmentList struct into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeEdDSACommitmentList(value tceddsa.CommitmentList) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeEdDSASigShare encodes a sigshare struct into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeEdDSASigShare(value *tceddsa.SigShare) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DecodeEdDSAKeyShare decodes an array of bytes into a keyshare struct, using the golang gob decoder. It returns an error if it cannot decode the struct.
+func DecodeEdDSAKeyShare(byteValue []byte) (*tceddsa.KeyShare, error) {
+	var value *tceddsa.KeyShare
+	buffer := bytes.NewBuffer(byteValue)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// DecodeEdDSAKeyMeta decodes an array of bytes into a keymeta struct, using the golang gob decoder. It returns an error if it cannot decode the struct.
+func DecodeEdDSAKeyMeta(byteValue []byte) (*tceddsa.KeyMeta, error) {
+	var value *tceddsa.KeyMeta
+	buffer := bytes.NewBuffer(byteValue)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// DecodeEdDSACommitment decodes an array of bytes into a commitment struct, using the golang gob decoder. It returns an error if it cannot decode the struct.
+func DecodeEdDSACommitment(byteValue []byte) (*tceddsa.Commitment, error) {
+	var value *tceddsa.Commitment
+	buffer := bytes.NewBuffer(byteValue)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// DecodeEdDSACommitmentList decodes an array of bytes into a CommitmentList struct, using the golang gob decoder. It returns an error if it cannot decode the struct.
+func DecodeEdDSACommitmentList(byteValue []byte) (tceddsa.CommitmentList, error) {
+	var value tceddsa.CommitmentList
+	buffer := bytes.NewBuffer(byteValue)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// DecodeEdDSASigShare decodes an array of bytes into a sigshare struct, using the golang gob decoder. It returns an error if it cannot decode the struct.
+func DecodeEdDSASigShare(byteValue []byte) (*tceddsa.SigShare, error) {
+	var value *tceddsa.SigShare
+	buffer := bytes.NewBuffer(byteValue)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
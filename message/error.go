@@ -21,23 +21,60 @@ const (
 	DocSignError
 	// Internal Errors (I/O)
 	InternalError
+	// Replay protection errors
+	ReplayedMessageError
+	// Policy errors
+	PermissionDeniedError
+	// Key management errors
+	KeyAlreadyExistsError
+	// Decryption errors
+	DecryptError
+	// Algorithm errors
+	AlgorithmNotAllowedError
+	// Refresh errors
+	EpochMismatchError
+	// Self-test errors
+	KeyQuarantinedError
+	// Lifecycle errors
+	KeyStateError
+	// Usage errors
+	SignatureLimitError
+	// Approval errors
+	ApprovalPendingError
+	ApprovalRejectedError
+	// Presignature errors
+	PresignatureNotFoundError
+	PresignaturePoolFullError
 	// Invalid error number (keep at the end)
 	UnknownError = NodeError(1<<8 - 1)
 )
 
 // ErrorToString maps the error codes to string message. Useful for debugging.
 var ErrorToString = map[NodeError]string{
-	Ok:                  "not an error",
-	InvalidMessageError: "invalid message",
-	ReceiveMessageError: "cannot receive message",
-	ParseMessageError:   "cannot parse received message",
-	SendResponseError:   "cannot send response",
-	EncodingError:       "cannot encode a struct to a message",
-	DecodingError:       "cannot decode received struct",
-	KeyNotFoundError:    "key not found in the node",
-	DocSignError:        "cannot sign the document",
-	InternalError:       "internal input/output error",
-	UnknownError:        "unknown error",
+	Ok:                        "not an error",
+	InvalidMessageError:       "invalid message",
+	ReceiveMessageError:       "cannot receive message",
+	ParseMessageError:         "cannot parse received message",
+	SendResponseError:         "cannot send response",
+	EncodingError:             "cannot encode a struct to a message",
+	DecodingError:             "cannot decode received struct",
+	KeyNotFoundError:          "key not found in the node",
+	DocSignError:              "cannot sign the document",
+	InternalError:             "internal input/output error",
+	ReplayedMessageError:      "message replayed or outside of the accepted time window",
+	PermissionDeniedError:     "operation not allowed by the key policy",
+	KeyAlreadyExistsError:     "a key with the same ID already exists in the node",
+	DecryptError:              "cannot compute the decryption share",
+	AlgorithmNotAllowedError:  "algorithm or curve not supported or not allowed for the client",
+	EpochMismatchError:        "refresh epoch does not follow the epoch of the key share",
+	KeyQuarantinedError:       "key share failed the self-test and is quarantined",
+	KeyStateError:             "operation not allowed in the lifecycle state of the key",
+	SignatureLimitError:       "the key share reached its signature limit",
+	ApprovalPendingError:      "the request is waiting for the approval of an operator of the node",
+	ApprovalRejectedError:     "an operator of the node rejected the request, or did not approve it in time",
+	PresignatureNotFoundError: "presignature not found in the node, or already used",
+	PresignaturePoolFullError: "the key share has the maximum number of presignatures",
+	UnknownError:              "unknown error",
 }
 
 func (err NodeError) Error() string {
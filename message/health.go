@@ -0,0 +1,52 @@
+package message
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+)
+
+// KeyHealth is the result of the self-test of a key share.
+type KeyHealth struct {
+	Algorithm string // Name of the threshold scheme of the key.
+	ID        string // Key ID.
+	Error     string // Reason why the key share failed the self-test, and was quarantined. Empty if it passed.
+}
+
+// Health is the result of the last self-test of the key shares of a node.
+type Health struct {
+	TestedAt time.Time    // Time of the last self-test.
+	Keys     []*KeyHealth // Result of each key share, sorted by algorithm and key ID.
+}
+
+// Quarantined returns the number of key shares that failed the self-test.
+func (health *Health) Quarantined() int {
+	n := 0
+	for _, key := range health.Keys {
+		if key.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// EncodeHealth encodes the health of a node as a byte array.
+func EncodeHealth(health *Health) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(health); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DecodeHealth decodes the health of a node from a byte array.
+func DecodeHealth(byteHealth []byte) (*Health, error) {
+	var health *Health
+	buffer := bytes.NewBuffer(byteHealth)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
@@ -0,0 +1,37 @@
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// KeyState is the lifecycle state of a key share in a node.
+type KeyState string
+
+// The following consts represent the lifecycle states of a key share.
+const (
+	KeyPendingInit     KeyState = "pending-init"     // ECDSA key share waiting for the key init messages. It cannot sign yet.
+	KeyActive          KeyState = "active"           // Key share that can be used. It is the default state.
+	KeyDisabled        KeyState = "disabled"         // Key share kept by the node, but refusing to sign or decrypt.
+	KeyPendingDeletion KeyState = "pending-deletion" // Key share that is deleted when its retention period ends.
+)
+
+// EncodeRetention encodes the retention period of a key share pending deletion into an array of bytes, in seconds.
+func EncodeRetention(retention time.Duration) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(retention/time.Second))
+	return b
+}
+
+// DecodeRetention decodes the retention period of a key share pending deletion from an array of bytes.
+func DecodeRetention(b []byte) (time.Duration, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("retention should have 8 bytes, but it has %d", len(b))
+	}
+	seconds := binary.BigEndian.Uint64(b)
+	if seconds > uint64(1<<63-1)/uint64(time.Second) {
+		return 0, fmt.Errorf("retention too long")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -1,9 +1,14 @@
 package message
 
 import (
+	"encoding/binary"
 	"fmt"
+	"time"
 )
 
+// HeaderLength is the number of fields of the message header. The rest of the fields are data.
+const HeaderLength = 7
+
 // Message represents a generic message which is sent between server and nodes.
 type Message struct {
 	From       string    // Identification for the sender node.
@@ -11,13 +16,18 @@ type Message struct {
 	ID         string    // Random hex ID for the message. Useful to do follow ups
 	Type       Type      // Type of the message.
 	Error      NodeError // An error code. It is 0 if the message is ok.
+	Timestamp  int64     // Creation time of the message, in nanoseconds since the Unix epoch.
+	Nonce      string    // Random hex value. A node does not accept two requests with the same nonce.
 	Data       [][]byte  // A list of byte arrays with the binary data of the message.
 }
 
 // FromBytes transforms a raw array of array of bytes into a message, or returns an error if it can't transform the message.
 func FromBytes(rawMsg [][]byte) (*Message, error) {
-	if len(rawMsg) < 5 { // header is dealer ID, rest is message struct.
-		return nil, fmt.Errorf("bad byte array length: %d instead of 5", len(rawMsg))
+	if len(rawMsg) < HeaderLength { // header is dealer ID, rest is message struct.
+		return nil, fmt.Errorf("bad byte array length: %d instead of %d", len(rawMsg), HeaderLength)
+	}
+	if len(rawMsg[3]) != 1 || len(rawMsg[4]) != 1 || len(rawMsg[5]) != 8 {
+		return nil, fmt.Errorf("bad header field length")
 	}
 	return &Message{
 		From:       string(rawMsg[0]),
@@ -25,22 +35,30 @@ func FromBytes(rawMsg [][]byte) (*Message, error) {
 		ID:         string(rawMsg[2]),
 		Type:       Type(rawMsg[3][0]),
 		Error:      NodeError(rawMsg[4][0]),
-		Data:       rawMsg[5:],
+		Timestamp:  int64(binary.BigEndian.Uint64(rawMsg[5])),
+		Nonce:      string(rawMsg[6]),
+		Data:       rawMsg[HeaderLength:],
 	}, nil
 }
 
 // NewMessage creates a new message using the arguments provided, or returns an error if it cannot create the message object
-//(related currently to a problem in the generation of message IDs)
+// (related currently to a problem in the generation of message IDs)
 func NewMessage(rType Type, from string, msgs ...[]byte) (*Message, error) {
 	id, err := GetRandomHexString(6)
 	if err != nil {
 		return nil, err
 	}
+	nonce, err := GetRandomHexString(12)
+	if err != nil {
+		return nil, err
+	}
 	req := &Message{
-		From: from,
-		ID:   id,
-		Type: rType,
-		Data: make([][]byte, 0),
+		From:      from,
+		ID:        id,
+		Type:      rType,
+		Timestamp: time.Now().UnixNano(),
+		Nonce:     nonce,
+		Data:      make([][]byte, 0),
 	}
 	req.Data = append(req.Data, msgs...)
 	return req, nil
@@ -48,12 +66,16 @@ func NewMessage(rType Type, from string, msgs ...[]byte) (*Message, error) {
 
 // GetBytesLists transforms a message into an array of arrays of bytes, useful to send the message to the other end.
 func (message *Message) GetBytesLists() []interface{} {
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(message.Timestamp))
 	b := []interface{}{
 		[]byte(message.From),
 		[]byte(message.ResponseOf),
 		[]byte(message.ID),
 		[]byte{byte(message.Type)},
 		[]byte{byte(message.Error)},
+		timestamp,
+		[]byte(message.Nonce),
 	}
 	for _, datum := range message.Data {
 		b = append(b, datum)
@@ -67,6 +89,7 @@ func (message *Message) AddMessage(data []byte) {
 }
 
 // NewResponse creates a new message with some fields copied from another message. This method is useful to create replies quickly. It receives a default status code as argument and the new Node ID.
+// The response keeps the nonce of the original message.
 func (message *Message) NewResponse(ourID string, status NodeError) *Message {
 	return &Message{
 		From:       ourID,
@@ -74,13 +97,15 @@ func (message *Message) NewResponse(ourID string, status NodeError) *Message {
 		ID:         message.ID,
 		Type:       message.Type,
 		Error:      status,
+		Timestamp:  time.Now().UnixNano(),
+		Nonce:      message.Nonce,
 		Data:       make([][]byte, 0),
 	}
 }
 
-// ValidClientDataLength returns true if the number of data fields is equal to the expected in a message sent by the client.
-func (message *Message) ValidClientDataLength() bool {
-	return len(message.Data) == message.Type.ClientDataLength()
+// Time returns the creation time of the message.
+func (message *Message) Time() time.Time {
+	return time.Unix(0, message.Timestamp)
 }
 
 // ValidNodeDataLength returns true if the number of data fields is equal to the expected in a message sent by the node.
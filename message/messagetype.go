@@ -15,49 +15,119 @@ const (
 	ECDSARound3
 	ECDSAGetSignature
 	DeleteECDSAKeyShare
+	ReplaceRSAKeyShare
+	ReplaceECDSAKeyShare
+	// GetRSADecryptShare asks for the ciphertext raised to the key share, without padding. It is also a signature
+	// share of any value the client chooses as ciphertext, so nodes only answer it to clients allowed to sign with the
+	// key, and count it as a signature.
+	GetRSADecryptShare
+	GetRSASigShareBatch
+	SendEdDSAKeyShare
+	ReplaceEdDSAKeyShare
+	EdDSARound1
+	EdDSARound2
+	DeleteEdDSAKeyShare
+	GetAlgorithms
+	RefreshRSAKeyShare
+	CommitRSAKeyShareRefresh
+	AbortRSAKeyShareRefresh
+	RefreshECDSAKeyShare
+	CommitECDSAKeyShareRefresh
+	AbortECDSAKeyShareRefresh
+	DealRSAKeyShare
+	ReshareRSAKeyShare
+	DealECDSAKeyShare
+	ReshareECDSAKeyShare
+	GetHealth
+	RunSelfTest
+	SetKeyState
+	ECDSAPresignRound1
+	ECDSAPresignRound2
+	ECDSAPresignRound3
+	ECDSASignPresigned
 )
 
 // TypeToString transforms a message type into a string. Useful for debugging.
 var TypeToString = map[Type]string{
-	None:                "Undefined type",
-	SendRSAKeyShare:     "RSA Send Key Share",
-	GetRSASigShare:      "RSA Ask for Signature Share",
-	DeleteRSAKeyShare:   "RSA Delete Key Share",
-	SendECDSAKeyShare:   "ECDSA Send Key Share",
-	ECDSAInitKeys:       "ECDSA Initialize RSAKeys",
-	ECDSARound1:         "ECDSA Round 1",
-	ECDSARound2:         "ECDSA Round 2",
-	ECDSARound3:         "ECDSA Round 3",
-	ECDSAGetSignature:   "ECDSA Get Signature",
-	DeleteECDSAKeyShare: "ECDSA Delete Key Share",
-}
-
-var TypeToClientDataLength = map[Type]int{
-	None:                0,
-	SendRSAKeyShare:     3, // keyID, keyShare, keyMeta -> {}
-	GetRSASigShare:      2, // keyID, hash -> sigShare
-	DeleteRSAKeyShare:   1, // keyID -> {}
-	SendECDSAKeyShare:   3, // keyID, keyShare, keyMeta -> InitKeyMessage
-	ECDSAInitKeys:       2, // keyID, InitKeyMessageList -> {}
-	ECDSARound1:         2, // keyID, hash -> Round1Message
-	ECDSARound2:         1, // Round1MessageList -> Round2Message
-	ECDSARound3:         1, // Round2MessageList -> Round3Message
-	ECDSAGetSignature:   1, // Round3MessageList -> r, s
-	DeleteECDSAKeyShare: 1, // keyID -> {}
+	None:                       "Undefined type",
+	SendRSAKeyShare:            "RSA Send Key Share",
+	GetRSASigShare:             "RSA Ask for Signature Share",
+	DeleteRSAKeyShare:          "RSA Delete Key Share",
+	SendECDSAKeyShare:          "ECDSA Send Key Share",
+	ECDSAInitKeys:              "ECDSA Initialize RSAKeys",
+	ECDSARound1:                "ECDSA Round 1",
+	ECDSARound2:                "ECDSA Round 2",
+	ECDSARound3:                "ECDSA Round 3",
+	ECDSAGetSignature:          "ECDSA Get Signature",
+	DeleteECDSAKeyShare:        "ECDSA Delete Key Share",
+	ReplaceRSAKeyShare:         "RSA Replace Key Share",
+	ReplaceECDSAKeyShare:       "ECDSA Replace Key Share",
+	GetRSADecryptShare:         "RSA Ask for Decryption Share",
+	GetRSASigShareBatch:        "RSA Ask for a Batch of Signature Shares",
+	SendEdDSAKeyShare:          "EdDSA Send Key Share",
+	ReplaceEdDSAKeyShare:       "EdDSA Replace Key Share",
+	EdDSARound1:                "EdDSA Round 1",
+	EdDSARound2:                "EdDSA Round 2",
+	DeleteEdDSAKeyShare:        "EdDSA Delete Key Share",
+	GetAlgorithms:              "Get Supported Algorithms",
+	RefreshRSAKeyShare:         "RSA Refresh Key Share",
+	CommitRSAKeyShareRefresh:   "RSA Commit Key Share Refresh",
+	AbortRSAKeyShareRefresh:    "RSA Abort Key Share Refresh",
+	RefreshECDSAKeyShare:       "ECDSA Refresh Key Share",
+	CommitECDSAKeyShareRefresh: "ECDSA Commit Key Share Refresh",
+	AbortECDSAKeyShareRefresh:  "ECDSA Abort Key Share Refresh",
+	DealRSAKeyShare:            "RSA Deal Key Share",
+	ReshareRSAKeyShare:         "RSA Reshare Key Share",
+	DealECDSAKeyShare:          "ECDSA Deal Key Share",
+	ReshareECDSAKeyShare:       "ECDSA Reshare Key Share",
+	GetHealth:                  "Get Node Health",
+	RunSelfTest:                "Run Key Share Self-Test",
+	SetKeyState:                "Set Key Lifecycle State",
+	ECDSAPresignRound1:         "ECDSA Presign Round 1",
+	ECDSAPresignRound2:         "ECDSA Presign Round 2",
+	ECDSAPresignRound3:         "ECDSA Presign Round 3",
+	ECDSASignPresigned:         "ECDSA Sign with Presignature",
 }
 
 var TypeToNodeDataLength = map[Type]int{
-	None:                0,
-	SendRSAKeyShare:     0, // keyID, keyShare, keyMeta -> {}
-	GetRSASigShare:      1, // keyID, hash -> sigShare
-	DeleteRSAKeyShare:   0, // keyID -> {}
-	SendECDSAKeyShare:   1, // keyID, keyShare, keyMeta -> InitKeyMessage
-	ECDSAInitKeys:       0, // keyID, InitKeyMessageList -> {}
-	ECDSARound1:         1, // keyID, hash -> Round1Message
-	ECDSARound2:         1, // Round1MessageList -> Round2Message
-	ECDSARound3:         1, // Round2MessageList -> Round3Message
-	ECDSAGetSignature:   1, // Round3MessageList -> (r, s)
-	DeleteECDSAKeyShare: 0, // keyID -> {}
+	None:                       0,
+	SendRSAKeyShare:            0, // keyID, keyShare, keyMeta -> {}
+	GetRSASigShare:             1, // keyID, hash, mechanism -> sigShare
+	DeleteRSAKeyShare:          1, // keyID -> wipe
+	SendECDSAKeyShare:          1, // keyID, keyShare, keyMeta -> InitKeyMessage
+	ECDSAInitKeys:              0, // keyID, InitKeyMessageList -> {}
+	ECDSARound1:                1, // keyID, hash -> Round1Message
+	ECDSARound2:                1, // Round1MessageList -> Round2Message
+	ECDSARound3:                1, // Round2MessageList -> Round3Message
+	ECDSAGetSignature:          1, // Round3MessageList -> (r, s)
+	DeleteECDSAKeyShare:        1, // keyID -> wipe
+	ReplaceRSAKeyShare:         0, // keyID, keyShare, keyMeta -> {}
+	ReplaceECDSAKeyShare:       1, // keyID, keyShare, keyMeta -> InitKeyMessage
+	GetRSADecryptShare:         1, // keyID, ciphertext -> decryptShare
+	GetRSASigShareBatch:        1, // keyID, hashList, mechanism -> batchSigShareList
+	SendEdDSAKeyShare:          0, // keyID, keyShare, keyMeta -> {}
+	ReplaceEdDSAKeyShare:       0, // keyID, keyShare, keyMeta -> {}
+	EdDSARound1:                1, // keyID -> Commitment
+	EdDSARound2:                1, // keyID, message, CommitmentList -> sigShare
+	DeleteEdDSAKeyShare:        1, // keyID -> wipe
+	GetAlgorithms:              1, // {} -> algorithmList
+	RefreshRSAKeyShare:         0, // keyID, epoch, delta, keyMeta -> {}
+	CommitRSAKeyShareRefresh:   0, // keyID, epoch -> {}
+	AbortRSAKeyShareRefresh:    0, // keyID, epoch -> {}
+	RefreshECDSAKeyShare:       0, // keyID, epoch, delta, keyMeta -> {}
+	CommitECDSAKeyShareRefresh: 0, // keyID, epoch -> {}
+	AbortECDSAKeyShareRefresh:  0, // keyID, epoch -> {}
+	DealRSAKeyShare:            1, // keyID, reshare -> dealing
+	ReshareRSAKeyShare:         0, // keyID, epoch, index, keyMeta, newKeyMeta, dealingList -> {}
+	DealECDSAKeyShare:          2, // keyID, reshare -> dealing, publicShare
+	ReshareECDSAKeyShare:       0, // keyID, epoch, index, keyMeta, newKeyMeta, dealingList, publicShare -> {}
+	GetHealth:                  1, // {} -> health
+	RunSelfTest:                1, // {} -> health
+	SetKeyState:                0, // algorithm, keyID, state, retention -> {}
+	ECDSAPresignRound1:         1, // keyID, presignatureID -> Round1Message
+	ECDSAPresignRound2:         1, // keyID, presignatureID, Round1MessageList -> Round2Message
+	ECDSAPresignRound3:         0, // keyID, presignatureID, Round2MessageList -> {}
+	ECDSASignPresigned:         2, // keyID, presignatureID, hash -> Round3Message, sigma
 }
 
 func (mType Type) String() string {
@@ -68,23 +138,6 @@ func (mType Type) String() string {
 	}
 }
 
-// Returns true if the message is of type RSA, and false if it is not.
-func (mType Type) IsRSA() bool {
-	return mType >= SendRSAKeyShare && mType <= DeleteRSAKeyShare
-}
-
-// IsECDSA returns true if the message is of type ECDSA, and false if it is not.
-func (mType Type) IsECDSA() bool {
-	return mType >= SendECDSAKeyShare && mType <= DeleteECDSAKeyShare
-}
-
-func (mType Type) ClientDataLength() int {
-	if length, ok := TypeToClientDataLength[mType]; ok {
-		return length
-	}
-	return 0
-}
-
 func (mType Type) NodeDataLength() int {
 	if length, ok := TypeToNodeDataLength[mType]; ok {
 		return length
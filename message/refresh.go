@@ -0,0 +1,21 @@
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EncodeEpoch encodes the epoch of a key share refresh into an array of bytes.
+func EncodeEpoch(epoch uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, epoch)
+	return b
+}
+
+// DecodeEpoch decodes an array of bytes into the epoch of a key share refresh. It returns an error if the array does not have 8 bytes.
+func DecodeEpoch(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("epoch has %d bytes, but it should have 8", len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
@@ -0,0 +1,96 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/niclabs/dtcnode/v3/refresh"
+)
+
+// Reshare represents the parameters of a resharing, sent to the nodes dealing the new key shares.
+type Reshare struct {
+	Dealers    []int    // Indexes of the key shares of the nodes dealing the new shares.
+	K          int      // Number of new key shares needed to sign.
+	L          int      // Number of new key shares.
+	Recipients [][]byte // Curve25519 public keys of the nodes receiving the new key shares, ordered by share index.
+}
+
+// EncodeReshare encodes the parameters of a resharing into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeReshare(reshare *Reshare) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(reshare); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DecodeReshare decodes an array of bytes into the parameters of a resharing, using the golang gob decoder. It returns an error if it cannot decode the struct.
+func DecodeReshare(byteReshare []byte) (*Reshare, error) {
+	var reshare Reshare
+	buffer := bytes.NewBuffer(byteReshare)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&reshare); err != nil {
+		return nil, err
+	}
+	return &reshare, nil
+}
+
+// EncodeDealing encodes a dealing struct into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the struct.
+func EncodeDealing(dealing *refresh.Dealing) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(dealing); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeDealingList encodes a list of dealings into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the list.
+func EncodeDealingList(dealings []*refresh.Dealing) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(dealings); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DecodeDealing decodes an array of bytes into a dealing struct, using the golang gob decoder. It returns an error if it cannot decode the struct.
+func DecodeDealing(byteDealing []byte) (*refresh.Dealing, error) {
+	var dealing refresh.Dealing
+	buffer := bytes.NewBuffer(byteDealing)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&dealing); err != nil {
+		return nil, err
+	}
+	return &dealing, nil
+}
+
+// DecodeDealingList decodes an array of bytes into a list of dealings, using the golang gob decoder. It returns an error if it cannot decode the list.
+func DecodeDealingList(byteList []byte) ([]*refresh.Dealing, error) {
+	var dealings []*refresh.Dealing
+	buffer := bytes.NewBuffer(byteList)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&dealings); err != nil {
+		return nil, err
+	}
+	return dealings, nil
+}
+
+// EncodeShareIndex encodes the index of a key share into an array of bytes.
+func EncodeShareIndex(index int) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(index))
+	return b
+}
+
+// DecodeShareIndex decodes an array of bytes into the index of a key share. It returns an error if the array does not have 2 bytes.
+func DecodeShareIndex(b []byte) (int, error) {
+	if len(b) != 2 {
+		return 0, fmt.Errorf("share index has %d bytes, but it should have 2", len(b))
+	}
+	return int(binary.BigEndian.Uint16(b)), nil
+}
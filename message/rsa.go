@@ -68,3 +68,55 @@ func DecodeRSASigShare(byteShare []byte) (*tcrsa.SigShare, error) {
 	}
 	return &sigShare, nil
 }
+
+// MaxRSABatchSize is the maximum number of documents a GetRSASigShareBatch message can carry.
+const MaxRSABatchSize = 4096
+
+// RSABatchSigShare is the result of signing one of the documents of a GetRSASigShareBatch message. If Error is not Ok,
+// SigShare is nil.
+type RSABatchSigShare struct {
+	Error    NodeError
+	SigShare *tcrsa.SigShare
+}
+
+// EncodeRSAHashList encodes a list of document hashes into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the list.
+func EncodeRSAHashList(hashes [][]byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(hashes); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DecodeRSAHashList decodes an array of bytes into a list of document hashes, using the golang gob decoder. It returns an error if it cannot decode the list.
+func DecodeRSAHashList(byteList []byte) ([][]byte, error) {
+	var hashes [][]byte
+	buffer := bytes.NewBuffer(byteList)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&hashes); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// EncodeRSABatchSigShares encodes a list of batch sig shares into an array of bytes, using the golang gob encoder. It returns an error if it cannot encode the list.
+func EncodeRSABatchSigShares(shares []*RSABatchSigShare) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(shares); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DecodeRSABatchSigShares decodes an array of bytes into a list of batch sig shares, using the golang gob decoder. It returns an error if it cannot decode the list.
+func DecodeRSABatchSigShares(byteList []byte) ([]*RSABatchSigShare, error) {
+	var shares []*RSABatchSigShare
+	buffer := bytes.NewBuffer(byteList)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
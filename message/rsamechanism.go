@@ -0,0 +1,146 @@
+package message
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/niclabs/tcrsa"
+)
+
+// RSAMechanism identifies how the document of a GetRSASigShare message is encoded before signing it.
+type RSAMechanism byte
+
+const (
+	// RSARaw means the document was already encoded by the client, and it is signed as is.
+	RSARaw RSAMechanism = iota
+	// RSAPKCS1v15 means the document is a DigestInfo structure, and the node pads it using PKCS#1 v1.5.
+	RSAPKCS1v15
+	// The following mechanisms mean the document is a hash of the specified function, and the node encodes and pads it
+	// using PKCS#1 v1.5.
+	RSAPKCS1v15SHA1
+	RSAPKCS1v15SHA224
+	RSAPKCS1v15SHA256
+	RSAPKCS1v15SHA384
+	RSAPKCS1v15SHA512
+	// The following mechanisms mean the document is a message representative already encoded by the client using
+	// EMSA-PSS with the specified hash function. The node checks its format and signs it as is.
+	RSAPSSSHA1
+	RSAPSSSHA224
+	RSAPSSSHA256
+	RSAPSSSHA384
+	RSAPSSSHA512
+)
+
+// RSAMechanismToHash maps the mechanisms to the hash functions they use. Mechanisms that do not hash are mapped to 0.
+var RSAMechanismToHash = map[RSAMechanism]crypto.Hash{
+	RSARaw:            0,
+	RSAPKCS1v15:       0,
+	RSAPKCS1v15SHA1:   crypto.SHA1,
+	RSAPKCS1v15SHA224: crypto.SHA224,
+	RSAPKCS1v15SHA256: crypto.SHA256,
+	RSAPKCS1v15SHA384: crypto.SHA384,
+	RSAPKCS1v15SHA512: crypto.SHA512,
+	RSAPSSSHA1:        crypto.SHA1,
+	RSAPSSSHA224:      crypto.SHA224,
+	RSAPSSSHA256:      crypto.SHA256,
+	RSAPSSSHA384:      crypto.SHA384,
+	RSAPSSSHA512:      crypto.SHA512,
+}
+
+// RSAMechanismToString transforms a mechanism into a string. Useful for debugging.
+var RSAMechanismToString = map[RSAMechanism]string{
+	RSARaw:            "RSA Raw",
+	RSAPKCS1v15:       "RSA PKCS#1 v1.5",
+	RSAPKCS1v15SHA1:   "RSA PKCS#1 v1.5 with SHA-1",
+	RSAPKCS1v15SHA224: "RSA PKCS#1 v1.5 with SHA-224",
+	RSAPKCS1v15SHA256: "RSA PKCS#1 v1.5 with SHA-256",
+	RSAPKCS1v15SHA384: "RSA PKCS#1 v1.5 with SHA-384",
+	RSAPKCS1v15SHA512: "RSA PKCS#1 v1.5 with SHA-512",
+	RSAPSSSHA1:        "RSA PSS with SHA-1",
+	RSAPSSSHA224:      "RSA PSS with SHA-224",
+	RSAPSSSHA256:      "RSA PSS with SHA-256",
+	RSAPSSSHA384:      "RSA PSS with SHA-384",
+	RSAPSSSHA512:      "RSA PSS with SHA-512",
+}
+
+// DecodeRSAMechanism transforms a data field into a mechanism. It returns an error if the mechanism is unknown.
+func DecodeRSAMechanism(data []byte) (RSAMechanism, error) {
+	if len(data) != 1 {
+		return 0, fmt.Errorf("bad mechanism length: %d instead of 1", len(data))
+	}
+	mechanism := RSAMechanism(data[0])
+	if _, ok := RSAMechanismToHash[mechanism]; !ok {
+		return 0, fmt.Errorf("unknown mechanism %d", mechanism)
+	}
+	return mechanism, nil
+}
+
+// Bytes transforms a mechanism into a data field.
+func (mechanism RSAMechanism) Bytes() []byte {
+	return []byte{byte(mechanism)}
+}
+
+func (mechanism RSAMechanism) String() string {
+	if name, ok := RSAMechanismToString[mechanism]; ok {
+		return name
+	}
+	return "Unknown Mechanism"
+}
+
+// Hash returns the hash function used by the mechanism, or 0 if it does not hash.
+func (mechanism RSAMechanism) Hash() crypto.Hash {
+	return RSAMechanismToHash[mechanism]
+}
+
+// IsPSS returns true if the mechanism expects a message representative encoded with EMSA-PSS.
+func (mechanism RSAMechanism) IsPSS() bool {
+	return mechanism >= RSAPSSSHA1 && mechanism <= RSAPSSSHA512
+}
+
+// Encode validates the length of a document signed with the mechanism, and returns it encoded and padded, ready to be
+// signed with the key shares of the key with the meta information provided.
+func (mechanism RSAMechanism) Encode(doc []byte, meta *tcrsa.KeyMeta) ([]byte, error) {
+	size := meta.PublicKey.Size()
+	switch mechanism {
+	case RSARaw:
+		if len(doc) != size {
+			return nil, fmt.Errorf("document length is %d, but it should be %d", len(doc), size)
+		}
+		return doc, nil
+	case RSAPKCS1v15:
+		return tcrsa.PrepareDocumentHash(size, 0, doc)
+	case RSAPSSSHA1, RSAPSSSHA224, RSAPSSSHA256, RSAPSSSHA384, RSAPSSSHA512:
+		if err := checkPSSEncoding(doc, meta.PublicKey.N.BitLen()-1, mechanism.Hash()); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	default:
+		hash := mechanism.Hash()
+		if hash == 0 {
+			return nil, fmt.Errorf("unknown mechanism %d", mechanism)
+		}
+		if len(doc) != hash.Size() {
+			return nil, fmt.Errorf("hash length is %d, but it should be %d for %s", len(doc), hash.Size(), mechanism)
+		}
+		return tcrsa.PrepareDocumentHash(size, hash, doc)
+	}
+}
+
+// checkPSSEncoding checks the format of a message representative encoded with EMSA-PSS, as defined in RFC 8017,
+// section 9.1.1. It cannot check the hash and salt, because they are masked and the node does not know the message.
+func checkPSSEncoding(em []byte, emBits int, hash crypto.Hash) error {
+	emLen := (emBits + 7) / 8
+	if len(em) != emLen {
+		return fmt.Errorf("encoded message length is %d, but it should be %d", len(em), emLen)
+	}
+	if emLen < hash.Size()+2 {
+		return fmt.Errorf("key is too small for PSS with hash length %d", hash.Size())
+	}
+	if em[emLen-1] != 0xbc {
+		return fmt.Errorf("encoded message does not end with 0xbc")
+	}
+	if em[0]&^(0xff>>uint(8*emLen-emBits)) != 0 {
+		return fmt.Errorf("encoded message leftmost bits are not zero")
+	}
+	return nil
+}
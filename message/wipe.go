@@ -0,0 +1,40 @@
+package message
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// Wipe is the report of the deletion of a key in a node.
+type Wipe struct {
+	Shares   int  // Key shares overwritten with zeros in memory, including pending and received ones.
+	Archived int  // Archived key shares with the same key ID removed from the config file.
+	Disk     bool // True if the previous config file was overwritten with zeros on disk.
+}
+
+func (wipe *Wipe) String() string {
+	return fmt.Sprintf("%d key shares zeroed in memory, %d archived key shares removed, previous config file overwritten: %t",
+		wipe.Shares, wipe.Archived, wipe.Disk)
+}
+
+// EncodeWipe encodes the report of the deletion of a key as a byte array.
+func EncodeWipe(wipe *Wipe) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(wipe); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DecodeWipe decodes the report of the deletion of a key from a byte array.
+func DecodeWipe(byteWipe []byte) (*Wipe, error) {
+	var wipe *Wipe
+	buffer := bytes.NewBuffer(byteWipe)
+	decoder := gob.NewDecoder(buffer)
+	if err := decoder.Decode(&wipe); err != nil {
+		return nil, err
+	}
+	return wipe, nil
+}
@@ -0,0 +1,267 @@
+package refresh
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/niclabs/tcecdsa"
+	"github.com/niclabs/tcpaillier"
+)
+
+// NewECDSARefresh creates a refresh of a tcecdsa key. Only the shares of the threshold Paillier key that protects the
+// ECDSA secret are refreshed. It returns the delta of each key share, ordered by share index, and the key meta with the
+// verification keys of the refreshed shares.
+func NewECDSARefresh(meta *tcecdsa.KeyMeta) ([]*big.Int, *tcecdsa.KeyMeta, error) {
+	paillier := meta.Paillier
+	deltas, err := zeroSharing(int(paillier.K), int(paillier.L), 2*paillier.N.BitLen()+StatisticalSecurity)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(paillier.Vi) != len(deltas) {
+		return nil, nil, fmt.Errorf("key meta has %d verification keys, but it should have %d", len(paillier.Vi), len(deltas))
+	}
+	mod := paillier.Cache().NToSPlusOne
+	newPaillier := *paillier
+	newPaillier.Vi = make([]*big.Int, len(deltas))
+	for i, delta := range deltas {
+		exp := new(big.Int).Mul(paillier.Delta, delta)
+		vi := new(big.Int).Exp(paillier.V, exp, mod)
+		newPaillier.Vi[i] = vi.Mul(vi, paillier.Vi[i]).Mod(vi, mod)
+	}
+	newPubKey := *meta.PubKey
+	newPubKey.Paillier = &newPaillier
+	newMeta := *meta
+	newMeta.PubKey = &newPubKey
+	return deltas, &newMeta, nil
+}
+
+// RefreshECDSAKeyShare adds a delta to the threshold Paillier share of a tcecdsa key share. It returns an error if the
+// new key meta is not a refresh of the old one, or if the refreshed share does not match its new verification key.
+func RefreshECDSAKeyShare(share *tcecdsa.KeyShare, delta *big.Int, meta, newMeta *tcecdsa.KeyMeta) (*tcecdsa.KeyShare, error) {
+	if err := checkECDSAKeyMeta(meta, newMeta); err != nil {
+		return nil, err
+	}
+	paillier := newMeta.Paillier
+	if share.PaillierShare == nil || share.PaillierShare.Index < 1 || share.PaillierShare.Index > paillier.L {
+		return nil, fmt.Errorf("invalid key share")
+	}
+	if delta.Sign() < 0 {
+		return nil, fmt.Errorf("delta must not be negative")
+	}
+	si := new(big.Int).Add(share.PaillierShare.Si, delta)
+	exp := new(big.Int).Mul(paillier.Delta, si)
+	if new(big.Int).Exp(paillier.V, exp, paillier.Cache().NToSPlusOne).Cmp(paillier.Vi[share.PaillierShare.Index-1]) != 0 {
+		return nil, fmt.Errorf("refreshed key share does not match its verification key")
+	}
+	paillierShare := *share.PaillierShare
+	paillierShare.PubKey = paillier
+	paillierShare.Si = si
+	newShare := *share
+	newShare.PaillierShare = &paillierShare
+	return &newShare, nil
+}
+
+// checkECDSAKeyMeta returns an error if the new key meta has a different curve, Paillier public key or threshold than
+// the old one, or if its verification keys are not a refresh of the old ones.
+func checkECDSAKeyMeta(meta, newMeta *tcecdsa.KeyMeta) error {
+	if err := checkSameECDSAKey(meta, newMeta); err != nil {
+		return err
+	}
+	old, paillier := meta.Paillier, newMeta.Paillier
+	if old.Delta.Cmp(paillier.Delta) != 0 || old.Constant.Cmp(paillier.Constant) != 0 {
+		return fmt.Errorf("the Paillier public key changed")
+	}
+	if old.K != paillier.K || old.L != paillier.L {
+		return fmt.Errorf("the threshold changed")
+	}
+	if len(old.Vi) != int(old.L) {
+		return fmt.Errorf("key meta has %d verification keys, but it should have %d", len(old.Vi), old.L)
+	}
+	mod := old.Cache().NToSPlusOne
+	r, err := ratios(old.Vi, paillier.Vi, mod)
+	if err != nil {
+		return err
+	}
+	return checkZeroSharing(r, int(old.K), old.Delta, mod)
+}
+
+// checkSameECDSAKey returns an error if the key metas do not have the same curve, L2FHE public key, ZK proof parameters
+// and Paillier public key, without looking at the threshold parameters.
+func checkSameECDSAKey(meta, newMeta *tcecdsa.KeyMeta) error {
+	for _, m := range []*tcecdsa.KeyMeta{meta, newMeta} {
+		if m.PubKey == nil || m.Paillier == nil || m.MaxMessageModule == nil || m.ZKProofMeta == nil ||
+			m.Paillier.Delta == nil || m.Paillier.Constant == nil {
+			return fmt.Errorf("incomplete key meta")
+		}
+	}
+	if meta.CurveName != newMeta.CurveName {
+		return fmt.Errorf("the curve changed")
+	}
+	if meta.MaxMessageModule.Cmp(newMeta.MaxMessageModule) != 0 {
+		return fmt.Errorf("the L2FHE public key changed")
+	}
+	if meta.NTilde.Cmp(newMeta.NTilde) != 0 || meta.H1.Cmp(newMeta.H1) != 0 || meta.H2.Cmp(newMeta.H2) != 0 {
+		return fmt.Errorf("the ZK proof parameters changed")
+	}
+	old, paillier := meta.Paillier, newMeta.Paillier
+	if old.N.Cmp(paillier.N) != 0 || old.V.Cmp(paillier.V) != 0 || old.S != paillier.S {
+		return fmt.Errorf("the Paillier public key changed")
+	}
+	return nil
+}
+
+// NewECDSADealing creates the dealing of a key share in the resharing of a tcecdsa key to k-of-l new key shares. Only
+// the shares of the threshold Paillier key are reshared. The dealers are the indexes of the K Paillier shares dealing,
+// and the recipients are the Curve25519 public keys of the new nodes, ordered by share index.
+func NewECDSADealing(share *tcecdsa.KeyShare, meta *tcecdsa.KeyMeta, dealers []int, k, l int, recipients [][]byte) (*Dealing, error) {
+	if err := checkThreshold(k, l, math.MaxUint8); err != nil {
+		return nil, err
+	}
+	if len(recipients) != l {
+		return nil, fmt.Errorf("resharing to %d key shares needs %d recipients, but it has %d", l, l, len(recipients))
+	}
+	if share.PaillierShare == nil {
+		return nil, fmt.Errorf("invalid key share")
+	}
+	paillier := meta.Paillier
+	indexes, err := dealerIndexes(dealers, int(paillier.K), int(paillier.L))
+	if err != nil {
+		return nil, err
+	}
+	index := int64(share.PaillierShare.Index)
+	if !containsIndex(indexes, index) {
+		return nil, fmt.Errorf("key share %d is not one of the dealers", index)
+	}
+	secret := lagrange(index, 0, indexes, paillier.Delta)
+	secret.Mul(secret, share.PaillierShare.Si)
+	mod := paillier.Cache().NToSPlusOne
+	return deal(int(index), secret, k, recipients, ecdsaResharingBase(paillier, l), mod)
+}
+
+// NewECDSAResharedMeta returns the key meta of the k-of-l key shares created by the dealings provided. It returns an
+// error if the dealings do not share the key of the old key meta.
+//
+// The new shares combine into the old secret multiplied by the old Delta, so the constant Paillier uses to remove the
+// factors of the combination is multiplied by the old Delta and divided by the square of the new one, which is
+// possible because it is computed modulo the public N^S.
+func NewECDSAResharedMeta(meta *tcecdsa.KeyMeta, dealings []*Dealing, k, l int) (*tcecdsa.KeyMeta, error) {
+	if err := checkECDSADealings(meta, dealings, k, l); err != nil {
+		return nil, err
+	}
+	paillier := meta.Paillier
+	nToS := paillier.Cache().NToS
+	newDelta := new(big.Int).MulRange(1, int64(l))
+	inverse := new(big.Int).Mul(newDelta, newDelta)
+	if inverse.ModInverse(inverse, nToS) == nil {
+		return nil, fmt.Errorf("the new delta is not invertible")
+	}
+	newPaillier := *paillier
+	newPaillier.Delta = newDelta
+	newPaillier.Constant = new(big.Int).Mul(paillier.Constant, paillier.Delta)
+	newPaillier.Constant.Mul(newPaillier.Constant, inverse).Mod(newPaillier.Constant, nToS)
+	newPaillier.K = uint8(k)
+	newPaillier.L = uint8(l)
+	newPaillier.Vi = verificationKeys(dealings, l, paillier.Cache().NToSPlusOne)
+	newPubKey := *meta.PubKey
+	newPubKey.Paillier = &newPaillier
+	newMeta := *meta
+	newMeta.PubKey = &newPubKey
+	return &newMeta, nil
+}
+
+// ReshareECDSAKeyShare returns the new key share with the index provided, created by the dealings provided. The public
+// share has the encrypted secret and the public key of the ECDSA key, which do not change. The private key is the
+// Curve25519 private key of the node. It returns an error if the dealings do not share the key of the old key meta, if
+// the new key meta does not match them, or if the node cannot decrypt its sub-shares.
+func ReshareECDSAKeyShare(index int, privateKey []byte, publicShare *tcecdsa.KeyShare, meta, newMeta *tcecdsa.KeyMeta, dealings []*Dealing) (*tcecdsa.KeyShare, error) {
+	if err := checkSameECDSAKey(meta, newMeta); err != nil {
+		return nil, err
+	}
+	paillier := newMeta.Paillier
+	expected, err := NewECDSAResharedMeta(meta, dealings, int(paillier.K), int(paillier.L))
+	if err != nil {
+		return nil, err
+	}
+	if !sameThresholdPaillier(expected.Paillier, paillier) {
+		return nil, fmt.Errorf("the new key meta does not match the dealings")
+	}
+	if publicShare.Alpha == nil || publicShare.Y == nil {
+		return nil, fmt.Errorf("the public share does not have the ECDSA key")
+	}
+	if index < 1 || index > int(paillier.L) {
+		return nil, fmt.Errorf("invalid key share index %d", index)
+	}
+	mod := paillier.Cache().NToSPlusOne
+	si, err := openDealings(dealings, index, privateKey, ecdsaResharingBase(meta.Paillier, int(paillier.L)), mod)
+	if err != nil {
+		return nil, err
+	}
+	return &tcecdsa.KeyShare{
+		Index: uint8(index - 1),
+		Alpha: publicShare.Alpha,
+		Y:     publicShare.Y,
+		PaillierShare: &tcpaillier.KeyShare{
+			PubKey: paillier,
+			Index:  uint8(index),
+			Si:     si,
+		},
+	}, nil
+}
+
+// checkECDSADealings returns an error if the dealings are not a resharing of the key of the old key meta to k-of-l key
+// shares, which happens if the constant term of a dealing is not the Lagrange term of the share of its dealer.
+func checkECDSADealings(meta *tcecdsa.KeyMeta, dealings []*Dealing, k, l int) error {
+	if err := checkThreshold(k, l, math.MaxUint8); err != nil {
+		return err
+	}
+	if meta.PubKey == nil || meta.Paillier == nil {
+		return fmt.Errorf("incomplete key meta")
+	}
+	paillier := meta.Paillier
+	indexes, err := checkDealings(dealings, int(paillier.K), int(paillier.L), k, l)
+	if err != nil {
+		return err
+	}
+	if len(paillier.Vi) != int(paillier.L) {
+		return fmt.Errorf("key meta has %d verification keys, but it should have %d", len(paillier.Vi), paillier.L)
+	}
+	// The commitments use V^(new Delta) as base, and the verification keys V^Delta, so both sides are raised to the
+	// other Delta before comparing them.
+	mod := paillier.Cache().NToSPlusOne
+	newDelta := new(big.Int).MulRange(1, int64(l))
+	for i, dealing := range dealings {
+		exp := lagrange(indexes[i], 0, indexes, paillier.Delta)
+		exp.Mul(exp, newDelta)
+		expected, err := expSigned(paillier.Vi[dealing.Dealer-1], exp, mod)
+		if err != nil {
+			return err
+		}
+		if new(big.Int).Exp(dealing.Commitments[0], paillier.Delta, mod).Cmp(expected) != 0 {
+			return fmt.Errorf("the dealing of share %d does not share its key share", dealing.Dealer)
+		}
+	}
+	return nil
+}
+
+// ecdsaResharingBase returns the base of the commitments of a resharing of a threshold Paillier key to l key shares,
+// which is V raised to the new Delta, like its verification keys.
+func ecdsaResharingBase(paillier *tcpaillier.PubKey, l int) *big.Int {
+	newDelta := new(big.Int).MulRange(1, int64(l))
+	return new(big.Int).Exp(paillier.V, newDelta, paillier.Cache().NToSPlusOne)
+}
+
+// sameThresholdPaillier returns true if both threshold Paillier public keys have the same threshold, Delta, constant
+// and verification keys.
+func sameThresholdPaillier(paillier, other *tcpaillier.PubKey) bool {
+	if paillier.K != other.K || paillier.L != other.L || paillier.Delta.Cmp(other.Delta) != 0 ||
+		paillier.Constant.Cmp(other.Constant) != 0 || len(paillier.Vi) != len(other.Vi) {
+		return false
+	}
+	for i := range paillier.Vi {
+		if other.Vi[i] == nil || paillier.Vi[i].Cmp(other.Vi[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+// Package refresh implements proactive refresh and resharing of tcrsa and tcecdsa key shares.
+//
+// Both libraries split the secret exponent with a polynomial over the integers and combine the shares with Lagrange
+// coefficients multiplied by Delta = L!. A refresh adds to each share the value at its index of a random polynomial with
+// a zero constant term, so the shares still combine into the same secret and the public key does not change, but the
+// shares of different epochs cannot be combined between them. An attacker must then compromise K nodes within a single
+// epoch to recover the key.
+//
+// The refresh is created by the client, which only knows the public key meta. The nodes receive their delta and the
+// new key meta, and check that the new verification keys are a refresh of the old ones before accepting their new share.
+//
+// A resharing moves the secret to a new set of nodes, with a new threshold. K nodes holding the current shares act as
+// dealers: each one shares its Lagrange term of the secret among the new nodes with a random polynomial, and publishes
+// commitments to its coefficients. The new share of each node is the sum of the values it receives, which are
+// encrypted for it so the client, which relays them, cannot learn them.
+package refresh
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// StatisticalSecurity is the number of bits the coefficients of the refresh polynomial have over the size of the
+// shares, so the refreshed shares do not leak information about the old ones.
+const StatisticalSecurity = 128
+
+// zeroSharing returns the values at 1..l of a random polynomial of degree k-1 with a zero constant term and positive
+// coefficients of the number of bits provided.
+func zeroSharing(k, l, bits int) ([]*big.Int, error) {
+	if k < 1 || l < k {
+		return nil, fmt.Errorf("invalid threshold: k=%d, l=%d", k, l)
+	}
+	max := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	coefs := make([]*big.Int, k-1)
+	for i := range coefs {
+		coef, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		coefs[i] = coef
+	}
+	values := make([]*big.Int, l)
+	for i := range values {
+		x := big.NewInt(int64(i + 1))
+		value := new(big.Int)
+		for j := len(coefs) - 1; j >= 0; j-- {
+			value.Add(value, coefs[j]).Mul(value, x)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// checkZeroSharing receives the ratios between the new and old verification keys of a key, which are a fixed base
+// raised to the values of the refresh polynomial, and returns an error if they do not come from a polynomial of degree
+// lower than k with a zero constant term.
+func checkZeroSharing(ratios []*big.Int, k int, delta, mod *big.Int) error {
+	if k < 1 || len(ratios) < k {
+		return fmt.Errorf("invalid threshold: k=%d, l=%d", k, len(ratios))
+	}
+	// The ratios of the first k indexes define the polynomial. Its constant term and its values at the other indexes
+	// are interpolated and compared with the ones provided.
+	indexes := make([]int64, k)
+	for i := range indexes {
+		indexes[i] = int64(i + 1)
+	}
+	for x := 0; x <= len(ratios); x++ {
+		if x >= 1 && x <= k {
+			continue
+		}
+		interpolated := big.NewInt(1)
+		for i := 1; i <= k; i++ {
+			term, err := expSigned(ratios[i-1], lagrange(int64(i), int64(x), indexes, delta), mod)
+			if err != nil {
+				return err
+			}
+			interpolated.Mul(interpolated, term).Mod(interpolated, mod)
+		}
+		expected := big.NewInt(1)
+		if x > 0 {
+			expected.Exp(ratios[x-1], delta, mod)
+		}
+		if interpolated.Cmp(expected) != 0 {
+			if x == 0 {
+				return fmt.Errorf("the refresh polynomial has a non zero constant term")
+			}
+			return fmt.Errorf("the refresh of the share %d does not match the refresh polynomial", x)
+		}
+	}
+	return nil
+}
+
+// ratios returns the ratios between the new and the old verification keys provided, modulo mod.
+func ratios(oldKeys, newKeys []*big.Int, mod *big.Int) ([]*big.Int, error) {
+	if len(oldKeys) != len(newKeys) {
+		return nil, fmt.Errorf("the number of verification keys changed from %d to %d", len(oldKeys), len(newKeys))
+	}
+	ratios := make([]*big.Int, len(oldKeys))
+	for i := range oldKeys {
+		inverse := new(big.Int).ModInverse(oldKeys[i], mod)
+		if inverse == nil {
+			return nil, fmt.Errorf("verification key %d is not invertible", i+1)
+		}
+		ratios[i] = inverse.Mul(inverse, newKeys[i]).Mod(inverse, mod)
+	}
+	return ratios, nil
+}
+
+// lagrange returns the Lagrange coefficient of the index i at x for the indexes provided, multiplied by delta so it is
+// an integer.
+func lagrange(i, x int64, indexes []int64, delta *big.Int) *big.Int {
+	num := new(big.Int).Set(delta)
+	den := big.NewInt(1)
+	for _, j := range indexes {
+		if j != i {
+			num.Mul(num, big.NewInt(x-j))
+			den.Mul(den, big.NewInt(i-j))
+		}
+	}
+	return num.Quo(num, den)
+}
+
+// expSigned returns base^exp modulo mod, using the inverse of base if exp is negative.
+func expSigned(base, exp, mod *big.Int) (*big.Int, error) {
+	if exp.Sign() >= 0 {
+		return new(big.Int).Exp(base, exp, mod), nil
+	}
+	inverse := new(big.Int).ModInverse(base, mod)
+	if inverse == nil {
+		return nil, fmt.Errorf("value is not invertible")
+	}
+	return inverse.Exp(inverse, new(big.Int).Neg(exp), mod), nil
+}
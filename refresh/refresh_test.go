@@ -0,0 +1,317 @@
+package refresh
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/niclabs/tcecdsa"
+	"github.com/niclabs/tcecdsa/l2fhe"
+	"github.com/niclabs/tcpaillier"
+	"github.com/niclabs/tcrsa"
+)
+
+var (
+	testRSAOnce   sync.Once
+	testRSAShares tcrsa.KeyShareList
+	testRSAMeta   *tcrsa.KeyMeta
+	testRSAErr    error
+)
+
+// testRSAKey returns a small 3-of-5 tcrsa key, created once for every test of the package.
+func testRSAKey(t *testing.T) (tcrsa.KeyShareList, *tcrsa.KeyMeta) {
+	testRSAOnce.Do(func() {
+		testRSAShares, testRSAMeta, testRSAErr = tcrsa.NewKey(512, 3, 5, nil)
+	})
+	if testRSAErr != nil {
+		t.Fatal(testRSAErr)
+	}
+	return testRSAShares, testRSAMeta
+}
+
+// testECDSAKey returns a tcecdsa key meta and key shares holding only a small 2-of-3 threshold Paillier key, which is
+// the only part of the key a refresh or a resharing uses. Creating a whole tcecdsa key takes minutes.
+func testECDSAKey(t *testing.T) ([]*tcecdsa.KeyShare, *tcecdsa.KeyMeta) {
+	paillierShares, paillier, err := tcpaillier.NewKey(256, 1, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := &tcecdsa.KeyMeta{
+		PubKey:      &l2fhe.PubKey{Paillier: paillier, MaxMessageModule: big.NewInt(7919)},
+		ZKProofMeta: &tcecdsa.ZKProofMeta{NTilde: big.NewInt(7), H1: big.NewInt(2), H2: big.NewInt(3)},
+		CurveName:   "P-256",
+	}
+	shares := make([]*tcecdsa.KeyShare, len(paillierShares))
+	for i, share := range paillierShares {
+		shares[i] = &tcecdsa.KeyShare{
+			Index:         uint8(i),
+			Alpha:         &l2fhe.EncryptedL1{},
+			Y:             tcecdsa.NewZero(),
+			PaillierShare: share,
+		}
+	}
+	return shares, meta
+}
+
+// rsaSign signs a document with the key shares provided, and returns an error if the joined signature does not verify
+// with the public key.
+func rsaSign(shares []*tcrsa.KeyShare, meta *tcrsa.KeyMeta, document []byte) error {
+	hash := sha256.Sum256(document)
+	doc, err := tcrsa.PrepareDocumentHash(meta.PublicKey.Size(), crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+	sigShares := make(tcrsa.SigShareList, len(shares))
+	for i, share := range shares {
+		if sigShares[i], err = share.Sign(doc, crypto.SHA256, meta); err != nil {
+			return err
+		}
+	}
+	sig, err := sigShares.Join(doc, meta)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(meta.PublicKey, crypto.SHA256, hash[:], sig)
+}
+
+// paillierDecrypt encrypts a message with the threshold Paillier key of a tcecdsa key and decrypts it with the key
+// shares provided.
+func paillierDecrypt(t *testing.T, shares []*tcecdsa.KeyShare, meta *tcecdsa.KeyMeta, msg *big.Int) *big.Int {
+	c, _, err := meta.Paillier.Encrypt(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decShares := make([]*tcpaillier.DecryptionShare, len(shares))
+	for i, share := range shares {
+		if decShares[i], err = share.PaillierShare.PartialDecrypt(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec, err := meta.Paillier.CombineShares(decShares...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dec
+}
+
+func TestZeroSharing(t *testing.T) {
+	mod := big.NewInt(1000003)
+	base := big.NewInt(2)
+	tests := []struct {
+		k, l int
+		ok   bool
+	}{
+		{1, 1, true},
+		{1, 3, true},
+		{2, 3, true},
+		{3, 5, true},
+		{5, 5, true},
+		{0, 3, false},
+		{4, 3, false},
+	}
+	for _, test := range tests {
+		values, err := zeroSharing(test.k, test.l, 64)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("zero sharing with k=%d, l=%d created", test.k, test.l)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("k=%d, l=%d: %s", test.k, test.l, err)
+		}
+		if len(values) != test.l {
+			t.Fatalf("k=%d, l=%d: %d values created", test.k, test.l, len(values))
+		}
+		ratios := make([]*big.Int, len(values))
+		for i, value := range values {
+			if value.Sign() < 0 {
+				t.Errorf("k=%d, l=%d: value %d is negative", test.k, test.l, i+1)
+			}
+			ratios[i] = new(big.Int).Exp(base, value, mod)
+		}
+		delta := new(big.Int).MulRange(1, int64(test.l))
+		if err := checkZeroSharing(ratios, test.k, delta, mod); err != nil {
+			t.Errorf("k=%d, l=%d: zero sharing rejected: %s", test.k, test.l, err)
+		}
+	}
+}
+
+func TestCheckZeroSharing(t *testing.T) {
+	mod := big.NewInt(1000003)
+	base := big.NewInt(2)
+	// exps returns the base raised to the values provided.
+	exps := func(values ...int64) []*big.Int {
+		ratios := make([]*big.Int, len(values))
+		for i, value := range values {
+			ratios[i] = new(big.Int).Exp(base, big.NewInt(value), mod)
+		}
+		return ratios
+	}
+	tests := []struct {
+		name   string
+		ratios []*big.Int
+		k      int
+		ok     bool
+	}{
+		{"constant polynomial", exps(0, 0, 0), 1, true},
+		{"linear polynomial", exps(5, 10, 15, 20), 2, true},              // 5x
+		{"quadratic polynomial", exps(5, 14, 27, 44, 65), 3, true},       // 2x^2 + 3x
+		{"threshold of every share", exps(2, 6, 12), 3, true},            // x^2 + x
+		{"non zero constant term", exps(6, 11, 16, 21), 2, false},        // 5x + 1
+		{"share off the polynomial", exps(5, 10, 15, 21), 2, false},      // 5x, except at 4
+		{"degree over the threshold", exps(5, 14, 27, 44, 65), 2, false}, // 2x^2 + 3x
+		{"threshold of every share, non zero", exps(3, 7, 13), 3, false}, // x^2 + x + 1
+		{"too few ratios", exps(5), 2, false},
+		{"zero threshold", exps(0, 0), 0, false},
+	}
+	delta := new(big.Int).MulRange(1, 5)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkZeroSharing(test.ratios, test.k, delta, mod)
+			if test.ok && err != nil {
+				t.Errorf("valid zero sharing rejected: %s", err)
+			}
+			if !test.ok && err == nil {
+				t.Errorf("invalid zero sharing accepted")
+			}
+		})
+	}
+}
+
+func TestRSARefresh(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	deltas, newMeta, err := NewRSARefresh(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed := make([]*tcrsa.KeyShare, len(shares))
+	for i, share := range shares {
+		if refreshed[i], err = RefreshRSAKeyShare(share, deltas[i], meta, newMeta); err != nil {
+			t.Fatalf("cannot refresh key share %d: %s", share.Id, err)
+		}
+		if refreshed[i].Id != share.Id {
+			t.Errorf("key share %d refreshed with ID %d", share.Id, refreshed[i].Id)
+		}
+	}
+	document := []byte("refreshed document")
+	for _, signers := range [][]int{{1, 2, 3}, {3, 4, 5}, {1, 3, 5}} {
+		signing := make([]*tcrsa.KeyShare, len(signers))
+		for i, id := range signers {
+			signing[i] = refreshed[id-1]
+		}
+		if err := rsaSign(signing, newMeta, document); err != nil {
+			t.Errorf("refreshed key shares %v cannot sign: %s", signers, err)
+		}
+	}
+	mixed := []*tcrsa.KeyShare{shares[0], shares[1], refreshed[2]}
+	if err := rsaSign(mixed, newMeta, document); err == nil {
+		t.Errorf("key shares of different epochs signed together")
+	}
+}
+
+func TestRefreshRSAKeyShareErrors(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	deltas, newMeta, err := NewRSARefresh(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// change returns a copy of the new key meta changed by the function provided.
+	change := func(f func(m *tcrsa.KeyMeta)) *tcrsa.KeyMeta {
+		m := *newMeta
+		vk := *newMeta.VerificationKey
+		vk.I = append([][]byte(nil), newMeta.VerificationKey.I...)
+		m.VerificationKey = &vk
+		f(&m)
+		return &m
+	}
+	otherDeltas, otherMeta, err := NewRSARefresh(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		share   *tcrsa.KeyShare
+		delta   *big.Int
+		newMeta *tcrsa.KeyMeta
+	}{
+		{"delta of another share", shares[0], deltas[1], newMeta},
+		{"delta of another refresh", shares[0], otherDeltas[0], newMeta},
+		{"negative delta", shares[0], new(big.Int).Neg(deltas[0]), newMeta},
+		{"meta of another refresh", shares[0], deltas[0], otherMeta},
+		{"changed threshold", shares[0], deltas[0], change(func(m *tcrsa.KeyMeta) { m.K = 2 })},
+		{"changed public key", shares[0], deltas[0], change(func(m *tcrsa.KeyMeta) {
+			m.PublicKey = &rsa.PublicKey{N: m.PublicKey.N, E: 3}
+		})},
+		{"changed verification value", shares[0], deltas[0], change(func(m *tcrsa.KeyMeta) {
+			m.VerificationKey.V = m.VerificationKey.I[0]
+		})},
+		{"verification key of another share", shares[0], deltas[0], change(func(m *tcrsa.KeyMeta) {
+			m.VerificationKey.I[0], m.VerificationKey.I[1] = m.VerificationKey.I[1], m.VerificationKey.I[0]
+		})},
+		{"missing verification key", shares[0], deltas[0], change(func(m *tcrsa.KeyMeta) {
+			m.VerificationKey.I = m.VerificationKey.I[:4]
+		})},
+		{"incomplete meta", shares[0], deltas[0], change(func(m *tcrsa.KeyMeta) { m.VerificationKey = nil })},
+		{"invalid ID", &tcrsa.KeyShare{Si: shares[0].Si, Id: 6}, deltas[0], newMeta},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := RefreshRSAKeyShare(test.share, test.delta, meta, test.newMeta); err == nil {
+				t.Errorf("invalid refresh accepted")
+			}
+		})
+	}
+}
+
+func TestECDSARefresh(t *testing.T) {
+	shares, meta := testECDSAKey(t)
+	deltas, newMeta, err := NewECDSARefresh(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed := make([]*tcecdsa.KeyShare, len(shares))
+	for i, share := range shares {
+		if refreshed[i], err = RefreshECDSAKeyShare(share, deltas[i], meta, newMeta); err != nil {
+			t.Fatalf("cannot refresh key share %d: %s", share.PaillierShare.Index, err)
+		}
+		if refreshed[i].PaillierShare.PubKey != newMeta.Paillier {
+			t.Errorf("key share %d does not use the new key meta", share.PaillierShare.Index)
+		}
+	}
+	if shares[0].PaillierShare.Si.Cmp(refreshed[0].PaillierShare.Si) == 0 {
+		t.Errorf("key share not refreshed")
+	}
+	msg := big.NewInt(424242)
+	for _, signers := range [][]int{{1, 2}, {2, 3}, {1, 3}} {
+		decrypting := []*tcecdsa.KeyShare{refreshed[signers[0]-1], refreshed[signers[1]-1]}
+		if dec := paillierDecrypt(t, decrypting, newMeta, msg); dec.Cmp(msg) != 0 {
+			t.Errorf("refreshed key shares %v decrypted %s instead of %s", signers, dec, msg)
+		}
+	}
+
+	tampered := *newMeta.Paillier
+	tampered.Vi = []*big.Int{newMeta.Paillier.Vi[1], newMeta.Paillier.Vi[0], newMeta.Paillier.Vi[2]}
+	tamperedMeta := *newMeta
+	tamperedMeta.PubKey = &l2fhe.PubKey{Paillier: &tampered, MaxMessageModule: newMeta.MaxMessageModule}
+	tests := []struct {
+		name    string
+		delta   *big.Int
+		newMeta *tcecdsa.KeyMeta
+	}{
+		{"delta of another share", deltas[1], newMeta},
+		{"negative delta", new(big.Int).Neg(deltas[0]), newMeta},
+		{"tampered verification keys", deltas[0], &tamperedMeta},
+		{"old key meta", deltas[0], meta},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := RefreshECDSAKeyShare(shares[0], test.delta, meta, test.newMeta); err == nil {
+				t.Errorf("invalid refresh accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package refresh
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/niclabs/dtcnode/v3/seal"
+)
+
+// Dealing is the contribution of a node holding a key share to a resharing.
+type Dealing struct {
+	Dealer      int        // Index of the key share of the dealer.
+	Commitments []*big.Int // A fixed base raised to each coefficient of the dealer polynomial, from the constant term.
+	Shares      [][]byte   // Values of the dealer polynomial at each new index, encrypted for the node receiving them.
+}
+
+// checkThreshold returns an error if the threshold of a resharing is not valid for a scheme with at most max shares.
+func checkThreshold(k, l, max int) error {
+	if k < 2 || l < k || l > max {
+		return fmt.Errorf("invalid threshold: k=%d, l=%d", k, l)
+	}
+	return nil
+}
+
+// dealerIndexes returns the indexes of the dealers of a resharing, after checking that there are k of them, and that
+// they are different and between 1 and l.
+func dealerIndexes(dealers []int, k, l int) ([]int64, error) {
+	if len(dealers) != k {
+		return nil, fmt.Errorf("a resharing needs %d dealers, but it has %d", k, len(dealers))
+	}
+	indexes := make([]int64, len(dealers))
+	seen := make(map[int]bool)
+	for i, dealer := range dealers {
+		if dealer < 1 || dealer > l {
+			return nil, fmt.Errorf("invalid dealer index %d", dealer)
+		}
+		if seen[dealer] {
+			return nil, fmt.Errorf("dealer %d is repeated", dealer)
+		}
+		seen[dealer] = true
+		indexes[i] = int64(dealer)
+	}
+	return indexes, nil
+}
+
+// deal shares a secret among new key shares with a random polynomial of degree k-1, and encrypts the value of each
+// share for the recipient with the same index. The coefficients have the bits of the secret plus StatisticalSecurity,
+// and their highest bit set, so the values at the new indexes are always positive.
+func deal(dealer int, secret *big.Int, k int, recipients [][]byte, base, mod *big.Int) (*Dealing, error) {
+	bits := uint(secret.BitLen() + StatisticalSecurity)
+	max := new(big.Int).Lsh(big.NewInt(1), bits)
+	coefs := make([]*big.Int, k)
+	coefs[0] = secret
+	for i := 1; i < k; i++ {
+		coef, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		coefs[i] = coef.SetBit(coef, int(bits), 1)
+	}
+	dealing := &Dealing{
+		Dealer:      dealer,
+		Commitments: make([]*big.Int, k),
+		Shares:      make([][]byte, len(recipients)),
+	}
+	for i, coef := range coefs {
+		commitment, err := expSigned(base, coef, mod)
+		if err != nil {
+			return nil, err
+		}
+		dealing.Commitments[i] = commitment
+	}
+	for i, recipient := range recipients {
+		x := big.NewInt(int64(i + 1))
+		value := new(big.Int)
+		for j := len(coefs) - 1; j >= 0; j-- {
+			value.Mul(value, x).Add(value, coefs[j])
+		}
+		share, err := seal.Seal(recipient, value.Bytes(), subShareData(dealer, i+1))
+		if err != nil {
+			return nil, fmt.Errorf("cannot encrypt the sub-share %d: %s", i+1, err)
+		}
+		dealing.Shares[i] = share
+	}
+	return dealing, nil
+}
+
+// subShareData returns the additional data bound to the encrypted sub-share of a dealer for a new index, so the client
+// cannot send it to another node or as the sub-share of another dealer.
+func subShareData(dealer, index int) []byte {
+	return []byte(fmt.Sprintf("dtcnode reshare dealer %d index %d", dealer, index))
+}
+
+// checkDealings returns the indexes of the dealers of a resharing from k-of-l key shares to newK-of-newL key shares,
+// after checking that there is one dealing per dealer and that each one has the expected number of commitments and
+// sub-shares.
+func checkDealings(dealings []*Dealing, k, l, newK, newL int) ([]int64, error) {
+	dealers := make([]int, len(dealings))
+	for i, dealing := range dealings {
+		if dealing == nil {
+			return nil, fmt.Errorf("missing dealing")
+		}
+		if len(dealing.Commitments) != newK || len(dealing.Shares) != newL {
+			return nil, fmt.Errorf("dealing of share %d has %d commitments and %d sub-shares, but it should have %d and %d",
+				dealing.Dealer, len(dealing.Commitments), len(dealing.Shares), newK, newL)
+		}
+		for _, commitment := range dealing.Commitments {
+			if commitment == nil {
+				return nil, fmt.Errorf("dealing of share %d has an empty commitment", dealing.Dealer)
+			}
+		}
+		dealers[i] = dealing.Dealer
+	}
+	return dealerIndexes(dealers, k, l)
+}
+
+// evalCommitments returns the base raised to the value at x of the polynomial with the commitments provided.
+func evalCommitments(commitments []*big.Int, x int64, mod *big.Int) *big.Int {
+	result := big.NewInt(1)
+	power := big.NewInt(1)
+	for _, commitment := range commitments {
+		term := new(big.Int).Exp(commitment, power, mod)
+		result.Mul(result, term).Mod(result, mod)
+		power.Mul(power, big.NewInt(x))
+	}
+	return result
+}
+
+// verificationKeys returns the base raised to each of the l new key shares created by the dealings provided.
+func verificationKeys(dealings []*Dealing, l int, mod *big.Int) []*big.Int {
+	keys := make([]*big.Int, l)
+	for i := range keys {
+		key := big.NewInt(1)
+		for _, dealing := range dealings {
+			key.Mul(key, evalCommitments(dealing.Commitments, int64(i+1), mod)).Mod(key, mod)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// openDealings decrypts the sub-shares of the index provided with the private key of the node, checks them against
+// the commitments of their dealings and returns their sum, which is the new key share.
+func openDealings(dealings []*Dealing, index int, privateKey []byte, base, mod *big.Int) (*big.Int, error) {
+	share := new(big.Int)
+	for _, dealing := range dealings {
+		plaintext, err := seal.Open(privateKey, dealing.Shares[index-1], subShareData(dealing.Dealer, index))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt the sub-share of dealer %d: %s", dealing.Dealer, err)
+		}
+		value := new(big.Int).SetBytes(plaintext)
+		if new(big.Int).Exp(base, value, mod).Cmp(evalCommitments(dealing.Commitments, int64(index), mod)) != 0 {
+			return nil, fmt.Errorf("the sub-share of dealer %d does not match its commitments", dealing.Dealer)
+		}
+		share.Add(share, value)
+	}
+	return share, nil
+}
+
+// containsIndex returns true if the index provided is in the list.
+func containsIndex(indexes []int64, index int64) bool {
+	for _, i := range indexes {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
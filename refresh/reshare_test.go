@@ -0,0 +1,207 @@
+package refresh
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/niclabs/tcecdsa"
+	"github.com/niclabs/tcrsa"
+)
+
+// testRecipients returns the Curve25519 public and private keys of l new nodes.
+func testRecipients(t *testing.T, l int) (publicKeys, privateKeys [][]byte) {
+	for i := 0; i < l; i++ {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKeys = append(publicKeys, key.PublicKey().Bytes())
+		privateKeys = append(privateKeys, key.Bytes())
+	}
+	return publicKeys, privateKeys
+}
+
+// rsaDealings returns the dealings of the key shares with the IDs provided in a resharing to k-of-l key shares.
+func rsaDealings(t *testing.T, shares []*tcrsa.KeyShare, meta *tcrsa.KeyMeta, dealers []int, k, l int, recipients [][]byte) []*Dealing {
+	dealings := make([]*Dealing, len(dealers))
+	for i, dealer := range dealers {
+		var err error
+		if dealings[i], err = NewRSADealing(shares[dealer-1], meta, dealers, k, l, recipients); err != nil {
+			t.Fatalf("key share %d cannot deal: %s", dealer, err)
+		}
+	}
+	return dealings
+}
+
+func TestRSAReshare(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	tests := []struct {
+		k, l int
+	}{
+		{3, 5},
+		{2, 4},
+		{4, 5},
+		{2, 2},
+	}
+	for _, test := range tests {
+		recipients, privateKeys := testRecipients(t, test.l)
+		dealings := rsaDealings(t, shares, meta, []int{1, 2, 3}, test.k, test.l, recipients)
+		newMeta, err := NewRSAResharedMeta(meta, dealings, test.k, test.l)
+		if err != nil {
+			t.Fatalf("%d-of-%d: %s", test.k, test.l, err)
+		}
+		if int(newMeta.K) != test.k || int(newMeta.L) != test.l || newMeta.PublicKey.N.Cmp(meta.PublicKey.N) != 0 {
+			t.Fatalf("%d-of-%d: the reshared key meta is %d-of-%d or has another public key", test.k, test.l, newMeta.K, newMeta.L)
+		}
+		newShares := make([]*tcrsa.KeyShare, test.l)
+		for i := range newShares {
+			if newShares[i], err = ReshareRSAKeyShare(i+1, privateKeys[i], meta, newMeta, dealings); err != nil {
+				t.Fatalf("%d-of-%d: node %d cannot get its key share: %s", test.k, test.l, i+1, err)
+			}
+		}
+		if err := rsaSign(newShares[:test.k], newMeta, []byte("reshared document")); err != nil {
+			t.Errorf("%d-of-%d: the first reshared key shares cannot sign: %s", test.k, test.l, err)
+		}
+		if err := rsaSign(newShares[test.l-test.k:], newMeta, []byte("reshared document")); err != nil {
+			t.Errorf("%d-of-%d: the last reshared key shares cannot sign: %s", test.k, test.l, err)
+		}
+	}
+}
+
+func TestNewRSADealingErrors(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	recipients, _ := testRecipients(t, 3)
+	tests := []struct {
+		name       string
+		share      *tcrsa.KeyShare
+		dealers    []int
+		k, l       int
+		recipients [][]byte
+	}{
+		{"not a dealer", shares[4], []int{1, 2, 3}, 2, 3, recipients},
+		{"too few dealers", shares[0], []int{1, 2}, 2, 3, recipients},
+		{"repeated dealer", shares[0], []int{1, 1, 2}, 2, 3, recipients},
+		{"invalid dealer", shares[0], []int{1, 2, 6}, 2, 3, recipients},
+		{"threshold of one", shares[0], []int{1, 2, 3}, 1, 3, recipients},
+		{"threshold over the shares", shares[0], []int{1, 2, 3}, 4, 3, recipients},
+		{"missing recipient", shares[0], []int{1, 2, 3}, 2, 3, recipients[:2]},
+		{"inexact coefficient", shares[0], []int{1, 2, 3}, 2, 6, append(recipients, recipients...)},
+		{"invalid recipient", shares[0], []int{1, 2, 3}, 2, 3, [][]byte{recipients[0], recipients[1], {1, 2, 3}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewRSADealing(test.share, meta, test.dealers, test.k, test.l, test.recipients); err == nil {
+				t.Errorf("invalid dealing created")
+			}
+		})
+	}
+}
+
+func TestReshareRSAKeyShareErrors(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	recipients, privateKeys := testRecipients(t, 3)
+	dealers := []int{1, 2, 3}
+	dealings := rsaDealings(t, shares, meta, dealers, 2, 3, recipients)
+	newMeta, err := NewRSAResharedMeta(meta, dealings, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDealings := rsaDealings(t, shares, meta, dealers, 2, 3, recipients)
+	otherMeta, err := NewRSAResharedMeta(meta, otherDealings, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// change returns a copy of the dealings with the dealing of the index provided changed by the function provided.
+	change := func(i int, f func(d *Dealing)) []*Dealing {
+		changed := append([]*Dealing(nil), dealings...)
+		d := *dealings[i]
+		d.Commitments = append([]*big.Int(nil), d.Commitments...)
+		d.Shares = append([][]byte(nil), d.Shares...)
+		f(&d)
+		changed[i] = &d
+		return changed
+	}
+	// The dealing of share 3 claims to come from share 4.
+	badDealing := *dealings[2]
+	badDealing.Dealer = 4
+	tests := []struct {
+		name       string
+		id         int
+		privateKey []byte
+		newMeta    *tcrsa.KeyMeta
+		dealings   []*Dealing
+	}{
+		{"private key of another node", 1, privateKeys[1], newMeta, dealings},
+		{"key meta of another resharing", 1, privateKeys[0], otherMeta, dealings},
+		{"old key meta", 1, privateKeys[0], meta, dealings},
+		{"invalid ID", 4, privateKeys[0], newMeta, dealings},
+		{"missing dealing", 1, privateKeys[0], newMeta, dealings[:2]},
+		{"nil dealing", 1, privateKeys[0], newMeta, []*Dealing{dealings[0], dealings[1], nil}},
+		{"dealing of another resharing", 1, privateKeys[0], newMeta, []*Dealing{dealings[0], dealings[1], otherDealings[2]}},
+		{"wrong dealer", 1, privateKeys[0], newMeta, []*Dealing{dealings[0], dealings[1], &badDealing}},
+		{"tampered constant term", 1, privateKeys[0], newMeta, change(0, func(d *Dealing) {
+			d.Commitments[0] = new(big.Int).Add(d.Commitments[0], big.NewInt(1))
+		})},
+		{"swapped sub-shares", 1, privateKeys[0], newMeta, change(0, func(d *Dealing) {
+			d.Shares[0], d.Shares[1] = d.Shares[1], d.Shares[0]
+		})},
+		{"sub-share of another dealer", 1, privateKeys[0], newMeta, change(0, func(d *Dealing) {
+			d.Shares[0] = dealings[1].Shares[0]
+		})},
+		{"missing commitment", 1, privateKeys[0], newMeta, change(0, func(d *Dealing) {
+			d.Commitments = d.Commitments[:1]
+		})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ReshareRSAKeyShare(test.id, test.privateKey, meta, test.newMeta, test.dealings); err == nil {
+				t.Errorf("invalid resharing accepted")
+			}
+		})
+	}
+}
+
+func TestECDSAReshare(t *testing.T) {
+	shares, meta := testECDSAKey(t)
+	msg := big.NewInt(271828)
+	tests := []struct {
+		k, l int
+	}{
+		{2, 3},
+		{3, 3},
+		{2, 2},
+		{3, 5},
+	}
+	for _, test := range tests {
+		recipients, privateKeys := testRecipients(t, test.l)
+		dealers := []int{1, 2}
+		dealings := make([]*Dealing, len(dealers))
+		for i, dealer := range dealers {
+			var err error
+			if dealings[i], err = NewECDSADealing(shares[dealer-1], meta, dealers, test.k, test.l, recipients); err != nil {
+				t.Fatalf("%d-of-%d: key share %d cannot deal: %s", test.k, test.l, dealer, err)
+			}
+		}
+		newMeta, err := NewECDSAResharedMeta(meta, dealings, test.k, test.l)
+		if err != nil {
+			t.Fatalf("%d-of-%d: %s", test.k, test.l, err)
+		}
+		newShares := make([]*tcecdsa.KeyShare, test.l)
+		for i := range newShares {
+			if newShares[i], err = ReshareECDSAKeyShare(i+1, privateKeys[i], shares[0], meta, newMeta, dealings); err != nil {
+				t.Fatalf("%d-of-%d: node %d cannot get its key share: %s", test.k, test.l, i+1, err)
+			}
+		}
+		if dec := paillierDecrypt(t, newShares[test.l-test.k:], newMeta, msg); dec.Cmp(msg) != 0 {
+			t.Errorf("%d-of-%d: reshared key shares decrypted %s instead of %s", test.k, test.l, dec, msg)
+		}
+		if _, err := ReshareECDSAKeyShare(1, privateKeys[0], shares[0], meta, meta, dealings); err == nil {
+			t.Errorf("%d-of-%d: resharing accepted with the old key meta", test.k, test.l)
+		}
+		if _, err := ReshareECDSAKeyShare(1, privateKeys[1], shares[0], meta, newMeta, dealings); err == nil {
+			t.Errorf("%d-of-%d: sub-shares opened with the private key of another node", test.k, test.l)
+		}
+	}
+}
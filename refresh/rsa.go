@@ -0,0 +1,237 @@
+package refresh
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/niclabs/tcrsa"
+)
+
+// NewRSARefresh creates a refresh of a tcrsa key. It returns the delta of each key share, ordered by share ID, and the
+// key meta with the verification keys of the refreshed shares.
+func NewRSARefresh(meta *tcrsa.KeyMeta) ([]*big.Int, *tcrsa.KeyMeta, error) {
+	n := meta.PublicKey.N
+	deltas, err := zeroSharing(int(meta.K), int(meta.L), n.BitLen()+StatisticalSecurity)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(meta.VerificationKey.I) != len(deltas) {
+		return nil, nil, fmt.Errorf("key meta has %d verification keys, but it should have %d", len(meta.VerificationKey.I), len(deltas))
+	}
+	v := new(big.Int).SetBytes(meta.VerificationKey.V)
+	vk := *meta.VerificationKey
+	vk.I = make([][]byte, len(deltas))
+	for i, delta := range deltas {
+		vki := new(big.Int).SetBytes(meta.VerificationKey.I[i])
+		vki.Mul(vki, new(big.Int).Exp(v, delta, n)).Mod(vki, n)
+		vk.I[i] = vki.Bytes()
+	}
+	newMeta := *meta
+	newMeta.VerificationKey = &vk
+	return deltas, &newMeta, nil
+}
+
+// RefreshRSAKeyShare adds a delta to a tcrsa key share. It returns an error if the new key meta is not a refresh of the
+// old one, or if the refreshed share does not match its new verification key.
+func RefreshRSAKeyShare(share *tcrsa.KeyShare, delta *big.Int, meta, newMeta *tcrsa.KeyMeta) (*tcrsa.KeyShare, error) {
+	if err := checkRSAKeyMeta(meta, newMeta); err != nil {
+		return nil, err
+	}
+	if share.Id < 1 || share.Id > newMeta.L {
+		return nil, fmt.Errorf("invalid key share ID %d", share.Id)
+	}
+	if delta.Sign() < 0 {
+		return nil, fmt.Errorf("delta must not be negative")
+	}
+	n := meta.PublicKey.N
+	si := new(big.Int).SetBytes(share.Si)
+	si.Add(si, delta)
+	v := new(big.Int).SetBytes(newMeta.VerificationKey.V)
+	vki := new(big.Int).SetBytes(newMeta.VerificationKey.I[share.Id-1])
+	if new(big.Int).Exp(v, si, n).Cmp(vki) != 0 {
+		return nil, fmt.Errorf("refreshed key share does not match its verification key")
+	}
+	return &tcrsa.KeyShare{
+		Si: si.Bytes(),
+		Id: share.Id,
+	}, nil
+}
+
+// checkRSAKeyMeta returns an error if the new key meta has a different public key or threshold than the old one, or
+// if its verification keys are not a refresh of the old ones.
+func checkRSAKeyMeta(meta, newMeta *tcrsa.KeyMeta) error {
+	if newMeta.PublicKey == nil || newMeta.VerificationKey == nil {
+		return fmt.Errorf("incomplete key meta")
+	}
+	if meta.PublicKey.N.Cmp(newMeta.PublicKey.N) != 0 || meta.PublicKey.E != newMeta.PublicKey.E {
+		return fmt.Errorf("the public key changed")
+	}
+	if meta.K != newMeta.K || meta.L != newMeta.L {
+		return fmt.Errorf("the threshold changed")
+	}
+	if !bytes.Equal(meta.VerificationKey.V, newMeta.VerificationKey.V) || !bytes.Equal(meta.VerificationKey.U, newMeta.VerificationKey.U) {
+		return fmt.Errorf("the verification values changed")
+	}
+	if len(meta.VerificationKey.I) != int(meta.L) {
+		return fmt.Errorf("key meta has %d verification keys, but it should have %d", len(meta.VerificationKey.I), meta.L)
+	}
+	n := meta.PublicKey.N
+	oldKeys := make([]*big.Int, len(meta.VerificationKey.I))
+	for i, vki := range meta.VerificationKey.I {
+		oldKeys[i] = new(big.Int).SetBytes(vki)
+	}
+	newKeys := make([]*big.Int, len(newMeta.VerificationKey.I))
+	for i, vki := range newMeta.VerificationKey.I {
+		newKeys[i] = new(big.Int).SetBytes(vki)
+	}
+	r, err := ratios(oldKeys, newKeys, n)
+	if err != nil {
+		return err
+	}
+	delta := new(big.Int).MulRange(1, int64(meta.L))
+	return checkZeroSharing(r, int(meta.K), delta, n)
+}
+
+// NewRSADealing creates the dealing of a key share in the resharing of a tcrsa key to k-of-l new key shares. The
+// dealers are the IDs of the K key shares dealing, and the recipients are the Curve25519 public keys of the new nodes,
+// ordered by share ID.
+//
+// tcrsa combines the shares with Lagrange coefficients multiplied by L!, so each dealer shares its coefficient
+// multiplied by the old L! and divided by the new one. The division must be exact, because it cannot be done modulo
+// the secret order of the group. That is always the case if the new key has at most as many shares as the old one and
+// the dealers are the shares 1..K.
+func NewRSADealing(share *tcrsa.KeyShare, meta *tcrsa.KeyMeta, dealers []int, k, l int, recipients [][]byte) (*Dealing, error) {
+	if err := checkThreshold(k, l, math.MaxUint16); err != nil {
+		return nil, err
+	}
+	if len(recipients) != l {
+		return nil, fmt.Errorf("resharing to %d key shares needs %d recipients, but it has %d", l, l, len(recipients))
+	}
+	indexes, err := dealerIndexes(dealers, int(meta.K), int(meta.L))
+	if err != nil {
+		return nil, err
+	}
+	if !containsIndex(indexes, int64(share.Id)) {
+		return nil, fmt.Errorf("key share %d is not one of the dealers", share.Id)
+	}
+	coef, err := rsaResharingCoefficient(int64(share.Id), indexes, int(meta.L), l)
+	if err != nil {
+		return nil, err
+	}
+	secret := coef.Mul(coef, new(big.Int).SetBytes(share.Si))
+	v := new(big.Int).SetBytes(meta.VerificationKey.V)
+	return deal(int(share.Id), secret, k, recipients, v, meta.PublicKey.N)
+}
+
+// NewRSAResharedMeta returns the key meta of the k-of-l key shares created by the dealings provided. It returns an
+// error if the dealings do not share the key of the old key meta.
+func NewRSAResharedMeta(meta *tcrsa.KeyMeta, dealings []*Dealing, k, l int) (*tcrsa.KeyMeta, error) {
+	if err := checkRSADealings(meta, dealings, k, l); err != nil {
+		return nil, err
+	}
+	keys := verificationKeys(dealings, l, meta.PublicKey.N)
+	vk := *meta.VerificationKey
+	vk.I = make([][]byte, len(keys))
+	for i, key := range keys {
+		vk.I[i] = key.Bytes()
+	}
+	newMeta := *meta
+	newMeta.K = uint16(k)
+	newMeta.L = uint16(l)
+	newMeta.VerificationKey = &vk
+	return &newMeta, nil
+}
+
+// ReshareRSAKeyShare returns the new key share with the ID provided, created by the dealings provided. The private key
+// is the Curve25519 private key of the node. It returns an error if the dealings do not share the key of the old key
+// meta, if the new key meta does not match them, or if the node cannot decrypt its sub-shares.
+func ReshareRSAKeyShare(id int, privateKey []byte, meta, newMeta *tcrsa.KeyMeta, dealings []*Dealing) (*tcrsa.KeyShare, error) {
+	if newMeta.PublicKey == nil || newMeta.VerificationKey == nil {
+		return nil, fmt.Errorf("incomplete key meta")
+	}
+	expected, err := NewRSAResharedMeta(meta, dealings, int(newMeta.K), int(newMeta.L))
+	if err != nil {
+		return nil, err
+	}
+	if !sameRSAKeyMeta(expected, newMeta) {
+		return nil, fmt.Errorf("the new key meta does not match the dealings")
+	}
+	if id < 1 || id > int(newMeta.L) {
+		return nil, fmt.Errorf("invalid key share ID %d", id)
+	}
+	v := new(big.Int).SetBytes(newMeta.VerificationKey.V)
+	si, err := openDealings(dealings, id, privateKey, v, newMeta.PublicKey.N)
+	if err != nil {
+		return nil, err
+	}
+	return &tcrsa.KeyShare{
+		Si: si.Bytes(),
+		Id: uint16(id),
+	}, nil
+}
+
+// checkRSADealings returns an error if the dealings are not a resharing of the key of the old key meta to k-of-l key
+// shares, which happens if the constant term of a dealing is not the scaled Lagrange term of the share of its dealer.
+func checkRSADealings(meta *tcrsa.KeyMeta, dealings []*Dealing, k, l int) error {
+	if meta.PublicKey == nil || meta.VerificationKey == nil {
+		return fmt.Errorf("incomplete key meta")
+	}
+	if err := checkThreshold(k, l, math.MaxUint16); err != nil {
+		return err
+	}
+	indexes, err := checkDealings(dealings, int(meta.K), int(meta.L), k, l)
+	if err != nil {
+		return err
+	}
+	if len(meta.VerificationKey.I) != int(meta.L) {
+		return fmt.Errorf("key meta has %d verification keys, but it should have %d", len(meta.VerificationKey.I), meta.L)
+	}
+	n := meta.PublicKey.N
+	for i, dealing := range dealings {
+		coef, err := rsaResharingCoefficient(indexes[i], indexes, int(meta.L), l)
+		if err != nil {
+			return err
+		}
+		vki := new(big.Int).SetBytes(meta.VerificationKey.I[dealing.Dealer-1])
+		expected, err := expSigned(vki, coef, n)
+		if err != nil {
+			return err
+		}
+		if dealing.Commitments[0].Cmp(expected) != 0 {
+			return fmt.Errorf("the dealing of share %d does not share its key share", dealing.Dealer)
+		}
+	}
+	return nil
+}
+
+// rsaResharingCoefficient returns the Lagrange coefficient at zero of a dealer, multiplied by the old L! and divided by
+// the new one, or an error if the division is not exact.
+func rsaResharingCoefficient(i int64, indexes []int64, oldL, l int) (*big.Int, error) {
+	delta := new(big.Int).MulRange(1, int64(oldL))
+	newDelta := new(big.Int).MulRange(1, int64(l))
+	coef, rem := new(big.Int).QuoRem(lagrange(i, 0, indexes, delta), newDelta, new(big.Int))
+	if rem.Sign() != 0 {
+		return nil, fmt.Errorf("the key share %d cannot be reshared to %d key shares with these dealers", i, l)
+	}
+	return coef, nil
+}
+
+// sameRSAKeyMeta returns true if both key metas have the same public key, threshold and verification values.
+func sameRSAKeyMeta(meta, other *tcrsa.KeyMeta) bool {
+	if meta.PublicKey.N.Cmp(other.PublicKey.N) != 0 || meta.PublicKey.E != other.PublicKey.E ||
+		meta.K != other.K || meta.L != other.L {
+		return false
+	}
+	vk, otherVK := meta.VerificationKey, other.VerificationKey
+	if !bytes.Equal(vk.V, otherVK.V) || !bytes.Equal(vk.U, otherVK.U) || len(vk.I) != len(otherVK.I) {
+		return false
+	}
+	for i := range vk.I {
+		if !bytes.Equal(vk.I[i], otherVK.I[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+// Package seal encrypts data for a node with the Curve25519 key the node uses in ZMQ CURVE Auth, so only that node can
+// read it, even if it goes through the client.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Seal encrypts a plaintext for the node with the Curve25519 public key provided, in binary form. It uses an ephemeral
+// X25519 key exchange and AES-GCM, and binds the additional data to the ciphertext. The result is the ephemeral public
+// key, the nonce and the ciphertext.
+func Seal(publicKey, plaintext, additionalData []byte) ([]byte, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(secret, ephemeral.PublicKey().Bytes(), publicKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(ephemeral.PublicKey().Bytes(), nonce...)
+	return aead.Seal(sealed, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a ciphertext created by Seal, using the Curve25519 private key of the node, in binary form.
+func Open(privateKey, sealed, additionalData []byte) ([]byte, error) {
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < 32 {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(sealed[:32])
+	if err != nil {
+		return nil, err
+	}
+	secret, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(secret, sealed[:32], key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[32:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// newAEAD derives the AES-GCM cipher of a ciphertext from the X25519 shared secret and the public keys of the exchange.
+func newAEAD(secret, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	hash := sha256.New()
+	hash.Write(secret)
+	hash.Write(ephemeral)
+	hash.Write(recipient)
+	block, err := aes.NewCipher(hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/pebbe/zmq4"
+)
+
+// AdminName is the name of the admin API in the RPC server of the admin socket.
+const AdminName = "Admin"
+
+// The following consts represent the log levels of the node.
+const (
+	LogDebug  = "debug"  // Node log and ZMQ authentication log. It is the default level.
+	LogInfo   = "info"   // Node log.
+	LogSilent = "silent" // Nothing. The audit log is still written.
+)
+
+// Admin is the API of the admin socket, used by the local operators of the node to inspect and control it. Its
+// methods follow the rules of net/rpc.
+type Admin struct {
+	node *Node
+}
+
+// AdminArgs are the arguments of the admin API calls. Each call uses only some of them.
+type AdminArgs struct {
+	Client    string           // Public key or host of the client. Empty selects every client, or the only one.
+	Algorithm string           // Algorithm of the key.
+	Key       string           // ID of the key.
+	State     message.KeyState // New lifecycle state of the key.
+	Level     string           // New log level: debug, info or silent.
+}
+
+// AdminClient describes a client of the node.
+type AdminClient struct {
+	ID        string // CURVE public key of the client.
+	Host      string
+	Keys      int // Number of key shares of the client.
+	Held      int // Number of requests waiting for operator approval.
+	HealthyAt time.Time
+	Unhealthy int // Number of key shares quarantined by the last self-test.
+}
+
+// AdminKey describes a key share of a client of the node.
+type AdminKey struct {
+	Client string
+	KeyInfo
+}
+
+// AdminSession describes the current ECDSA signing session of a client.
+type AdminSession struct {
+	Client  string
+	Key     string
+	Started time.Time
+	Round   int // Last round answered by the node. The signature is round 4.
+}
+
+// AdminCounters are the numbers of requests of a client answered by the node since it started, by message type and by
+// error, and the sig share verification metrics of its RSA keys.
+type AdminCounters struct {
+	Client       string
+	Started      time.Time // Time the node started.
+	Requests     map[string]uint64
+	Errors       map[string]uint64
+	Verification []*VerifyMetrics
+}
+
+// counters represents the numbers of requests of a client answered by the node, by message type and by error.
+type counters struct {
+	requests map[message.Type]uint64
+	errors   map[message.NodeError]uint64
+}
+
+func newCounters() *counters {
+	return &counters{
+		requests: make(map[message.Type]uint64),
+		errors:   make(map[message.NodeError]uint64),
+	}
+}
+
+// count adds a request and the error of its response to the counters.
+func (c *counters) count(mType message.Type, err message.NodeError) {
+	c.requests[mType]++
+	if err != message.Ok {
+		c.errors[err]++
+	}
+}
+
+// ServeAdmin answers the admin API calls on a Unix socket in the background. The socket can only be used by the user
+// that runs the node: it is created without permissions for other users, in a directory they cannot write, so they
+// cannot replace it either. A socket left by a previous run of the node is replaced.
+func (node *Node) ServeAdmin(path string) error {
+	dir, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if dir.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("the directory of %s can be written by other users (mode %s)", path, dir.Mode().Perm())
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName(AdminName, &Admin{node: node}); err != nil {
+		listener.Close()
+		return err
+	}
+	log.Printf("Admin socket listening on %s", path)
+	go server.Accept(listener)
+	return nil
+}
+
+// Clients lists the clients of the node.
+func (admin *Admin) Clients(args *AdminArgs, reply *[]*AdminClient) error {
+	clients, err := admin.node.selectClients(args.Client)
+	if err != nil {
+		return err
+	}
+	list := make([]*AdminClient, 0)
+	for _, client := range clients {
+		client.mutex.Lock()
+		info := &AdminClient{
+			ID:   client.GetID(),
+			Host: client.GetConnString(),
+			Held: len(client.approvals.held),
+		}
+		for _, store := range client.keys {
+			info.Keys += store.len()
+		}
+		if client.health != nil {
+			info.HealthyAt = client.health.TestedAt
+			info.Unhealthy = client.health.Quarantined()
+		}
+		client.mutex.Unlock()
+		list = append(list, info)
+	}
+	*reply = list
+	return nil
+}
+
+// Keys lists the key shares of the clients of the node, with their lifecycle states and signature counters.
+func (admin *Admin) Keys(args *AdminArgs, reply *[]*AdminKey) error {
+	clients, err := admin.node.selectClients(args.Client)
+	if err != nil {
+		return err
+	}
+	list := make([]*AdminKey, 0)
+	for _, client := range clients {
+		client.mutex.Lock()
+		for _, info := range inventory(client.keys) {
+			list = append(list, &AdminKey{Client: client.GetConnString(), KeyInfo: *info})
+		}
+		client.mutex.Unlock()
+	}
+	*reply = list
+	return nil
+}
+
+// Sessions lists the current ECDSA signing sessions of the clients of the node.
+func (admin *Admin) Sessions(args *AdminArgs, reply *[]*AdminSession) error {
+	clients, err := admin.node.selectClients(args.Client)
+	if err != nil {
+		return err
+	}
+	list := make([]*AdminSession, 0)
+	for _, client := range clients {
+		client.mutex.Lock()
+		state := client.ecdsa()
+		if state.currentKey != "" && state.currentSession != nil {
+			list = append(list, &AdminSession{
+				Client:  client.GetConnString(),
+				Key:     state.currentKey,
+				Started: state.sessionStarted,
+				Round:   state.sessionRound,
+			})
+		}
+		client.mutex.Unlock()
+	}
+	*reply = list
+	return nil
+}
+
+// SetKeyState moves a key share of a client to the active or disabled state. Unlike the messages of the client, it is
+// not restricted by the key policy.
+func (admin *Admin) SetKeyState(args *AdminArgs, reply *KeyInfo) error {
+	if args.State != message.KeyActive && args.State != message.KeyDisabled {
+		return fmt.Errorf("the admin socket can only enable or disable key shares")
+	}
+	clients, err := admin.node.selectClients(args.Client)
+	if err != nil {
+		return err
+	}
+	if len(clients) != 1 {
+		return fmt.Errorf("the node has %d clients, select one of them", len(clients))
+	}
+	client := clients[0]
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	algName := strings.ToLower(args.Algorithm)
+	store, ok := client.keys[algName]
+	if !ok {
+		return fmt.Errorf("algorithm %s is not supported", args.Algorithm)
+	}
+	l, ok := store.lifecycles()[args.Key]
+	if !ok {
+		return fmt.Errorf("%s key %s not found", algName, args.Key)
+	}
+	old := *l
+	if err := l.moveTo(args.State, 0, time.Now()); err != nil {
+		return err
+	}
+	if err := client.node.SaveConfigKeys(); err != nil {
+		*l = old
+		return fmt.Errorf("cannot save the config: %s", err)
+	}
+	store.endSession(args.Key)
+	log.Printf("The admin socket moved %s key %s from state %s to state %s", algName, args.Key, old.current(), args.State)
+	client.audit("admin-state", algName, args.Key, fmt.Sprintf("from %s to %s", old.current(), args.State))
+	for _, info := range store.inventory(time.Now()) {
+		if info.ID == args.Key {
+			*reply = *info
+		}
+	}
+	return nil
+}
+
+// SetLogLevel changes the log level of the node.
+func (admin *Admin) SetLogLevel(args *AdminArgs, reply *string) error {
+	if err := setLogLevel(args.Level); err != nil {
+		return err
+	}
+	log.Printf("Log level changed to %s by the admin socket", args.Level)
+	*reply = args.Level
+	return nil
+}
+
+// SelfTest runs the self-test of the key shares of the clients of the node, and returns the health of each client.
+func (admin *Admin) SelfTest(args *AdminArgs, reply *[]*message.Health) error {
+	clients, err := admin.node.selectClients(args.Client)
+	if err != nil {
+		return err
+	}
+	list := make([]*message.Health, 0)
+	for _, client := range clients {
+		client.mutex.Lock()
+		log.Printf("The admin socket is running the self-test of client %s", client.GetConnString())
+		list = append(list, client.selfTest())
+		client.mutex.Unlock()
+	}
+	*reply = list
+	return nil
+}
+
+// Counters returns the numbers of requests of the clients answered by the node since it started, and the verification
+// metrics of their RSA keys.
+func (admin *Admin) Counters(args *AdminArgs, reply *[]*AdminCounters) error {
+	clients, err := admin.node.selectClients(args.Client)
+	if err != nil {
+		return err
+	}
+	list := make([]*AdminCounters, 0)
+	for _, client := range clients {
+		client.mutex.Lock()
+		c := &AdminCounters{
+			Client:       client.GetConnString(),
+			Started:      admin.node.started,
+			Requests:     make(map[string]uint64),
+			Errors:       make(map[string]uint64),
+			Verification: client.rsa().verifyMetrics(),
+		}
+		for mType, n := range client.counters.requests {
+			c.Requests[mType.String()] = n
+		}
+		for nodeErr, n := range client.counters.errors {
+			c.Errors[nodeErr.Error()] = n
+		}
+		client.mutex.Unlock()
+		list = append(list, c)
+	}
+	*reply = list
+	return nil
+}
+
+// selectClients returns the client with the public key or host provided, or every client if it is empty.
+func (node *Node) selectClients(id string) ([]*Client, error) {
+	if id == "" {
+		return node.clients, nil
+	}
+	for _, client := range node.clients {
+		if client.GetID() == id || client.GetConnString() == id || client.host.String() == id {
+			return []*Client{client}, nil
+		}
+	}
+	return nil, fmt.Errorf("client %s not found", id)
+}
+
+// setLogLevel changes the log level of the node.
+func setLogLevel(level string) error {
+	switch level {
+	case LogDebug:
+		log.SetOutput(os.Stderr)
+		zmq4.AuthSetVerbose(true)
+	case LogInfo:
+		log.SetOutput(os.Stderr)
+		zmq4.AuthSetVerbose(false)
+	case LogSilent:
+		log.SetOutput(ioutil.Discard)
+		zmq4.AuthSetVerbose(false)
+	default:
+		return fmt.Errorf("unknown log level %s, it should be %s, %s or %s", level, LogDebug, LogInfo, LogSilent)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+// +build !unix
+
+package server
+
+import "net"
+
+// listenPrivate opens a Unix socket. Systems without umask rely on the mode set after it is created.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeAdminPermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		dirMode os.FileMode
+		ok      bool
+	}{
+		{"private directory", 0700, true},
+		{"directory writable by the group", 0770, false},
+		{"directory writable by other users", 0707, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			dir := filepath.Join(node.dir, "run")
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(dir, test.dirMode); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "admin.sock")
+			err := node.ServeAdmin(path)
+			if !test.ok {
+				if err == nil {
+					t.Errorf("admin socket opened in a directory with mode %s", test.dirMode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm()&0077 != 0 {
+				t.Errorf("admin socket created with mode %s", info.Mode().Perm())
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+// +build unix
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate opens a Unix socket that only the user of the node can use. The socket is created with the umask of
+// the process, so it is cleared while it is created: changing the mode of the socket afterwards would let other users
+// connect in between.
+func listenPrivate(path string) (net.Listener, error) {
+	mask := syscall.Umask(0077)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// algorithm represents a threshold scheme supported by the node. Each scheme registers itself with registerAlgorithm
+// in an init function of its file, together with the handlers of its message types, so the node can load and save
+// its keys without knowing the scheme.
+type algorithm struct {
+	name   string                                            // Name of the scheme, used in the config file.
+	curves []string                                          // Curves supported by the scheme. Empty if it does not use curves.
+	load   func(conf *config.ClientConfig) (keyStore, error) // Creates the key store of a client from its config.
+}
+
+// keyStore represents the keys a client has on the node for a scheme, and the state of their signing processes.
+type keyStore interface {
+	// save writes the keys of the store in the client config.
+	save(conf *config.ClientConfig) error
+	// len returns the number of keys in the store.
+	len() int
+	// lifecycles returns the lifecycle state of each key in the store, by key ID.
+	lifecycles() map[string]*lifecycle
+	// delete removes a key from the store, with its archived key shares. Its key shares are only overwritten in memory
+	// with zeros by the zero function of the deletion, after the config file without them is saved. It returns nil if
+	// the store has nothing with the ID provided.
+	delete(id string) *deletion
+	// inventory returns the inventory entries of the keys in the store.
+	inventory(now time.Time) []*KeyInfo
+	// selfTest tests each key share against its key meta, and quarantines the ones that fail.
+	selfTest() []*message.KeyHealth
+	// endSession drops the signing session of a key, if there is one, so it cannot go on after the key share changed
+	// its lifecycle state or was replaced.
+	endSession(id string)
+}
+
+// deletion represents a key removed from a key store, whose key shares are still in memory.
+type deletion struct {
+	wipe    *message.Wipe // Archived key shares removed. Shares is set by zero.
+	zero    func()        // Overwrites the removed key shares in memory with zeros, and counts them.
+	restore func()        // Puts the key and its archived key shares back in the store, if the config cannot be saved.
+}
+
+func init() {
+	registerHandler(message.GetAlgorithms, 0, (*Client).dispatchAlgorithms) // {} -> algorithmList
+}
+
+// algorithms is the list of the threshold schemes supported by the node.
+var algorithms = make([]*algorithm, 0)
+
+// registerAlgorithm adds a threshold scheme to the list of supported schemes.
+func registerAlgorithm(alg *algorithm) {
+	sort.Strings(alg.curves)
+	algorithms = append(algorithms, alg)
+	sort.Slice(algorithms, func(i, j int) bool {
+		return algorithms[i].name < algorithms[j].name
+	})
+}
+
+// getAlgorithm returns the threshold scheme with the name provided, or nil if it is not supported.
+func getAlgorithm(name string) *algorithm {
+	for _, alg := range algorithms {
+		if alg.name == name {
+			return alg
+		}
+	}
+	return nil
+}
+
+// supportsCurve returns true if the scheme supports the curve provided.
+func (alg *algorithm) supportsCurve(curve string) bool {
+	for _, c := range alg.curves {
+		if c == curve {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedAlgorithms represents the schemes and curves a client can create keys with.
+// An empty list of schemes or curves allows every supported one.
+type allowedAlgorithms struct {
+	names  map[string]bool
+	curves map[string]map[string]bool // Allowed curves, by scheme name.
+}
+
+func parseAllowedAlgorithms(names []string, curves map[string][]string) (*allowedAlgorithms, error) {
+	allowed := &allowedAlgorithms{
+		names:  make(map[string]bool),
+		curves: make(map[string]map[string]bool),
+	}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if getAlgorithm(name) == nil {
+			return nil, fmt.Errorf("unknown algorithm %s", name)
+		}
+		allowed.names[name] = true
+	}
+	for name, algCurves := range curves {
+		alg := getAlgorithm(name)
+		if alg == nil {
+			return nil, fmt.Errorf("unknown algorithm %s", name)
+		}
+		allowed.curves[name] = make(map[string]bool)
+		for _, curve := range algCurves {
+			if !alg.supportsCurve(curve) {
+				return nil, fmt.Errorf("curve %s is not supported by algorithm %s", curve, name)
+			}
+			allowed.curves[name][curve] = true
+		}
+	}
+	return allowed, nil
+}
+
+// Allow returns an error if the client cannot create a key using the scheme and curve provided. The curve is ignored
+// for schemes that do not use curves.
+func (allowed *allowedAlgorithms) Allow(name, curve string) error {
+	alg := getAlgorithm(name)
+	if alg == nil {
+		return fmt.Errorf("algorithm %s is not supported", name)
+	}
+	if len(allowed.names) > 0 && !allowed.names[name] {
+		return fmt.Errorf("algorithm %s is not allowed", name)
+	}
+	if len(alg.curves) == 0 {
+		return nil
+	}
+	if !alg.supportsCurve(curve) {
+		return fmt.Errorf("curve %s is not supported by algorithm %s", curve, name)
+	}
+	if len(allowed.curves[name]) > 0 && !allowed.curves[name][curve] {
+		return fmt.Errorf("curve %s is not allowed for algorithm %s", curve, name)
+	}
+	return nil
+}
+
+// List returns the schemes the client can create keys with. Schemes with curves are listed once per curve, as
+// name:curve.
+func (allowed *allowedAlgorithms) List() []string {
+	list := make([]string, 0)
+	for _, alg := range algorithms {
+		if len(alg.curves) == 0 {
+			if allowed.Allow(alg.name, "") == nil {
+				list = append(list, alg.name)
+			}
+			continue
+		}
+		for _, curve := range alg.curves {
+			if allowed.Allow(alg.name, curve) == nil {
+				list = append(list, alg.name+":"+curve)
+			}
+		}
+	}
+	return list
+}
+
+// canCreate returns true if the client is allowed to create keys with the scheme and curve provided.
+func (client *Client) canCreate(name, curve string) bool {
+	if err := client.algorithms.Allow(name, curve); err != nil {
+		log.Printf("Client %s cannot create the key: %s", client.GetConnString(), err)
+		return false
+	}
+	return true
+}
+
+// dispatchAlgorithms answers the messages about the threshold schemes supported by the node.
+func (client *Client) dispatchAlgorithms(msg *message.Message) *message.Message {
+	resp := msg.NewResponse(client.node.GetID(), message.Ok)
+	switch msg.Type {
+	case message.GetAlgorithms:
+		log.Printf("Client %s is asking us for the supported algorithms", client.GetConnString())
+		encodedList, err := message.EncodeAlgorithmList(client.algorithms.List())
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encodedList)
+	default:
+		log.Printf("invalid message received from client %s", client.GetConnString())
+		resp.Error = message.InvalidMessageError
+	}
+	return resp
+}
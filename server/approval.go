@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// DefaultApprovalTimeout is the time a request waits for operator approval if the config does not define it.
+const DefaultApprovalTimeout = time.Hour
+
+// ApprovalStatus represents the decision of an operator about a held request.
+type ApprovalStatus string
+
+// The following consts represent the decisions of an operator.
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+)
+
+// Approval represents a request held by the node until an operator approves or rejects it. Each one is saved as a
+// JSON file in the approval directory, so the operators can decide on it with dtcnode approvals while the node runs.
+type Approval struct {
+	ID        string         // Message ID of the request. The client polls for the result sending the request again.
+	Client    string         // Client that sent the request.
+	Type      string         // Type of the request.
+	Algorithm string         // Algorithm of the key.
+	Key       string         // ID of the key.
+	Data      []string       // Hashes to sign, or the ciphertext to decrypt, in hexadecimal.
+	Digest    string         // SHA-256 digest of the type and data of the request, in hexadecimal.
+	Received  time.Time      // Time the node received the request.
+	Expires   time.Time      // Time the request is rejected if it was not approved.
+	Status    ApprovalStatus // Decision of the operator.
+	Decided   time.Time      // Time of the decision. Zero while it is pending.
+}
+
+// approvals represents the requests of a client held until an operator approves them.
+type approvals struct {
+	dir     string                    // Directory where the requests are saved. Empty disables approvals.
+	timeout time.Duration             // Time a request waits for approval before it is rejected.
+	held    map[replyKey]*heldRequest // Held requests by sender and message ID.
+}
+
+// heldRequest represents a request waiting for approval, with the message the client sends again to poll for its
+// result.
+type heldRequest struct {
+	approval *Approval
+	msg      *message.Message
+	digest   []byte
+	approved bool // True while the approved request is executed.
+}
+
+// ApprovalDir returns the directory where a node saves the requests waiting for approval: the approvaldir option, or
+// the approvals directory next to its config file. It returns an empty string if there is none.
+func ApprovalDir(conf *config.Config, configFile string) string {
+	if conf.ApprovalDir != "" {
+		return conf.ApprovalDir
+	}
+	if configFile == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(configFile), "approvals")
+}
+
+// newApprovals returns an empty approval queue saved in the directory provided. The requests left by a previous run of
+// the node are removed, because their messages were only kept in memory.
+func newApprovals(dir string, timeout time.Duration) (*approvals, error) {
+	if timeout <= 0 {
+		timeout = DefaultApprovalTimeout
+	}
+	a := &approvals{
+		dir:     dir,
+		timeout: timeout,
+		held:    make(map[replyKey]*heldRequest),
+	}
+	if dir == "" {
+		return a, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range stale {
+		log.Printf("Removing request %s held for approval before the node restarted", strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// get returns the held request with the same sender, ID and contents as the message, or nil if there is none.
+func (a *approvals) get(msg *message.Message) *heldRequest {
+	req, ok := a.held[replyKey{msg.From, msg.ID}]
+	if !ok || !bytes.Equal(req.digest, requestDigest(msg)) {
+		return nil
+	}
+	return req
+}
+
+// contains returns true if there is a held request with the same sender and ID as the message, whatever its contents.
+func (a *approvals) contains(msg *message.Message) bool {
+	_, ok := a.held[replyKey{msg.From, msg.ID}]
+	return ok
+}
+
+// hold saves a request in the queue until an operator approves it. It fails if a request with the same sender and ID
+// is already held, so a request cannot be replaced after the operators listed it.
+func (a *approvals) hold(msg *message.Message, client, algorithm, keyID string, data [][]byte, now time.Time) (*heldRequest, error) {
+	if a.dir == "" {
+		return nil, fmt.Errorf("there is no approval directory")
+	}
+	if _, err := hex.DecodeString(msg.ID); err != nil || msg.ID == "" {
+		return nil, fmt.Errorf("invalid message ID %q", msg.ID)
+	}
+	if a.contains(msg) {
+		return nil, fmt.Errorf("request %s is already held", msg.ID)
+	}
+	digest := requestDigest(msg)
+	approval := &Approval{
+		ID:        msg.ID,
+		Client:    client,
+		Type:      msg.Type.String(),
+		Algorithm: algorithm,
+		Key:       keyID,
+		Digest:    hex.EncodeToString(digest),
+		Received:  now.UTC(),
+		Expires:   now.Add(a.timeout).UTC(),
+		Status:    ApprovalPending,
+	}
+	for _, datum := range data {
+		approval.Data = append(approval.Data, hex.EncodeToString(datum))
+	}
+	if err := writeApproval(a.dir, approval); err != nil {
+		return nil, err
+	}
+	req := &heldRequest{
+		approval: approval,
+		msg:      msg,
+		digest:   digest,
+	}
+	a.held[replyKey{msg.From, msg.ID}] = req
+	return req, nil
+}
+
+// reload reads the decision of the operator about a held request. A request whose file was removed is rejected.
+func (a *approvals) reload(req *heldRequest) error {
+	approval, err := readApproval(approvalPath(a.dir, req.approval.ID))
+	if os.IsNotExist(err) {
+		req.approval.Status = ApprovalRejected
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	req.approval.Status = approval.Status
+	req.approval.Decided = approval.Decided
+	return nil
+}
+
+// remove deletes a request from the queue and from the approval directory.
+func (a *approvals) remove(req *heldRequest) {
+	delete(a.held, replyKey{req.msg.From, req.msg.ID})
+	if err := os.Remove(approvalPath(a.dir, req.approval.ID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("cannot remove held request %s: %s", req.approval.ID, err)
+	}
+}
+
+// checkApproval returns message.Ok if an operator approved the request. Otherwise, it holds the request until an
+// operator decides on it and returns message.ApprovalPendingError.
+func (client *Client) checkApproval(msg *message.Message, algorithm, keyID string, data ...[]byte) message.NodeError {
+	if req := client.approvals.get(msg); req != nil && req.approved {
+		return message.Ok
+	}
+	if client.approvals.contains(msg) {
+		log.Printf("Request %s is already held for approval with other contents, refusing it", msg.ID)
+		return message.InvalidMessageError
+	}
+	req, err := client.approvals.hold(msg, client.GetConnString(), algorithm, keyID, data, time.Now())
+	if err != nil {
+		log.Printf("cannot hold request %s for approval: %s", msg.ID, err)
+		return message.InternalError
+	}
+	log.Printf("Request %s with %s key %s is waiting for the approval of an operator until %s", msg.ID, algorithm, keyID, req.approval.Expires.Format(time.RFC3339))
+	client.audit("approval-requested", algorithm, keyID, fmt.Sprintf("request %s with digest %s", msg.ID, req.approval.Digest))
+	return message.ApprovalPendingError
+}
+
+// pollApproval answers a request sent again by the client while it was held. It executes the request if an operator
+// approved it, and rejects it if an operator rejected it or its approval timed out.
+func (client *Client) pollApproval(req *heldRequest, msg *message.Message) *message.Message {
+	approval := req.approval
+	if err := client.approvals.reload(req); err != nil {
+		log.Printf("cannot read the approval of request %s: %s", approval.ID, err)
+	}
+	switch {
+	case !time.Now().Before(approval.Expires):
+		log.Printf("Request %s was not approved in time", approval.ID)
+		client.audit("approval-expired", approval.Algorithm, approval.Key, "request "+approval.ID)
+	case approval.Status == ApprovalApproved:
+		log.Printf("Request %s was approved by an operator, executing it", approval.ID)
+		client.audit("approve", approval.Algorithm, approval.Key, "request "+approval.ID)
+		req.approved = true
+		resp := client.dispatch(msg)
+		client.approvals.remove(req)
+		return resp
+	case approval.Status == ApprovalRejected:
+		log.Printf("Request %s was rejected by an operator", approval.ID)
+		client.audit("reject", approval.Algorithm, approval.Key, "request "+approval.ID)
+	default:
+		return msg.NewResponse(client.node.GetID(), message.ApprovalPendingError)
+	}
+	client.approvals.remove(req)
+	return msg.NewResponse(client.node.GetID(), message.ApprovalRejectedError)
+}
+
+// expireApprovals rejects the held requests whose approval timed out, saving the rejection in the reply cache, so a
+// client that polls later gets it. Approved requests also expire if the client does not poll for them in time. The node
+// calls it before answering each message.
+func (client *Client) expireApprovals(now time.Time) {
+	for _, req := range client.approvals.held {
+		if !now.Before(req.approval.Expires) {
+			client.replies.Add(req.msg, client.pollApproval(req, req.msg), now)
+		}
+	}
+}
+
+// ListApprovals returns the requests saved in an approval directory, sorted by the time the node received them.
+func ListApprovals(dir string) ([]*Approval, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Approval, 0)
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		approval, err := readApproval(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %s", file.Name(), err)
+		}
+		list = append(list, approval)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Received.Before(list[j].Received)
+	})
+	return list, nil
+}
+
+// DecideApproval approves or rejects a pending request saved in an approval directory. A request is only approved if
+// the digest provided is its digest, so the operators approve the contents they listed. The node executes or rejects
+// the request the next time the client polls for it.
+func DecideApproval(dir, id, digest string, approve bool) (*Approval, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, fmt.Errorf("invalid request ID %q", id)
+	}
+	approval, err := readApproval(approvalPath(dir, id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("request %s not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if approval.Status != ApprovalPending {
+		return nil, fmt.Errorf("request %s was already %s", id, approval.Status)
+	}
+	if approve && !strings.EqualFold(digest, approval.Digest) {
+		return nil, fmt.Errorf("request %s has digest %s, not %q", id, approval.Digest, digest)
+	}
+	now := time.Now()
+	if !now.Before(approval.Expires) {
+		return nil, fmt.Errorf("request %s expired at %s", id, approval.Expires.Format(time.RFC3339))
+	}
+	approval.Status = ApprovalRejected
+	if approve {
+		approval.Status = ApprovalApproved
+	}
+	approval.Decided = now.UTC()
+	if err := writeApproval(dir, approval); err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// approvalPath returns the file of a request in an approval directory.
+func approvalPath(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+func readApproval(path string) (*Approval, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var approval Approval
+	if err := json.Unmarshal(data, &approval); err != nil {
+		return nil, err
+	}
+	return &approval, nil
+}
+
+// writeApproval saves a request into a temporary file and renames it over the old one, so the node never reads a
+// partially written decision.
+func writeApproval(dir string, approval *Approval) error {
+	data, err := json.MarshalIndent(approval, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(dir, ".tmp-"+approval.ID+".json")
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Decisions written by root must still be readable by a node that dropped its privileges.
+	if err := matchDirOwner(tmpPath, dir); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, approvalPath(dir, approval.ID)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// newTestApprovals gives the client of the node an approval queue saved in its directory.
+func newTestApprovals(t *testing.T, node *testNode, timeout time.Duration) string {
+	dir := filepath.Join(node.dir, "approvals")
+	var err error
+	if node.client.approvals, err = newApprovals(dir, timeout); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestApprovalHold(t *testing.T) {
+	node := newTestNode(t)
+	defer node.close()
+	dir := newTestApprovals(t, node, time.Hour)
+	a := node.client.approvals
+	now := time.Now()
+	msg := testRequest("1f2e", now, []byte("k"), []byte("hash"))
+	req, err := a.hold(msg, "client", rsaAlgorithm, "k", [][]byte{[]byte("hash")}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.approval.Digest != hex.EncodeToString(requestDigest(msg)) {
+		t.Errorf("approval digest is not the digest of the request")
+	}
+	tests := []struct {
+		name string
+		msg  *message.Message
+	}{
+		{"same request", testRequest("1f2e", now, []byte("k"), []byte("hash"))},
+		{"same ID with other contents", testRequest("1f2e", now, []byte("k"), []byte("other hash"))},
+		{"invalid ID", testRequest("request", now, []byte("k"), []byte("hash"))},
+	}
+	for _, test := range tests {
+		if _, err := a.hold(test.msg, "client", rsaAlgorithm, "k", test.msg.Data[1:], now); err == nil {
+			t.Errorf("%s: request held", test.name)
+		}
+	}
+	list, err := ListApprovals(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Digest != req.approval.Digest || list[0].Data[0] != hex.EncodeToString([]byte("hash")) {
+		t.Errorf("held request replaced in the approval directory")
+	}
+}
+
+func TestDecideApproval(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		digest   func(approval *Approval) string
+		approve  bool
+		decided  bool
+		wait     time.Duration
+		expected ApprovalStatus
+	}{
+		{"approved", "1f2e", func(a *Approval) string { return a.Digest }, true, false, 0, ApprovalApproved},
+		{"approved with uppercase digest", "1f2e", func(a *Approval) string { return strings.ToUpper(a.Digest) }, true, false, 0, ApprovalApproved},
+		{"approved without digest", "1f2e", func(a *Approval) string { return "" }, true, false, 0, ""},
+		{"approved with another digest", "1f2e", func(a *Approval) string { return hex.EncodeToString(make([]byte, sha256.Size)) }, true, false, 0, ""},
+		{"rejected without digest", "1f2e", func(a *Approval) string { return "" }, false, false, 0, ApprovalRejected},
+		{"already decided", "1f2e", func(a *Approval) string { return a.Digest }, true, true, 0, ""},
+		{"expired", "1f2e", func(a *Approval) string { return a.Digest }, true, false, -2 * time.Hour, ""},
+		{"not found", "3c4d", func(a *Approval) string { return a.Digest }, true, false, 0, ""},
+		{"invalid ID", "../1f2e", func(a *Approval) string { return a.Digest }, true, false, 0, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			dir := newTestApprovals(t, node, time.Hour)
+			now := time.Now().Add(test.wait)
+			msg := testRequest("1f2e", now, []byte("k"), []byte("hash"))
+			req, err := node.client.approvals.hold(msg, "client", rsaAlgorithm, "k", msg.Data[1:], now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.decided {
+				if _, err := DecideApproval(dir, "1f2e", "", false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			approval, err := DecideApproval(dir, test.id, test.digest(req.approval), test.approve)
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("request %s", approval.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := node.client.approvals.reload(req); err != nil {
+				t.Fatal(err)
+			}
+			if req.approval.Status != test.expected {
+				t.Errorf("expected request %s, got %s", test.expected, req.approval.Status)
+			}
+		})
+	}
+}
+
+func TestApprovalFlow(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	node := newTestNode(t)
+	defer node.close()
+	dir := newTestApprovals(t, node, time.Hour)
+	client := node.client
+	if err := client.SaveRSAKey("k", copyRSAKeyShare(shares[0]), meta); err != nil {
+		t.Fatal(err)
+	}
+	client.rsa().keys["k"].requiresApproval = true
+	hash := sha256.Sum256([]byte("document"))
+	other := sha256.Sum256([]byte("other document"))
+	request := func(nonce string, hash []byte) *message.Message {
+		return &message.Message{
+			From:      "client",
+			ID:        "1f2e",
+			Type:      message.GetRSASigShare,
+			Timestamp: time.Now().UnixNano(),
+			Nonce:     nonce,
+			Data:      [][]byte{[]byte("k"), hash, message.RSAPKCS1v15.Bytes()},
+		}
+	}
+	held := request("n1", hash[:])
+	steps := []struct {
+		name     string
+		msg      *message.Message
+		approve  bool
+		expected message.NodeError
+	}{
+		{"held", held, false, message.ApprovalPendingError},
+		{"same ID with another hash", request("n2", other[:]), false, message.InvalidMessageError},
+		{"poll while pending", held, false, message.ApprovalPendingError},
+		{"poll after approval", held, true, message.Ok},
+	}
+	for _, step := range steps {
+		if step.approve {
+			list, err := ListApprovals(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 1 {
+				t.Fatalf("%s: %d held requests instead of 1", step.name, len(list))
+			}
+			if _, err := DecideApproval(dir, list[0].ID, list[0].Digest, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp := client.answer(step.msg)
+		if resp.Error != step.expected {
+			t.Errorf("%s: expected %s, got %s", step.name, step.expected, resp.Error)
+		}
+	}
+	if len(client.approvals.held) != 0 {
+		t.Errorf("executed request still held")
+	}
+}
+
+// TestBatchApprovalAfterPolicy checks that a batch the client cannot sign is refused without asking an operator.
+func TestBatchApprovalAfterPolicy(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	hash := sha256.Sum256([]byte("document"))
+	tests := []struct {
+		name       string
+		operations []string
+		hashLength int
+		expected   message.NodeError // Error of the signature share of the hash.
+	}{
+		{"sign not allowed", []string{"decrypt"}, 0, message.PermissionDeniedError},
+		{"hash length not allowed", []string{"sign"}, 20, message.PermissionDeniedError},
+		{"allowed", []string{"sign"}, 0, message.Ok},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			newTestApprovals(t, node, time.Hour)
+			client := node.client
+			policy := &config.PolicyConfig{Key: "k", Operations: test.operations}
+			if test.hashLength != 0 {
+				policy.HashLengths = []int{test.hashLength}
+			}
+			var err error
+			if client.policies, err = parsePolicies([]*config.PolicyConfig{policy}); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.SaveRSAKey("k", copyRSAKeyShare(shares[0]), meta); err != nil {
+				t.Fatal(err)
+			}
+			client.rsa().keys["k"].requiresApproval = true
+			hashes, err := message.EncodeRSAHashList([][]byte{hash[:]})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := node.request(message.GetRSASigShareBatch, []byte("k"), hashes, message.RSAPKCS1v15.Bytes())
+			if test.expected == message.Ok {
+				if resp.Error != message.ApprovalPendingError {
+					t.Errorf("expected %s, got %s", message.ApprovalPendingError, resp.Error)
+				}
+				return
+			}
+			if resp.Error != message.Ok {
+				t.Fatalf("batch failed: %s", resp.Error)
+			}
+			sigShares, err := message.DecodeRSABatchSigShares(resp.Data[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sigShares) != 1 || sigShares[0].Error != test.expected {
+				t.Errorf("expected a share with error %s", test.expected)
+			}
+			if len(client.approvals.held) != 0 {
+				t.Errorf("batch the client cannot sign held for approval")
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// auditEntry is a line of the audit log, which records the operations that destroy key material as one JSON object
+// per line.
+type auditEntry struct {
+	Time      string
+	Node      string // CURVE public key of the node.
+	Client    string
+	Event     string
+	Algorithm string `json:",omitempty"`
+	Key       string `json:",omitempty"`
+	Detail    string `json:",omitempty"`
+}
+
+// openAuditLog returns the logger of the audit log, appending to the file provided, or writing to the standard error
+// if it is empty.
+func openAuditLog(path string) (*log.Logger, error) {
+	if path == "" {
+		return log.New(os.Stderr, "AUDIT ", 0), nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(file, "", 0), nil
+}
+
+// audit records an event about a key of the client in the audit log of the node.
+func (client *Client) audit(event, algorithm, keyID, detail string) {
+	line, err := json.Marshal(&auditEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Node:      client.node.pubKey,
+		Client:    client.GetConnString(),
+		Event:     event,
+		Algorithm: algorithm,
+		Key:       keyID,
+		Detail:    detail,
+	})
+	if err != nil {
+		log.Printf("cannot encode audit log entry: %s", err)
+		return
+	}
+	client.node.audit.Print(string(line))
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// DefaultReplyCacheSize is the number of responses saved by the reply cache if the config does not define it.
+const DefaultReplyCacheSize = 128
+
+// replyCacheHardLimit is the number of times the size of the reply cache it can hold while keeping young responses.
+// Past it, the oldest response is removed even if it is young, so a flood of requests cannot exhaust the memory of the
+// node. A retry of its request is then rejected as a replay, but it is not executed twice.
+const replyCacheHardLimit = 8
+
+// replyCache saves the last responses sent to a client, so a retried request is answered with the same response
+// instead of being executed twice. It is bounded: when it is full, the oldest response is removed, unless a retry of its
+// request could still pass the replay guard. Otherwise, the retry would be rejected as a replay instead of answered.
+// It never holds more than maxSize responses, young or not.
+type replyCache struct {
+	size    int                        // Maximum number of responses saved, unless they are younger than keepFor.
+	maxSize int                        // Maximum number of responses saved, even if they are younger than keepFor.
+	keepFor time.Duration              // Time a response is kept even if the cache is full.
+	order   *list.List                 // List of cached replies, the most recent at the front.
+	entries map[replyKey]*list.Element // Map from request sender and ID to its position on the list.
+}
+
+// replyKey identifies a request by its sender and message ID.
+type replyKey struct {
+	from string
+	id   string
+}
+
+// cachedReply represents a response saved in the cache, with a digest of the request that generated it.
+type cachedReply struct {
+	key    replyKey
+	digest []byte
+	resp   *message.Message
+	added  time.Time // Time the response was saved.
+}
+
+// newReplyCache returns an empty reply cache for a client whose requests are checked by a replay guard with the
+// maximum clock skew provided. A request timestamped up to maxSkew after the time it was answered can be retried up to
+// maxSkew after its timestamp, so its response is kept for twice the skew.
+func newReplyCache(size int, maxSkew time.Duration) *replyCache {
+	if size <= 0 {
+		size = DefaultReplyCacheSize
+	}
+	return &replyCache{
+		size:    size,
+		maxSize: replyCacheHardLimit * size,
+		keepFor: 2 * maxSkew,
+		order:   list.New(),
+		entries: make(map[replyKey]*list.Element),
+	}
+}
+
+// Get returns the response sent to a previous request with the same sender, ID and contents, or nil if there is none.
+func (cache *replyCache) Get(msg *message.Message) *message.Message {
+	elem, ok := cache.entries[replyKey{msg.From, msg.ID}]
+	if !ok {
+		return nil
+	}
+	reply := elem.Value.(*cachedReply)
+	if !bytes.Equal(reply.digest, requestDigest(msg)) {
+		return nil
+	}
+	return reply.resp
+}
+
+// Add saves the response to a request, removing the oldest responses if the cache is full and they are older than the
+// time responses are kept, or if the cache holds its maximum size.
+func (cache *replyCache) Add(msg *message.Message, resp *message.Message, now time.Time) {
+	key := replyKey{msg.From, msg.ID}
+	if elem, ok := cache.entries[key]; ok {
+		cache.order.Remove(elem)
+	}
+	cache.entries[key] = cache.order.PushFront(&cachedReply{
+		key:    key,
+		digest: requestDigest(msg),
+		resp:   resp,
+		added:  now,
+	})
+	for cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		reply := oldest.Value.(*cachedReply)
+		if cache.order.Len() <= cache.maxSize && now.Sub(reply.added) < cache.keepFor {
+			break
+		}
+		cache.order.Remove(oldest)
+		delete(cache.entries, reply.key)
+	}
+}
+
+// requestDigest returns a hash of the type and data of a request, used to check that a request with a known ID is a
+// retry of the same request.
+func requestDigest(msg *message.Message) []byte {
+	h := sha256.New()
+	h.Write([]byte{byte(msg.Type)})
+	for _, datum := range msg.Data {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(datum)))
+		h.Write(length[:])
+		h.Write(datum)
+	}
+	return h.Sum(nil)
+}
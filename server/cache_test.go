@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+func TestReplyCache(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	skew := time.Minute
+	tests := []struct {
+		name   string
+		added  []time.Duration // Times the requests 0, 1, 2... are answered, after start.
+		get    string          // ID of the request retried.
+		data   string          // Data of the retried request.
+		cached bool
+	}{
+		{"retry", []time.Duration{0}, "0", "data", true},
+		{"unknown request", []time.Duration{0}, "1", "data", false},
+		{"same ID, different data", []time.Duration{0}, "0", "other", false},
+		{"full cache keeps young responses", []time.Duration{0, 0, 0, 0}, "0", "data", true},
+		{"full cache drops old responses", []time.Duration{0, 0, 3 * time.Minute}, "0", "data", false},
+		{"full cache keeps the newest responses", []time.Duration{0, 0, 3 * time.Minute}, "1", "data", true},
+		{"old responses under the size are kept", []time.Duration{0, 3 * time.Minute}, "0", "data", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := newReplyCache(2, skew)
+			for i, added := range test.added {
+				now := start.Add(added)
+				req := testRequest(fmt.Sprint(i), now, []byte("data"))
+				cache.Add(req, req.NewResponse("node", message.Ok), now)
+			}
+			resp := cache.Get(testRequest(test.get, start, []byte(test.data)))
+			if (resp != nil) != test.cached {
+				t.Errorf("expected cached %v, got response %v", test.cached, resp)
+			}
+		})
+	}
+}
+
+// TestReplyCacheHardLimit checks that the cache removes young responses when it holds its maximum size.
+func TestReplyCacheHardLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := newReplyCache(2, time.Minute)
+	total := 3 * cache.maxSize
+	for i := 0; i < total; i++ {
+		req := testRequest(fmt.Sprint(i), now)
+		cache.Add(req, req.NewResponse("node", message.Ok), now)
+	}
+	if cache.order.Len() != cache.maxSize || len(cache.entries) != cache.maxSize {
+		t.Errorf("expected %d responses, got %d on the list and %d on the map", cache.maxSize, cache.order.Len(), len(cache.entries))
+	}
+	if cache.Get(testRequest("0", now)) != nil {
+		t.Errorf("oldest response kept past the maximum size")
+	}
+	if cache.Get(testRequest(fmt.Sprint(total-1), now)) == nil {
+		t.Errorf("newest response removed")
+	}
+}
+
+// TestReplyCacheRetryAfterReplayWindow checks that a retry accepted by the timestamp check of the replay guard is
+// answered from the cache, even after more requests than the size of the cache were answered.
+func TestReplyCacheRetryAfterReplayWindow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	guard := newReplayGuard(time.Minute)
+	cache := newReplyCache(4, guard.maxSkew)
+	// The first request is timestamped with the maximum skew, so it can be retried until twice the skew.
+	first := testRequest("first", start.Add(guard.maxSkew))
+	if err := guard.Check(first, start); err != nil {
+		t.Fatalf("first request rejected: %s", err)
+	}
+	cache.Add(first, first.NewResponse("node", message.Ok), start)
+	for i := 0; i < 16; i++ {
+		now := start.Add(time.Duration(i) * 7 * time.Second)
+		req := testRequest(fmt.Sprint(i), now)
+		if err := guard.Check(req, now); err != nil {
+			t.Fatalf("request %d rejected: %s", i, err)
+		}
+		cache.Add(req, req.NewResponse("node", message.Ok), now)
+	}
+	// The replay guard rejects the retry, because its nonce was used, so it must be answered by the cache.
+	retry := start.Add(2*guard.maxSkew - time.Second)
+	if err := guard.Check(first, retry); err == nil {
+		t.Errorf("retry of the first request accepted by the replay guard")
+	}
+	if cache.Get(first) == nil {
+		t.Errorf("response to the first request removed from the cache before its retries are rejected by timestamp")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/niclabs/dtcnode/v3/message"
 	"github.com/pebbe/zmq4"
@@ -12,11 +14,18 @@ import (
 // Client represents the connection with the Distributed TCHSM server.
 // It saves its connection values, its public key, and the keyshares and keymetainfo sent by the server.
 type Client struct {
-	host   *net.IPAddr // IP where the server is listening.
-	pubKey string      // Public key of the server. Used for SMQ CURVE auth.
-	rsa    rsa         // struct with RSA structures, as keys.
-	ecdsa  ecdsa       // struct with ECDSA structures, as keys and the active currentSession.
-	node   *Node       // A pointer to the node that manages this server subroutine.
+	host       *net.IPAddr         // IP where the server is listening.
+	pubKey     string              // Public key of the server. Used for SMQ CURVE auth.
+	keys       map[string]keyStore // Key stores of the client, by algorithm name.
+	node       *Node               // A pointer to the node that manages this server subroutine.
+	replies    *replyCache         // The last responses sent to the client, used to answer retried requests.
+	replay     *replayGuard        // The nonces already used by the client, used to reject replayed requests.
+	policies   *policies           // The rules the client must follow to use its keys.
+	algorithms *allowedAlgorithms  // The schemes and curves the client can create keys with.
+	health     *message.Health     // The result of the last self-test of the key shares of the client.
+	approvals  *approvals          // The requests held until an operator approves them.
+	counters   *counters           // The numbers of requests answered, shown by the admin socket.
+	mutex      sync.Mutex          // Serializes the messages of the client and the admin socket calls.
 }
 
 // GetID returns the id of the server.
@@ -29,11 +38,31 @@ func (client *Client) GetConnString() string {
 	return fmt.Sprintf("%s://%s", TchsmProtocol, client.host)
 }
 
+// allow returns true if the key policy allows the client to do the operation with the key. The operation only counts
+// for the rate limit of the key if the request succeeds.
+func (client *Client) allow(keyID string, op operation, hash []byte) bool {
+	if err := client.policies.Allow(keyID, op, hash, time.Now()); err != nil {
+		log.Printf("Client %s is not allowed to %s with key %s: %s", client.GetConnString(), op, keyID, err)
+		return false
+	}
+	return true
+}
+
 // Listen is the subroutine that keeps waiting for message on its channel. Then it acts depending on each message.
+// It returns when the node is closed.
 func (client *Client) Listen() {
+	stop := make(chan struct{})
+	defer close(stop)
+	go client.purgeEvery(keyPurgeInterval, stop)
 	for {
 		log.Printf("Waiting for message...")
 		rawMsg, err := client.node.socket.RecvMessageBytes(0)
+		if zmq4.AsErrno(err) == zmq4.ETERM {
+			log.Printf("Node closed, closing the socket of client %s", client.GetConnString())
+			client.node.socket.SetLinger(0)
+			client.node.socket.Close()
+			return
+		}
 		if err != nil {
 			log.Printf("%s", message.ReceiveMessageError.ComposeError(err))
 			continue
@@ -45,22 +74,9 @@ func (client *Client) Listen() {
 			log.Printf("%s", message.ParseMessageError.ComposeError(err))
 			continue
 		}
-		var resp *message.Message
-		if !msg.ValidClientDataLength() {
-			resp.Error = message.InvalidMessageError
-		} else {
-			if msg.Type.IsRSA() {
-				resp = client.dispatchRSA(msg)
-			} else if msg.Type.IsECDSA() {
-				resp = client.dispatchECDSA(msg)
-			} else {
-				log.Printf("Unknown message of type %d. Ignored.", msg.Type)
-				continue
-			}
-		}
-		if resp.Error != message.Ok {
-			log.Printf("Error processing message: %s", resp.Error.Error())
-		}
+		client.mutex.Lock()
+		resp := client.answer(msg)
+		client.mutex.Unlock()
 		log.Printf("sending response to client %s", client.GetConnString())
 		if _, err := client.node.socket.SendMessage(resp.GetBytesLists()...); err != nil {
 			log.Printf("%s", err.Error())
@@ -74,3 +90,38 @@ func (client *Client) Listen() {
 		log.Printf("A response to client %s was sent", client.GetConnString())
 	}
 }
+
+// answer returns the response to a message, sending the cached response again if it was already answered.
+func (client *Client) answer(msg *message.Message) *message.Message {
+	client.expireApprovals(time.Now())
+	var resp *message.Message
+	if cached := client.replies.Get(msg); cached != nil {
+		log.Printf("message %s from %s was already answered, sending the same response again", msg.ID, msg.From)
+		resp = cached
+	} else {
+		if held := client.approvals.get(msg); held != nil {
+			// The client polls for the result of a held request sending it again, so it is not a replay.
+			resp = client.pollApproval(held, msg)
+		} else if err := client.replay.Check(msg, time.Now()); err != nil {
+			log.Printf("rejecting message %s from %s: %s", msg.ID, msg.From, err)
+			resp = msg.NewResponse(client.node.GetID(), message.ReplayedMessageError)
+		} else {
+			client.purgeKeys(time.Now())
+			resp = client.dispatch(msg)
+		}
+		if resp.Error != message.ApprovalPendingError {
+			client.replies.Add(msg, resp, time.Now())
+		}
+	}
+	if resp.Error != message.Ok {
+		log.Printf("Error processing message: %s", resp.Error.Error())
+	}
+	// Requests refused by a later check, or held for approval, do not use the rate limit of their keys.
+	if resp.Error == message.Ok {
+		client.policies.Commit(time.Now())
+	} else {
+		client.policies.Discard()
+	}
+	client.counters.count(msg.Type, resp.Error)
+	return resp
+}
@@ -5,30 +5,99 @@ import (
 	"fmt"
 	"github.com/niclabs/dtcnode/v3/config"
 	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/refresh"
 	"github.com/niclabs/tcecdsa"
 	"log"
+	"math/big"
+	"time"
 )
 
 type ecdsa struct {
 	keys           map[string]*ecdsaKey
+	archived       []*config.ECDSAKeyConfig // Replaced key shares, saved so they can be recovered.
+	received       map[string]*ecdsaRefresh // Key shares received by resharing for keys the node does not have yet.
 	currentKey     string
 	currentSession *tcecdsa.SigSession
+	sessionStarted time.Time        // Time the client started the current session.
+	sessionRound   int              // Last round of the current session answered by the node. The signature is round 4.
+	presigning     *ecdsaPresigning // Presignature the client is creating, until it is added to the pool of its key.
 }
 
 // ecdsaKey represents a keyshare managed by the node and used by the server for signing documents.
 type ecdsaKey struct {
-	ID        string
-	Completed bool
-	Share     *tcecdsa.KeyShare
-	Meta      *tcecdsa.KeyMeta
+	ID               string
+	Completed        bool
+	Share            *tcecdsa.KeyShare
+	Meta             *tcecdsa.KeyMeta
+	Epoch            uint64                       // Number of refreshes applied to the key share.
+	pending          *ecdsaRefresh                // Refreshed key share waiting for the client to commit it.
+	quarantine       error                        // Reason why the key share failed the self-test. It is not used while it is set.
+	lifecycle                                     // Lifecycle state of the key share.
+	usage                                         // Signature counters and limits of the key share.
+	requiresApproval bool                         // Signing requests wait until an operator of the node approves them.
+	presignatures    []*message.ECDSAPresignature // One-time presignatures, removed from the pool when they are used.
+}
+
+// ecdsaRefresh represents a refreshed key share, kept next to the current one until the client commits or aborts the
+// refresh.
+type ecdsaRefresh struct {
+	Epoch uint64
+	Share *tcecdsa.KeyShare
+	Meta  *tcecdsa.KeyMeta
+}
+
+// ecdsaAlgorithm is the name of the ECDSA threshold scheme.
+const ecdsaAlgorithm = "ecdsa"
+
+func init() {
+	curves := make([]string, 0)
+	for name := range tcecdsa.CurveNameToCurve {
+		curves = append(curves, name)
+	}
+	registerAlgorithm(&algorithm{
+		name:   ecdsaAlgorithm,
+		curves: curves,
+		load:   loadECDSAKeys,
+	})
+	for mType, dataLength := range map[message.Type]int{
+		message.SendECDSAKeyShare:          3, // keyID, keyShare, keyMeta -> InitKeyMessage
+		message.ReplaceECDSAKeyShare:       3, // keyID, keyShare, keyMeta -> InitKeyMessage
+		message.ECDSAInitKeys:              2, // keyID, InitKeyMessageList -> {}
+		message.ECDSARound1:                2, // keyID, hash -> Round1Message
+		message.ECDSARound2:                1, // Round1MessageList -> Round2Message
+		message.ECDSARound3:                1, // Round2MessageList -> Round3Message
+		message.ECDSAGetSignature:          1, // Round3MessageList -> (r, s)
+		message.DeleteECDSAKeyShare:        1, // keyID -> wipe
+		message.RefreshECDSAKeyShare:       4, // keyID, epoch, delta, keyMeta -> {}
+		message.CommitECDSAKeyShareRefresh: 2, // keyID, epoch -> {}
+		message.AbortECDSAKeyShareRefresh:  2, // keyID, epoch -> {}
+		message.DealECDSAKeyShare:          2, // keyID, reshare -> dealing, publicShare
+		message.ReshareECDSAKeyShare:       7, // keyID, epoch, index, keyMeta, newKeyMeta, dealingList, publicShare -> {}
+	} {
+		registerHandler(mType, dataLength, (*Client).dispatchECDSA)
+	}
 }
 
 func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 	resp := msg.NewResponse(client.node.GetID(), message.Ok)
 	switch msg.Type {
-	case message.SendECDSAKeyShare:
+	case message.SendECDSAKeyShare, message.ReplaceECDSAKeyShare:
 		keyID := string(msg.Data[0])
 		log.Printf("Client %s is sending us a new incomplete ECDSA KeyShare with id=%s", client.GetConnString(), keyID)
+		old, exists := client.ecdsa().keys[keyID]
+		if exists && msg.Type != message.ReplaceECDSAKeyShare {
+			log.Printf("ECDSA keyshare with keyid=%s already exists, refusing to overwrite it", keyID)
+			resp.Error = message.KeyAlreadyExistsError
+			break
+		}
+		if exists && !old.allows(overwriteOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if exists && !client.allow(keyID, overwriteOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
 		keyShare, err := message.DecodeECDSAKeyShare(msg.Data[1])
 		if err != nil {
 			log.Printf("error decoding ECDSA KeyShare message: %s", err)
@@ -41,6 +110,10 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 			resp.Error = message.DecodingError
 			break
 		}
+		if !client.canCreate(ecdsaAlgorithm, keyMeta.CurveName) {
+			resp.Error = message.AlgorithmNotAllowedError
+			break
+		}
 		keyInitMsg, err := keyShare.Init(keyMeta)
 		encodedKeyInit, err := message.EncodeECDSAKeyInitMessage(keyInitMsg)
 		if err != nil {
@@ -49,8 +122,14 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 			break
 		}
 		resp.AddMessage(encodedKeyInit)
-		log.Printf("Saving incomplete keyshare for keyid=%s", keyID)
-		if err := client.SaveECDSAKey(keyID, keyShare, keyMeta); err != nil {
+		if exists {
+			log.Printf("Archiving old keyshare and saving incomplete keyshare for keyid=%s", keyID)
+			err = client.ReplaceECDSAKey(keyID, keyShare, keyMeta)
+		} else {
+			log.Printf("Saving incomplete keyshare for keyid=%s", keyID)
+			err = client.SaveECDSAKey(keyID, keyShare, keyMeta)
+		}
+		if err != nil {
 			log.Printf("Error with incomplete ECDSA keyshare saving process: %s", err)
 			resp.Error = message.InternalError
 			break
@@ -65,12 +144,17 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 			resp.Error = message.DecodingError
 			break
 		}
-		key, ok := client.ecdsa.keys[keyID]
+		key, ok := client.ecdsa().keys[keyID]
 		if !ok {
 			log.Printf("error finding ECDSA key with id: %s", keyID)
 			resp.Error = message.KeyNotFoundError
 			break
 		}
+		if key.Share.Alpha != nil {
+			log.Printf("ECDSA key with id %s is already initialized, refusing to overwrite it", keyID)
+			resp.Error = message.KeyAlreadyExistsError
+			break
+		}
 		err = key.Share.SetKey(key.Meta, keyInitMessages)
 		if err != nil {
 			log.Printf("error setting ECDSA Key: %s", err)
@@ -87,22 +171,48 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 		log.Printf("complete ECDSA Keyshare saved for keyid=%s", keyID)
 	case message.ECDSARound1:
 		keyID := string(msg.Data[0])
-		key, ok := client.ecdsa.keys[keyID]
+		key, ok := client.ecdsa().keys[keyID]
 		if !ok {
 			log.Printf("error finding ECDSA key with id: %s. Keys available:", keyID)
-			for k, _ := range client.ecdsa.keys {
+			for k, _ := range client.ecdsa().keys {
 				log.Printf("%s", k)
 			}
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if !key.allows(signOperation) {
+			resp.Error = message.KeyStateError
+			break
 		}
-		client.ecdsa.currentKey = keyID
 		h := msg.Data[1]
+		if !client.allow(keyID, signOperation, h) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		if !client.canSign(keyID, &key.usage) {
+			resp.Error = message.SignatureLimitError
+			break
+		}
+		if key.requiresApproval {
+			if nodeErr := client.checkApproval(msg, ecdsaAlgorithm, keyID, h); nodeErr != message.Ok {
+				resp.Error = nodeErr
+				break
+			}
+		}
+		client.ecdsa().currentKey = keyID
 		log.Printf("Starting Round1 in signing document with key %s as asked by client %s", keyID, client.GetConnString())
 		session, err := key.Share.NewSigSession(key.Meta, h)
 		if err != nil {
 			resp.Error = message.InternalError
 			break
 		}
-		client.ecdsa.currentSession = session
+		client.ecdsa().currentSession = session
+		client.ecdsa().sessionStarted = time.Now()
+		client.ecdsa().sessionRound = 0
 		round1Msg, err := session.Round1()
 		if err != nil {
 			log.Printf("cannot execute round 1: %s", err)
@@ -115,12 +225,12 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 			resp.Error = message.EncodingError
 			break
 		}
+		client.ecdsa().sessionRound = 1
 		resp.AddMessage(encoded)
 	case message.ECDSARound2:
-		keyID := client.ecdsa.currentKey
-		if keyID == "" {
-			log.Printf("Error: currentKey is not set %s", keyID)
-			resp.Error = message.InternalError
+		keyID, _, nodeErr := client.ecdsaSessionKey()
+		if nodeErr != message.Ok {
+			resp.Error = nodeErr
 			break
 		}
 		log.Printf("Starting Round2 in signing document with key %s as asked by client %s", keyID, client.GetConnString())
@@ -130,7 +240,7 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 			resp.Error = message.DecodingError
 			break
 		}
-		round2Msg, err := client.ecdsa.currentSession.Round2(round1Messages)
+		round2Msg, err := client.ecdsa().currentSession.Round2(round1Messages)
 		if err != nil {
 			log.Printf("cannot execute round 2: %s", err)
 			resp.Error = message.InternalError
@@ -142,22 +252,28 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 			resp.Error = message.EncodingError
 			break
 		}
+		client.ecdsa().sessionRound = 2
 		resp.AddMessage(encoded)
 	case message.ECDSARound3:
-		keyID := client.ecdsa.currentKey
-		if keyID == "" {
-			log.Printf("Error: currentKey is not set %s", keyID)
-			resp.Error = message.InternalError
+		keyID, key, nodeErr := client.ecdsaSessionKey()
+		if nodeErr != message.Ok {
+			resp.Error = nodeErr
 			break
 		}
-		log.Printf("Starting Round2 in signing document with key %s as asked by client %s", keyID, client.GetConnString())
+		// The share of the signature leaves the node in this round, so the limit is checked and the signature is counted
+		// here, before sending it.
+		if !client.canSign(keyID, &key.usage) {
+			resp.Error = message.SignatureLimitError
+			break
+		}
+		log.Printf("Starting Round3 in signing document with key %s as asked by client %s", keyID, client.GetConnString())
 		round2Messages, err := message.DecodeECDSARound2MessageList(msg.Data[0])
 		if err != nil {
 			log.Printf("cannot decode ECDSA round 2 message List: %s", err)
 			resp.Error = message.DecodingError
 			break
 		}
-		round3Msg, err := client.ecdsa.currentSession.Round3(round2Messages)
+		round3Msg, err := client.ecdsa().currentSession.Round3(round2Messages)
 		if err != nil {
 			log.Printf("cannot execute round 3: %s", err)
 			resp.Error = message.InternalError
@@ -169,22 +285,26 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 			resp.Error = message.EncodingError
 			break
 		}
+		if err := client.countSignatures(&key.usage, 1); err != nil {
+			resp.Error = message.InternalError
+			break
+		}
+		client.ecdsa().sessionRound = 3
 		resp.AddMessage(encoded)
 	case message.ECDSAGetSignature:
-		keyID := client.ecdsa.currentKey
-		if keyID == "" {
-			log.Printf("Error: currentKey is not set %s", keyID)
-			resp.Error = message.InternalError
+		keyID, _, nodeErr := client.ecdsaSessionKey()
+		if nodeErr != message.Ok {
+			resp.Error = nodeErr
 			break
 		}
-		log.Printf("Starting Round3 in signing document with key %s as asked by client %s", keyID, client.GetConnString())
+		log.Printf("Getting the signature of the document with key %s as asked by client %s", keyID, client.GetConnString())
 		round3Messages, err := message.DecodeECDSARound3MessageList(msg.Data[0])
 		if err != nil {
 			log.Printf("cannot decode ECDSA round 3 message list: %s", err)
 			resp.Error = message.DecodingError
 			break
 		}
-		r, s, err := client.ecdsa.currentSession.GetSignature(round3Messages)
+		r, s, err := client.ecdsa().currentSession.GetSignature(round3Messages)
 		if err != nil {
 			log.Printf("error getting signature: %s", err)
 			resp.Error = message.InternalError
@@ -196,39 +316,646 @@ func (client *Client) dispatchECDSA(msg *message.Message) *message.Message {
 			resp.Error = message.EncodingError
 			break
 		}
+		client.ecdsa().sessionRound = 4
 		resp.AddMessage(encoded)
 	case message.DeleteECDSAKeyShare:
 		log.Printf("Client %s is asking us to delete a ECDSA KeyShare", client.GetConnString())
 		keyID := string(msg.Data[0])
+		if !client.allow(keyID, deleteOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
 		log.Printf("Deleting keyshare for keyid=%s", keyID)
-		if err := client.DeleteECDSAKey(keyID); err != nil {
+		wipe, err := client.DeleteECDSAKey(keyID)
+		if err != nil {
 			log.Printf("Error with key deleting: %s", err)
 			resp.Error = message.InternalError
 			break
 		}
-		log.Printf("Keyshare deleted for keyid=%s", keyID)
+		encodedWipe, err := message.EncodeWipe(wipe)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encodedWipe)
+		log.Printf("Keyshare deleted for keyid=%s: %s", keyID, wipe)
+	case message.RefreshECDSAKeyShare:
+		keyID := string(msg.Data[0])
+		log.Printf("Client %s is sending us a refresh of ECDSA KeyShare with id=%s", client.GetConnString(), keyID)
+		key, ok := client.ecdsa().keys[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if key.Share.Alpha == nil {
+			log.Printf("ECDSA key with id %s is not initialized, it cannot be refreshed", keyID)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if !key.allows(refreshOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if !client.allow(keyID, refreshOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		epoch, err := message.DecodeEpoch(msg.Data[1])
+		if err != nil {
+			log.Printf("error decoding refresh epoch: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if epoch != key.Epoch+1 {
+			log.Printf("refresh epoch %d does not follow epoch %d of key %s", epoch, key.Epoch, keyID)
+			resp.Error = message.EpochMismatchError
+			break
+		}
+		keyMeta, err := message.DecodeECDSAKeyMeta(msg.Data[3])
+		if err != nil {
+			log.Printf("error decoding ECDSA KeyMeta message: %s", err)
+			resp.Error = message.DecodingError
+			break
+		}
+		keyShare, err := refresh.RefreshECDSAKeyShare(key.Share, new(big.Int).SetBytes(msg.Data[2]), key.Meta, keyMeta)
+		if err != nil {
+			log.Printf("invalid refresh of key %s: %s", keyID, err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if err := client.RefreshECDSAKey(keyID, epoch, keyShare, keyMeta); err != nil {
+			log.Printf("Error with ECDSA keyshare refresh saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		log.Printf("Refreshed keyshare saved for keyid=%s and epoch %d, waiting for commit", keyID, epoch)
+	case message.DealECDSAKeyShare:
+		keyID := string(msg.Data[0])
+		log.Printf("Client %s is asking us to deal ECDSA KeyShare with id=%s to a new node set", client.GetConnString(), keyID)
+		key, ok := client.ecdsa().keys[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if key.Share.Alpha == nil {
+			log.Printf("ECDSA key with id %s is not initialized, it cannot be reshared", keyID)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if !key.allows(reshareOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if !client.allow(keyID, reshareOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		reshare, err := message.DecodeReshare(msg.Data[1])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		dealing, err := refresh.NewECDSADealing(key.Share, key.Meta, reshare.Dealers, reshare.K, reshare.L, reshare.Recipients)
+		if err != nil {
+			log.Printf("cannot deal key %s: %s", keyID, err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		encodedDealing, err := message.EncodeDealing(dealing)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		// The encrypted secret and the public key do not change, so the nodes receiving the new shares get them from
+		// the dealers.
+		encodedPublicShare, err := message.EncodeECDSAKeyShare(&tcecdsa.KeyShare{
+			Alpha: key.Share.Alpha,
+			Y:     key.Share.Y,
+		})
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encodedDealing)
+		resp.AddMessage(encodedPublicShare)
+		log.Printf("Keyshare with keyid=%s dealt to %d-of-%d new key shares", keyID, reshare.K, reshare.L)
+	case message.ReshareECDSAKeyShare:
+		keyID := string(msg.Data[0])
+		log.Printf("Client %s is sending us a reshared ECDSA KeyShare with id=%s", client.GetConnString(), keyID)
+		key, exists := client.ecdsa().keys[keyID]
+		if exists && key.Share.Alpha == nil {
+			log.Printf("ECDSA key with id %s is not initialized, it cannot be reshared", keyID)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if exists && !key.allows(reshareOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if exists && !client.allow(keyID, reshareOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		epoch, err := message.DecodeEpoch(msg.Data[1])
+		if err != nil {
+			log.Printf("error decoding reshare epoch: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if exists && epoch != key.Epoch+1 || epoch == 0 {
+			log.Printf("reshare epoch %d does not follow the epoch of key %s", epoch, keyID)
+			resp.Error = message.EpochMismatchError
+			break
+		}
+		index, err := message.DecodeShareIndex(msg.Data[2])
+		if err != nil {
+			log.Printf("error decoding reshare index: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		keyMeta, err := message.DecodeECDSAKeyMeta(msg.Data[3])
+		if err != nil {
+			log.Printf("error decoding ECDSA KeyMeta message: %s", err)
+			resp.Error = message.DecodingError
+			break
+		}
+		publicShare, err := message.DecodeECDSAKeyShare(msg.Data[6])
+		if err != nil {
+			log.Printf("error decoding ECDSA public share: %s", err)
+			resp.Error = message.DecodingError
+			break
+		}
+		if exists {
+			// A node holding the key checks the dealings against its own key meta and key share.
+			keyMeta = key.Meta
+			publicShare = key.Share
+		} else if !client.canCreate(ecdsaAlgorithm, keyMeta.CurveName) {
+			resp.Error = message.AlgorithmNotAllowedError
+			break
+		}
+		newKeyMeta, err := message.DecodeECDSAKeyMeta(msg.Data[4])
+		if err != nil {
+			log.Printf("error decoding ECDSA KeyMeta message: %s", err)
+			resp.Error = message.DecodingError
+			break
+		}
+		dealings, err := message.DecodeDealingList(msg.Data[5])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		keyShare, err := refresh.ReshareECDSAKeyShare(index, client.node.curveSecretKey(), publicShare, keyMeta, newKeyMeta, dealings)
+		if err != nil {
+			log.Printf("invalid reshare of key %s: %s", keyID, err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if exists {
+			err = client.RefreshECDSAKey(keyID, epoch, keyShare, newKeyMeta)
+		} else {
+			err = client.ReceiveECDSAKey(keyID, epoch, keyShare, newKeyMeta)
+		}
+		if err != nil {
+			log.Printf("Error with ECDSA keyshare reshare saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		log.Printf("Reshared keyshare saved for keyid=%s and epoch %d, waiting for commit", keyID, epoch)
+	case message.CommitECDSAKeyShareRefresh, message.AbortECDSAKeyShareRefresh:
+		keyID := string(msg.Data[0])
+		epoch, err := message.DecodeEpoch(msg.Data[1])
+		if err != nil {
+			log.Printf("error decoding refresh epoch: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		commit := msg.Type == message.CommitECDSAKeyShareRefresh
+		key, ok := client.ecdsa().keys[keyID]
+		if !ok {
+			resp.Error = client.finishReceivedECDSAKey(keyID, epoch, commit)
+			break
+		}
+		if key.pending == nil || key.pending.Epoch != epoch {
+			// Retried commits and aborts of refreshes this node never received are answered as done.
+			if commit && key.Epoch == epoch || !commit && key.Epoch < epoch {
+				break
+			}
+			log.Printf("key %s has no pending refresh for epoch %d", keyID, epoch)
+			resp.Error = message.EpochMismatchError
+			break
+		}
+		if commit {
+			log.Printf("Client %s is committing the refresh of ECDSA key %s to epoch %d", client.GetConnString(), keyID, epoch)
+			err = client.CommitECDSAKeyRefresh(keyID)
+		} else {
+			log.Printf("Client %s is aborting the refresh of ECDSA key %s to epoch %d", client.GetConnString(), keyID, epoch)
+			err = client.AbortECDSAKeyRefresh(keyID)
+		}
+		if err != nil {
+			log.Printf("Error with ECDSA keyshare refresh saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
 	}
 	return resp
 }
 
+// ecdsaSessionKey returns the ID and the key of the current signing session. It fails if there is no session, or if the
+// key share cannot sign anymore because it was quarantined or moved to a state that does not allow signing.
+func (client *Client) ecdsaSessionKey() (string, *ecdsaKey, message.NodeError) {
+	state := client.ecdsa()
+	if state.currentKey == "" || state.currentSession == nil {
+		log.Printf("Error: there is no ECDSA signing session")
+		return "", nil, message.InternalError
+	}
+	key, ok := state.keys[state.currentKey]
+	if !ok {
+		return "", nil, message.KeyNotFoundError
+	}
+	if key.quarantine != nil {
+		return "", nil, message.KeyQuarantinedError
+	}
+	if !key.allows(signOperation) {
+		log.Printf("ECDSA key %s cannot sign in state %s", state.currentKey, key.current())
+		return "", nil, message.KeyStateError
+	}
+	return state.currentKey, key, message.Ok
+}
+
 // SaveECDSAKey updates the key array of the server and asks the node to save the ecdsaKeys into the config file.
 func (client *Client) SaveECDSAKey(id string, keyShare *tcecdsa.KeyShare, keyMeta *tcecdsa.KeyMeta) error {
-	key, ok := client.ecdsa.keys[id]
+	key, ok := client.ecdsa().keys[id]
 	if !ok {
 		key = &ecdsaKey{}
-		client.ecdsa.keys[id] = key
+		client.ecdsa().keys[id] = key
 	}
 	key.ID = id
 	key.Meta = keyMeta
 	key.Share = keyShare
+	key.quarantine = nil
+	// A key share is pending initialization until the client sends the init messages of every node.
+	if keyShare.Alpha == nil {
+		key.state = message.KeyPendingInit
+	} else if key.state == message.KeyPendingInit {
+		key.state = message.KeyActive
+	}
 	return client.node.SaveConfigKeys()
 }
 
-// SaveECDSAKey deletes a key from the array of the server and asks the node to save the new key array into the config file.
-func (client *Client) DeleteECDSAKey(id string) error {
+// RefreshECDSAKey saves a refreshed key share next to the current one, which is still used until the client commits
+// the refresh.
+func (client *Client) RefreshECDSAKey(id string, epoch uint64, keyShare *tcecdsa.KeyShare, keyMeta *tcecdsa.KeyMeta) error {
+	key := client.ecdsa().keys[id]
+	old := key.pending
+	key.pending = &ecdsaRefresh{
+		Epoch: epoch,
+		Share: keyShare,
+		Meta:  keyMeta,
+	}
+	if err := client.node.SaveConfigKeys(); err != nil {
+		key.pending = old
+		return err
+	}
+	return nil
+}
+
+// CommitECDSAKeyRefresh replaces the key share with its pending refresh, ending the signing session of the key if
+// there is one. The old key share is discarded and not archived, because an attacker could combine it with other old
+// shares.
+func (client *Client) CommitECDSAKeyRefresh(id string) error {
+	key := client.ecdsa().keys[id]
+	old := *key
+	key.Share = key.pending.Share
+	key.Meta = key.pending.Meta
+	key.Epoch = key.pending.Epoch
+	key.pending = nil
+	// The presignatures are encrypted with the old threshold Paillier key.
+	key.presignatures = nil
+	// The pending key share was checked against its verification key when it was received.
+	key.quarantine = nil
+	if err := client.node.SaveConfigKeys(); err != nil {
+		*key = old
+		return err
+	}
+	client.ecdsa().endSession(id)
+	wipeECDSAKeyShare(old.Share)
+	return nil
+}
+
+// AbortECDSAKeyRefresh discards the pending refresh of a key share.
+func (client *Client) AbortECDSAKeyRefresh(id string) error {
+	key := client.ecdsa().keys[id]
+	old := key.pending
+	key.pending = nil
+	if err := client.node.SaveConfigKeys(); err != nil {
+		key.pending = old
+		return err
+	}
+	return nil
+}
+
+// ReceiveECDSAKey saves a key share received by resharing for a key the node does not have. It is not used until the
+// client commits it.
+func (client *Client) ReceiveECDSAKey(id string, epoch uint64, keyShare *tcecdsa.KeyShare, keyMeta *tcecdsa.KeyMeta) error {
+	old, ok := client.ecdsa().received[id]
+	client.ecdsa().received[id] = &ecdsaRefresh{
+		Epoch: epoch,
+		Share: keyShare,
+		Meta:  keyMeta,
+	}
+	if err := client.node.SaveConfigKeys(); err != nil {
+		if ok {
+			client.ecdsa().received[id] = old
+		} else {
+			delete(client.ecdsa().received, id)
+		}
+		return err
+	}
+	return nil
+}
+
+// finishReceivedECDSAKey commits or aborts the key share received by resharing for a key the node does not have, and
+// returns the error code of the response.
+func (client *Client) finishReceivedECDSAKey(id string, epoch uint64, commit bool) message.NodeError {
+	received, ok := client.ecdsa().received[id]
+	if !ok || received.Epoch != epoch {
+		if commit {
+			return message.KeyNotFoundError
+		}
+		// Aborts of reshares this node never received are answered as done.
+		return message.Ok
+	}
+	var err error
+	if commit {
+		log.Printf("Client %s is committing the ECDSA key %s received by resharing at epoch %d", client.GetConnString(), id, epoch)
+		err = client.CommitReceivedECDSAKey(id)
+	} else {
+		log.Printf("Client %s is aborting the ECDSA key %s received by resharing at epoch %d", client.GetConnString(), id, epoch)
+		err = client.AbortReceivedECDSAKey(id)
+	}
+	if err != nil {
+		log.Printf("Error with ECDSA keyshare reshare saving process: %s", err)
+		return message.InternalError
+	}
+	return message.Ok
+}
+
+// CommitReceivedECDSAKey adds the key share received by resharing to the keys of the node.
+func (client *Client) CommitReceivedECDSAKey(id string) error {
+	received := client.ecdsa().received[id]
+	client.ecdsa().keys[id] = &ecdsaKey{
+		ID:        id,
+		Completed: true,
+		Share:     received.Share,
+		Meta:      received.Meta,
+		Epoch:     received.Epoch,
+	}
+	delete(client.ecdsa().received, id)
+	if err := client.node.SaveConfigKeys(); err != nil {
+		delete(client.ecdsa().keys, id)
+		client.ecdsa().received[id] = received
+		return err
+	}
+	return nil
+}
+
+// AbortReceivedECDSAKey discards the key share received by resharing.
+func (client *Client) AbortReceivedECDSAKey(id string) error {
+	received := client.ecdsa().received[id]
+	delete(client.ecdsa().received, id)
+	if err := client.node.SaveConfigKeys(); err != nil {
+		client.ecdsa().received[id] = received
+		return err
+	}
+	return nil
+}
+
+// ReplaceECDSAKey archives the current key share with the ID provided and saves the new one in its place, ending the
+// signing session of the key if there is one. The key keeps its settings, lifecycle state and signature counters. The
+// old key share is only overwritten with zeros in memory after the config file with the new one and the archived one is
+// saved.
+func (client *Client) ReplaceECDSAKey(id string, keyShare *tcecdsa.KeyShare, keyMeta *tcecdsa.KeyMeta) error {
+	state := client.ecdsa()
+	old, ok := state.keys[id]
+	if !ok {
+		return client.SaveECDSAKey(id, keyShare, keyMeta)
+	}
+	archived, err := encodeECDSAKey(old)
+	if err != nil {
+		return err
+	}
+	archived.ArchivedAt = time.Now().UTC().Format(time.RFC3339)
+	// An archived key share moved back to the keys list must not sign again with presignatures it already had.
+	archived.Presignatures = nil
+	key := *old
+	key.Share = keyShare
+	key.Meta = keyMeta
+	key.Completed = false
+	key.Epoch = 0
+	key.pending = nil
+	key.presignatures = nil
+	key.quarantine = nil
+	// The new key share is pending initialization until the client sends the init messages of every node.
+	if keyShare.Alpha == nil {
+		key.state = message.KeyPendingInit
+	} else if key.state == message.KeyPendingInit {
+		key.state = message.KeyActive
+	}
+	oldArchived := state.archived
+	state.archived = append(oldArchived[:len(oldArchived):len(oldArchived)], archived)
+	state.keys[id] = &key
+	if err := client.node.SaveConfigKeys(); err != nil {
+		state.keys[id] = old
+		state.archived = oldArchived
+		return err
+	}
+	state.endSession(id)
+	wipeECDSAKeyShare(old.Share)
+	if old.pending != nil {
+		wipeECDSAKeyShare(old.pending.Share)
+	}
+	return nil
+}
+
+// DeleteECDSAKey deletes a key from the array of the server, overwriting its key shares with zeros, and asks the node
+// to save the new key array into the config file.
+func (client *Client) DeleteECDSAKey(id string) (*message.Wipe, error) {
 	log.Printf("deleting ecdsa key with id %s", id)
-	delete(client.ecdsa.keys, id)
-	return client.node.SaveConfigKeys()
+	return client.deleteKey(ecdsaAlgorithm, id)
+}
+
+// ecdsa returns the ECDSA key store of the client.
+func (client *Client) ecdsa() *ecdsa {
+	return client.keys[ecdsaAlgorithm].(*ecdsa)
+}
+
+// loadECDSAKeys creates the ECDSA key store of a client from its config.
+func loadECDSAKeys(conf *config.ClientConfig) (keyStore, error) {
+	keys, err := parseECDSAKeys(conf.ECDSA.Keys)
+	if err != nil {
+		return nil, err
+	}
+	received := make(map[string]*ecdsaRefresh)
+	for _, key := range conf.ECDSA.ReceivedKeys {
+		if received[key.ID], err = parseECDSARefresh(key); err != nil {
+			return nil, fmt.Errorf("invalid received key %s: %s", key.ID, err)
+		}
+	}
+	return &ecdsa{
+		keys:     keys,
+		archived: conf.ECDSA.ArchivedKeys,
+		received: received,
+	}, nil
+}
+
+func (state *ecdsa) save(conf *config.ClientConfig) error {
+	keys, err := saveECDSAKeys(state.keys)
+	if err != nil {
+		return err
+	}
+	conf.ECDSA.Keys = keys
+	conf.ECDSA.ArchivedKeys = state.archived
+	conf.ECDSA.ReceivedKeys = make([]*config.ECDSAKeyConfig, 0)
+	for id, received := range state.received {
+		keyConfig := &config.ECDSAKeyConfig{ID: id}
+		if err := encodeECDSARefresh(keyConfig, received); err != nil {
+			return err
+		}
+		conf.ECDSA.ReceivedKeys = append(conf.ECDSA.ReceivedKeys, keyConfig)
+	}
+	return nil
+}
+
+func (state *ecdsa) len() int {
+	return len(state.keys)
+}
+
+func (state *ecdsa) lifecycles() map[string]*lifecycle {
+	lifecycles := make(map[string]*lifecycle, len(state.keys))
+	for id, key := range state.keys {
+		lifecycles[id] = &key.lifecycle
+	}
+	return lifecycles
+}
+
+func (state *ecdsa) delete(id string) *deletion {
+	key, hasKey := state.keys[id]
+	received, hasReceived := state.received[id]
+	oldArchived := state.archived
+	archived := make([]*config.ECDSAKeyConfig, 0, len(state.archived))
+	for _, key := range state.archived {
+		if key.ID != id {
+			archived = append(archived, key)
+		}
+	}
+	if !hasKey && !hasReceived && len(archived) == len(oldArchived) {
+		return nil
+	}
+	delete(state.keys, id)
+	delete(state.received, id)
+	state.archived = archived
+	d := &deletion{wipe: &message.Wipe{Archived: len(oldArchived) - len(archived)}}
+	d.zero = func() {
+		state.endSession(id)
+		if hasKey {
+			d.wipe.Shares += wipeECDSAKeyShare(key.Share)
+			if key.pending != nil {
+				d.wipe.Shares += wipeECDSAKeyShare(key.pending.Share)
+			}
+		}
+		if hasReceived {
+			d.wipe.Shares += wipeECDSAKeyShare(received.Share)
+		}
+	}
+	d.restore = func() {
+		if hasKey {
+			state.keys[id] = key
+		}
+		if hasReceived {
+			state.received[id] = received
+		}
+		state.archived = oldArchived
+	}
+	return d
+}
+
+// wipeECDSAKeyShare overwrites the threshold Paillier share of a key share with zeros, and returns the number of key
+// shares overwritten.
+func wipeECDSAKeyShare(share *tcecdsa.KeyShare) int {
+	if share == nil || share.PaillierShare == nil {
+		return 0
+	}
+	wipeInt(share.PaillierShare.Si)
+	return 1
+}
+
+func (state *ecdsa) endSession(id string) {
+	if state.currentKey == id {
+		state.currentKey = ""
+		state.currentSession = nil
+	}
+	if state.presigning != nil && state.presigning.keyID == id {
+		state.presigning = nil
+	}
+}
+
+func (state *ecdsa) inventory(now time.Time) []*KeyInfo {
+	keys := make([]*KeyInfo, 0, len(state.keys))
+	for id, key := range state.keys {
+		info := keyInfo(ecdsaAlgorithm, id, &key.lifecycle, &key.usage, key.quarantine, now)
+		info.Epoch = key.Epoch
+		keys = append(keys, info)
+	}
+	return keys
+}
+
+func (state *ecdsa) selfTest() []*message.KeyHealth {
+	results := make([]*message.KeyHealth, 0, len(state.keys))
+	for id, key := range state.keys {
+		key.quarantine = key.selfTest()
+		results = append(results, keyHealth(ecdsaAlgorithm, id, key.quarantine))
+	}
+	return results
+}
+
+// selfTest partially decrypts a fixed Paillier ciphertext with the Paillier share of the key, and verifies the proof of
+// the decryption share with the verification key of the share in the key meta. tcecdsa signatures need K nodes, so
+// this is the part of the key share a node can test alone.
+func (key *ecdsaKey) selfTest() error {
+	if key.Share == nil || key.Meta == nil {
+		return fmt.Errorf("missing key share or key meta")
+	}
+	if key.Meta.PubKey == nil || key.Meta.Paillier == nil || key.Meta.Paillier.Delta == nil || len(key.Meta.Paillier.Vi) != int(key.Meta.Paillier.L) {
+		return fmt.Errorf("incomplete key meta")
+	}
+	paillier := key.Meta.Paillier
+	share := key.Share.PaillierShare
+	if share == nil || share.Si == nil || share.Index < 1 || share.Index > paillier.L {
+		return fmt.Errorf("invalid Paillier key share")
+	}
+	c, err := paillier.EncryptFixed(new(big.Int).SetBytes(selfTestDocument), big.NewInt(2))
+	if err != nil {
+		return err
+	}
+	// The proof is created with the verification key of the key meta, so it only verifies if the share matches it.
+	testShare := *share
+	testShare.PubKey = paillier
+	decryptShare, proof, err := testShare.PartialDecryptWithProof(c)
+	if err != nil {
+		return err
+	}
+	if err := proof.Verify(paillier, c, decryptShare); err != nil {
+		return fmt.Errorf("key share does not match its verification key: %s", err)
+	}
+	return nil
 }
 
 func parseECDSAKeys(conf []*config.ECDSAKeyConfig) (map[string]*ecdsaKey, error) {
@@ -254,33 +981,144 @@ func parseECDSAKeys(conf []*config.ECDSAKeyConfig) (map[string]*ecdsaKey, error)
 				return nil, err
 			}
 		}
+		pending, err := parseECDSARefresh(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pending refresh for key %s: %s", key.ID, err)
+		}
+		defaultState := message.KeyActive
+		if keyShare == nil || keyShare.Alpha == nil {
+			defaultState = message.KeyPendingInit
+		}
+		lifecycle, err := parseLifecycle(key.State, key.DeleteAfter, defaultState)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lifecycle state for key %s: %s", key.ID, err)
+		}
+		usage, err := parseUsage(key.Usage)
+		if err != nil {
+			return nil, fmt.Errorf("invalid usage for key %s: %s", key.ID, err)
+		}
+		presignatures, err := parseECDSAPresignatures(key.Presignatures)
+		if err != nil {
+			return nil, fmt.Errorf("invalid presignatures for key %s: %s", key.ID, err)
+		}
 		keys[key.ID] = &ecdsaKey{
-			ID:    key.ID,
-			Meta:  keyMeta,
-			Share: keyShare,
+			ID:               key.ID,
+			Meta:             keyMeta,
+			Share:            keyShare,
+			Epoch:            key.Epoch,
+			pending:          pending,
+			lifecycle:        lifecycle,
+			usage:            usage,
+			requiresApproval: key.RequiresApproval,
+			presignatures:    presignatures,
 		}
 	}
 	return keys, nil
 }
 
+// parseECDSAPresignatures decodes the presignatures of a key in the config file.
+func parseECDSAPresignatures(conf []string) ([]*message.ECDSAPresignature, error) {
+	presignatures := make([]*message.ECDSAPresignature, 0, len(conf))
+	for _, encoded := range conf {
+		presignatureBytes, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		presignature, err := message.DecodeECDSAPresignature(presignatureBytes)
+		if err != nil {
+			return nil, err
+		}
+		presignatures = append(presignatures, presignature)
+	}
+	return presignatures, nil
+}
+
+// parseECDSARefresh returns the pending refresh of a key in the config file, or nil if it has none.
+func parseECDSARefresh(key *config.ECDSAKeyConfig) (*ecdsaRefresh, error) {
+	if key.PendingEpoch == 0 {
+		return nil, nil
+	}
+	keyShareByte, err := base64.StdEncoding.DecodeString(key.PendingKeyShare)
+	if err != nil {
+		return nil, err
+	}
+	keyShare, err := message.DecodeECDSAKeyShare(keyShareByte)
+	if err != nil {
+		return nil, err
+	}
+	keyMetaByte, err := base64.StdEncoding.DecodeString(key.PendingKeyMeta)
+	if err != nil {
+		return nil, err
+	}
+	keyMeta, err := message.DecodeECDSAKeyMeta(keyMetaByte)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsaRefresh{
+		Epoch: key.PendingEpoch,
+		Share: keyShare,
+		Meta:  keyMeta,
+	}, nil
+}
+
 func saveECDSAKeys(keys map[string]*ecdsaKey) ([]*config.ECDSAKeyConfig, error) {
 	keysConfig := make([]*config.ECDSAKeyConfig, 0)
 	for _, key := range keys {
-		keyShareBytes, err := message.EncodeECDSAKeyShare(key.Share)
+		keyConfig, err := encodeECDSAKey(key)
 		if err != nil {
-			return nil, fmt.Errorf("error encoding ecdsaKeys: %s", err)
+			return nil, err
 		}
-		keyMetaBytes, err := message.EncodeECDSAKeyMeta(key.Meta)
+		keysConfig = append(keysConfig, keyConfig)
+	}
+	return keysConfig, nil
+}
+
+// encodeECDSAKey transforms a key into its representation in the config file.
+func encodeECDSAKey(key *ecdsaKey) (*config.ECDSAKeyConfig, error) {
+	keyShareBytes, err := message.EncodeECDSAKeyShare(key.Share)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding ecdsaKeys: %s", err)
+	}
+	keyMetaBytes, err := message.EncodeECDSAKeyMeta(key.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding ecdsaKeys: %s", err)
+	}
+	keyConfig := &config.ECDSAKeyConfig{
+		ID:          key.ID,
+		KeyMetaInfo: base64.StdEncoding.EncodeToString(keyMetaBytes),
+		KeyShare:    base64.StdEncoding.EncodeToString(keyShareBytes),
+		Epoch:       key.Epoch,
+	}
+	keyConfig.State, keyConfig.DeleteAfter = key.lifecycle.encode()
+	keyConfig.Usage = key.usage.encode()
+	keyConfig.RequiresApproval = key.requiresApproval
+	for _, presignature := range key.presignatures {
+		presignatureBytes, err := message.EncodeECDSAPresignature(presignature)
 		if err != nil {
 			return nil, fmt.Errorf("error encoding ecdsaKeys: %s", err)
 		}
-		keyShareB64 := base64.StdEncoding.EncodeToString(keyShareBytes)
-		keyMetaB64 := base64.StdEncoding.EncodeToString(keyMetaBytes)
-		keysConfig = append(keysConfig, &config.ECDSAKeyConfig{
-			ID:          key.ID,
-			KeyMetaInfo: keyMetaB64,
-			KeyShare:    keyShareB64,
-		})
+		keyConfig.Presignatures = append(keyConfig.Presignatures, base64.StdEncoding.EncodeToString(presignatureBytes))
 	}
-	return keysConfig, nil
+	if key.pending != nil {
+		if err := encodeECDSARefresh(keyConfig, key.pending); err != nil {
+			return nil, err
+		}
+	}
+	return keyConfig, nil
+}
+
+// encodeECDSARefresh saves a refreshed or reshared key share into the pending fields of a key in the config file.
+func encodeECDSARefresh(keyConfig *config.ECDSAKeyConfig, pending *ecdsaRefresh) error {
+	pendingShareBytes, err := message.EncodeECDSAKeyShare(pending.Share)
+	if err != nil {
+		return fmt.Errorf("error encoding ecdsaKeys: %s", err)
+	}
+	pendingMetaBytes, err := message.EncodeECDSAKeyMeta(pending.Meta)
+	if err != nil {
+		return fmt.Errorf("error encoding ecdsaKeys: %s", err)
+	}
+	keyConfig.PendingEpoch = pending.Epoch
+	keyConfig.PendingKeyShare = base64.StdEncoding.EncodeToString(pendingShareBytes)
+	keyConfig.PendingKeyMeta = base64.StdEncoding.EncodeToString(pendingMetaBytes)
+	return nil
 }
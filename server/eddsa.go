@@ -0,0 +1,439 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/tceddsa"
+)
+
+// eddsaAlgorithm is the name of the EdDSA threshold scheme.
+const eddsaAlgorithm = "eddsa"
+
+func init() {
+	registerAlgorithm(&algorithm{
+		name:   eddsaAlgorithm,
+		curves: []string{tceddsa.CurveName},
+		load:   loadEdDSAKeys,
+	})
+	for mType, dataLength := range map[message.Type]int{
+		message.SendEdDSAKeyShare:    3, // keyID, keyShare, keyMeta -> {}
+		message.ReplaceEdDSAKeyShare: 3, // keyID, keyShare, keyMeta -> {}
+		message.EdDSARound1:          1, // keyID -> Commitment
+		message.EdDSARound2:          3, // keyID, message, CommitmentList -> sigShare
+		message.DeleteEdDSAKeyShare:  1, // keyID -> wipe
+	} {
+		registerHandler(mType, dataLength, (*Client).dispatchEdDSA)
+	}
+}
+
+// eddsa represents the data related to eddsa signing processes
+type eddsa struct {
+	keys     map[string]*eddsaKey
+	archived []*config.EdDSAKeyConfig       // Replaced key shares, saved so they can be recovered.
+	sessions map[string]*tceddsa.SigSession // Signing sessions started in round 1, by key ID. They are used only once.
+}
+
+// eddsaKey represents a keyshare managed by the node and used by the server for signing documents.
+type eddsaKey struct {
+	ID         string
+	Share      *tceddsa.KeyShare
+	Meta       *tceddsa.KeyMeta
+	quarantine error // Reason why the key share failed the self-test. It is not used while it is set.
+	lifecycle        // Lifecycle state of the key share.
+	usage            // Signature counters and limits of the key share.
+}
+
+func (client *Client) dispatchEdDSA(msg *message.Message) *message.Message {
+	resp := msg.NewResponse(client.node.GetID(), message.Ok)
+	switch msg.Type {
+	case message.SendEdDSAKeyShare, message.ReplaceEdDSAKeyShare:
+		log.Printf("Client %s is sending us a new EdDSA KeyShare", client.GetConnString())
+		keyID := string(msg.Data[0])
+		old, exists := client.eddsa().keys[keyID]
+		if exists && msg.Type != message.ReplaceEdDSAKeyShare {
+			log.Printf("EdDSA keyshare with keyid=%s already exists, refusing to overwrite it", keyID)
+			resp.Error = message.KeyAlreadyExistsError
+			break
+		}
+		if exists && !old.allows(overwriteOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if exists && !client.allow(keyID, overwriteOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		if !client.canCreate(eddsaAlgorithm, tceddsa.CurveName) {
+			resp.Error = message.AlgorithmNotAllowedError
+			break
+		}
+		keyShare, err := message.DecodeEdDSAKeyShare(msg.Data[1])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		keyMeta, err := message.DecodeEdDSAKeyMeta(msg.Data[2])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		if err := keyShare.Verify(keyMeta); err != nil {
+			log.Printf("invalid EdDSA keyshare: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if exists {
+			log.Printf("Archiving old keyshare and saving new keyshare for keyid=%s", keyID)
+			err = client.ReplaceEdDSAKey(keyID, keyShare, keyMeta)
+		} else {
+			log.Printf("Saving keyshare for keyid=%s", keyID)
+			err = client.SaveEdDSAKey(keyID, keyShare, keyMeta)
+		}
+		if err != nil {
+			log.Printf("Error with EdDSA keyshare saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		log.Printf("Keyshare saved for keyid=%s", keyID)
+	case message.EdDSARound1:
+		keyID := string(msg.Data[0])
+		log.Printf("Starting Round1 in signing document with key %s as asked by client %s", keyID, client.GetConnString())
+		key, ok := client.eddsa().keys[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if !key.allows(signOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		session, commitment, err := key.Share.NewSigSession(key.Meta)
+		if err != nil {
+			log.Printf("cannot execute round 1: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		encoded, err := message.EncodeEdDSACommitment(commitment)
+		if err != nil {
+			log.Printf("cannot encode EdDSA commitment: %s", err)
+			resp.Error = message.EncodingError
+			break
+		}
+		client.eddsa().sessions[keyID] = session
+		resp.AddMessage(encoded)
+	case message.EdDSARound2:
+		keyID := string(msg.Data[0])
+		log.Printf("Starting Round2 in signing document with key %s as asked by client %s", keyID, client.GetConnString())
+		key, ok := client.eddsa().keys[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		session, ok := client.eddsa().sessions[keyID]
+		if !ok {
+			log.Printf("there is no signing session for key %s", keyID)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		// The nonces of a session must never sign twice, so the session is dropped even if this round fails.
+		delete(client.eddsa().sessions, keyID)
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if !key.allows(signOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		doc := msg.Data[1]
+		if !client.allow(keyID, signOperation, doc) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		if !client.canSign(keyID, &key.usage) {
+			resp.Error = message.SignatureLimitError
+			break
+		}
+		commitments, err := message.DecodeEdDSACommitmentList(msg.Data[2])
+		if err != nil {
+			log.Printf("cannot decode EdDSA commitment list: %s", err)
+			resp.Error = message.DecodingError
+			break
+		}
+		sigShare, err := session.Sign(doc, commitments)
+		if err != nil {
+			log.Printf("cannot execute round 2: %s", err)
+			resp.Error = message.DocSignError
+			break
+		}
+		if err := sigShare.Verify(doc, commitments, key.Meta); err != nil {
+			log.Printf("sig share of key %s does not verify: %s", keyID, err)
+			resp.Error = message.DocSignError
+			break
+		}
+		encoded, err := message.EncodeEdDSASigShare(sigShare)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		log.Printf("The document was signed succesfully with key %s as asked by client %s", keyID, client.GetConnString())
+		if err := client.countSignatures(&key.usage, 1); err != nil {
+			resp.Error = message.InternalError
+			break
+		}
+		resp.AddMessage(encoded)
+	case message.DeleteEdDSAKeyShare:
+		log.Printf("Client %s is asking us to delete a EdDSA KeyShare", client.GetConnString())
+		keyID := string(msg.Data[0])
+		if !client.allow(keyID, deleteOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		log.Printf("Deleting keyshare for keyid=%s", keyID)
+		wipe, err := client.DeleteEdDSAKey(keyID)
+		if err != nil {
+			log.Printf("Error with key deleting: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		encodedWipe, err := message.EncodeWipe(wipe)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encodedWipe)
+		log.Printf("Keyshare deleted for keyid=%s: %s", keyID, wipe)
+	default:
+		log.Printf("invalid message received from client %s", client.GetConnString())
+		resp.Error = message.InvalidMessageError
+	}
+	return resp
+}
+
+// SaveEdDSAKey updates the key array of the server and asks the node to save the eddsaKeys into the config file.
+func (client *Client) SaveEdDSAKey(id string, keyShare *tceddsa.KeyShare, keyMeta *tceddsa.KeyMeta) error {
+	key := &eddsaKey{
+		ID:    id,
+		Share: keyShare,
+		Meta:  keyMeta,
+	}
+	if old, ok := client.eddsa().keys[id]; ok {
+		// The signature limits and counters belong to the key ID, so replacing the key share does not reset them.
+		key.usage = old.usage
+	}
+	client.eddsa().keys[id] = key
+	client.eddsa().endSession(id)
+	return client.node.SaveConfigKeys()
+}
+
+// ReplaceEdDSAKey archives the current key share with the ID provided and saves the new one in its place. The old key
+// share is overwritten with zeros only after the config file is saved.
+func (client *Client) ReplaceEdDSAKey(id string, keyShare *tceddsa.KeyShare, keyMeta *tceddsa.KeyMeta) error {
+	state := client.eddsa()
+	old, ok := state.keys[id]
+	if !ok {
+		return client.SaveEdDSAKey(id, keyShare, keyMeta)
+	}
+	archived, err := encodeEdDSAKey(old)
+	if err != nil {
+		return err
+	}
+	archived.ArchivedAt = time.Now().UTC().Format(time.RFC3339)
+	key := *old
+	key.Share = keyShare
+	key.Meta = keyMeta
+	key.quarantine = nil
+	oldArchived := state.archived
+	state.archived = append(oldArchived[:len(oldArchived):len(oldArchived)], archived)
+	state.keys[id] = &key
+	if err := client.node.SaveConfigKeys(); err != nil {
+		state.keys[id] = old
+		state.archived = oldArchived
+		return err
+	}
+	state.endSession(id)
+	wipeBytes(old.Share.Secret)
+	return nil
+}
+
+// DeleteEdDSAKey deletes a key from the array of the server, overwriting its key share with zeros, and asks the node
+// to save the new key array into the config file.
+func (client *Client) DeleteEdDSAKey(id string) (*message.Wipe, error) {
+	log.Printf("deleting eddsa key with id %s", id)
+	return client.deleteKey(eddsaAlgorithm, id)
+}
+
+// eddsa returns the EdDSA key store of the client.
+func (client *Client) eddsa() *eddsa {
+	return client.keys[eddsaAlgorithm].(*eddsa)
+}
+
+// loadEdDSAKeys creates the EdDSA key store of a client from its config.
+func loadEdDSAKeys(conf *config.ClientConfig) (keyStore, error) {
+	keys, err := parseEdDSAKeys(conf.EdDSA.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return &eddsa{
+		keys:     keys,
+		archived: conf.EdDSA.ArchivedKeys,
+		sessions: make(map[string]*tceddsa.SigSession),
+	}, nil
+}
+
+func (state *eddsa) save(conf *config.ClientConfig) error {
+	keys, err := saveEdDSAKeys(state.keys)
+	if err != nil {
+		return err
+	}
+	conf.EdDSA.Keys = keys
+	conf.EdDSA.ArchivedKeys = state.archived
+	return nil
+}
+
+func (state *eddsa) len() int {
+	return len(state.keys)
+}
+
+func (state *eddsa) lifecycles() map[string]*lifecycle {
+	lifecycles := make(map[string]*lifecycle, len(state.keys))
+	for id, key := range state.keys {
+		lifecycles[id] = &key.lifecycle
+	}
+	return lifecycles
+}
+
+func (state *eddsa) delete(id string) *deletion {
+	key, hasKey := state.keys[id]
+	oldArchived := state.archived
+	archived := make([]*config.EdDSAKeyConfig, 0, len(state.archived))
+	for _, key := range state.archived {
+		if key.ID != id {
+			archived = append(archived, key)
+		}
+	}
+	if !hasKey && len(archived) == len(oldArchived) {
+		return nil
+	}
+	delete(state.keys, id)
+	state.archived = archived
+	d := &deletion{wipe: &message.Wipe{Archived: len(oldArchived) - len(archived)}}
+	d.zero = func() {
+		state.endSession(id)
+		if hasKey && key.Share != nil {
+			wipeBytes(key.Share.Secret)
+			d.wipe.Shares++
+		}
+	}
+	d.restore = func() {
+		if hasKey {
+			state.keys[id] = key
+		}
+		state.archived = oldArchived
+	}
+	return d
+}
+
+func (state *eddsa) endSession(id string) {
+	delete(state.sessions, id)
+}
+
+func (state *eddsa) inventory(now time.Time) []*KeyInfo {
+	keys := make([]*KeyInfo, 0, len(state.keys))
+	for id, key := range state.keys {
+		keys = append(keys, keyInfo(eddsaAlgorithm, id, &key.lifecycle, &key.usage, key.quarantine, now))
+	}
+	return keys
+}
+
+func (state *eddsa) selfTest() []*message.KeyHealth {
+	results := make([]*message.KeyHealth, 0, len(state.keys))
+	for id, key := range state.keys {
+		if key.Share == nil || key.Meta == nil {
+			key.quarantine = fmt.Errorf("missing key share or key meta")
+		} else {
+			key.quarantine = key.Share.Verify(key.Meta)
+		}
+		results = append(results, keyHealth(eddsaAlgorithm, id, key.quarantine))
+	}
+	return results
+}
+
+func parseEdDSAKeys(conf []*config.EdDSAKeyConfig) (map[string]*eddsaKey, error) {
+	keys := make(map[string]*eddsaKey)
+	for _, key := range conf {
+		keyShareByte, err := base64.StdEncoding.DecodeString(key.KeyShare)
+		if err != nil {
+			return nil, err
+		}
+		keyShare, err := message.DecodeEdDSAKeyShare(keyShareByte)
+		if err != nil {
+			return nil, err
+		}
+		keyMetaByte, err := base64.StdEncoding.DecodeString(key.KeyMetaInfo)
+		if err != nil {
+			return nil, err
+		}
+		keyMeta, err := message.DecodeEdDSAKeyMeta(keyMetaByte)
+		if err != nil {
+			return nil, err
+		}
+		lifecycle, err := parseLifecycle(key.State, key.DeleteAfter, message.KeyActive)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lifecycle state for key %s: %s", key.ID, err)
+		}
+		usage, err := parseUsage(key.Usage)
+		if err != nil {
+			return nil, fmt.Errorf("invalid usage for key %s: %s", key.ID, err)
+		}
+		keys[key.ID] = &eddsaKey{
+			ID:        key.ID,
+			Share:     keyShare,
+			Meta:      keyMeta,
+			lifecycle: lifecycle,
+			usage:     usage,
+		}
+	}
+	return keys, nil
+}
+
+func saveEdDSAKeys(keys map[string]*eddsaKey) ([]*config.EdDSAKeyConfig, error) {
+	keysConfig := make([]*config.EdDSAKeyConfig, 0)
+	for _, key := range keys {
+		keyConfig, err := encodeEdDSAKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keysConfig = append(keysConfig, keyConfig)
+	}
+	return keysConfig, nil
+}
+
+// encodeEdDSAKey transforms a key into its representation in the config file.
+func encodeEdDSAKey(key *eddsaKey) (*config.EdDSAKeyConfig, error) {
+	keyShareBytes, err := message.EncodeEdDSAKeyShare(key.Share)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding eddsaKeys: %s", err)
+	}
+	keyMetaBytes, err := message.EncodeEdDSAKeyMeta(key.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding eddsaKeys: %s", err)
+	}
+	keyConfig := &config.EdDSAKeyConfig{
+		ID:          key.ID,
+		KeyMetaInfo: base64.StdEncoding.EncodeToString(keyMetaBytes),
+		KeyShare:    base64.StdEncoding.EncodeToString(keyShareBytes),
+	}
+	keyConfig.State, keyConfig.DeleteAfter = key.lifecycle.encode()
+	keyConfig.Usage = key.usage.encode()
+	return keyConfig, nil
+}
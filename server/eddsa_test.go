@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/niclabs/dtcnode/v3/tceddsa"
+)
+
+func TestReplaceEdDSAKey(t *testing.T) {
+	shares, meta, err := tceddsa.NewKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		saveFails bool
+	}{
+		{"saved", false},
+		{"save fails", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			client := node.client
+			old := &tceddsa.KeyShare{Index: shares[0].Index, Secret: append([]byte(nil), shares[0].Secret...)}
+			if err := client.SaveEdDSAKey("k", old, meta); err != nil {
+				t.Fatal(err)
+			}
+			client.eddsa().sessions["k"] = &tceddsa.SigSession{}
+			if test.saveFails {
+				node.breakConfig(t)
+			}
+			err := client.ReplaceEdDSAKey("k", shares[1], meta)
+			key := client.eddsa().keys["k"]
+			if test.saveFails {
+				if err == nil {
+					t.Fatal("replace succeeded without saving the config")
+				}
+				if key.Share != old || len(client.eddsa().archived) != 0 {
+					t.Errorf("key changed in memory after the save failed")
+				}
+				if !bytes.Equal(old.Secret, shares[0].Secret) {
+					t.Errorf("old key share wiped after the save failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Share != shares[1] {
+				t.Errorf("key share not replaced")
+			}
+			if _, ok := client.eddsa().sessions["k"]; ok {
+				t.Errorf("signing session of the old key share not ended")
+			}
+			if !bytes.Equal(old.Secret, make([]byte, len(old.Secret))) {
+				t.Errorf("old key share not wiped")
+			}
+			restored, err := parseEdDSAKeys(client.eddsa().archived)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(restored["k"].Share.Secret, shares[0].Secret) {
+				t.Errorf("archived key share does not match the old one")
+			}
+		})
+	}
+}
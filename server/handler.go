@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// handlerFunc answers a message sent by the client.
+type handlerFunc func(client *Client, msg *message.Message) *message.Message
+
+// handler represents the way the node answers a message type.
+type handler struct {
+	dataLength int         // Number of data fields of the messages of this type sent by the client.
+	handle     handlerFunc // Function that answers the messages of this type.
+}
+
+// handlers maps each message type the node answers to its handler. Each file registers the types it answers with
+// registerHandler in an init function, so the client loop does not need to know them.
+var handlers = make(map[message.Type]*handler)
+
+// registerHandler sets the function that answers a message type, and the number of data fields of the messages of that
+// type sent by the client. It panics if the type already has a handler, because it is a programming error.
+func registerHandler(mType message.Type, dataLength int, handle handlerFunc) {
+	if _, ok := handlers[mType]; ok {
+		panic(fmt.Errorf("message type %s already has a handler", mType))
+	}
+	handlers[mType] = &handler{
+		dataLength: dataLength,
+		handle:     handle,
+	}
+}
+
+// dispatch answers a message using the handler of its type. It answers with an InvalidMessageError if the type has
+// no handler or if the message has the wrong number of data fields.
+func (client *Client) dispatch(msg *message.Message) *message.Message {
+	h, ok := handlers[msg.Type]
+	if !ok {
+		log.Printf("Unknown message of type %d received from client %s", msg.Type, client.GetConnString())
+		return msg.NewResponse(client.node.GetID(), message.InvalidMessageError)
+	}
+	if len(msg.Data) != h.dataLength {
+		log.Printf("%s message with %d data fields instead of %d", msg.Type, len(msg.Data), h.dataLength)
+		return msg.NewResponse(client.node.GetID(), message.InvalidMessageError)
+	}
+	return h.handle(client, msg)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const rlimInfinity = ^uint64(0)
+
+// harden disables core dumps, so the key shares are not written to disk if the process crashes, and locks the current
+// and future memory of the process, so they are not written to swap.
+func harden() error {
+	if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{Cur: 0, Max: 0}); err != nil {
+		return fmt.Errorf("cannot disable core dumps: %s", err)
+	}
+	// A process that is not dumpable does not write core dumps, and other processes of the same user cannot attach
+	// to it or read its memory.
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_DUMPABLE, 0, 0); errno != 0 {
+		return fmt.Errorf("cannot disable core dumps: %s", errno)
+	}
+	// Without a memory lock limit, the memory the node allocates after switching to the user of the sandbox is locked too.
+	// Only root can raise it, so the node goes on without it: Mlockall fails below if the limit is too small.
+	if err := syscall.Setrlimit(unix.RLIMIT_MEMLOCK, &syscall.Rlimit{Cur: rlimInfinity, Max: rlimInfinity}); err != nil {
+		log.Printf("Hardened mode: cannot remove the memory lock limit: %s", err)
+	}
+	if err := syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE); err != nil {
+		return fmt.Errorf("cannot lock the memory of the process, it needs CAP_IPC_LOCK or a larger RLIMIT_MEMLOCK: %s", err)
+	}
+	return nil
+}
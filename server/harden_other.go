@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import "fmt"
+
+// harden is only supported on Linux.
+func harden() error {
+	return fmt.Errorf("hardened mode is only supported on Linux")
+}
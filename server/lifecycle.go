@@ -0,0 +1,251 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// keyPurgeInterval is the time between two checks of the key shares pending deletion while the node waits for messages.
+const keyPurgeInterval = time.Minute
+
+// lifecycle represents the lifecycle state of a key share. The zero value is an active key share.
+type lifecycle struct {
+	state       message.KeyState
+	deleteAfter time.Time // With message.KeyPendingDeletion, time after which the key share is deleted.
+}
+
+// stateOperations are the operations allowed in each lifecycle state. Disabled key shares can still be refreshed, so
+// the operators can disable a key whose shares may have leaked and refresh them before enabling it again: the refresh
+// makes the leaked shares useless, and it does not sign anything.
+var stateOperations = map[message.KeyState]map[operation]bool{
+	message.KeyActive: {
+		signOperation:      true,
+		decryptOperation:   true,
+		deleteOperation:    true,
+		overwriteOperation: true,
+		refreshOperation:   true,
+		reshareOperation:   true,
+	},
+	message.KeyPendingInit: {
+		deleteOperation:    true,
+		overwriteOperation: true,
+	},
+	message.KeyDisabled: {
+		deleteOperation:  true,
+		refreshOperation: true,
+	},
+	message.KeyPendingDeletion: {
+		deleteOperation: true,
+	},
+}
+
+func init() {
+	registerHandler(message.SetKeyState, 4, (*Client).dispatchLifecycle) // algorithm, keyID, state, retention -> {}
+}
+
+// parseLifecycle reads the lifecycle state of a key share from the config file. An empty state means the default
+// state provided.
+func parseLifecycle(state, deleteAfter string, defaultState message.KeyState) (lifecycle, error) {
+	l := lifecycle{state: message.KeyState(strings.ToLower(state))}
+	if l.state == "" {
+		l.state = defaultState
+	}
+	if _, ok := stateOperations[l.state]; !ok {
+		return l, fmt.Errorf("unknown lifecycle state %s", state)
+	}
+	if l.state == message.KeyPendingDeletion {
+		var err error
+		if l.deleteAfter, err = time.Parse(time.RFC3339, deleteAfter); err != nil {
+			return l, fmt.Errorf("invalid deletion time: %s", err)
+		}
+	}
+	return l, nil
+}
+
+// encode returns the lifecycle state of a key share as it is saved in the config file.
+func (l *lifecycle) encode() (state, deleteAfter string) {
+	if l.current() == message.KeyPendingDeletion {
+		deleteAfter = l.deleteAfter.UTC().Format(time.RFC3339)
+	}
+	return string(l.current()), deleteAfter
+}
+
+// current returns the lifecycle state of the key share.
+func (l *lifecycle) current() message.KeyState {
+	if l.state == "" {
+		return message.KeyActive
+	}
+	return l.state
+}
+
+// allows returns true if the operation is allowed in the lifecycle state of the key share.
+func (l *lifecycle) allows(op operation) bool {
+	return stateOperations[l.current()][op]
+}
+
+// moveTo changes the lifecycle state of the key share. The retention period is the time a key share moved to
+// message.KeyPendingDeletion is kept before deleting it. Key shares cannot be moved to message.KeyPendingInit, nor
+// be enabled or disabled before they are initialized.
+func (l *lifecycle) moveTo(state message.KeyState, retention time.Duration, now time.Time) error {
+	switch state {
+	case message.KeyActive, message.KeyDisabled:
+		if l.current() == message.KeyPendingInit {
+			return fmt.Errorf("the key share is not initialized")
+		}
+	case message.KeyPendingDeletion:
+		if retention < 0 {
+			return fmt.Errorf("negative retention period")
+		}
+	default:
+		return fmt.Errorf("cannot move a key share to state %s", state)
+	}
+	l.state = state
+	l.deleteAfter = time.Time{}
+	if state == message.KeyPendingDeletion {
+		l.deleteAfter = now.Add(retention)
+	}
+	return nil
+}
+
+// expired returns true if the key share is pending deletion and its retention period ended.
+func (l *lifecycle) expired(now time.Time) bool {
+	return l.current() == message.KeyPendingDeletion && !now.Before(l.deleteAfter)
+}
+
+// stateOperation returns the operation the key policy must allow to move a key share to a lifecycle state.
+func stateOperation(state message.KeyState) operation {
+	if state == message.KeyPendingDeletion {
+		return deleteOperation
+	}
+	return lifecycleOperation
+}
+
+// dispatchLifecycle answers the messages that change the lifecycle state of a key share.
+func (client *Client) dispatchLifecycle(msg *message.Message) *message.Message {
+	resp := msg.NewResponse(client.node.GetID(), message.Ok)
+	switch msg.Type {
+	case message.SetKeyState:
+		algName := strings.ToLower(string(msg.Data[0]))
+		keyID := string(msg.Data[1])
+		state := message.KeyState(msg.Data[2])
+		log.Printf("Client %s is asking us to move %s key %s to state %s", client.GetConnString(), algName, keyID, state)
+		retention, err := message.DecodeRetention(msg.Data[3])
+		if err != nil {
+			log.Printf("error decoding retention: %s", err)
+			resp.Error = message.DecodingError
+			break
+		}
+		store, ok := client.keys[algName]
+		if !ok {
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		l, ok := store.lifecycles()[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if !client.allow(keyID, stateOperation(state), nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		old := *l
+		now := time.Now()
+		if err := l.moveTo(state, retention, now); err != nil {
+			log.Printf("cannot move %s key %s to state %s: %s", algName, keyID, state, err)
+			resp.Error = message.KeyStateError
+			break
+		}
+		if err := client.node.SaveConfigKeys(); err != nil {
+			log.Printf("Error saving the state of %s key %s: %s", algName, keyID, err)
+			*l = old
+			resp.Error = message.InternalError
+			break
+		}
+		store.endSession(keyID)
+		log.Printf("%s key %s moved from state %s to state %s", algName, keyID, old.current(), state)
+		client.purgeKeys(now)
+	default:
+		log.Printf("invalid message received from client %s", client.GetConnString())
+		resp.Error = message.InvalidMessageError
+	}
+	return resp
+}
+
+// purgeEvery calls purgeKeys after each interval until stop is closed, so key shares are deleted when their retention
+// period ends even if the client sends no messages.
+func (client *Client) purgeEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			client.mutex.Lock()
+			client.purgeKeys(now)
+			client.mutex.Unlock()
+		}
+	}
+}
+
+// purgeKeys deletes the key shares pending deletion whose retention period ended. The node calls it when it starts,
+// before answering each message and every keyPurgeInterval.
+func (client *Client) purgeKeys(now time.Time) {
+	type purged struct {
+		algorithm, id string
+		*deletion
+	}
+	keys := make([]*purged, 0)
+	for _, alg := range algorithms {
+		store := client.keys[alg.name]
+		for id, l := range store.lifecycles() {
+			if l.expired(now) {
+				log.Printf("The retention period of %s key %s ended, deleting it", alg.name, id)
+				keys = append(keys, &purged{algorithm: alg.name, id: id, deletion: store.delete(id)})
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	disk, err := client.node.WipeConfigKeys()
+	if err != nil {
+		log.Printf("Error saving the config after deleting %d keys: %s", len(keys), err)
+		for _, key := range keys {
+			key.restore()
+		}
+		return
+	}
+	for _, key := range keys {
+		key.zero()
+		key.wipe.Disk = disk
+		client.audit("purge", key.algorithm, key.id, key.wipe.String())
+	}
+}
+
+// SetConfigKeyState changes the lifecycle state of a key in the config of a client. It is used to change it while the
+// node is not running.
+func SetConfigKeyState(conf *config.ClientConfig, algName, id string, state message.KeyState, retention time.Duration) error {
+	alg := getAlgorithm(strings.ToLower(algName))
+	if alg == nil {
+		return fmt.Errorf("algorithm %s is not supported", algName)
+	}
+	store, err := alg.load(conf)
+	if err != nil {
+		return fmt.Errorf("cannot load %s keys: %s", alg.name, err)
+	}
+	l, ok := store.lifecycles()[id]
+	if !ok {
+		return fmt.Errorf("%s key %s not found", alg.name, id)
+	}
+	if err := l.moveTo(state, retention, time.Now()); err != nil {
+		return err
+	}
+	return store.save(conf)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/tceddsa"
+	"github.com/niclabs/tcecdsa"
+)
+
+func TestLifecycleMoveTo(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		from      message.KeyState
+		to        message.KeyState
+		retention time.Duration
+		ok        bool
+		sign      bool
+		refresh   bool
+	}{
+		{message.KeyActive, message.KeyDisabled, 0, true, false, true},
+		{message.KeyDisabled, message.KeyActive, 0, true, true, true},
+		{message.KeyActive, message.KeyPendingDeletion, time.Hour, true, false, false},
+		{message.KeyPendingDeletion, message.KeyActive, 0, true, true, true},
+		{message.KeyActive, message.KeyPendingDeletion, -time.Hour, false, true, true},
+		{message.KeyPendingInit, message.KeyActive, 0, false, false, false},
+		{message.KeyPendingInit, message.KeyDisabled, 0, false, false, false},
+		{message.KeyPendingInit, message.KeyPendingDeletion, 0, true, false, false},
+		{message.KeyActive, message.KeyPendingInit, 0, false, true, true},
+		{message.KeyActive, "unknown", 0, false, true, true},
+	}
+	for _, test := range tests {
+		l := lifecycle{state: test.from}
+		err := l.moveTo(test.to, test.retention, now)
+		if (err == nil) != test.ok {
+			t.Errorf("%s to %s: expected success %v, got error %v", test.from, test.to, test.ok, err)
+			continue
+		}
+		if test.ok && l.current() != test.to {
+			t.Errorf("%s to %s: state is %s", test.from, test.to, l.current())
+		}
+		if !test.ok && l.current() != test.from {
+			t.Errorf("%s to %s: state changed to %s after an error", test.from, test.to, l.current())
+		}
+		if l.allows(signOperation) != test.sign {
+			t.Errorf("%s to %s: expected sign allowed %v", test.from, test.to, test.sign)
+		}
+		if l.allows(refreshOperation) != test.refresh {
+			t.Errorf("%s to %s: expected refresh allowed %v", test.from, test.to, test.refresh)
+		}
+		if test.ok && test.to == message.KeyPendingDeletion {
+			if l.expired(now.Add(test.retention - time.Second)) {
+				t.Errorf("key share expired before its retention period ended")
+			}
+			if !l.expired(now.Add(test.retention)) {
+				t.Errorf("key share not expired after its retention period ended")
+			}
+		}
+	}
+}
+
+// startEdDSASession saves a 2-of-3 EdDSA key share in the node, and starts a signing session with it.
+func startEdDSASession(t *testing.T, node *testNode) {
+	shares, meta, err := tceddsa.NewKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.client.SaveEdDSAKey("k", shares[0], meta); err != nil {
+		t.Fatal(err)
+	}
+	if resp := node.request(message.EdDSARound1, []byte("k")); resp.Error != message.Ok {
+		t.Fatalf("round 1 failed: %s", resp.Error)
+	}
+}
+
+func TestSetKeyStateEndsSessions(t *testing.T) {
+	tests := []struct {
+		name     string
+		setState func(node *testNode) error
+	}{
+		{"client message", func(node *testNode) error {
+			resp := node.request(message.SetKeyState, []byte(eddsaAlgorithm), []byte("k"), []byte(message.KeyDisabled), message.EncodeRetention(0))
+			if resp.Error != message.Ok {
+				return resp.Error
+			}
+			return nil
+		}},
+		{"admin socket", func(node *testNode) error {
+			args := &AdminArgs{Algorithm: eddsaAlgorithm, Key: "k", State: message.KeyDisabled}
+			return (&Admin{node: node.Node}).SetKeyState(args, &KeyInfo{})
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			startEdDSASession(t, node)
+			if err := test.setState(node); err != nil {
+				t.Fatalf("cannot disable the key: %s", err)
+			}
+			if _, ok := node.client.eddsa().sessions["k"]; ok {
+				t.Errorf("signing session not ended after disabling the key")
+			}
+		})
+	}
+}
+
+func TestSigningRoundsCheckState(t *testing.T) {
+	node := newTestNode(t)
+	defer node.close()
+	startEdDSASession(t, node)
+	// The state is changed without ending the session, as a self-test quarantine does.
+	node.client.eddsa().keys["k"].state = message.KeyDisabled
+	resp := node.request(message.EdDSARound2, []byte("k"), []byte("doc"), []byte{})
+	if resp.Error != message.KeyStateError {
+		t.Errorf("EdDSA round 2 with a disabled key answered %s", resp.Error)
+	}
+
+	state := node.client.ecdsa()
+	tests := []struct {
+		name       string
+		prepare    func(key *ecdsaKey)
+		expected   message.NodeError
+		endSession bool
+	}{
+		{"disabled", func(key *ecdsaKey) { key.state = message.KeyDisabled }, message.KeyStateError, false},
+		{"pending deletion", func(key *ecdsaKey) { key.state = message.KeyPendingDeletion }, message.KeyStateError, false},
+		{"quarantined", func(key *ecdsaKey) { key.quarantine = fmt.Errorf("self-test failed") }, message.KeyQuarantinedError, false},
+		{"session ended", func(key *ecdsaKey) {}, message.InternalError, true},
+	}
+	for _, test := range tests {
+		key := &ecdsaKey{ID: "k", Completed: true}
+		test.prepare(key)
+		state.keys["k"] = key
+		state.currentKey = "k"
+		state.currentSession = &tcecdsa.SigSession{}
+		if test.endSession {
+			state.endSession("k")
+		}
+		for _, mType := range []message.Type{message.ECDSARound2, message.ECDSARound3, message.ECDSAGetSignature} {
+			if resp := node.request(mType, []byte{}); resp.Error != test.expected {
+				t.Errorf("%s: %s answered %s instead of %s", test.name, mType, resp.Error, test.expected)
+			}
+		}
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/niclabs/dtcnode/v3/config"
 	"github.com/niclabs/dtcnode/v3/message"
@@ -29,7 +32,10 @@ type Node struct {
 	context     *zmq4.Context  // The context used by zmq connections.
 	clients     []*Client      // A list of clients. Currently the configuration allows only one server at a time.
 	configMutex sync.Mutex     // A mutex used for config editing.
+	audit       *log.Logger    // Logger of the audit log.
+	viper       *viper.Viper   // The viper instance used to persist the configuration of the node.
 	socket      *zmq4.Socket   // The socket where the message are received and sent to the server.
+	started     time.Time      // Time the node started.
 }
 
 func init() {
@@ -38,6 +44,12 @@ func init() {
 
 // InitNode inits the node using the configuration provided. Returns a started node or an error if the function fails.
 func InitNode(config *config.Config) (*Node, error) {
+	return InitNodeWithViper(config, viper.GetViper())
+}
+
+// InitNodeWithViper inits the node using the configuration provided, saving its keys with the viper instance provided.
+// It is useful to run more than one node on the same process. Returns a started node or an error if the function fails.
+func InitNodeWithViper(config *config.Config, v *viper.Viper) (*Node, error) {
 	ip, err := net.ResolveIPAddr("ip", config.Host)
 	if err != nil {
 		return nil, err
@@ -53,9 +65,19 @@ func InitNode(config *config.Config) (*Node, error) {
 		host:    ip,
 		port:    config.Port,
 		config:  config,
+		viper:   v,
 		clients: make([]*Client, 0),
+		started: time.Now().UTC(),
 	}
 	log.Printf("Creating node with ID: %s", node.GetID())
+	if node.audit, err = openAuditLog(config.AuditLog); err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %s", err)
+	}
+	if path := v.ConfigFileUsed(); path != "" {
+		if err := wipeLeftover(path); err != nil {
+			log.Printf("cannot overwrite the previous config file: %s", err)
+		}
+	}
 	context, err := zmq4.NewContext()
 	if err != nil {
 		return nil, err
@@ -79,23 +101,45 @@ func InitNode(config *config.Config) (*Node, error) {
 	if err != nil {
 		return nil, err
 	}
+	replay := newReplayGuard(time.Duration(config.MaxClockSkew) * time.Second)
 	server := &Client{
-		pubKey: serverConfig.PublicKey,
-		host:   serverIP,
-		node:   node,
+		pubKey:   serverConfig.PublicKey,
+		host:     serverIP,
+		node:     node,
+		replies:  newReplyCache(config.ReplyCacheSize, replay.maxSkew),
+		replay:   replay,
+		counters: newCounters(),
 	}
 
-	server.rsa.keys, err = parseRSAKeys(serverConfig.RSA.Keys)
+	server.approvals, err = newApprovals(ApprovalDir(config, v.ConfigFileUsed()), time.Duration(config.ApprovalTimeout)*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open approval directory: %s", err)
+	}
+
+	server.policies, err = parsePolicies(serverConfig.Policies)
 	if err != nil {
 		return nil, err
 	}
 
-	server.ecdsa.keys, err = parseECDSAKeys(serverConfig.ECDSA.Keys)
+	server.keys = make(map[string]keyStore)
+	for _, alg := range algorithms {
+		server.keys[alg.name], err = alg.load(serverConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load %s keys: %s", alg.name, err)
+		}
+	}
+
+	server.algorithms, err = parseAllowedAlgorithms(serverConfig.Algorithms, map[string][]string{
+		ecdsaAlgorithm: serverConfig.ECDSA.Curves,
+	})
 	if err != nil {
 		return nil, err
 	}
+	log.Printf("Client %s can create keys with the following algorithms: %v", serverIP, server.algorithms.List())
+	server.selfTest()
 
 	node.clients = append(node.clients, server)
+	server.purgeKeys(time.Now())
 
 	return node, nil
 }
@@ -115,46 +159,101 @@ func (node *Node) FindServer(name string) *Client {
 	return nil
 }
 
+// curveSecretKey returns the private key of the node in binary form. It is the Curve25519 key used in ZMQ CURVE Auth,
+// which also decrypts the sub-shares dealt to the node in a resharing.
+func (node *Node) curveSecretKey() []byte {
+	return []byte(zmq4.Z85decode(node.privKey))
+}
+
 // GetConnString returns the string that is used to bind the node to a port.
 func (node *Node) GetConnString() string {
 	return fmt.Sprintf("%s://%s:%d", TchsmProtocol, node.host, node.port)
 }
 
-// SaveConfigKeys saves the currently received rsaKeys and ecdsaKeys into memory.
+// SaveConfigKeys saves the keys of every scheme into the config file.
 func (node *Node) SaveConfigKeys() error {
+	_, err := node.saveConfigKeys(false)
+	return err
+}
+
+// saveConfigKeys saves the keys of every scheme into the config file. If wipe is true, the previous config file is
+// overwritten with zeros, and it returns false if it could not be overwritten.
+func (node *Node) saveConfigKeys(wipe bool) (bool, error) {
 	node.configMutex.Lock()
 	defer node.configMutex.Unlock()
-	var err error
 	for _, client := range node.clients {
 		serverConfig := node.config.GetClientByID(client.GetID())
 		if serverConfig == nil {
-			return fmt.Errorf("error encoding rsaKeys: client config not found")
-		}
-		log.Printf("saving %d rsa keys and %d ecdsa keys...", len(client.rsa.keys), len(client.ecdsa.keys))
-		serverConfig.RSA.Keys, err = saveRSAKeys(client.rsa.keys)
-		if err != nil {
-			return err
+			return false, fmt.Errorf("error encoding rsaKeys: client config not found")
 		}
-		serverConfig.ECDSA.Keys, err = saveECDSAKeys(client.ecdsa.keys)
-		if err != nil {
-			return err
+		for _, alg := range algorithms {
+			store := client.keys[alg.name]
+			log.Printf("saving %d %s keys...", store.len(), alg.name)
+			if err := store.save(serverConfig); err != nil {
+				return false, err
+			}
 		}
+	}
+	node.viper.Set("config", node.config)
+	if wipe {
+		return writeConfigWiping(node.viper)
+	}
+	return false, WriteConfig(node.viper)
+}
 
+// WriteConfig writes the config of a viper instance into a temporary file in the same directory as its config file
+// and renames it over the old one, so a failure while writing cannot leave the node with a truncated file, or with a
+// mix of old and new key shares.
+func WriteConfig(v *viper.Viper) error {
+	path := v.ConfigFileUsed()
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err := v.WriteConfigAs(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR, 0)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = tmp.Sync()
+	tmp.Close()
+	if err == nil {
+		err = os.Chmod(tmpPath, info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
 	}
-	viper.Set("config", node.config)
-	return viper.WriteConfig()
+	return err
 }
 
 // Listen starts all the server listening subroutines, and waits for a message received in the input socket. It checks and parses the message to Message objects and sends them to a channel, that is used by the subroutines.
+// It returns after the node is closed.
 func (node *Node) Listen() {
 	for _, client := range node.clients {
 		client.Listen()
 	}
-	select {}
 }
 
-func (node *Node) connect() error {
+// Close stops the node. It terminates the ZMQ context, which makes Listen close the socket of the node and return, and
+// waits until it does. It is used to stop the nodes started on the same process.
+func (node *Node) Close() error {
+	return node.context.Term()
+}
 
+func (node *Node) connect() error {
+	if node.socket != nil {
+		// The old socket must be closed, or it keeps the port and blocks Close.
+		node.socket.SetLinger(0)
+		node.socket.Close()
+	}
 	s, err := node.context.NewSocket(zmq4.REP)
 	if err != nil {
 		return err
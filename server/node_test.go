@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/tcrsa"
+	"github.com/spf13/viper"
+)
+
+// testNode represents a node with a client whose config file is saved in a temporary directory.
+type testNode struct {
+	*Node
+	client *Client
+	dir    string
+	audit  *bytes.Buffer // Audit log of the node.
+}
+
+// newTestNode returns a node with a client, without RSA, ECDSA nor EdDSA keys. It does not listen on a socket.
+func newTestNode(t *testing.T) *testNode {
+	dir, err := ioutil.TempDir("", "dtcnode-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.Config{
+		PublicKey: "node",
+		Client:    &config.ClientConfig{PublicKey: "client", Host: "127.0.0.1"},
+	}
+	v := viper.New()
+	v.SetConfigFile(filepath.Join(dir, "dtcnode-config.yaml"))
+	v.Set("config", conf)
+	if err := v.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	audit := new(bytes.Buffer)
+	node := &Node{
+		pubKey:  conf.PublicKey,
+		config:  conf,
+		viper:   v,
+		audit:   log.New(audit, "", 0),
+		started: time.Now(),
+	}
+	client := &Client{
+		pubKey:    conf.Client.PublicKey,
+		host:      &net.IPAddr{IP: net.ParseIP(conf.Client.Host)},
+		node:      node,
+		replies:   newReplyCache(0, DefaultMaxClockSkew),
+		replay:    newReplayGuard(0),
+		counters:  newCounters(),
+		approvals: &approvals{held: make(map[replyKey]*heldRequest)},
+		keys:      make(map[string]keyStore),
+	}
+	if client.policies, err = parsePolicies(nil); err != nil {
+		t.Fatal(err)
+	}
+	if client.algorithms, err = parseAllowedAlgorithms(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range algorithms {
+		if client.keys[alg.name], err = alg.load(conf.Client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node.clients = []*Client{client}
+	return &testNode{Node: node, client: client, dir: dir, audit: audit}
+}
+
+// request sends a message of the type provided to the client of the node, and returns the response.
+func (node *testNode) request(mType message.Type, data ...[]byte) *message.Message {
+	return node.client.dispatch(&message.Message{
+		From:      "client",
+		ID:        "1f2e3d4c5b6a",
+		Type:      mType,
+		Timestamp: time.Now().UnixNano(),
+		Data:      data,
+	})
+}
+
+// close removes the config file of the node.
+func (node *testNode) close() {
+	os.RemoveAll(node.dir)
+}
+
+// breakConfig makes the next saves of the config file fail.
+func (node *testNode) breakConfig(t *testing.T) {
+	if err := os.Remove(node.viper.ConfigFileUsed()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var (
+	testRSAOnce   sync.Once
+	testRSAShares tcrsa.KeyShareList
+	testRSAMeta   *tcrsa.KeyMeta
+)
+
+// testRSAKey returns the key shares of a 2-of-3 RSA key, generated once for all the tests.
+func testRSAKey(t *testing.T) (tcrsa.KeyShareList, *tcrsa.KeyMeta) {
+	testRSAOnce.Do(func() {
+		var err error
+		if testRSAShares, testRSAMeta, err = tcrsa.NewKey(512, 2, 3, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if testRSAMeta == nil {
+		t.Fatal("cannot generate RSA key")
+	}
+	return testRSAShares, testRSAMeta
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+)
+
+// operation represents an action a client can do with a key, restricted by the key policy.
+type operation string
+
+// The following consts represent the operations a policy can allow.
+const (
+	signOperation      operation = "sign"      // Sign a document or start an ECDSA signing session.
+	deleteOperation    operation = "delete"    // Delete a key share.
+	overwriteOperation operation = "overwrite" // Replace the key share of an existing key ID.
+	decryptOperation   operation = "decrypt"   // Compute a decryption share of a ciphertext.
+	refreshOperation   operation = "refresh"   // Refresh a key share without changing the key.
+	reshareOperation   operation = "reshare"   // Deal a key share to a new node set, or replace it by a reshared one.
+	lifecycleOperation operation = "lifecycle" // Disable or enable a key share, or cancel its deletion.
+)
+
+// anyKey is the key ID of the policy applied to the keys without their own policy.
+const anyKey = "*"
+
+// policies represents the set of rules a client must follow to use its keys.
+// If no policy is configured, every operation but decryption is allowed. If at least one policy is configured, the
+// operations on keys without a policy (and without a "*" policy) are denied. Decryption is always opt-in: it is only
+// allowed on keys whose policy lists it, together with signing.
+type policies struct {
+	byKey map[string]*policy // Policies by key ID.
+}
+
+// policy represents the rules a client must follow to use a key.
+type policy struct {
+	key         string             // Key ID of the policy.
+	operations  map[operation]bool // Allowed operations.
+	hashLengths map[int]bool       // Allowed hash lengths. Empty allows any length.
+	rateLimit   int                // Maximum number of operations per minute. Zero means no limit.
+	windows     []timeWindow       // Time ranges when the key can be used. Empty means any time.
+	recent      []time.Time        // Time of the operations done in the last minute.
+	reserved    int                // Operations allowed in the current request, counted if it succeeds.
+}
+
+// timeWindow represents a time range of a day, in minutes since midnight UTC. If end is lower than start, the range
+// includes midnight.
+type timeWindow struct {
+	start, end int
+}
+
+func parsePolicies(conf []*config.PolicyConfig) (*policies, error) {
+	p := &policies{
+		byKey: make(map[string]*policy),
+	}
+	for _, policyConf := range conf {
+		if policyConf.Key == "" {
+			return nil, fmt.Errorf("policy without key ID")
+		}
+		if _, ok := p.byKey[policyConf.Key]; ok {
+			return nil, fmt.Errorf("duplicated policy for key %s", policyConf.Key)
+		}
+		pol := &policy{
+			key:         policyConf.Key,
+			operations:  make(map[operation]bool),
+			hashLengths: make(map[int]bool),
+			rateLimit:   policyConf.RateLimit,
+		}
+		for _, op := range policyConf.Operations {
+			switch operation(strings.ToLower(op)) {
+			case signOperation, deleteOperation, overwriteOperation, decryptOperation, refreshOperation, reshareOperation,
+				lifecycleOperation:
+				pol.operations[operation(strings.ToLower(op))] = true
+			default:
+				return nil, fmt.Errorf("unknown operation %s in policy for key %s", op, policyConf.Key)
+			}
+		}
+		for _, length := range policyConf.HashLengths {
+			pol.hashLengths[length] = true
+		}
+		for _, window := range policyConf.TimeWindows {
+			w, err := parseTimeWindow(window)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time window in policy for key %s: %s", policyConf.Key, err)
+			}
+			pol.windows = append(pol.windows, w)
+		}
+		p.byKey[pol.key] = pol
+	}
+	return p, nil
+}
+
+// parseTimeWindow parses a time range with the format HH:MM-HH:MM.
+func parseTimeWindow(window string) (timeWindow, error) {
+	limits := strings.Split(window, "-")
+	if len(limits) != 2 {
+		return timeWindow{}, fmt.Errorf("%s should have the format HH:MM-HH:MM", window)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(limits[0]))
+	if err != nil {
+		return timeWindow{}, err
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(limits[1]))
+	if err != nil {
+		return timeWindow{}, err
+	}
+	return timeWindow{
+		start: start.Hour()*60 + start.Minute(),
+		end:   end.Hour()*60 + end.Minute(),
+	}, nil
+}
+
+// Allow returns an error if the operation on the key is not allowed at the time provided. The hash is only checked on
+// sign operations. If the operation is allowed, it is reserved for the rate limit of the key until the request ends:
+// Commit counts it if the request succeeds, and Discard releases it if it fails.
+func (p *policies) Allow(keyID string, op operation, hash []byte, now time.Time) error {
+	if len(p.byKey) == 0 {
+		if op == decryptOperation {
+			return fmt.Errorf("operation %s needs a policy that allows it", op)
+		}
+		return nil
+	}
+	pol := p.get(keyID)
+	if pol == nil {
+		return fmt.Errorf("there is no policy for key %s", keyID)
+	}
+	return pol.allow(op, hash, now)
+}
+
+// Release releases operations reserved on a key which were not done, such as the items of a batch refused by other
+// checks.
+func (p *policies) Release(keyID string, n int) {
+	if pol := p.get(keyID); pol != nil {
+		pol.reserved -= n
+		if pol.reserved < 0 {
+			pol.reserved = 0
+		}
+	}
+}
+
+// Commit counts the operations reserved in the current request for the rate limits of their keys.
+func (p *policies) Commit(now time.Time) {
+	for _, pol := range p.byKey {
+		for ; pol.reserved > 0; pol.reserved-- {
+			pol.recent = append(pol.recent, now)
+		}
+	}
+}
+
+// Discard releases the operations reserved in the current request.
+func (p *policies) Discard() {
+	for _, pol := range p.byKey {
+		pol.reserved = 0
+	}
+}
+
+// get returns the policy of a key, or nil if it has none.
+func (p *policies) get(keyID string) *policy {
+	if pol, ok := p.byKey[keyID]; ok {
+		return pol
+	}
+	return p.byKey[anyKey]
+}
+
+func (pol *policy) allow(op operation, hash []byte, now time.Time) error {
+	if !pol.operations[op] {
+		return fmt.Errorf("operation %s is not allowed", op)
+	}
+	if op == signOperation && len(pol.hashLengths) > 0 && !pol.hashLengths[len(hash)] {
+		return fmt.Errorf("hash length %d is not allowed", len(hash))
+	}
+	if op == decryptOperation && !pol.operations[signOperation] {
+		// A decryption share is a signature share of any padded hash the client chooses.
+		return fmt.Errorf("operation %s is not allowed without %s", op, signOperation)
+	}
+	if op == decryptOperation && len(pol.hashLengths) > 0 {
+		// A decryption share is a signature share of any padded hash the client chooses.
+		return fmt.Errorf("operation %s is not allowed with a hash length restriction", op)
+	}
+	if len(pol.windows) > 0 && !pol.inWindow(now) {
+		return fmt.Errorf("key cannot be used at %s", now.UTC().Format("15:04"))
+	}
+	if pol.rateLimit > 0 {
+		limit := now.Add(-time.Minute)
+		recent := pol.recent[:0]
+		for _, t := range pol.recent {
+			if t.After(limit) {
+				recent = append(recent, t)
+			}
+		}
+		pol.recent = recent
+		if len(pol.recent)+pol.reserved >= pol.rateLimit {
+			return fmt.Errorf("rate limit of %d operations per minute exceeded", pol.rateLimit)
+		}
+		pol.reserved++
+	}
+	return nil
+}
+
+// inWindow returns true if the time provided is inside one of the time windows of the policy.
+func (pol *policy) inWindow(now time.Time) bool {
+	utc := now.UTC()
+	minute := utc.Hour()*60 + utc.Minute()
+	for _, w := range pol.windows {
+		if w.start <= w.end {
+			if minute >= w.start && minute <= w.end {
+				return true
+			}
+		} else if minute >= w.start || minute <= w.end {
+			return true
+		}
+	}
+	return false
+}
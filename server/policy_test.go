@@ -0,0 +1,157 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+)
+
+func TestParsePolicies(t *testing.T) {
+	tests := []struct {
+		name  string
+		conf  []*config.PolicyConfig
+		valid bool
+	}{
+		{"empty", nil, true},
+		{"valid", []*config.PolicyConfig{{Key: "k", Operations: []string{"Sign", "decrypt"}, TimeWindows: []string{"22:00-06:00"}}}, true},
+		{"missing key ID", []*config.PolicyConfig{{Operations: []string{"sign"}}}, false},
+		{"duplicated key ID", []*config.PolicyConfig{{Key: "k"}, {Key: "k"}}, false},
+		{"unknown operation", []*config.PolicyConfig{{Key: "k", Operations: []string{"export"}}}, false},
+		{"invalid time window", []*config.PolicyConfig{{Key: "k", TimeWindows: []string{"08:00"}}}, false},
+		{"invalid time", []*config.PolicyConfig{{Key: "k", TimeWindows: []string{"08:00-25:00"}}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parsePolicies(test.conf)
+			if (err == nil) != test.valid {
+				t.Errorf("expected valid %v, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestPoliciesAllow(t *testing.T) {
+	conf := []*config.PolicyConfig{
+		{Key: "ca", Operations: []string{"sign"}, HashLengths: []int{32, 48}, TimeWindows: []string{"08:00-18:00"}},
+		{Key: "night", Operations: []string{"sign", "delete"}, TimeWindows: []string{"22:00-06:00"}},
+		{Key: "decrypt", Operations: []string{"sign", "decrypt"}},
+		{Key: "decrypt-only", Operations: []string{"decrypt"}},
+		{Key: "decrypt-hashes", Operations: []string{"sign", "decrypt"}, HashLengths: []int{32}},
+		{Key: "*", Operations: []string{"delete"}},
+	}
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	midnight := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		key     string
+		op      operation
+		hash    []byte
+		now     time.Time
+		allowed bool
+	}{
+		{"allowed operation", "ca", signOperation, make([]byte, 32), noon, true},
+		{"other allowed hash length", "ca", signOperation, make([]byte, 48), noon, true},
+		{"denied hash length", "ca", signOperation, make([]byte, 20), noon, false},
+		{"denied operation", "ca", deleteOperation, nil, noon, false},
+		{"outside of the time window", "ca", signOperation, make([]byte, 32), midnight, false},
+		{"window including midnight", "night", signOperation, nil, midnight, true},
+		{"outside of a window including midnight", "night", signOperation, nil, noon, false},
+		{"decryption listed in the policy", "decrypt", decryptOperation, nil, noon, true},
+		{"decryption not listed in the policy", "ca", decryptOperation, nil, noon, false},
+		{"decryption without signing", "decrypt-only", decryptOperation, nil, noon, false},
+		{"decryption with a hash length restriction", "decrypt-hashes", decryptOperation, nil, noon, false},
+		{"default policy", "other", deleteOperation, nil, noon, true},
+		{"operation denied by the default policy", "other", signOperation, make([]byte, 32), noon, false},
+	}
+	p, err := parsePolicies(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := p.Allow(test.key, test.op, test.hash, test.now)
+			if (err == nil) != test.allowed {
+				t.Errorf("expected allowed %v, got error %v", test.allowed, err)
+			}
+		})
+	}
+}
+
+func TestNoPolicies(t *testing.T) {
+	p, err := parsePolicies(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []operation{signOperation, deleteOperation, overwriteOperation, refreshOperation,
+		reshareOperation, lifecycleOperation} {
+		if err := p.Allow("k", op, nil, time.Now()); err != nil {
+			t.Errorf("operation %s denied without policies: %s", op, err)
+		}
+	}
+	if err := p.Allow("k", decryptOperation, nil, time.Now()); err == nil {
+		t.Errorf("decryption allowed without a policy that lists it")
+	}
+}
+
+func TestPoliciesWithoutDefault(t *testing.T) {
+	p, err := parsePolicies([]*config.PolicyConfig{{Key: "ca", Operations: []string{"sign"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Allow("other", signOperation, nil, time.Now()); err == nil {
+		t.Errorf("operation allowed on a key without policy")
+	}
+}
+
+func TestPoliciesRateLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		steps   []string // allow, commit, discard or release, applied in order.
+		allowed bool     // Result of a last allow.
+	}{
+		{"under the limit", []string{"allow", "commit"}, true},
+		{"committed operations count", []string{"allow", "commit", "allow", "commit"}, false},
+		{"reserved operations count", []string{"allow", "allow"}, false},
+		{"discarded operations do not count", []string{"allow", "discard", "allow", "discard", "allow", "discard"}, true},
+		{"released operations do not count", []string{"allow", "allow", "release", "commit"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := parsePolicies([]*config.PolicyConfig{{Key: "k", Operations: []string{"sign"}, RateLimit: 2}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, step := range test.steps {
+				switch step {
+				case "allow":
+					if err := p.Allow("k", signOperation, nil, now); err != nil {
+						t.Fatalf("operation denied: %s", err)
+					}
+				case "commit":
+					p.Commit(now)
+				case "discard":
+					p.Discard()
+				case "release":
+					p.Release("k", 1)
+				}
+			}
+			err = p.Allow("k", signOperation, nil, now)
+			if (err == nil) != test.allowed {
+				t.Errorf("expected allowed %v, got error %v", test.allowed, err)
+			}
+		})
+	}
+	p, err := parsePolicies([]*config.PolicyConfig{{Key: "k", Operations: []string{"sign"}, RateLimit: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Allow("k", signOperation, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	p.Commit(now)
+	if err := p.Allow("k", signOperation, nil, now.Add(time.Minute)); err != nil {
+		t.Errorf("operation denied after the rate limit window: %s", err)
+	}
+}
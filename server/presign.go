@@ -0,0 +1,357 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/tcecdsa"
+	"github.com/niclabs/tcecdsa/l2fhe"
+)
+
+// maxECDSAPresignatures is the maximum number of unused presignatures a key share keeps.
+const maxECDSAPresignatures = 100
+
+// presignHash is the hash of the sessions used to create presignatures. The first round of a tcecdsa signing session
+// does not use its hash, and the presignature is used later with the hash of the document.
+var presignHash = []byte("presignature")
+
+// ecdsaPresigning represents a presignature the client is creating with the nodes.
+type ecdsaPresigning struct {
+	keyID string
+	id    string
+	round int                // Last round answered by the node.
+	r     *big.Int           // r value of the signature, known after round 2.
+	u     *l2fhe.EncryptedL1 // Encrypted mask of the signers, known after round 2.
+	z     *l2fhe.EncryptedL2 // Encrypted product of the nonce and the mask, known after round 2.
+}
+
+func init() {
+	for mType, dataLength := range map[message.Type]int{
+		message.ECDSAPresignRound1: 2, // keyID, presignatureID -> Round1Message
+		message.ECDSAPresignRound2: 3, // keyID, presignatureID, Round1MessageList -> Round2Message
+		message.ECDSAPresignRound3: 3, // keyID, presignatureID, Round2MessageList -> {}
+		message.ECDSASignPresigned: 3, // keyID, presignatureID, hash -> Round3Message, sigma
+	} {
+		registerHandler(mType, dataLength, (*Client).dispatchECDSAPresign)
+	}
+}
+
+func (client *Client) dispatchECDSAPresign(msg *message.Message) *message.Message {
+	resp := msg.NewResponse(client.node.GetID(), message.Ok)
+	keyID, id := string(msg.Data[0]), string(msg.Data[1])
+	switch msg.Type {
+	case message.ECDSAPresignRound1:
+		key, nodeErr := client.ecdsaSigningKey(keyID)
+		if nodeErr != message.Ok {
+			resp.Error = nodeErr
+			break
+		}
+		if id == "" || key.presignature(id) != nil {
+			log.Printf("ECDSA key %s already has a presignature with id %q", keyID, id)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if len(key.presignatures) >= maxECDSAPresignatures {
+			resp.Error = message.PresignaturePoolFullError
+			break
+		}
+		log.Printf("Starting Round1 of presignature %s with key %s as asked by client %s", id, keyID, client.GetConnString())
+		session, err := key.Share.NewSigSession(key.Meta, presignHash)
+		if err != nil {
+			resp.Error = message.InternalError
+			break
+		}
+		round1Msg, err := session.Round1()
+		if err != nil {
+			log.Printf("cannot execute presign round 1: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		encoded, err := message.EncodeECDSARound1Message(round1Msg)
+		if err != nil {
+			log.Printf("cannot encode ECDSA round 1 message: %s", err)
+			resp.Error = message.EncodingError
+			break
+		}
+		client.ecdsa().presigning = &ecdsaPresigning{keyID: keyID, id: id, round: 1}
+		resp.AddMessage(encoded)
+	case message.ECDSAPresignRound2:
+		presigning, key, nodeErr := client.ecdsaPresigning(keyID, id, 1)
+		if nodeErr != message.Ok {
+			resp.Error = nodeErr
+			break
+		}
+		log.Printf("Starting Round2 of presignature %s with key %s as asked by client %s", id, keyID, client.GetConnString())
+		round1Messages, err := message.DecodeECDSARound1MessageList(msg.Data[2])
+		if err != nil {
+			log.Printf("cannot decode ECDSA round 1 message list: %s", err)
+			resp.Error = message.DecodingError
+			break
+		}
+		round2Msg, err := presigning.round2(key, round1Messages)
+		if err != nil {
+			log.Printf("cannot execute presign round 2: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		encoded, err := message.EncodeECDSARound2Message(round2Msg)
+		if err != nil {
+			log.Printf("cannot encode ECDSA round 2 message: %s", err)
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encoded)
+	case message.ECDSAPresignRound3:
+		presigning, key, nodeErr := client.ecdsaPresigning(keyID, id, 2)
+		if nodeErr != message.Ok {
+			resp.Error = nodeErr
+			break
+		}
+		log.Printf("Finishing presignature %s with key %s as asked by client %s", id, keyID, client.GetConnString())
+		round2Messages, err := message.DecodeECDSARound2MessageList(msg.Data[2])
+		if err != nil {
+			log.Printf("cannot decode ECDSA round 2 message list: %s", err)
+			resp.Error = message.DecodingError
+			break
+		}
+		presignature, err := presigning.presignature(key, round2Messages)
+		if err != nil {
+			log.Printf("cannot execute presign round 3: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		if len(key.presignatures) >= maxECDSAPresignatures {
+			resp.Error = message.PresignaturePoolFullError
+			break
+		}
+		if err := client.AddECDSAPresignature(keyID, presignature); err != nil {
+			log.Printf("Error with ECDSA presignature saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		client.ecdsa().presigning = nil
+		log.Printf("Presignature %s saved for keyid=%s, %d unused", id, keyID, len(key.presignatures))
+	case message.ECDSASignPresigned:
+		key, nodeErr := client.ecdsaSigningKey(keyID)
+		if nodeErr != message.Ok {
+			resp.Error = nodeErr
+			break
+		}
+		presignature := key.presignature(id)
+		if presignature == nil {
+			log.Printf("ECDSA key %s has no presignature with id %q", keyID, id)
+			resp.Error = message.PresignatureNotFoundError
+			break
+		}
+		h := msg.Data[2]
+		if !client.allow(keyID, signOperation, h) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		if !client.canSign(keyID, &key.usage) {
+			resp.Error = message.SignatureLimitError
+			break
+		}
+		if key.requiresApproval {
+			if nodeErr := client.checkApproval(msg, ecdsaAlgorithm, keyID, h); nodeErr != message.Ok {
+				resp.Error = nodeErr
+				break
+			}
+		}
+		log.Printf("Signing document with presignature %s of key %s as asked by client %s", id, keyID, client.GetConnString())
+		round3Msg, sigma, err := signPresigned(key, presignature, h)
+		if err != nil {
+			log.Printf("cannot sign with presignature %s: %s", id, err)
+			resp.Error = message.InternalError
+			break
+		}
+		encoded, err := message.EncodeECDSARound3Message(round3Msg)
+		if err != nil {
+			log.Printf("cannot encode ECDSA round 3 message: %s", err)
+			resp.Error = message.EncodingError
+			break
+		}
+		encodedSigma, err := message.EncodeECDSASigma(sigma)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		// A presignature used with two hashes reveals the secret key, so it is removed from the config file before the
+		// share of the signature leaves the node.
+		if err := client.UseECDSAPresignature(keyID, id); err != nil {
+			log.Printf("Error with ECDSA presignature saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		if err := client.countSignatures(&key.usage, 1); err != nil {
+			resp.Error = message.InternalError
+			break
+		}
+		resp.AddMessage(encoded)
+		resp.AddMessage(encodedSigma)
+	}
+	return resp
+}
+
+// ecdsaSigningKey returns the ECDSA key with the ID provided. It fails if the key does not exist, is not initialized,
+// or cannot sign because it was quarantined or is in a state that does not allow signing.
+func (client *Client) ecdsaSigningKey(keyID string) (*ecdsaKey, message.NodeError) {
+	key, ok := client.ecdsa().keys[keyID]
+	if !ok {
+		log.Printf("error finding ECDSA key with id: %s", keyID)
+		return nil, message.KeyNotFoundError
+	}
+	if key.quarantine != nil {
+		return nil, message.KeyQuarantinedError
+	}
+	if !key.allows(signOperation) || key.Share == nil || key.Share.Alpha == nil {
+		log.Printf("ECDSA key %s cannot sign in state %s", keyID, key.current())
+		return nil, message.KeyStateError
+	}
+	return key, message.Ok
+}
+
+// ecdsaPresigning returns the presignature the client is creating and its key. It fails if the presignature does not
+// have the ID provided or is not at the round provided, or if the key cannot sign anymore.
+func (client *Client) ecdsaPresigning(keyID, id string, round int) (*ecdsaPresigning, *ecdsaKey, message.NodeError) {
+	presigning := client.ecdsa().presigning
+	if presigning == nil || presigning.keyID != keyID || presigning.id != id || presigning.round != round {
+		log.Printf("Error: there is no presignature %s of key %s at round %d", id, keyID, round)
+		return nil, nil, message.InvalidMessageError
+	}
+	key, nodeErr := client.ecdsaSigningKey(keyID)
+	if nodeErr != message.Ok {
+		return nil, nil, nodeErr
+	}
+	return presigning, key, message.Ok
+}
+
+// AddECDSAPresignature adds a presignature to the pool of a key and asks the node to save it into the config file.
+func (client *Client) AddECDSAPresignature(keyID string, presignature *message.ECDSAPresignature) error {
+	key := client.ecdsa().keys[keyID]
+	old := key.presignatures
+	key.presignatures = append(old[:len(old):len(old)], presignature)
+	if err := client.node.SaveConfigKeys(); err != nil {
+		key.presignatures = old
+		return err
+	}
+	return nil
+}
+
+// UseECDSAPresignature removes a presignature from the pool of a key and asks the node to save the pool into the config
+// file. The presignature is kept if the config file cannot be saved, because it was not used.
+func (client *Client) UseECDSAPresignature(keyID, id string) error {
+	key := client.ecdsa().keys[keyID]
+	old := key.presignatures
+	presignatures := make([]*message.ECDSAPresignature, 0, len(old))
+	for _, presignature := range old {
+		if presignature.ID != id {
+			presignatures = append(presignatures, presignature)
+		}
+	}
+	key.presignatures = presignatures
+	if err := client.node.SaveConfigKeys(); err != nil {
+		key.presignatures = old
+		return err
+	}
+	return nil
+}
+
+// presignature returns the unused presignature of the key with the ID provided, or nil if there is none.
+func (key *ecdsaKey) presignature(id string) *message.ECDSAPresignature {
+	for _, presignature := range key.presignatures {
+		if presignature.ID == id {
+			return presignature
+		}
+	}
+	return nil
+}
+
+// round2 joins the round 1 messages of the signers, and returns the partial decryption of z, like the second round of
+// a tcecdsa signing session. It keeps r, u and z for the last round.
+func (presigning *ecdsaPresigning) round2(key *ecdsaKey, msgs tcecdsa.Round1MessageList) (*tcecdsa.Round2Message, error) {
+	meta := key.Meta
+	R, u, v, w, err := msgs.Join(meta)
+	if err != nil {
+		return nil, err
+	}
+	uv, err := meta.Mul(v, u)
+	if err != nil {
+		return nil, err
+	}
+	qw, err := meta.MulConstL1(w, meta.Q())
+	if err != nil {
+		return nil, err
+	}
+	qwL2, err := qw.ToL2(meta.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	z, err := meta.AddL2(uv, qwL2)
+	if err != nil {
+		return nil, err
+	}
+	pdZ, proof, err := meta.PartialDecryptL2(key.Share.PaillierShare, z)
+	if err != nil {
+		return nil, err
+	}
+	presigning.r, presigning.u, presigning.z = R.X, u, z
+	presigning.round = 2
+	return &tcecdsa.Round2Message{PDZ: pdZ, Proof: proof}, nil
+}
+
+// presignature joins the round 2 messages of the signers into nu, and returns the presignature, with the encrypted
+// value the third round of a tcecdsa signing session computes before using the hash.
+func (presigning *ecdsaPresigning) presignature(key *ecdsaKey, msgs tcecdsa.Round2MessageList) (*message.ECDSAPresignature, error) {
+	meta := key.Meta
+	nu, err := msgs.Join(meta, presigning.z)
+	if err != nil {
+		return nil, err
+	}
+	psi := new(big.Int).ModInverse(nu, meta.Q())
+	if psi == nil {
+		return nil, fmt.Errorf("nu is not invertible")
+	}
+	vHat, err := meta.MulConstL1(presigning.u, psi)
+	if err != nil {
+		return nil, err
+	}
+	return &message.ECDSAPresignature{
+		ID:   presigning.id,
+		R:    presigning.r,
+		VHat: vHat,
+	}, nil
+}
+
+// signPresigned returns the partial decryption of the encrypted s value of the signature of a hash with a
+// presignature, and the encrypted s value, which is the same in every node and is needed to check the partial
+// decryptions.
+func signPresigned(key *ecdsaKey, presignature *message.ECDSAPresignature, h []byte) (*tcecdsa.Round3Message, *l2fhe.EncryptedL2, error) {
+	if len(h) == 0 {
+		return nil, nil, fmt.Errorf("empty hash")
+	}
+	meta := key.Meta
+	encM, err := meta.EncryptFixedB(tcecdsa.HashToInt(h, meta.Curve()), big.NewInt(1), big.NewInt(1))
+	if err != nil {
+		return nil, nil, err
+	}
+	rAlpha, err := meta.MulConstL1(key.Share.Alpha, presignature.R)
+	if err != nil {
+		return nil, nil, err
+	}
+	rAlphaPlusEncM, err := meta.AddL1(rAlpha, encM)
+	if err != nil {
+		return nil, nil, err
+	}
+	sigma, err := meta.Mul(rAlphaPlusEncM, presignature.VHat)
+	if err != nil {
+		return nil, nil, err
+	}
+	pdSigma, proof, err := meta.PartialDecryptL2(key.Share.PaillierShare, sigma)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tcecdsa.Round3Message{PDSigma: pdSigma, Proof: proof}, sigma, nil
+}
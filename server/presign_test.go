@@ -0,0 +1,122 @@
+package server
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/tcecdsa"
+	"github.com/niclabs/tcecdsa/l2fhe"
+	"github.com/niclabs/tcpaillier"
+)
+
+// testPresignedKey adds to a node an ECDSA key with a presignature with the ID provided. The key holds only a small
+// threshold Paillier key and random encrypted values, because signing with a presignature only uses the Paillier
+// share and creating a whole tcecdsa key takes minutes.
+func testPresignedKey(t *testing.T, node *testNode, keyID, id string) *ecdsaKey {
+	paillierShares, paillier, err := tcpaillier.NewKey(256, 1, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := &tcecdsa.KeyMeta{
+		PubKey:      &l2fhe.PubKey{Paillier: paillier, MaxMessageModule: big.NewInt(7919)},
+		ZKProofMeta: &tcecdsa.ZKProofMeta{NTilde: big.NewInt(7), H1: big.NewInt(2), H2: big.NewInt(3)},
+		CurveName:   "P-256",
+	}
+	alpha, _, err := meta.Encrypt(big.NewInt(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vHat, _, err := meta.Encrypt(big.NewInt(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &ecdsaKey{
+		ID:        keyID,
+		Completed: true,
+		Share:     &tcecdsa.KeyShare{Alpha: alpha, Y: tcecdsa.NewZero(), PaillierShare: paillierShares[0]},
+		Meta:      meta,
+		presignatures: []*message.ECDSAPresignature{
+			{ID: id, R: big.NewInt(3), VHat: vHat},
+		},
+	}
+	node.client.ecdsa().keys[keyID] = key
+	if err := node.SaveConfigKeys(); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignPresignedUsesPresignatureOnce(t *testing.T) {
+	node := newTestNode(t)
+	defer node.close()
+	key := testPresignedKey(t, node, "k", "p1")
+	hash := []byte("0123456789abcdef0123456789abcdef")
+
+	resp := node.request(message.ECDSASignPresigned, []byte("k"), []byte("p1"), hash)
+	if resp.Error != message.Ok || len(resp.Data) != 2 {
+		t.Fatalf("signing with the presignature answered %s with %d values", resp.Error, len(resp.Data))
+	}
+	if len(key.presignatures) != 0 {
+		t.Errorf("the presignature was not removed from the pool")
+	}
+	if key.usage.signatures != 1 {
+		t.Errorf("the signature was counted %d times", key.usage.signatures)
+	}
+	// A restarted node reads the pool from the config file.
+	keys, err := parseECDSAKeys(node.config.Client.ECDSA.Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys["k"].presignatures) != 0 {
+		t.Errorf("the used presignature is still in the config file")
+	}
+	resp = node.request(message.ECDSASignPresigned, []byte("k"), []byte("p1"), []byte("another hash"))
+	if resp.Error != message.PresignatureNotFoundError || len(resp.Data) != 0 {
+		t.Errorf("signing again with the presignature answered %s with %d values", resp.Error, len(resp.Data))
+	}
+}
+
+func TestSignPresignedKeepsShareIfNotSaved(t *testing.T) {
+	node := newTestNode(t)
+	defer node.close()
+	key := testPresignedKey(t, node, "k", "p1")
+	node.breakConfig(t)
+
+	resp := node.request(message.ECDSASignPresigned, []byte("k"), []byte("p1"), []byte("0123456789abcdef"))
+	if resp.Error != message.InternalError || len(resp.Data) != 0 {
+		t.Errorf("signing without saving the pool answered %s with %d values", resp.Error, len(resp.Data))
+	}
+	if key.presignature("p1") == nil {
+		t.Errorf("the unused presignature was removed from the pool")
+	}
+	if key.usage.signatures != 0 {
+		t.Errorf("the signature was counted without sending its share")
+	}
+}
+
+func TestPresignaturesDroppedWithKeyShare(t *testing.T) {
+	node := newTestNode(t)
+	defer node.close()
+	key := testPresignedKey(t, node, "k", "p1")
+	key.pending = &ecdsaRefresh{Epoch: 1, Share: key.Share, Meta: key.Meta}
+	if err := node.client.CommitECDSAKeyRefresh("k"); err != nil {
+		t.Fatal(err)
+	}
+	if len(key.presignatures) != 0 {
+		t.Errorf("the presignatures of the old key share were kept after a refresh")
+	}
+
+	key = testPresignedKey(t, node, "k", "p2")
+	if err := node.client.ReplaceECDSAKey("k", &tcecdsa.KeyShare{}, key.Meta); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.client.ecdsa().keys["k"].presignatures) != 0 {
+		t.Errorf("the presignatures of the old key share were kept after a replace")
+	}
+	for _, archived := range node.client.ecdsa().archived {
+		if len(archived.Presignatures) != 0 {
+			t.Errorf("the presignatures of the old key share were archived")
+		}
+	}
+}
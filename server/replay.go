@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// DefaultMaxClockSkew is the maximum difference accepted between the clock of the node and the timestamp of a request
+// if the config does not define it.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// replayGuard rejects requests with a timestamp outside of the accepted time window, or with a nonce that was already
+// used. Nonces are only remembered while their timestamps are inside the window, because older requests are rejected
+// anyway.
+type replayGuard struct {
+	maxSkew   time.Duration        // Maximum difference between the clock of the node and the timestamp of a request.
+	seen      map[string]time.Time // Nonces already used, with the timestamps of their requests.
+	lastPrune time.Time            // Last time the expired nonces were removed.
+}
+
+func newReplayGuard(maxSkew time.Duration) *replayGuard {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxClockSkew
+	}
+	return &replayGuard{
+		maxSkew: maxSkew,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// Check returns an error if the request is outside of the accepted time window or if its nonce was already used.
+// Otherwise, it remembers the nonce of the request.
+func (guard *replayGuard) Check(msg *message.Message, now time.Time) error {
+	timestamp := msg.Time()
+	if timestamp.Before(now.Add(-guard.maxSkew)) || timestamp.After(now.Add(guard.maxSkew)) {
+		return fmt.Errorf("timestamp %s is outside of the accepted window of %s", timestamp, guard.maxSkew)
+	}
+	if msg.Nonce == "" {
+		return fmt.Errorf("empty nonce")
+	}
+	if now.Sub(guard.lastPrune) > time.Second {
+		guard.prune(now)
+	}
+	if _, ok := guard.seen[msg.Nonce]; ok {
+		return fmt.Errorf("nonce %s was already used", msg.Nonce)
+	}
+	guard.seen[msg.Nonce] = timestamp
+	return nil
+}
+
+// prune removes the nonces whose requests would be rejected by their timestamp.
+func (guard *replayGuard) prune(now time.Time) {
+	limit := now.Add(-guard.maxSkew)
+	for nonce, timestamp := range guard.seen {
+		if timestamp.Before(limit) {
+			delete(guard.seen, nonce)
+		}
+	}
+	guard.lastPrune = now
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+func testRequest(id string, timestamp time.Time, data ...[]byte) *message.Message {
+	return &message.Message{
+		From:      "client",
+		ID:        id,
+		Type:      message.GetRSASigShare,
+		Timestamp: timestamp.UnixNano(),
+		Nonce:     "nonce-" + id,
+		Data:      data,
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		offset  time.Duration // Timestamp of the request, relative to the clock of the node.
+		nonce   string
+		replays bool
+	}{
+		{"current", 0, "a", false},
+		{"inside the window, in the past", -4 * time.Minute, "b", false},
+		{"inside the window, in the future", 4 * time.Minute, "c", false},
+		{"too old", -6 * time.Minute, "d", true},
+		{"too far in the future", 6 * time.Minute, "e", true},
+		{"empty nonce", 0, "", true},
+		{"used nonce", time.Second, "a", true},
+	}
+	guard := newReplayGuard(5 * time.Minute)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := testRequest(test.name, now.Add(test.offset))
+			req.Nonce = test.nonce
+			err := guard.Check(req, now)
+			if (err != nil) != test.replays {
+				t.Errorf("expected rejection %v, got error %v", test.replays, err)
+			}
+		})
+	}
+}
+
+func TestReplayGuardForgetsExpiredNonces(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	guard := newReplayGuard(time.Minute)
+	req := testRequest("0", now)
+	if err := guard.Check(req, now); err != nil {
+		t.Fatalf("request rejected: %s", err)
+	}
+	later := now.Add(2 * time.Minute)
+	if err := guard.Check(testRequest("1", later), later); err != nil {
+		t.Fatalf("request rejected: %s", err)
+	}
+	if _, ok := guard.seen[req.Nonce]; ok {
+		t.Errorf("nonce of an expired request still remembered")
+	}
+	if err := guard.Check(req, later); err == nil {
+		t.Errorf("expired request accepted")
+	}
+}
+
+func TestReplayGuardDefaultSkew(t *testing.T) {
+	if guard := newReplayGuard(0); guard.maxSkew != DefaultMaxClockSkew {
+		t.Errorf("expected default skew %s, got %s", DefaultMaxClockSkew, guard.maxSkew)
+	}
+}
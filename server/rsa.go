@@ -2,32 +2,99 @@ package server
 
 import (
 	"crypto"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"github.com/niclabs/dtcnode/v3/config"
 	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/niclabs/dtcnode/v3/refresh"
 	"github.com/niclabs/tcrsa"
 	"log"
+	"math/big"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
 )
 
 // rsa represents the data related to rsa signing processes
 type rsa struct {
-	keys map[string]*rsaKey
+	keys     map[string]*rsaKey
+	archived []*config.RSAKeyConfig // Replaced key shares, saved so they can be recovered.
+	received map[string]*rsaRefresh // Key shares received by resharing for keys the node does not have yet.
 }
 
 // rsaKey represents a keyshare managed by the node and used by the server for signing documents.
 type rsaKey struct {
-	ID    string
+	ID               string
+	Share            *tcrsa.KeyShare
+	Meta             *tcrsa.KeyMeta
+	verifier         *verifier   // Decides which sig shares are verified locally.
+	Epoch            uint64      // Number of refreshes applied to the key share.
+	pending          *rsaRefresh // Refreshed key share waiting for the client to commit it.
+	quarantine       error       // Reason why the key share failed the self-test. It is not used while it is set.
+	lifecycle                    // Lifecycle state of the key share.
+	usage                        // Signature counters and limits of the key share.
+	requiresApproval bool        // Signing requests wait until an operator of the node approves them.
+}
+
+// rsaRefresh represents a refreshed key share, kept next to the current one until the client commits or aborts the
+// refresh.
+type rsaRefresh struct {
+	Epoch uint64
 	Share *tcrsa.KeyShare
 	Meta  *tcrsa.KeyMeta
 }
 
+// rsaAlgorithm is the name of the RSA threshold scheme.
+const rsaAlgorithm = "rsa"
+
+func init() {
+	registerAlgorithm(&algorithm{
+		name: rsaAlgorithm,
+		load: loadRSAKeys,
+	})
+	for mType, dataLength := range map[message.Type]int{
+		message.SendRSAKeyShare:          3, // keyID, keyShare, keyMeta -> {}
+		message.ReplaceRSAKeyShare:       3, // keyID, keyShare, keyMeta -> {}
+		message.GetRSASigShare:           3, // keyID, hash, mechanism -> sigShare
+		message.GetRSASigShareBatch:      3, // keyID, hashList, mechanism -> batchSigShareList
+		message.GetRSADecryptShare:       2, // keyID, ciphertext -> decryptShare
+		message.DeleteRSAKeyShare:        1, // keyID -> wipe
+		message.RefreshRSAKeyShare:       4, // keyID, epoch, delta, keyMeta -> {}
+		message.CommitRSAKeyShareRefresh: 2, // keyID, epoch -> {}
+		message.AbortRSAKeyShareRefresh:  2, // keyID, epoch -> {}
+		message.DealRSAKeyShare:          2, // keyID, reshare -> dealing
+		message.ReshareRSAKeyShare:       6, // keyID, epoch, index, keyMeta, newKeyMeta, dealingList -> {}
+	} {
+		registerHandler(mType, dataLength, (*Client).dispatchRSA)
+	}
+}
+
 func (client *Client) dispatchRSA(msg *message.Message) *message.Message {
 	resp := msg.NewResponse(client.node.GetID(), message.Ok)
 	switch msg.Type {
-	case message.SendRSAKeyShare:
+	case message.SendRSAKeyShare, message.ReplaceRSAKeyShare:
 		log.Printf("Client %s is sending us a new RSA KeyShare", client.GetConnString())
 		keyID := string(msg.Data[0])
+		old, exists := client.rsa().keys[keyID]
+		if exists && msg.Type != message.ReplaceRSAKeyShare {
+			log.Printf("RSA keyshare with keyid=%s already exists, refusing to overwrite it", keyID)
+			resp.Error = message.KeyAlreadyExistsError
+			break
+		}
+		if exists && !old.allows(overwriteOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if exists && !client.allow(keyID, overwriteOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		if !client.canCreate(rsaAlgorithm, "") {
+			resp.Error = message.AlgorithmNotAllowedError
+			break
+		}
 		keyShare, err := message.DecodeRSAKeyShare(msg.Data[1])
 		if err != nil {
 			resp.Error = message.DecodingError
@@ -38,8 +105,14 @@ func (client *Client) dispatchRSA(msg *message.Message) *message.Message {
 			resp.Error = message.DecodingError
 			break
 		}
-		log.Printf("Saving keyshare for keyid=%s", keyID)
-		if err := client.SaveRSAKey(keyID, keyShare, keyMeta); err != nil {
+		if exists {
+			log.Printf("Archiving old keyshare and saving new keyshare for keyid=%s", keyID)
+			err = client.ReplaceRSAKey(keyID, keyShare, keyMeta)
+		} else {
+			log.Printf("Saving keyshare for keyid=%s", keyID)
+			err = client.SaveRSAKey(keyID, keyShare, keyMeta)
+		}
+		if err != nil {
 			log.Printf("Error with RSA keyshare saving process: %s", err)
 			resp.Error = message.InternalError
 			break
@@ -48,42 +121,411 @@ func (client *Client) dispatchRSA(msg *message.Message) *message.Message {
 	case message.GetRSASigShare:
 		keyID := string(msg.Data[0])
 		log.Printf("Client %s is asking us for a RSA signature share using key %s", client.GetConnString(), keyID)
-		key, ok := client.rsa.keys[keyID]
+		key, ok := client.rsa().keys[keyID]
 		if !ok {
 			resp.Error = message.KeyNotFoundError
 			break
 		}
-		doc := msg.Data[1]
-		b64doc := base64.StdEncoding.EncodeToString(doc)
-		log.Printf("Signing document hash %s with key %s as asked by client %s", b64doc, keyID, client.GetConnString())
-		sigShare, err := key.Share.Sign(doc, crypto.SHA256, key.Meta)
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if !key.allows(signOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		hash := msg.Data[1]
+		mechanism, err := message.DecodeRSAMechanism(msg.Data[2])
 		if err != nil {
-			resp.Error = message.DocSignError
+			log.Printf("error decoding RSA mechanism: %s", err)
+			resp.Error = message.InvalidMessageError
 			break
 		}
-		// Verify sigshare locally
-		if err := sigShare.Verify(doc, key.Meta); err != nil {
-			resp.Error = message.DocSignError
+		if !client.allow(keyID, signOperation, hash) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		if !client.canSign(keyID, &key.usage) {
+			resp.Error = message.SignatureLimitError
+			break
+		}
+		if key.requiresApproval {
+			if nodeErr := client.checkApproval(msg, rsaAlgorithm, keyID, hash); nodeErr != message.Ok {
+				resp.Error = nodeErr
+				break
+			}
+		}
+		b64doc := base64.StdEncoding.EncodeToString(hash)
+		log.Printf("Signing document hash %s using %s with key %s as asked by client %s", b64doc, mechanism, keyID, client.GetConnString())
+		sigShare, nodeErr := key.sign(mechanism, hash)
+		if nodeErr != message.Ok {
+			resp.Error = nodeErr
 			break
 		}
-
 		log.Printf("The document %s was signed succesfully with key %s as asked by client %s", b64doc, keyID, client.GetConnString())
+		if err := client.countSignatures(&key.usage, 1); err != nil {
+			resp.Error = message.InternalError
+			break
+		}
 		encodedSigShare, err := message.EncodeRSASigShare(sigShare)
 		if err != nil {
 			resp.Error = message.EncodingError
 			break
 		}
 		resp.AddMessage(encodedSigShare)
+	case message.GetRSASigShareBatch:
+		keyID := string(msg.Data[0])
+		log.Printf("Client %s is asking us for a batch of RSA signature shares using key %s", client.GetConnString(), keyID)
+		key, ok := client.rsa().keys[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if !key.allows(signOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		hashes, err := message.DecodeRSAHashList(msg.Data[1])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		if len(hashes) == 0 || len(hashes) > message.MaxRSABatchSize {
+			log.Printf("invalid batch size: %d", len(hashes))
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		mechanism, err := message.DecodeRSAMechanism(msg.Data[2])
+		if err != nil {
+			log.Printf("error decoding RSA mechanism: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		sigShares := make([]*message.RSABatchSigShare, len(hashes))
+		for i, hash := range hashes {
+			sigShares[i] = &message.RSABatchSigShare{}
+			if !client.allow(keyID, signOperation, hash) {
+				sigShares[i].Error = message.PermissionDeniedError
+			}
+		}
+		// Hashes over the signature limit are refused one by one, so the batch signs as many as the limit allows.
+		remaining := key.remaining(time.Now())
+		allowed := 0
+		for _, sigShare := range sigShares {
+			if sigShare.Error != message.Ok {
+				continue
+			}
+			if remaining == 0 {
+				sigShare.Error = message.SignatureLimitError
+				continue
+			}
+			remaining--
+			allowed++
+		}
+		// The policy and the limits are checked before the approval, as for single signatures, so an operator is not
+		// asked to approve a batch the client cannot sign.
+		if key.requiresApproval && allowed > 0 {
+			if nodeErr := client.checkApproval(msg, rsaAlgorithm, keyID, hashes...); nodeErr != message.Ok {
+				resp.Error = nodeErr
+				break
+			}
+		}
+		log.Printf("Signing %d document hashes using %s with key %s as asked by client %s", len(hashes), mechanism, keyID, client.GetConnString())
+		key.signBatch(mechanism, hashes, sigShares)
+		signed := uint64(0)
+		notSigned := 0
+		for _, sigShare := range sigShares {
+			if sigShare.Error == message.Ok {
+				signed++
+			} else if sigShare.Error != message.PermissionDeniedError {
+				notSigned++
+			}
+		}
+		client.policies.Release(keyID, notSigned)
+		if err := client.countSignatures(&key.usage, signed); err != nil {
+			resp.Error = message.InternalError
+			break
+		}
+		encodedSigShares, err := message.EncodeRSABatchSigShares(sigShares)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encodedSigShares)
+	case message.GetRSADecryptShare:
+		keyID := string(msg.Data[0])
+		log.Printf("Client %s is asking us for a RSA decryption share using key %s", client.GetConnString(), keyID)
+		key, ok := client.rsa().keys[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if !key.allows(decryptOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		ciphertext := msg.Data[1]
+		if err := checkCiphertext(ciphertext, key.Meta); err != nil {
+			log.Printf("invalid ciphertext: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if !client.allow(keyID, decryptOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		// A decryption share can be used as a signature share of any padded hash, so it counts as a signature.
+		if !client.canSign(keyID, &key.usage) {
+			resp.Error = message.SignatureLimitError
+			break
+		}
+		// A decryption share is computed as a signature share, so it needs the same approval.
+		if key.requiresApproval {
+			if nodeErr := client.checkApproval(msg, rsaAlgorithm, keyID, ciphertext); nodeErr != message.Ok {
+				resp.Error = nodeErr
+				break
+			}
+		}
+		// A decryption share is a signature share of the ciphertext: joining them raises it to the private exponent.
+		decryptShare, err := key.Share.Sign(ciphertext, crypto.SHA256, key.Meta)
+		if err != nil {
+			resp.Error = message.DecryptError
+			break
+		}
+		if err := key.verifier.verify(func() error { return decryptShare.Verify(ciphertext, key.Meta) }); err != nil {
+			resp.Error = message.DecryptError
+			break
+		}
+		log.Printf("Decryption share computed with key %s as asked by client %s", keyID, client.GetConnString())
+		if err := client.countSignatures(&key.usage, 1); err != nil {
+			resp.Error = message.InternalError
+			break
+		}
+		encodedDecryptShare, err := message.EncodeRSASigShare(decryptShare)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encodedDecryptShare)
 	case message.DeleteRSAKeyShare:
 		log.Printf("Client %s is asking us to delete a RSA KeyShare", client.GetConnString())
 		keyID := string(msg.Data[0])
+		if !client.allow(keyID, deleteOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
 		log.Printf("Deleting keyshare for keyid=%s", keyID)
-		if err := client.DeleteRSAKey(keyID); err != nil {
+		wipe, err := client.DeleteRSAKey(keyID)
+		if err != nil {
 			log.Printf("Error with key deleting: %s", err)
 			resp.Error = message.InternalError
 			break
 		}
-		log.Printf("Keyshare deleted for keyid=%s", keyID)
+		encodedWipe, err := message.EncodeWipe(wipe)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encodedWipe)
+		log.Printf("Keyshare deleted for keyid=%s: %s", keyID, wipe)
+	case message.RefreshRSAKeyShare:
+		keyID := string(msg.Data[0])
+		log.Printf("Client %s is sending us a refresh of RSA KeyShare with id=%s", client.GetConnString(), keyID)
+		key, ok := client.rsa().keys[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if !key.allows(refreshOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if !client.allow(keyID, refreshOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		epoch, err := message.DecodeEpoch(msg.Data[1])
+		if err != nil {
+			log.Printf("error decoding refresh epoch: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if epoch != key.Epoch+1 {
+			log.Printf("refresh epoch %d does not follow epoch %d of key %s", epoch, key.Epoch, keyID)
+			resp.Error = message.EpochMismatchError
+			break
+		}
+		keyMeta, err := message.DecodeRSAKeyMeta(msg.Data[3])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		keyShare, err := refresh.RefreshRSAKeyShare(key.Share, new(big.Int).SetBytes(msg.Data[2]), key.Meta, keyMeta)
+		if err != nil {
+			log.Printf("invalid refresh of key %s: %s", keyID, err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if err := client.RefreshRSAKey(keyID, epoch, keyShare, keyMeta); err != nil {
+			log.Printf("Error with RSA keyshare refresh saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		log.Printf("Refreshed keyshare saved for keyid=%s and epoch %d, waiting for commit", keyID, epoch)
+	case message.DealRSAKeyShare:
+		keyID := string(msg.Data[0])
+		log.Printf("Client %s is asking us to deal RSA KeyShare with id=%s to a new node set", client.GetConnString(), keyID)
+		key, ok := client.rsa().keys[keyID]
+		if !ok {
+			resp.Error = message.KeyNotFoundError
+			break
+		}
+		if key.quarantine != nil {
+			resp.Error = message.KeyQuarantinedError
+			break
+		}
+		if !key.allows(reshareOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if !client.allow(keyID, reshareOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		reshare, err := message.DecodeReshare(msg.Data[1])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		dealing, err := refresh.NewRSADealing(key.Share, key.Meta, reshare.Dealers, reshare.K, reshare.L, reshare.Recipients)
+		if err != nil {
+			log.Printf("cannot deal key %s: %s", keyID, err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		encodedDealing, err := message.EncodeDealing(dealing)
+		if err != nil {
+			resp.Error = message.EncodingError
+			break
+		}
+		resp.AddMessage(encodedDealing)
+		log.Printf("Keyshare with keyid=%s dealt to %d-of-%d new key shares", keyID, reshare.K, reshare.L)
+	case message.ReshareRSAKeyShare:
+		keyID := string(msg.Data[0])
+		log.Printf("Client %s is sending us a reshared RSA KeyShare with id=%s", client.GetConnString(), keyID)
+		key, exists := client.rsa().keys[keyID]
+		if exists && !key.allows(reshareOperation) {
+			resp.Error = message.KeyStateError
+			break
+		}
+		if exists && !client.allow(keyID, reshareOperation, nil) {
+			resp.Error = message.PermissionDeniedError
+			break
+		}
+		if !exists && !client.canCreate(rsaAlgorithm, "") {
+			resp.Error = message.AlgorithmNotAllowedError
+			break
+		}
+		epoch, err := message.DecodeEpoch(msg.Data[1])
+		if err != nil {
+			log.Printf("error decoding reshare epoch: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if exists && epoch != key.Epoch+1 || epoch == 0 {
+			log.Printf("reshare epoch %d does not follow the epoch of key %s", epoch, keyID)
+			resp.Error = message.EpochMismatchError
+			break
+		}
+		index, err := message.DecodeShareIndex(msg.Data[2])
+		if err != nil {
+			log.Printf("error decoding reshare index: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		keyMeta, err := message.DecodeRSAKeyMeta(msg.Data[3])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		if exists {
+			// A node holding the key checks the dealings against its own key meta.
+			keyMeta = key.Meta
+		}
+		newKeyMeta, err := message.DecodeRSAKeyMeta(msg.Data[4])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		dealings, err := message.DecodeDealingList(msg.Data[5])
+		if err != nil {
+			resp.Error = message.DecodingError
+			break
+		}
+		keyShare, err := refresh.ReshareRSAKeyShare(index, client.node.curveSecretKey(), keyMeta, newKeyMeta, dealings)
+		if err != nil {
+			log.Printf("invalid reshare of key %s: %s", keyID, err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		if exists {
+			err = client.RefreshRSAKey(keyID, epoch, keyShare, newKeyMeta)
+		} else {
+			err = client.ReceiveRSAKey(keyID, epoch, keyShare, newKeyMeta)
+		}
+		if err != nil {
+			log.Printf("Error with RSA keyshare reshare saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
+		log.Printf("Reshared keyshare saved for keyid=%s and epoch %d, waiting for commit", keyID, epoch)
+	case message.CommitRSAKeyShareRefresh, message.AbortRSAKeyShareRefresh:
+		keyID := string(msg.Data[0])
+		epoch, err := message.DecodeEpoch(msg.Data[1])
+		if err != nil {
+			log.Printf("error decoding refresh epoch: %s", err)
+			resp.Error = message.InvalidMessageError
+			break
+		}
+		commit := msg.Type == message.CommitRSAKeyShareRefresh
+		key, ok := client.rsa().keys[keyID]
+		if !ok {
+			resp.Error = client.finishReceivedRSAKey(keyID, epoch, commit)
+			break
+		}
+		if key.pending == nil || key.pending.Epoch != epoch {
+			// Retried commits and aborts of refreshes this node never received are answered as done.
+			if commit && key.Epoch == epoch || !commit && key.Epoch < epoch {
+				break
+			}
+			log.Printf("key %s has no pending refresh for epoch %d", keyID, epoch)
+			resp.Error = message.EpochMismatchError
+			break
+		}
+		if commit {
+			log.Printf("Client %s is committing the refresh of RSA key %s to epoch %d", client.GetConnString(), keyID, epoch)
+			err = client.CommitRSAKeyRefresh(keyID)
+		} else {
+			log.Printf("Client %s is aborting the refresh of RSA key %s to epoch %d", client.GetConnString(), keyID, epoch)
+			err = client.AbortRSAKeyRefresh(keyID)
+		}
+		if err != nil {
+			log.Printf("Error with RSA keyshare refresh saving process: %s", err)
+			resp.Error = message.InternalError
+			break
+		}
 	default:
 		log.Printf("invalid message received from client %s", client.GetConnString())
 
@@ -94,22 +536,414 @@ func (client *Client) dispatchRSA(msg *message.Message) *message.Message {
 
 // SaveRSAKey updates the key array of the server and asks the node to save the rsaKeys into the config file.
 func (client *Client) SaveRSAKey(id string, keyShare *tcrsa.KeyShare, keyMeta *tcrsa.KeyMeta) error {
-	key, ok := client.rsa.keys[id]
+	key, ok := client.rsa().keys[id]
 	if !ok {
 		key = &rsaKey{}
-		client.rsa.keys[id] = key
+		key.verifier, _ = newVerifier(string(verifyAlways), 0)
+		client.rsa().keys[id] = key
 	}
 	key.ID = id
 	key.Meta = keyMeta
 	key.Share = keyShare
+	key.quarantine = nil
 	return client.node.SaveConfigKeys()
 }
 
-// SaveRSAKey deletes a key from the array of the server and asks the node to save the new key array into the config file.
-func (client *Client) DeleteRSAKey(id string) error {
-	log.Printf("deleting ecdsa key with id %s", id)
-	delete(client.rsa.keys, id)
-	return client.node.SaveConfigKeys()
+// RefreshRSAKey saves a refreshed key share next to the current one, which is still used until the client commits the
+// refresh.
+func (client *Client) RefreshRSAKey(id string, epoch uint64, keyShare *tcrsa.KeyShare, keyMeta *tcrsa.KeyMeta) error {
+	key := client.rsa().keys[id]
+	old := key.pending
+	key.pending = &rsaRefresh{
+		Epoch: epoch,
+		Share: keyShare,
+		Meta:  keyMeta,
+	}
+	if err := client.node.SaveConfigKeys(); err != nil {
+		key.pending = old
+		return err
+	}
+	return nil
+}
+
+// CommitRSAKeyRefresh replaces the key share with its pending refresh. The old key share is discarded and not
+// archived, because an attacker could combine it with other old shares.
+func (client *Client) CommitRSAKeyRefresh(id string) error {
+	key := client.rsa().keys[id]
+	old := *key
+	key.Share = key.pending.Share
+	key.Meta = key.pending.Meta
+	key.Epoch = key.pending.Epoch
+	key.pending = nil
+	// The pending key share was checked against its verification key when it was received.
+	key.quarantine = nil
+	if err := client.node.SaveConfigKeys(); err != nil {
+		*key = old
+		return err
+	}
+	wipeRSAKeyShare(old.Share)
+	return nil
+}
+
+// AbortRSAKeyRefresh discards the pending refresh of a key share.
+func (client *Client) AbortRSAKeyRefresh(id string) error {
+	key := client.rsa().keys[id]
+	old := key.pending
+	key.pending = nil
+	if err := client.node.SaveConfigKeys(); err != nil {
+		key.pending = old
+		return err
+	}
+	return nil
+}
+
+// ReceiveRSAKey saves a key share received by resharing for a key the node does not have. It is not used until the
+// client commits it.
+func (client *Client) ReceiveRSAKey(id string, epoch uint64, keyShare *tcrsa.KeyShare, keyMeta *tcrsa.KeyMeta) error {
+	old, ok := client.rsa().received[id]
+	client.rsa().received[id] = &rsaRefresh{
+		Epoch: epoch,
+		Share: keyShare,
+		Meta:  keyMeta,
+	}
+	if err := client.node.SaveConfigKeys(); err != nil {
+		if ok {
+			client.rsa().received[id] = old
+		} else {
+			delete(client.rsa().received, id)
+		}
+		return err
+	}
+	return nil
+}
+
+// finishReceivedRSAKey commits or aborts the key share received by resharing for a key the node does not have, and
+// returns the error code of the response.
+func (client *Client) finishReceivedRSAKey(id string, epoch uint64, commit bool) message.NodeError {
+	received, ok := client.rsa().received[id]
+	if !ok || received.Epoch != epoch {
+		if commit {
+			return message.KeyNotFoundError
+		}
+		// Aborts of reshares this node never received are answered as done.
+		return message.Ok
+	}
+	var err error
+	if commit {
+		log.Printf("Client %s is committing the RSA key %s received by resharing at epoch %d", client.GetConnString(), id, epoch)
+		err = client.CommitReceivedRSAKey(id)
+	} else {
+		log.Printf("Client %s is aborting the RSA key %s received by resharing at epoch %d", client.GetConnString(), id, epoch)
+		err = client.AbortReceivedRSAKey(id)
+	}
+	if err != nil {
+		log.Printf("Error with RSA keyshare reshare saving process: %s", err)
+		return message.InternalError
+	}
+	return message.Ok
+}
+
+// CommitReceivedRSAKey adds the key share received by resharing to the keys of the node.
+func (client *Client) CommitReceivedRSAKey(id string) error {
+	received := client.rsa().received[id]
+	key := &rsaKey{
+		ID:    id,
+		Share: received.Share,
+		Meta:  received.Meta,
+		Epoch: received.Epoch,
+	}
+	key.verifier, _ = newVerifier(string(verifyAlways), 0)
+	client.rsa().keys[id] = key
+	delete(client.rsa().received, id)
+	if err := client.node.SaveConfigKeys(); err != nil {
+		delete(client.rsa().keys, id)
+		client.rsa().received[id] = received
+		return err
+	}
+	return nil
+}
+
+// AbortReceivedRSAKey discards the key share received by resharing.
+func (client *Client) AbortReceivedRSAKey(id string) error {
+	received := client.rsa().received[id]
+	delete(client.rsa().received, id)
+	if err := client.node.SaveConfigKeys(); err != nil {
+		client.rsa().received[id] = received
+		return err
+	}
+	return nil
+}
+
+// sign returns a signature share of the hash provided, encoded with the mechanism, after verifying it locally.
+// It returns InvalidMessageError if the hash cannot be encoded, and DocSignError if the share cannot be created.
+func (key *rsaKey) sign(mechanism message.RSAMechanism, hash []byte) (*tcrsa.SigShare, message.NodeError) {
+	doc, err := mechanism.Encode(hash, key.Meta)
+	if err != nil {
+		log.Printf("error encoding document with mechanism %s: %s", mechanism, err)
+		return nil, message.InvalidMessageError
+	}
+	sigShare, err := key.Share.Sign(doc, signHash(mechanism), key.Meta)
+	if err != nil {
+		return nil, message.DocSignError
+	}
+	// Verify sigshare locally
+	if err := key.verifier.verify(func() error { return sigShare.Verify(doc, key.Meta) }); err != nil {
+		log.Printf("sig share of key %s does not verify: %s", key.ID, err)
+		return nil, message.DocSignError
+	}
+	return sigShare, message.Ok
+}
+
+// signBatch signs the hashes provided in parallel, using as many goroutines as CPUs, and saves the result of each one
+// in the sig share with the same index. Hashes whose sig share already has an error are skipped.
+func (key *rsaKey) signBatch(mechanism message.RSAMechanism, hashes [][]byte, sigShares []*message.RSABatchSigShare) {
+	workers := runtime.NumCPU()
+	if workers > len(hashes) {
+		workers = len(hashes)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sigShares[i].SigShare, sigShares[i].Error = key.sign(mechanism, hashes[i])
+			}
+		}()
+	}
+	for i := range hashes {
+		if sigShares[i].Error == message.Ok {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// signHash returns the hash function passed to tcrsa when signing with a mechanism. tcrsa uses it to define the size
+// of the random value of the signature share proof, so mechanisms that do not hash use SHA-256.
+func signHash(mechanism message.RSAMechanism) crypto.Hash {
+	if hash := mechanism.Hash(); hash != 0 {
+		return hash
+	}
+	return crypto.SHA256
+}
+
+// checkCiphertext returns an error if the ciphertext is not as long as the modulus of the key, or if it is not lower
+// than it.
+func checkCiphertext(ciphertext []byte, meta *tcrsa.KeyMeta) error {
+	if len(ciphertext) != meta.PublicKey.Size() {
+		return fmt.Errorf("ciphertext length is %d, but it should be %d", len(ciphertext), meta.PublicKey.Size())
+	}
+	if new(big.Int).SetBytes(ciphertext).Cmp(meta.PublicKey.N) >= 0 {
+		return fmt.Errorf("ciphertext is not lower than the modulus")
+	}
+	return nil
+}
+
+// ReplaceRSAKey archives the current key share with the ID provided and saves the new one in its place. The key keeps
+// its settings, lifecycle state and signature counters. The old key share is only overwritten with zeros in memory
+// after the config file with the new one and the archived one is saved.
+func (client *Client) ReplaceRSAKey(id string, keyShare *tcrsa.KeyShare, keyMeta *tcrsa.KeyMeta) error {
+	state := client.rsa()
+	old, ok := state.keys[id]
+	if !ok {
+		return client.SaveRSAKey(id, keyShare, keyMeta)
+	}
+	archived, err := encodeRSAKey(old)
+	if err != nil {
+		return err
+	}
+	archived.ArchivedAt = time.Now().UTC().Format(time.RFC3339)
+	key := *old
+	key.Share = keyShare
+	key.Meta = keyMeta
+	key.Epoch = 0
+	key.pending = nil
+	key.quarantine = nil
+	oldArchived := state.archived
+	state.archived = append(oldArchived[:len(oldArchived):len(oldArchived)], archived)
+	state.keys[id] = &key
+	if err := client.node.SaveConfigKeys(); err != nil {
+		state.keys[id] = old
+		state.archived = oldArchived
+		return err
+	}
+	wipeRSAKeyShare(old.Share)
+	if old.pending != nil {
+		wipeRSAKeyShare(old.pending.Share)
+	}
+	return nil
+}
+
+// DeleteRSAKey deletes a key from the array of the server, overwriting its key shares with zeros, and asks the node to
+// save the new key array into the config file.
+func (client *Client) DeleteRSAKey(id string) (*message.Wipe, error) {
+	log.Printf("deleting rsa key with id %s", id)
+	return client.deleteKey(rsaAlgorithm, id)
+}
+
+// rsa returns the RSA key store of the client.
+func (client *Client) rsa() *rsa {
+	return client.keys[rsaAlgorithm].(*rsa)
+}
+
+// loadRSAKeys creates the RSA key store of a client from its config.
+func loadRSAKeys(conf *config.ClientConfig) (keyStore, error) {
+	keys, err := parseRSAKeys(conf.RSA.Keys)
+	if err != nil {
+		return nil, err
+	}
+	received := make(map[string]*rsaRefresh)
+	for _, key := range conf.RSA.ReceivedKeys {
+		if received[key.ID], err = parseRSARefresh(key); err != nil {
+			return nil, fmt.Errorf("invalid received key %s: %s", key.ID, err)
+		}
+	}
+	return &rsa{
+		keys:     keys,
+		archived: conf.RSA.ArchivedKeys,
+		received: received,
+	}, nil
+}
+
+func (state *rsa) save(conf *config.ClientConfig) error {
+	keys, err := saveRSAKeys(state.keys)
+	if err != nil {
+		return err
+	}
+	conf.RSA.Keys = keys
+	conf.RSA.ArchivedKeys = state.archived
+	conf.RSA.ReceivedKeys = make([]*config.RSAKeyConfig, 0)
+	for id, received := range state.received {
+		keyConfig := &config.RSAKeyConfig{ID: id}
+		if err := encodeRSARefresh(keyConfig, received); err != nil {
+			return err
+		}
+		conf.RSA.ReceivedKeys = append(conf.RSA.ReceivedKeys, keyConfig)
+	}
+	return nil
+}
+
+func (state *rsa) len() int {
+	return len(state.keys)
+}
+
+func (state *rsa) lifecycles() map[string]*lifecycle {
+	lifecycles := make(map[string]*lifecycle, len(state.keys))
+	for id, key := range state.keys {
+		lifecycles[id] = &key.lifecycle
+	}
+	return lifecycles
+}
+
+func (state *rsa) delete(id string) *deletion {
+	key, hasKey := state.keys[id]
+	received, hasReceived := state.received[id]
+	oldArchived := state.archived
+	archived := make([]*config.RSAKeyConfig, 0, len(state.archived))
+	for _, key := range state.archived {
+		if key.ID != id {
+			archived = append(archived, key)
+		}
+	}
+	if !hasKey && !hasReceived && len(archived) == len(oldArchived) {
+		return nil
+	}
+	delete(state.keys, id)
+	delete(state.received, id)
+	state.archived = archived
+	d := &deletion{wipe: &message.Wipe{Archived: len(oldArchived) - len(archived)}}
+	d.zero = func() {
+		if hasKey {
+			d.wipe.Shares += wipeRSAKeyShare(key.Share)
+			if key.pending != nil {
+				d.wipe.Shares += wipeRSAKeyShare(key.pending.Share)
+			}
+		}
+		if hasReceived {
+			d.wipe.Shares += wipeRSAKeyShare(received.Share)
+		}
+	}
+	d.restore = func() {
+		if hasKey {
+			state.keys[id] = key
+		}
+		if hasReceived {
+			state.received[id] = received
+		}
+		state.archived = oldArchived
+	}
+	return d
+}
+
+// wipeRSAKeyShare overwrites the secret of a key share with zeros, and returns the number of key shares overwritten.
+func wipeRSAKeyShare(share *tcrsa.KeyShare) int {
+	if share == nil {
+		return 0
+	}
+	wipeBytes(share.Si)
+	return 1
+}
+
+// endSession does nothing, because RSA signature shares are computed in one message.
+func (state *rsa) endSession(id string) {}
+
+func (state *rsa) inventory(now time.Time) []*KeyInfo {
+	keys := make([]*KeyInfo, 0, len(state.keys))
+	for id, key := range state.keys {
+		info := keyInfo(rsaAlgorithm, id, &key.lifecycle, &key.usage, key.quarantine, now)
+		info.Epoch = key.Epoch
+		keys = append(keys, info)
+	}
+	return keys
+}
+
+// verifyMetrics returns the sig share verification metrics of the keys, sorted by key ID.
+func (state *rsa) verifyMetrics() []*VerifyMetrics {
+	metrics := make([]*VerifyMetrics, 0, len(state.keys))
+	for id, key := range state.keys {
+		metrics = append(metrics, key.verifier.metrics(id))
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Key < metrics[j].Key
+	})
+	return metrics
+}
+
+func (state *rsa) selfTest() []*message.KeyHealth {
+	results := make([]*message.KeyHealth, 0, len(state.keys))
+	for id, key := range state.keys {
+		key.quarantine = key.selfTest()
+		results = append(results, keyHealth(rsaAlgorithm, id, key.quarantine))
+	}
+	return results
+}
+
+// selfTest signs the self-test document with the key share, and verifies the sig share with the verification key of
+// the share in the key meta.
+func (key *rsaKey) selfTest() error {
+	if key.Share == nil || key.Meta == nil {
+		return fmt.Errorf("missing key share or key meta")
+	}
+	meta := key.Meta
+	if meta.PublicKey == nil || meta.VerificationKey == nil || len(meta.VerificationKey.I) != int(meta.L) {
+		return fmt.Errorf("incomplete key meta")
+	}
+	if key.Share.Id < 1 || key.Share.Id > meta.L {
+		return fmt.Errorf("invalid key share ID %d", key.Share.Id)
+	}
+	hash := sha256.Sum256(selfTestDocument)
+	doc, err := message.RSAPKCS1v15SHA256.Encode(hash[:], meta)
+	if err != nil {
+		return err
+	}
+	sigShare, err := key.Share.Sign(doc, crypto.SHA256, meta)
+	if err != nil {
+		return err
+	}
+	return sigShare.Verify(doc, meta)
 }
 
 func parseRSAKeys(conf []*config.RSAKeyConfig) (map[string]*rsaKey, error) {
@@ -135,33 +969,120 @@ func parseRSAKeys(conf []*config.RSAKeyConfig) (map[string]*rsaKey, error) {
 				return nil, err
 			}
 		}
+		verifier, err := newVerifier(key.Verify, key.VerifySampleRate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verify mode for key %s: %s", key.ID, err)
+		}
+		pending, err := parseRSARefresh(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pending refresh for key %s: %s", key.ID, err)
+		}
+		lifecycle, err := parseLifecycle(key.State, key.DeleteAfter, message.KeyActive)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lifecycle state for key %s: %s", key.ID, err)
+		}
+		usage, err := parseUsage(key.Usage)
+		if err != nil {
+			return nil, fmt.Errorf("invalid usage for key %s: %s", key.ID, err)
+		}
 		keys[key.ID] = &rsaKey{
-			ID:    key.ID,
-			Meta:  keyMeta,
-			Share: keyShare,
+			ID:               key.ID,
+			Meta:             keyMeta,
+			Share:            keyShare,
+			verifier:         verifier,
+			Epoch:            key.Epoch,
+			pending:          pending,
+			lifecycle:        lifecycle,
+			usage:            usage,
+			requiresApproval: key.RequiresApproval,
 		}
 	}
 	return keys, nil
 }
 
+// parseRSARefresh returns the pending refresh of a key in the config file, or nil if it has none.
+func parseRSARefresh(key *config.RSAKeyConfig) (*rsaRefresh, error) {
+	if key.PendingEpoch == 0 {
+		return nil, nil
+	}
+	keyShareByte, err := base64.StdEncoding.DecodeString(key.PendingKeyShare)
+	if err != nil {
+		return nil, err
+	}
+	keyShare, err := message.DecodeRSAKeyShare(keyShareByte)
+	if err != nil {
+		return nil, err
+	}
+	keyMetaByte, err := base64.StdEncoding.DecodeString(key.PendingKeyMeta)
+	if err != nil {
+		return nil, err
+	}
+	keyMeta, err := message.DecodeRSAKeyMeta(keyMetaByte)
+	if err != nil {
+		return nil, err
+	}
+	return &rsaRefresh{
+		Epoch: key.PendingEpoch,
+		Share: keyShare,
+		Meta:  keyMeta,
+	}, nil
+}
+
 func saveRSAKeys(keys map[string]*rsaKey) ([]*config.RSAKeyConfig, error) {
 	keysConfig := make([]*config.RSAKeyConfig, 0)
 	for _, key := range keys {
-		keyShareBytes, err := message.EncodeRSAKeyShare(key.Share)
-		if err != nil {
-			return nil, fmt.Errorf("error encoding rsaKeys: %s", err)
-		}
-		keyMetaBytes, err := message.EncodeRSAKeyMeta(key.Meta)
+		keyConfig, err := encodeRSAKey(key)
 		if err != nil {
-			return nil, fmt.Errorf("error encoding rsaKeys: %s", err)
+			return nil, err
 		}
-		keyShareB64 := base64.StdEncoding.EncodeToString(keyShareBytes)
-		keyMetaB64 := base64.StdEncoding.EncodeToString(keyMetaBytes)
-		keysConfig = append(keysConfig, &config.RSAKeyConfig{
-			ID:          key.ID,
-			KeyMetaInfo: keyMetaB64,
-			KeyShare:    keyShareB64,
-		})
+		keysConfig = append(keysConfig, keyConfig)
 	}
 	return keysConfig, nil
 }
+
+// encodeRSAKey transforms a key into its representation in the config file.
+func encodeRSAKey(key *rsaKey) (*config.RSAKeyConfig, error) {
+	keyShareBytes, err := message.EncodeRSAKeyShare(key.Share)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding rsaKeys: %s", err)
+	}
+	keyMetaBytes, err := message.EncodeRSAKeyMeta(key.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding rsaKeys: %s", err)
+	}
+	keyConfig := &config.RSAKeyConfig{
+		ID:          key.ID,
+		KeyMetaInfo: base64.StdEncoding.EncodeToString(keyMetaBytes),
+		KeyShare:    base64.StdEncoding.EncodeToString(keyShareBytes),
+	}
+	if key.verifier.mode != verifyAlways {
+		keyConfig.Verify = string(key.verifier.mode)
+		keyConfig.VerifySampleRate = key.verifier.sampleRate
+	}
+	keyConfig.Epoch = key.Epoch
+	keyConfig.State, keyConfig.DeleteAfter = key.lifecycle.encode()
+	keyConfig.Usage = key.usage.encode()
+	keyConfig.RequiresApproval = key.requiresApproval
+	if key.pending != nil {
+		if err := encodeRSARefresh(keyConfig, key.pending); err != nil {
+			return nil, err
+		}
+	}
+	return keyConfig, nil
+}
+
+// encodeRSARefresh saves a refreshed or reshared key share into the pending fields of a key in the config file.
+func encodeRSARefresh(keyConfig *config.RSAKeyConfig, pending *rsaRefresh) error {
+	pendingShareBytes, err := message.EncodeRSAKeyShare(pending.Share)
+	if err != nil {
+		return fmt.Errorf("error encoding rsaKeys: %s", err)
+	}
+	pendingMetaBytes, err := message.EncodeRSAKeyMeta(pending.Meta)
+	if err != nil {
+		return fmt.Errorf("error encoding rsaKeys: %s", err)
+	}
+	keyConfig.PendingEpoch = pending.Epoch
+	keyConfig.PendingKeyShare = base64.StdEncoding.EncodeToString(pendingShareBytes)
+	keyConfig.PendingKeyMeta = base64.StdEncoding.EncodeToString(pendingMetaBytes)
+	return nil
+}
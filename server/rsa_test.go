@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/niclabs/tcrsa"
+)
+
+// copyRSAKeyShare returns a copy of a key share, so wiping it does not change the original.
+func copyRSAKeyShare(share *tcrsa.KeyShare) *tcrsa.KeyShare {
+	return &tcrsa.KeyShare{Si: append([]byte(nil), share.Si...), Id: share.Id}
+}
+
+func TestReplaceRSAKey(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	tests := []struct {
+		name      string
+		saveFails bool
+	}{
+		{"saved", false},
+		{"save fails", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			client := node.client
+			old := copyRSAKeyShare(shares[0])
+			if err := client.SaveRSAKey("k", old, meta); err != nil {
+				t.Fatal(err)
+			}
+			client.rsa().keys["k"].Epoch = 3
+			oldSi := append([]byte(nil), old.Si...)
+			if test.saveFails {
+				node.breakConfig(t)
+			}
+			err := client.ReplaceRSAKey("k", copyRSAKeyShare(shares[1]), meta)
+			key := client.rsa().keys["k"]
+			if test.saveFails {
+				if err == nil {
+					t.Fatal("replace succeeded without saving the config")
+				}
+				if key.Share != old || key.Epoch != 3 || len(client.rsa().archived) != 0 {
+					t.Errorf("key changed in memory after the save failed")
+				}
+				if !bytes.Equal(old.Si, oldSi) {
+					t.Errorf("old key share wiped after the save failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Share.Id != shares[1].Id || key.Epoch != 0 {
+				t.Errorf("key share not replaced")
+			}
+			if len(client.rsa().archived) != 1 || client.rsa().archived[0].ArchivedAt == "" {
+				t.Errorf("old key share not archived")
+			}
+			if !bytes.Equal(old.Si, make([]byte, len(old.Si))) {
+				t.Errorf("old key share not wiped")
+			}
+			// The archived key share must still be the old one.
+			restored, err := parseRSAKeys(client.rsa().archived)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(restored["k"].Share.Si, oldSi) {
+				t.Errorf("archived key share does not match the old one")
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"golang.org/x/sys/unix"
+)
+
+// sandboxEnv marks the process that enterSandbox started again inside the Landlock domain. The process checks that
+// it is restricted before trusting it, because it can also be inherited from the environment.
+const sandboxEnv = "DTCNODE_SANDBOXED"
+
+// oPath opens a file descriptor that only refers to a path.
+const oPath = 0x200000
+
+// prSetNoNewPrivs stops the process and its children from gaining privileges with execve.
+const prSetNoNewPrivs = 38
+
+// Landlock system calls and flags.
+const (
+	sysLandlockCreateRuleset     = 444
+	sysLandlockAddRule           = 445
+	sysLandlockRestrictSelf      = 446
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+)
+
+// Filesystem access rights of the first Landlock ABI.
+const (
+	accessExecute    = 1 << 0
+	accessWriteFile  = 1 << 1
+	accessReadFile   = 1 << 2
+	accessReadDir    = 1 << 3
+	accessRemoveDir  = 1 << 4
+	accessRemoveFile = 1 << 5
+	accessMakeChar   = 1 << 6
+	accessMakeDir    = 1 << 7
+	accessMakeReg    = 1 << 8
+	accessMakeSock   = 1 << 9
+	accessMakeFifo   = 1 << 10
+	accessMakeBlock  = 1 << 11
+	accessMakeSym    = 1 << 12
+
+	accessAll       = 1<<13 - 1
+	accessFile      = accessExecute | accessWriteFile | accessReadFile
+	accessRead      = accessExecute | accessReadFile | accessReadDir
+	accessReadWrite = accessReadFile | accessReadDir | accessWriteFile | accessRemoveDir | accessRemoveFile | accessMakeDir | accessMakeReg | accessMakeSock | accessMakeSym
+)
+
+// sandboxSystemPaths are the paths the node can read in the sandbox besides its own: the libraries it loads when it
+// starts, and the files it reads to resolve host names, users and the local time. Missing paths are skipped.
+var sandboxSystemPaths = []string{
+	"/usr",
+	"/lib",
+	"/lib64",
+	"/etc/ld.so.cache",
+	"/etc/localtime",
+	"/etc/hosts",
+	"/etc/resolv.conf",
+	"/etc/nsswitch.conf",
+	"/etc/passwd",
+	"/etc/group",
+	"/dev/null",
+	"/dev/urandom",
+}
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// enterSandbox restricts the filesystem access of the node with Landlock to the directories it writes and the paths
+// of the sandbox config, and sets no_new_privs. A Landlock domain only restricts the thread that creates it and the
+// threads created after it, so the node starts again with execve from the restricted thread. It only returns in the
+// process started again, or if the sandbox cannot be created.
+func enterSandbox(conf *config.SandboxConfig, dirs []string) error {
+	readWrite := append(dirs, conf.Paths...)
+	for _, path := range readWrite {
+		if filepath.Clean(path) == "/" {
+			return fmt.Errorf("the node cannot be allowed to write the whole filesystem")
+		}
+	}
+	if os.Getenv(sandboxEnv) == "1" {
+		if !landlocked() {
+			return fmt.Errorf("%s is set, but the node is not restricted by Landlock", sandboxEnv)
+		}
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot find the executable of the node: %s", err)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := setNoNewPrivs(); err != nil {
+		return err
+	}
+	if err := restrictFilesystem(append(sandboxSystemPaths, exe), readWrite); err != nil {
+		return err
+	}
+	env := append(os.Environ(), sandboxEnv+"=1")
+	if err := syscall.Exec(exe, os.Args, env); err != nil {
+		return fmt.Errorf("cannot start the node again inside the sandbox: %s", err)
+	}
+	return nil
+}
+
+// landlocked returns true if the process is restricted by a Landlock domain. The root directory is never allowed by
+// enterSandbox, so listing it is denied inside the sandbox.
+func landlocked() bool {
+	fd, err := syscall.Open("/", syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err == syscall.EACCES
+	}
+	syscall.Close(fd)
+	return false
+}
+
+// dropPrivileges switches the node to the user of the sandbox config and installs the seccomp filter if it is
+// enabled. The node calls it after binding its socket and loading its config. The files and directories of the node
+// in owned, which may have been created by root, are given to the user first. After switching, it checks that the user
+// can write them and the directories in dirs, where the node creates files, so the node fails when it starts instead of
+// when it saves a key share.
+func dropPrivileges(conf *config.SandboxConfig, owned, dirs []string) error {
+	if conf.User != "" {
+		uid, gid, err := lookupUser(conf.User)
+		if err != nil {
+			return err
+		}
+		for _, path := range owned {
+			if err := os.Chown(path, uid, gid); err != nil {
+				return fmt.Errorf("cannot give %s to user %s: %s", path, conf.User, err)
+			}
+		}
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("cannot set the groups of the node: %s", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("cannot switch to group %d: %s", gid, err)
+		}
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("cannot switch to user %s: %s", conf.User, err)
+		}
+		if uid != 0 && syscall.Setuid(0) == nil {
+			return fmt.Errorf("the node can still switch back to root")
+		}
+		for _, path := range append(owned, dirs...) {
+			if err := unix.Access(path, unix.W_OK); err != nil {
+				return fmt.Errorf("user %s cannot write %s, which the node writes: %s", conf.User, path, err)
+			}
+		}
+		log.Printf("Switched to user %s (uid %d, gid %d)", conf.User, uid, gid)
+	}
+	if conf.Seccomp {
+		if err := installSeccomp(); err != nil {
+			return err
+		}
+		log.Printf("Seccomp filter installed")
+	}
+	return nil
+}
+
+// lookupUser returns the uid and gid of a user.
+func lookupUser(name string) (uid, gid int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot find user %s: %s", name, err)
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, fmt.Errorf("invalid uid of user %s: %s", name, err)
+	}
+	if gid, err = strconv.Atoi(u.Gid); err != nil {
+		return 0, 0, fmt.Errorf("invalid gid of user %s: %s", name, err)
+	}
+	return uid, gid, nil
+}
+
+// matchDirOwner gives a file created by root to the owner of the directory provided. It does nothing if the process
+// is not root.
+func matchDirOwner(path, dir string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Chown(path, int(stat.Uid), int(stat.Gid))
+}
+
+// setNoNewPrivs sets no_new_privs in the current thread, which the threads and processes it creates inherit.
+func setNoNewPrivs() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("cannot set no_new_privs: %s", errno)
+	}
+	return nil
+}
+
+// restrictFilesystem creates a Landlock domain in the current thread that allows reading the first list of paths and
+// reading and writing the second one, and denies the rest of the filesystem.
+func restrictFilesystem(readOnly, readWrite []string) error {
+	abi, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("Landlock is not supported by the kernel: %s", errno)
+	}
+	if abi < 1 {
+		return fmt.Errorf("unknown Landlock ABI version %d", abi)
+	}
+	attr := landlockRulesetAttr{handledAccessFS: accessAll}
+	fd, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("cannot create the Landlock ruleset: %s", errno)
+	}
+	defer syscall.Close(int(fd))
+	for _, path := range readOnly {
+		if err := addLandlockRule(int(fd), path, accessRead); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+	}
+	for _, path := range readWrite {
+		if err := addLandlockRule(int(fd), path, accessReadWrite); err != nil {
+			return err
+		}
+	}
+	if _, _, errno := syscall.RawSyscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("cannot enforce the Landlock ruleset: %s", errno)
+	}
+	return nil
+}
+
+// addLandlockRule allows the access rights provided beneath a path. Files only keep the rights that apply to files.
+func addLandlockRule(rulesetFd int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer syscall.Close(fd)
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= accessFile
+	}
+	attr := landlockPathBeneathAttr{allowedAccess: access, parentFd: int32(fd)}
+	if _, _, errno := syscall.RawSyscall(sysLandlockAddRule, uintptr(rulesetFd), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return fmt.Errorf("cannot allow access to %s: %s", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/niclabs/dtcnode/v3/config"
+)
+
+// landlockTestEnv makes the test binary run TestLandlocked as the process that restricts itself.
+const landlockTestEnv = "DTCNODE_LANDLOCK_TEST"
+
+func TestLandlocked(t *testing.T) {
+	if os.Getenv(landlockTestEnv) == "1" {
+		landlockChild(t)
+		return
+	}
+	if landlocked() {
+		t.Fatalf("process without a Landlock domain reported as restricted")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLandlocked$", "-test.v")
+	cmd.Env = append(os.Environ(), landlockTestEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Landlock test process failed: %s\n%s", err, out)
+	}
+	t.Logf("%s", out)
+}
+
+// landlockChild restricts the current thread with Landlock and checks that it is reported as restricted.
+func landlockChild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtcnode-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runtime.LockOSThread()
+	if err := setNoNewPrivs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restrictFilesystem(sandboxSystemPaths, []string{dir}); err != nil {
+		t.Skipf("cannot create a Landlock domain: %s", err)
+	}
+	if !landlocked() {
+		t.Errorf("thread restricted by Landlock reported as not restricted")
+	}
+}
+
+func TestEnterSandboxRejectsRoot(t *testing.T) {
+	for _, dirs := range [][]string{{"/"}, {"/tmp", "/./"}} {
+		if err := enterSandbox(&config.SandboxConfig{}, dirs); err == nil {
+			t.Errorf("sandbox allowed to write %v", dirs)
+		}
+	}
+}
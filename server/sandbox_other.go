@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/niclabs/dtcnode/v3/config"
+)
+
+// enterSandbox is only supported on Linux.
+func enterSandbox(conf *config.SandboxConfig, dirs []string) error {
+	return fmt.Errorf("the sandbox is only supported on Linux")
+}
+
+// dropPrivileges is only supported on Linux.
+func dropPrivileges(conf *config.SandboxConfig, owned, dirs []string) error {
+	return fmt.Errorf("the sandbox is only supported on Linux")
+}
+
+// matchDirOwner does nothing, because the node only changes its user on Linux.
+func matchDirOwner(path, dir string) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Seccomp flags and return values.
+const (
+	seccompSetModeFilter    = 1
+	seccompFilterFlagTsync  = 1 << 0
+	seccompRetKillProcess   = 0x80000000
+	seccompRetErrno         = 0x00050000
+	seccompRetAllow         = 0x7fff0000
+	seccompDataNrOffset     = 0
+	seccompDataArchOffset   = 4
+	seccompMaxSyscallNumber = 0x40000000 // Numbers from here on belong to other ABIs, like x32 on amd64.
+)
+
+// installSeccomp installs a seccomp filter in every thread of the node. The filter denies the system calls in
+// deniedSyscalls with EPERM, and kills the node if it makes a system call of another architecture.
+//
+// The filter is a denylist. An allowlist would have to follow the system calls of the Go runtime, which change
+// between Go versions, and the ones of libzmq and libc, which depend on how they were built, and a missing one would
+// kill or break the node in the middle of a signature. The denylist only blocks the system calls that let a
+// compromised node run other programs, read other processes, change its users or namespaces, or reach the kernel
+// through module loading, BPF and similar interfaces, which the node never needs after it starts.
+func installSeccomp() error {
+	if len(deniedSyscalls) == 0 {
+		return fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+	filter := seccompFilter()
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	// The filter can only be installed by a thread with no_new_privs, which TSYNC copies to the other threads.
+	if err := setNoNewPrivs(); err != nil {
+		return err
+	}
+	tid, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("cannot install the seccomp filter: %s", errno)
+	}
+	if tid != 0 {
+		return fmt.Errorf("cannot install the seccomp filter in thread %d", tid)
+	}
+	return nil
+}
+
+// seccompFilter returns the BPF program of the seccomp filter.
+func seccompFilter() []syscall.SockFilter {
+	n := len(deniedSyscalls)
+	filter := []syscall.SockFilter{
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArchOffset),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, auditArch, 1, 0),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess),
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNrOffset),
+		// Jumps to the last instruction, which returns EPERM.
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, seccompMaxSyscallNumber, uint8(n+1), 0),
+	}
+	for i, nr := range deniedSyscalls {
+		filter = append(filter, bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, uint8(n-i), 0))
+	}
+	return append(filter,
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EPERM)),
+	)
+}
+
+func bpfStmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
@@ -0,0 +1,22 @@
+package server
+
+// auditArch is AUDIT_ARCH_X86_64.
+const auditArch = 0xc000003e
+
+const sysSeccomp = 317
+
+// deniedSyscalls are the system calls the seccomp filter denies. The node never needs them after it starts.
+var deniedSyscalls = []uint32{
+	59, 322, // execve, execveat
+	101, 310, 311, // ptrace, process_vm_readv, process_vm_writev
+	165, 166, 155, 161, 428, 429, 430, 431, 432, 433, 442, // mount, umount2, pivot_root, chroot, open_tree, move_mount, fsopen, fsconfig, fsmount, fspick, mount_setattr
+	105, 106, 113, 114, 116, 117, 119, 122, 123, // setuid, setgid, setreuid, setregid, setgroups, setresuid, setresgid, setfsuid, setfsgid
+	272, 308, // unshare, setns
+	246, 320, 175, 313, 176, 169, // kexec_load, kexec_file_load, init_module, finit_module, delete_module, reboot
+	167, 168, 163, 179, 153, // swapon, swapoff, acct, quotactl, vhangup
+	135, 172, 173, // personality, iopl, ioperm
+	248, 249, 250, // add_key, request_key, keyctl
+	298, 321, 323, // perf_event_open, bpf, userfaultfd
+	304,                          // open_by_handle_at
+	164, 227, 159, 305, 170, 171, // settimeofday, clock_settime, adjtimex, clock_adjtime, sethostname, setdomainname
+}
@@ -0,0 +1,22 @@
+package server
+
+// auditArch is AUDIT_ARCH_AARCH64.
+const auditArch = 0xc00000b7
+
+const sysSeccomp = 277
+
+// deniedSyscalls are the system calls the seccomp filter denies. The node never needs them after it starts.
+var deniedSyscalls = []uint32{
+	221, 281, // execve, execveat
+	117, 270, 271, // ptrace, process_vm_readv, process_vm_writev
+	40, 39, 41, 51, 428, 429, 430, 431, 432, 433, 442, // mount, umount2, pivot_root, chroot, open_tree, move_mount, fsopen, fsconfig, fsmount, fspick, mount_setattr
+	146, 144, 145, 143, 159, 147, 149, 151, 152, // setuid, setgid, setreuid, setregid, setgroups, setresuid, setresgid, setfsuid, setfsgid
+	97, 268, // unshare, setns
+	104, 294, 105, 273, 106, 142, // kexec_load, kexec_file_load, init_module, finit_module, delete_module, reboot
+	224, 225, 89, 60, 58, // swapon, swapoff, acct, quotactl, vhangup
+	92,            // personality
+	217, 218, 219, // add_key, request_key, keyctl
+	241, 280, 282, // perf_event_open, bpf, userfaultfd
+	265,                          // open_by_handle_at
+	170, 112, 171, 266, 161, 162, // settimeofday, clock_settime, adjtimex, clock_adjtime, sethostname, setdomainname
+}
@@ -0,0 +1,12 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package server
+
+const (
+	auditArch  = 0
+	sysSeccomp = 0
+)
+
+// deniedSyscalls is empty on the architectures without a seccomp filter.
+var deniedSyscalls []uint32
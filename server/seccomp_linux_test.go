@@ -0,0 +1,64 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+// seccompTestEnv makes the test binary run TestSeccompFilter as the process that installs the filter, because the
+// filter cannot be removed from the process that installs it.
+const seccompTestEnv = "DTCNODE_SECCOMP_TEST"
+
+func TestSeccompFilter(t *testing.T) {
+	if len(deniedSyscalls) == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	if os.Getenv(seccompTestEnv) == "1" {
+		seccompChild(t)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSeccompFilter$", "-test.v")
+	cmd.Env = append(os.Environ(), seccompTestEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("seccomp test process failed: %s\n%s", err, out)
+	}
+	t.Logf("%s", out)
+}
+
+// seccompChild installs the filter and checks that denied system calls fail with EPERM, and that other ones still work.
+func seccompChild(t *testing.T) {
+	if err := installSeccomp(); err != nil {
+		t.Skipf("cannot install the seccomp filter: %s", err)
+	}
+	denied := []struct {
+		name string
+		call func() error
+	}{
+		{"unshare", func() error {
+			if _, _, errno := syscall.RawSyscall(syscall.SYS_UNSHARE, 0, 0, 0); errno != 0 {
+				return errno
+			}
+			return nil
+		}},
+		{"setuid", func() error {
+			if _, _, errno := syscall.RawSyscall(syscall.SYS_SETUID, uintptr(os.Getuid()), 0, 0); errno != 0 {
+				return errno
+			}
+			return nil
+		}},
+		{"execve", func() error {
+			return syscall.Exec("/bin/true", []string{"true"}, nil)
+		}},
+	}
+	for _, d := range denied {
+		if err := d.call(); err != syscall.EPERM {
+			t.Errorf("%s returned %v instead of EPERM", d.name, err)
+		}
+	}
+	if _, err := os.Stat(os.Args[0]); err != nil {
+		t.Errorf("allowed system call failed: %s", err)
+	}
+}
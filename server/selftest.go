@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// selfTestDocument is the document signed by the self-test of the key shares that can sign alone.
+var selfTestDocument = []byte("dtcnode key share self-test")
+
+func init() {
+	registerHandler(message.GetHealth, 0, (*Client).dispatchHealth)   // {} -> health
+	registerHandler(message.RunSelfTest, 0, (*Client).dispatchHealth) // {} -> health
+}
+
+// selfTest tests the key shares of every scheme against their key metas, quarantines the ones that fail and saves
+// the result as the health of the client.
+func (client *Client) selfTest() *message.Health {
+	health := &message.Health{
+		TestedAt: time.Now().UTC(),
+		Keys:     make([]*message.KeyHealth, 0),
+	}
+	for _, alg := range algorithms {
+		health.Keys = append(health.Keys, client.keys[alg.name].selfTest()...)
+	}
+	sort.Slice(health.Keys, func(i, j int) bool {
+		if health.Keys[i].Algorithm != health.Keys[j].Algorithm {
+			return health.Keys[i].Algorithm < health.Keys[j].Algorithm
+		}
+		return health.Keys[i].ID < health.Keys[j].ID
+	})
+	for _, key := range health.Keys {
+		if key.Error != "" {
+			log.Printf("Self-test of %s key %s failed, the key share is quarantined: %s", key.Algorithm, key.ID, key.Error)
+		}
+	}
+	log.Printf("Self-test of client %s: %d key shares tested, %d quarantined", client.GetConnString(), len(health.Keys), health.Quarantined())
+	client.health = health
+	return health
+}
+
+// keyHealth returns the result of the self-test of a key share.
+func keyHealth(algorithm, id string, err error) *message.KeyHealth {
+	health := &message.KeyHealth{
+		Algorithm: algorithm,
+		ID:        id,
+	}
+	if err != nil {
+		health.Error = err.Error()
+	}
+	return health
+}
+
+// dispatchHealth answers the messages about the health of the key shares of the client.
+func (client *Client) dispatchHealth(msg *message.Message) *message.Message {
+	resp := msg.NewResponse(client.node.GetID(), message.Ok)
+	switch msg.Type {
+	case message.GetHealth:
+		log.Printf("Client %s is asking us for the health of its key shares", client.GetConnString())
+	case message.RunSelfTest:
+		log.Printf("Client %s is asking us to run the self-test of its key shares", client.GetConnString())
+		client.selfTest()
+	default:
+		log.Printf("invalid message received from client %s", client.GetConnString())
+		resp.Error = message.InvalidMessageError
+		return resp
+	}
+	encodedHealth, err := message.EncodeHealth(client.health)
+	if err != nil {
+		resp.Error = message.EncodingError
+		return resp
+	}
+	resp.AddMessage(encodedHealth)
+	return resp
+}
@@ -5,6 +5,9 @@ import (
 	"github.com/niclabs/dtcnode/v3/config"
 	"github.com/pebbe/zmq4"
 	"github.com/spf13/viper"
+	"log"
+	"os"
+	"path/filepath"
 )
 
 func Serve() error {
@@ -19,6 +22,18 @@ func Serve() error {
 	if conf.PublicKey == "" || conf.PrivateKey == "" || conf.Client == nil || conf.Port == 0 {
 		return fmt.Errorf("missing fields in conf file")
 	}
+	if conf.Sandbox != nil {
+		if err := enterSandbox(conf.Sandbox, nodeDirs(&conf, viper.ConfigFileUsed())); err != nil {
+			return fmt.Errorf("cannot start the sandbox: %s", err)
+		}
+		log.Printf("Sandbox: filesystem access restricted by Landlock")
+	}
+	if conf.Hardened {
+		if err := harden(); err != nil {
+			return fmt.Errorf("cannot start in hardened mode: %s", err)
+		}
+		log.Printf("Hardened mode: memory locked and core dumps disabled")
+	}
 
 	err = zmq4.AuthStart()
 	if err != nil {
@@ -30,6 +45,55 @@ func Serve() error {
 	if err != nil {
 		return fmt.Errorf("error initializing node: %s", err)
 	}
+	if conf.Sandbox != nil {
+		configFile := viper.ConfigFileUsed()
+		// The node creates files in these directories after switching users: the new config file, and the admin socket.
+		dirs := []string{filepath.Dir(configFile)}
+		if conf.AdminSocket != "" {
+			dirs = append(dirs, filepath.Dir(conf.AdminSocket))
+		}
+		if err := dropPrivileges(conf.Sandbox, nodeFiles(&conf, configFile), dirs); err != nil {
+			return fmt.Errorf("cannot drop privileges: %s", err)
+		}
+	}
+	if conf.AdminSocket != "" {
+		if err := n.ServeAdmin(conf.AdminSocket); err != nil {
+			return fmt.Errorf("cannot open admin socket: %s", err)
+		}
+	}
 	n.Listen()
 	return nil
 }
+
+// nodeFiles returns the files and directories that belong to the node and that it writes after switching to the user
+// of the sandbox: its config file, its audit log and the approval directory. The directories where they are, which may
+// be shared with other programs, are not included.
+func nodeFiles(conf *config.Config, configFile string) []string {
+	files := []string{configFile}
+	if conf.AuditLog != "" {
+		files = append(files, conf.AuditLog)
+	}
+	if dir := ApprovalDir(conf, configFile); dir != "" {
+		files = append(files, dir)
+	}
+	return files
+}
+
+// nodeDirs returns the directories the node writes: the ones of its config file, its audit log and its admin socket,
+// and the approval directory, which is created if it does not exist.
+func nodeDirs(conf *config.Config, configFile string) []string {
+	dirs := []string{filepath.Dir(configFile)}
+	if conf.AuditLog != "" {
+		dirs = append(dirs, filepath.Dir(conf.AuditLog))
+	}
+	if conf.AdminSocket != "" {
+		dirs = append(dirs, filepath.Dir(conf.AdminSocket))
+	}
+	if dir := ApprovalDir(conf, configFile); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Printf("cannot create approval directory: %s", err)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+// usage represents the signature counters of a key share, and the limits that stop it from signing. Each node
+// enforces the limits of its own key share.
+type usage struct {
+	maxSignatures        uint64    // Maximum number of signatures. Zero means no limit.
+	maxSignaturesPerHour uint64    // Maximum number of signatures per clock hour (UTC). Zero means no limit.
+	signatures           uint64    // Signatures made with the key share.
+	hour                 time.Time // Start of the hour of hourSignatures.
+	hourSignatures       uint64    // Signatures made in the hour that starts at hour.
+	lastUsed             time.Time // Time of the last signature. Zero if the key share never signed.
+}
+
+// KeyInfo describes a key share of the node in the key inventory.
+type KeyInfo struct {
+	Algorithm            string
+	ID                   string
+	State                message.KeyState
+	Epoch                uint64    // Number of refreshes applied to the key share. Always zero for EdDSA keys.
+	Quarantine           string    // Reason why the key share failed the self-test. Empty if it passed.
+	Signatures           uint64    // Signatures made with the key share.
+	HourSignatures       uint64    // Signatures made in the current clock hour.
+	MaxSignatures        uint64    // Maximum number of signatures. Zero means no limit.
+	MaxSignaturesPerHour uint64    // Maximum number of signatures per clock hour. Zero means no limit.
+	LastUsed             time.Time // Time of the last signature. Zero if the key share never signed.
+}
+
+// parseUsage reads the signature counters and limits of a key share from the config file.
+func parseUsage(conf config.UsageConfig) (usage, error) {
+	u := usage{
+		maxSignatures:        conf.MaxSignatures,
+		maxSignaturesPerHour: conf.MaxSignaturesPerHour,
+		signatures:           conf.Signatures,
+		hourSignatures:       conf.HourSignatures,
+	}
+	var err error
+	if conf.Hour != "" {
+		if u.hour, err = time.Parse(time.RFC3339, conf.Hour); err != nil {
+			return u, fmt.Errorf("invalid usage hour: %s", err)
+		}
+	}
+	if conf.LastUsed != "" {
+		if u.lastUsed, err = time.Parse(time.RFC3339, conf.LastUsed); err != nil {
+			return u, fmt.Errorf("invalid last use time: %s", err)
+		}
+	}
+	return u, nil
+}
+
+// encode returns the signature counters and limits of a key share as they are saved in the config file.
+func (u *usage) encode() config.UsageConfig {
+	conf := config.UsageConfig{
+		MaxSignatures:        u.maxSignatures,
+		MaxSignaturesPerHour: u.maxSignaturesPerHour,
+		Signatures:           u.signatures,
+		HourSignatures:       u.hourSignatures,
+	}
+	if !u.hour.IsZero() {
+		conf.Hour = u.hour.Format(time.RFC3339)
+	}
+	if !u.lastUsed.IsZero() {
+		conf.LastUsed = u.lastUsed.UTC().Format(time.RFC3339)
+	}
+	return conf
+}
+
+// limited returns true if the key share has a signature limit.
+func (u *usage) limited() bool {
+	return u.maxSignatures > 0 || u.maxSignaturesPerHour > 0
+}
+
+// signaturesInHour returns the number of signatures made in the clock hour of the time provided.
+func (u *usage) signaturesInHour(now time.Time) uint64 {
+	if !u.hour.Equal(now.UTC().Truncate(time.Hour)) {
+		return 0
+	}
+	return u.hourSignatures
+}
+
+// remaining returns the number of signatures the key share can make at the time provided before reaching a limit.
+func (u *usage) remaining(now time.Time) uint64 {
+	remaining := uint64(math.MaxUint64)
+	if u.maxSignatures > 0 {
+		if u.signatures >= u.maxSignatures {
+			return 0
+		}
+		remaining = u.maxSignatures - u.signatures
+	}
+	if u.maxSignaturesPerHour > 0 {
+		inHour := u.signaturesInHour(now)
+		if inHour >= u.maxSignaturesPerHour {
+			return 0
+		}
+		if u.maxSignaturesPerHour-inHour < remaining {
+			remaining = u.maxSignaturesPerHour - inHour
+		}
+	}
+	return remaining
+}
+
+// count adds signatures made at the time provided to the counters.
+func (u *usage) count(n uint64, now time.Time) {
+	hour := now.UTC().Truncate(time.Hour)
+	if !u.hour.Equal(hour) {
+		u.hour = hour
+		u.hourSignatures = 0
+	}
+	u.signatures += n
+	u.hourSignatures += n
+	u.lastUsed = now
+}
+
+// fill copies the counters and limits into the inventory entry of the key share.
+func (u *usage) fill(info *KeyInfo, now time.Time) {
+	info.Signatures = u.signatures
+	info.HourSignatures = u.signaturesInHour(now)
+	info.MaxSignatures = u.maxSignatures
+	info.MaxSignaturesPerHour = u.maxSignaturesPerHour
+	info.LastUsed = u.lastUsed
+}
+
+// canSign returns false and logs the reason if the key share reached a signature limit.
+func (client *Client) canSign(keyID string, u *usage) bool {
+	if u.remaining(time.Now()) == 0 {
+		log.Printf("Key %s of client %s reached its signature limit: %d signatures, %d in this hour", keyID, client.GetConnString(), u.signatures, u.signaturesInHour(time.Now()))
+		return false
+	}
+	return true
+}
+
+// countSignatures adds signatures to the counters of a key share. If the key share has limits, the config file is
+// saved at once, so a restart does not reset them, and it returns an error if it cannot be saved: the caller must not
+// send the signature shares then. The counters of the other key shares are saved with the next change of the config
+// file.
+func (client *Client) countSignatures(u *usage, n uint64) error {
+	if n == 0 {
+		return nil
+	}
+	u.count(n, time.Now())
+	if !u.limited() {
+		return nil
+	}
+	if err := client.node.SaveConfigKeys(); err != nil {
+		log.Printf("Error saving the signature counters: %s", err)
+		return err
+	}
+	return nil
+}
+
+// Inventory returns the key shares in the config of a client, sorted by algorithm and key ID. It is used to list them
+// while the node is not running.
+func Inventory(conf *config.ClientConfig) ([]*KeyInfo, error) {
+	stores := make(map[string]keyStore)
+	for _, alg := range algorithms {
+		store, err := alg.load(conf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load %s keys: %s", alg.name, err)
+		}
+		stores[alg.name] = store
+	}
+	return inventory(stores), nil
+}
+
+// inventory returns the key shares of the stores provided, sorted by algorithm and key ID.
+func inventory(stores map[string]keyStore) []*KeyInfo {
+	now := time.Now()
+	keys := make([]*KeyInfo, 0)
+	for _, alg := range algorithms {
+		keys = append(keys, stores[alg.name].inventory(now)...)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Algorithm != keys[j].Algorithm {
+			return keys[i].Algorithm < keys[j].Algorithm
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// keyInfo returns the inventory entry of a key share.
+func keyInfo(algorithm, id string, l *lifecycle, u *usage, quarantine error, now time.Time) *KeyInfo {
+	info := &KeyInfo{
+		Algorithm: algorithm,
+		ID:        id,
+		State:     l.current(),
+	}
+	if quarantine != nil {
+		info.Quarantine = quarantine.Error()
+	}
+	u.fill(info, now)
+	return info
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/config"
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+func TestUsageRemaining(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)
+	hour := now.Truncate(time.Hour)
+	tests := []struct {
+		name      string
+		u         usage
+		remaining uint64
+	}{
+		{"no limits", usage{signatures: 100}, ^uint64(0)},
+		{"under the total limit", usage{maxSignatures: 10, signatures: 7}, 3},
+		{"total limit reached", usage{maxSignatures: 10, signatures: 10}, 0},
+		{"under the hourly limit", usage{maxSignaturesPerHour: 5, hour: hour, hourSignatures: 4}, 1},
+		{"hourly limit reached", usage{maxSignaturesPerHour: 5, hour: hour, hourSignatures: 5}, 0},
+		{"hourly limit reached in another hour", usage{maxSignaturesPerHour: 5, hour: hour.Add(-time.Hour), hourSignatures: 5}, 5},
+		{"lowest of both limits", usage{maxSignatures: 10, signatures: 8, maxSignaturesPerHour: 5}, 2},
+	}
+	for _, test := range tests {
+		if remaining := test.u.remaining(now); remaining != test.remaining {
+			t.Errorf("%s: expected %d remaining signatures, got %d", test.name, test.remaining, remaining)
+		}
+	}
+}
+
+func TestUsageCount(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)
+	u := usage{maxSignaturesPerHour: 5}
+	u.count(3, now)
+	u.count(1, now.Add(10*time.Minute))
+	if u.signatures != 4 || u.signaturesInHour(now) != 4 {
+		t.Errorf("expected 4 signatures in the hour, got %d of %d", u.signaturesInHour(now), u.signatures)
+	}
+	u.count(2, now.Add(time.Hour))
+	if u.signatures != 6 || u.signaturesInHour(now.Add(time.Hour)) != 2 {
+		t.Errorf("expected 2 signatures in the next hour, got %d of %d", u.signaturesInHour(now.Add(time.Hour)), u.signatures)
+	}
+	if !u.lastUsed.Equal(now.Add(time.Hour)) {
+		t.Errorf("last use time not updated")
+	}
+}
+
+func TestRSASignatureLimits(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	hash := sha256.Sum256([]byte("document"))
+	ciphertext := make([]byte, meta.PublicKey.Size())
+	ciphertext[len(ciphertext)-1] = 2
+	sign := []message.Type{message.GetRSASigShare}
+	decrypt := []message.Type{message.GetRSADecryptShare}
+	tests := []struct {
+		name      string
+		first     []message.Type
+		second    []message.Type
+		saveFails bool
+		expected  message.NodeError
+	}{
+		{"signature over the limit", sign, sign, false, message.SignatureLimitError},
+		{"decryption after a signature", sign, decrypt, false, message.SignatureLimitError},
+		{"signature after a decryption", decrypt, sign, false, message.SignatureLimitError},
+		{"counters not saved", nil, sign, true, message.InternalError},
+		{"counters not saved on decryption", nil, decrypt, true, message.InternalError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			client := node.client
+			var err error
+			if client.policies, err = parsePolicies([]*config.PolicyConfig{{Key: "k", Operations: []string{"sign", "decrypt"}}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.SaveRSAKey("k", copyRSAKeyShare(shares[0]), meta); err != nil {
+				t.Fatal(err)
+			}
+			client.rsa().keys["k"].maxSignatures = 1
+			request := func(mType message.Type) *message.Message {
+				if mType == message.GetRSADecryptShare {
+					return node.request(mType, []byte("k"), ciphertext)
+				}
+				return node.request(mType, []byte("k"), hash[:], message.RSAPKCS1v15.Bytes())
+			}
+			for _, mType := range test.first {
+				if resp := request(mType); resp.Error != message.Ok {
+					t.Fatalf("%s failed: %s", mType, resp.Error)
+				}
+			}
+			if test.saveFails {
+				node.breakConfig(t)
+			}
+			for _, mType := range test.second {
+				resp := request(mType)
+				if resp.Error != test.expected {
+					t.Errorf("%s answered %s instead of %s", mType, resp.Error, test.expected)
+				}
+				if len(resp.Data) != 0 {
+					t.Errorf("%s answered a share with error %s", mType, resp.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verifyMode defines which of the sig shares created with a key are verified by the node before sending them.
+type verifyMode string
+
+// The following consts represent the verification modes of a key.
+const (
+	verifyAlways verifyMode = "always" // Verify every sig share. It is the default mode.
+	verifyNever  verifyMode = "never"  // Do not verify sig shares.
+	verifySample verifyMode = "sample" // Verify one of every N sig shares.
+)
+
+// verifier decides which sig shares of a key are verified locally, and measures the time spent verifying them.
+// It is safe for concurrent use, because batch sig shares are created in parallel.
+type verifier struct {
+	mode       verifyMode
+	sampleRate int // With verifySample, one of every sampleRate sig shares is verified.
+	mutex      sync.Mutex
+	created    uint64        // Number of sig shares created.
+	verified   uint64        // Number of sig shares verified.
+	failed     uint64        // Number of sig shares that did not verify.
+	total      time.Duration // Time spent verifying sig shares.
+	max        time.Duration // Longest verification time.
+}
+
+// newVerifier returns a verifier with the mode and sample rate provided. An empty mode means verifyAlways.
+func newVerifier(mode string, sampleRate int) (*verifier, error) {
+	v := &verifier{
+		mode:       verifyMode(strings.ToLower(mode)),
+		sampleRate: sampleRate,
+	}
+	switch v.mode {
+	case "":
+		v.mode = verifyAlways
+	case verifyAlways, verifyNever:
+	case verifySample:
+		if sampleRate < 1 {
+			return nil, fmt.Errorf("sample rate should be at least 1, but it is %d", sampleRate)
+		}
+	default:
+		return nil, fmt.Errorf("unknown verify mode %s", mode)
+	}
+	return v, nil
+}
+
+// next counts a new sig share, and returns true if it should be verified.
+func (v *verifier) next() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.created++
+	switch v.mode {
+	case verifyNever:
+		return false
+	case verifySample:
+		return (v.created-1)%uint64(v.sampleRate) == 0
+	default:
+		return true
+	}
+}
+
+// record saves the time a verification took, and whether it failed.
+func (v *verifier) record(elapsed time.Duration, err error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.verified++
+	if err != nil {
+		v.failed++
+	}
+	v.total += elapsed
+	if elapsed > v.max {
+		v.max = elapsed
+	}
+}
+
+// verify calls the verification function if the sig share should be verified, and records the time it takes.
+func (v *verifier) verify(verifyFn func() error) error {
+	if !v.next() {
+		return nil
+	}
+	start := time.Now()
+	err := verifyFn()
+	v.record(time.Since(start), err)
+	return err
+}
+
+// VerifyMetrics are the verification metrics of the sig shares created with a key since the node started.
+type VerifyMetrics struct {
+	Key      string
+	Mode     string
+	Created  uint64        // Sig shares created.
+	Verified uint64        // Sig shares verified.
+	Failed   uint64        // Sig shares that did not verify.
+	Total    time.Duration // Time spent verifying sig shares.
+	Average  time.Duration // Average verification time.
+	Max      time.Duration // Longest verification time.
+}
+
+// metrics returns the verification metrics of the key with the ID provided.
+func (v *verifier) metrics(keyID string) *VerifyMetrics {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	m := &VerifyMetrics{
+		Key:      keyID,
+		Mode:     string(v.mode),
+		Created:  v.created,
+		Verified: v.verified,
+		Failed:   v.failed,
+		Total:    v.total,
+		Max:      v.max,
+	}
+	if v.verified > 0 {
+		m.Average = v.total / time.Duration(v.verified)
+	}
+	return m
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewVerifier(t *testing.T) {
+	tests := []struct {
+		mode       string
+		sampleRate int
+		expected   verifyMode
+		valid      bool
+	}{
+		{"", 0, verifyAlways, true},
+		{"Always", 0, verifyAlways, true},
+		{"never", 0, verifyNever, true},
+		{"sample", 10, verifySample, true},
+		{"sample", 0, "", false},
+		{"sometimes", 0, "", false},
+	}
+	for _, test := range tests {
+		v, err := newVerifier(test.mode, test.sampleRate)
+		if (err == nil) != test.valid {
+			t.Errorf("mode %q: expected valid %v, got error %v", test.mode, test.valid, err)
+			continue
+		}
+		if err == nil && v.mode != test.expected {
+			t.Errorf("mode %q: expected %s, got %s", test.mode, test.expected, v.mode)
+		}
+	}
+}
+
+func TestVerifierMetrics(t *testing.T) {
+	tests := []struct {
+		mode       string
+		sampleRate int
+		shares     int
+		verified   uint64
+	}{
+		{"always", 0, 10, 10},
+		{"never", 0, 10, 0},
+		{"sample", 3, 10, 4},
+		{"sample", 1, 10, 10},
+	}
+	for _, test := range tests {
+		v, err := newVerifier(test.mode, test.sampleRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := uint64(0)
+		for i := 0; i < test.shares; i++ {
+			err := v.verify(func() error {
+				calls++
+				if calls == 1 {
+					return errors.New("invalid sig share")
+				}
+				return nil
+			})
+			if err != nil && calls != 1 {
+				t.Errorf("%s: unexpected error %s", test.mode, err)
+			}
+		}
+		m := v.metrics("k")
+		if m.Key != "k" || m.Mode != test.mode || m.Created != uint64(test.shares) || m.Verified != test.verified || calls != test.verified {
+			t.Errorf("%s: unexpected metrics %+v after %d verifications", test.mode, m, calls)
+		}
+		// Only the first verification fails.
+		failed := uint64(0)
+		if test.verified > 0 {
+			failed = 1
+		}
+		if m.Failed != failed {
+			t.Errorf("%s: expected %d failed, got %d", test.mode, failed, m.Failed)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/niclabs/dtcnode/v3/message"
+	"github.com/spf13/viper"
+)
+
+// wipePrefix is the prefix of the hard link to the previous config file, kept until it is overwritten with zeros.
+const wipePrefix = ".wipe-"
+
+// wipeBytes overwrites a byte slice with zeros.
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// wipeInt overwrites the words of an integer with zeros and sets it to zero.
+func wipeInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+	words := x.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	x.SetInt64(0)
+}
+
+// deleteKey removes a key from the store of its scheme and saves the config file, overwriting the previous one on
+// disk. Its key shares are overwritten in memory with zeros only after the config file is saved: if it cannot be
+// saved, the key is put back in the store, and the deletion is not recorded in the audit log.
+func (client *Client) deleteKey(algName, id string) (*message.Wipe, error) {
+	d := client.keys[algName].delete(id)
+	if d == nil {
+		return &message.Wipe{}, client.node.SaveConfigKeys()
+	}
+	disk, err := client.node.WipeConfigKeys()
+	if err != nil {
+		d.restore()
+		return nil, err
+	}
+	d.zero()
+	d.wipe.Disk = disk
+	client.audit("delete", algName, id, d.wipe.String())
+	return d.wipe, nil
+}
+
+// WipeConfigKeys saves the keys like SaveConfigKeys, and then overwrites the previous config file with zeros, so the
+// key shares removed from it cannot be read from the disk blocks it used. It returns false if the previous file
+// could not be overwritten, which is logged but does not make the save fail.
+func (node *Node) WipeConfigKeys() (bool, error) {
+	return node.saveConfigKeys(true)
+}
+
+// writeConfigWiping writes the config of a viper instance like WriteConfig. The previous config file is kept as a hard
+// link until the new one replaces it, and then it is overwritten with zeros and removed. It returns false if the
+// previous file could not be overwritten.
+func writeConfigWiping(v *viper.Viper) (bool, error) {
+	path := v.ConfigFileUsed()
+	wipePath, err := linkForWipe(path)
+	if err != nil {
+		log.Printf("cannot keep the previous config file to overwrite it: %s", err)
+	}
+	if err := WriteConfig(v); err != nil {
+		if wipePath != "" {
+			os.Remove(wipePath)
+		}
+		return false, err
+	}
+	if wipePath == "" {
+		return false, nil
+	}
+	if err := wipeFile(wipePath); err != nil {
+		log.Printf("cannot overwrite the previous config file: %s", err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// linkForWipe creates a hard link to the config file, so its content can be overwritten after a new config file
+// replaces it. A link left by a previous failed wipe is overwritten first.
+func linkForWipe(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("the config file is unknown")
+	}
+	wipePath := filepath.Join(filepath.Dir(path), wipePrefix+filepath.Base(path))
+	if err := wipeLeftover(path); err != nil {
+		return "", err
+	}
+	if err := os.Link(path, wipePath); err != nil {
+		return "", err
+	}
+	return wipePath, nil
+}
+
+// wipeLeftover overwrites and removes the previous config file left by a wipe that did not finish, if there is one.
+func wipeLeftover(path string) error {
+	wipePath := filepath.Join(filepath.Dir(path), wipePrefix+filepath.Base(path))
+	if _, err := os.Stat(wipePath); os.IsNotExist(err) {
+		return nil
+	}
+	log.Printf("Overwriting the previous config file left by an unfinished wipe: %s", wipePath)
+	return wipeFile(wipePath)
+}
+
+// wipeFile overwrites the content of a file with zeros, flushes it to disk and removes it.
+func wipeFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	zeros := make([]byte, 4096)
+	for left := info.Size(); left > 0; {
+		n := int64(len(zeros))
+		if left < n {
+			n = left
+		}
+		if _, err := file.Write(zeros[:n]); err != nil {
+			file.Close()
+			return err
+		}
+		left -= n
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/niclabs/dtcnode/v3/message"
+)
+
+func TestDeleteKey(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	tests := []struct {
+		name      string
+		saveFails bool
+	}{
+		{"saved", false},
+		{"save fails", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			client := node.client
+			share := copyRSAKeyShare(shares[0])
+			if err := client.SaveRSAKey("k", share, meta); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.ReplaceRSAKey("k", copyRSAKeyShare(shares[1]), meta); err != nil {
+				t.Fatal(err)
+			}
+			share = client.rsa().keys["k"].Share
+			si := append([]byte(nil), share.Si...)
+			if test.saveFails {
+				node.breakConfig(t)
+			}
+			wipe, err := client.DeleteRSAKey("k")
+			if test.saveFails {
+				if err == nil {
+					t.Fatal("delete succeeded without saving the config")
+				}
+				if client.rsa().keys["k"] == nil || len(client.rsa().archived) != 1 {
+					t.Errorf("key not restored after the save failed")
+				}
+				if !bytes.Equal(share.Si, si) {
+					t.Errorf("key share wiped after the save failed")
+				}
+				if node.audit.Len() != 0 {
+					t.Errorf("deletion recorded in the audit log after the save failed: %s", node.audit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wipe.Shares != 1 || wipe.Archived != 1 || !wipe.Disk {
+				t.Errorf("unexpected wipe: %s", wipe)
+			}
+			if client.rsa().keys["k"] != nil || len(client.rsa().archived) != 0 {
+				t.Errorf("key not deleted")
+			}
+			if !bytes.Equal(share.Si, make([]byte, len(share.Si))) {
+				t.Errorf("key share not wiped")
+			}
+			if !strings.Contains(node.audit.String(), `"delete"`) {
+				t.Errorf("deletion not recorded in the audit log")
+			}
+		})
+	}
+}
+
+func TestDeleteMissingKey(t *testing.T) {
+	node := newTestNode(t)
+	defer node.close()
+	wipe, err := node.client.DeleteRSAKey("missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *wipe != (message.Wipe{}) || node.audit.Len() != 0 {
+		t.Errorf("missing key reported as wiped: %s", wipe)
+	}
+}
+
+func TestPurgeKeys(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	now := time.Now()
+	tests := []struct {
+		name      string
+		saveFails bool
+	}{
+		{"saved", false},
+		{"save fails", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t)
+			defer node.close()
+			client := node.client
+			for _, id := range []string{"expired", "kept"} {
+				if err := client.SaveRSAKey(id, copyRSAKeyShare(shares[0]), meta); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := client.rsa().keys["expired"].moveTo(message.KeyPendingDeletion, time.Hour, now); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.rsa().keys["kept"].moveTo(message.KeyPendingDeletion, 2*time.Hour, now); err != nil {
+				t.Fatal(err)
+			}
+			share := client.rsa().keys["expired"].Share
+			if test.saveFails {
+				node.breakConfig(t)
+			}
+			client.purgeKeys(now.Add(90 * time.Minute))
+			if client.rsa().keys["kept"] == nil {
+				t.Errorf("key deleted before its retention period ended")
+			}
+			if test.saveFails {
+				if client.rsa().keys["expired"] == nil || !bytes.Equal(share.Si, shares[0].Si) || node.audit.Len() != 0 {
+					t.Errorf("expired key purged without saving the config")
+				}
+				return
+			}
+			if client.rsa().keys["expired"] != nil || !bytes.Equal(share.Si, make([]byte, len(share.Si))) {
+				t.Errorf("expired key not purged")
+			}
+			if !strings.Contains(node.audit.String(), `"purge"`) {
+				t.Errorf("purge not recorded in the audit log")
+			}
+		})
+	}
+}
+
+func TestWipeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtcnode-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// A second hard link keeps the data of the file readable after wipeFile removes it.
+	link := filepath.Join(dir, "link")
+	if err := os.Link(path, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := wipeFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("wiped file not removed")
+	}
+	content, err := ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, make([]byte, len("secret"))) {
+		t.Errorf("file not overwritten with zeros: %q", content)
+	}
+}
+
+func TestPurgeEvery(t *testing.T) {
+	shares, meta := testRSAKey(t)
+	node := newTestNode(t)
+	defer node.close()
+	client := node.client
+	if err := client.SaveRSAKey("k", copyRSAKeyShare(shares[0]), meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.rsa().keys["k"].moveTo(message.KeyPendingDeletion, time.Millisecond, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go client.purgeEvery(10*time.Millisecond, stop)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		client.mutex.Lock()
+		_, ok := client.rsa().keys["k"]
+		client.mutex.Unlock()
+		if !ok {
+			return
+		}
+	}
+	t.Errorf("key not purged without messages after its retention period ended")
+}
@@ -0,0 +1,395 @@
+// Package tceddsa implements threshold Ed25519 signatures. The signatures it creates verify with crypto/ed25519.
+//
+// Key shares are created by a trusted dealer, splitting the secret scalar of the key with Shamir secret sharing.
+// Signatures are created with a two round protocol based on FROST, coordinated by the client: in the first round each
+// signer creates a pair of one-time nonces and sends their commitments, and in the second round each signer receives
+// the message and the commitments of all the signers, and returns its signature share.
+package tceddsa
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"math/big"
+
+	"filippo.io/edwards25519"
+)
+
+// CurveName is the name of the only curve supported by this package.
+const CurveName = "Ed25519"
+
+// order is the order of the prime subgroup of Ed25519.
+var order, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+
+// KeyMeta represents the public information of a threshold key.
+type KeyMeta struct {
+	PublicKey        []byte   // Ed25519 public key.
+	K                uint8    // Number of signers needed to create a signature.
+	L                uint8    // Number of key shares.
+	VerificationKeys [][]byte // Public keys of the key shares, ordered by index, used to verify signature shares.
+}
+
+// KeyShare represents the share of the secret key a signer has.
+type KeyShare struct {
+	Index  uint8  // Index of the share, between 1 and L.
+	Secret []byte // Share of the secret scalar.
+}
+
+// NewKey creates a key with l shares, where k of them are needed to sign.
+func NewKey(l, k uint8) ([]*KeyShare, *KeyMeta, error) {
+	if k == 0 || k > l {
+		return nil, nil, fmt.Errorf("threshold should be between 1 and %d, but it is %d", l, k)
+	}
+	coefficients := make([]*edwards25519.Scalar, k)
+	for i := range coefficients {
+		c, err := randomScalar()
+		if err != nil {
+			return nil, nil, err
+		}
+		coefficients[i] = c
+	}
+	meta := &KeyMeta{
+		PublicKey:        edwards25519.NewIdentityPoint().ScalarBaseMult(coefficients[0]).Bytes(),
+		K:                k,
+		L:                l,
+		VerificationKeys: make([][]byte, l),
+	}
+	shares := make([]*KeyShare, l)
+	for i := range shares {
+		x := indexScalar(uint8(i + 1))
+		// Horner evaluation of the polynomial on x.
+		y := edwards25519.NewScalar()
+		for j := len(coefficients) - 1; j >= 0; j-- {
+			y.MultiplyAdd(y, x, coefficients[j])
+		}
+		shares[i] = &KeyShare{
+			Index:  uint8(i + 1),
+			Secret: y.Bytes(),
+		}
+		meta.VerificationKeys[i] = edwards25519.NewIdentityPoint().ScalarBaseMult(y).Bytes()
+	}
+	return shares, meta, nil
+}
+
+// Verify returns an error if the key share does not match its verification key in the key meta.
+func (share *KeyShare) Verify(meta *KeyMeta) error {
+	if meta.K == 0 || meta.K > meta.L || len(meta.VerificationKeys) != int(meta.L) {
+		return fmt.Errorf("invalid key meta")
+	}
+	if share.Index == 0 || share.Index > meta.L {
+		return fmt.Errorf("invalid key share index %d", share.Index)
+	}
+	secret, err := edwards25519.NewScalar().SetCanonicalBytes(share.Secret)
+	if err != nil {
+		return fmt.Errorf("invalid key share: %s", err)
+	}
+	if string(edwards25519.NewIdentityPoint().ScalarBaseMult(secret).Bytes()) != string(meta.VerificationKeys[share.Index-1]) {
+		return fmt.Errorf("key share does not match its verification key")
+	}
+	return nil
+}
+
+// Commitment represents the commitments of the nonces of a signer, sent in the first round.
+type Commitment struct {
+	Index uint8  // Index of the key share of the signer.
+	D     []byte // Commitment of the hiding nonce.
+	E     []byte // Commitment of the binding nonce.
+}
+
+// CommitmentList represents the commitments of all the signers, ordered by index.
+type CommitmentList []*Commitment
+
+// SigShare represents the signature share of a signer, sent in the second round.
+type SigShare struct {
+	Index uint8  // Index of the key share of the signer.
+	Z     []byte // Signature share.
+}
+
+// SigShareList represents the signature shares of all the signers.
+type SigShareList []*SigShare
+
+// SigSession represents the state of a signer between the two rounds. It can sign only one message, because signing
+// two messages with the same nonces reveals the key share.
+type SigSession struct {
+	share      *KeyShare
+	meta       *KeyMeta
+	d, e       *edwards25519.Scalar
+	commitment *Commitment
+}
+
+// NewSigSession creates the one-time nonces of a signing session and returns their commitments.
+func (share *KeyShare) NewSigSession(meta *KeyMeta) (*SigSession, *Commitment, error) {
+	if share.Index == 0 || share.Index > meta.L {
+		return nil, nil, fmt.Errorf("invalid key share index %d", share.Index)
+	}
+	d, err := randomScalar()
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := randomScalar()
+	if err != nil {
+		return nil, nil, err
+	}
+	commitment := &Commitment{
+		Index: share.Index,
+		D:     edwards25519.NewIdentityPoint().ScalarBaseMult(d).Bytes(),
+		E:     edwards25519.NewIdentityPoint().ScalarBaseMult(e).Bytes(),
+	}
+	return &SigSession{
+		share:      share,
+		meta:       meta,
+		d:          d,
+		e:          e,
+		commitment: commitment,
+	}, commitment, nil
+}
+
+// Sign returns the signature share of the message, using the commitments of all the signers. The nonces of the
+// session are erased before returning, so a session can sign only once.
+func (session *SigSession) Sign(msg []byte, commitments CommitmentList) (*SigShare, error) {
+	if session.d == nil {
+		return nil, fmt.Errorf("session was already used")
+	}
+	d, e := session.d, session.e
+	session.d, session.e = nil, nil
+	own := commitments.find(session.share.Index)
+	if own == nil || string(own.D) != string(session.commitment.D) || string(own.E) != string(session.commitment.E) {
+		return nil, fmt.Errorf("commitment list does not include the commitment of this session")
+	}
+	r, rhos, err := groupCommitment(msg, commitments, session.meta)
+	if err != nil {
+		return nil, err
+	}
+	c, err := challenge(r, session.meta.PublicKey, msg)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := edwards25519.NewScalar().SetCanonicalBytes(session.share.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key share: %s", err)
+	}
+	lambda, err := lagrange(session.share.Index, commitments)
+	if err != nil {
+		return nil, err
+	}
+	// z = d + e * rho + lambda * secret * c
+	z := edwards25519.NewScalar().MultiplyAdd(e, rhos[session.share.Index], d)
+	z.MultiplyAdd(edwards25519.NewScalar().Multiply(lambda, secret), c, z)
+	return &SigShare{
+		Index: session.share.Index,
+		Z:     z.Bytes(),
+	}, nil
+}
+
+// Verify returns an error if the signature share was not created for the message and commitments provided using the
+// key share with its index.
+func (sigShare *SigShare) Verify(msg []byte, commitments CommitmentList, meta *KeyMeta) error {
+	if sigShare.Index == 0 || sigShare.Index > meta.L {
+		return fmt.Errorf("invalid signature share index %d", sigShare.Index)
+	}
+	commitment := commitments.find(sigShare.Index)
+	if commitment == nil {
+		return fmt.Errorf("there is no commitment for signature share %d", sigShare.Index)
+	}
+	z, err := edwards25519.NewScalar().SetCanonicalBytes(sigShare.Z)
+	if err != nil {
+		return fmt.Errorf("invalid signature share: %s", err)
+	}
+	r, rhos, err := groupCommitment(msg, commitments, meta)
+	if err != nil {
+		return err
+	}
+	c, err := challenge(r, meta.PublicKey, msg)
+	if err != nil {
+		return err
+	}
+	lambda, err := lagrange(sigShare.Index, commitments)
+	if err != nil {
+		return err
+	}
+	d, e, err := commitment.points()
+	if err != nil {
+		return err
+	}
+	y, err := edwards25519.NewIdentityPoint().SetBytes(meta.VerificationKeys[sigShare.Index-1])
+	if err != nil {
+		return err
+	}
+	// z * B == D + rho * E + lambda * c * Y
+	expected := edwards25519.NewIdentityPoint().ScalarMult(rhos[sigShare.Index], e)
+	expected.Add(expected, d)
+	expected.Add(expected, edwards25519.NewIdentityPoint().ScalarMult(edwards25519.NewScalar().Multiply(lambda, c), y))
+	if edwards25519.NewIdentityPoint().ScalarBaseMult(z).Equal(expected) != 1 {
+		return fmt.Errorf("signature share %d does not verify", sigShare.Index)
+	}
+	return nil
+}
+
+// Join combines the signature shares of all the signers of the commitment list into an Ed25519 signature.
+func (sigShares SigShareList) Join(msg []byte, commitments CommitmentList, meta *KeyMeta) ([]byte, error) {
+	if len(sigShares) != len(commitments) {
+		return nil, fmt.Errorf("there are %d signature shares, but %d signers", len(sigShares), len(commitments))
+	}
+	r, _, err := groupCommitment(msg, commitments, meta)
+	if err != nil {
+		return nil, err
+	}
+	z := edwards25519.NewScalar()
+	seen := make(map[uint8]bool)
+	for _, sigShare := range sigShares {
+		if seen[sigShare.Index] || commitments.find(sigShare.Index) == nil {
+			return nil, fmt.Errorf("unexpected signature share %d", sigShare.Index)
+		}
+		seen[sigShare.Index] = true
+		zi, err := edwards25519.NewScalar().SetCanonicalBytes(sigShare.Z)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature share: %s", err)
+		}
+		z.Add(z, zi)
+	}
+	return append(r.Bytes(), z.Bytes()...), nil
+}
+
+// find returns the commitment of the signer with the index provided, or nil if it is not in the list.
+func (commitments CommitmentList) find(index uint8) *Commitment {
+	for _, commitment := range commitments {
+		if commitment.Index == index {
+			return commitment
+		}
+	}
+	return nil
+}
+
+// points returns the points of the nonce commitments. It returns an error if they are not valid points, or if they
+// are the identity or another point of small order, which would let a signer cancel the nonces of the others.
+func (commitment *Commitment) points() (d, e *edwards25519.Point, err error) {
+	if d, err = commitmentPoint(commitment.D); err != nil {
+		return nil, nil, fmt.Errorf("invalid commitment %d: %s", commitment.Index, err)
+	}
+	if e, err = commitmentPoint(commitment.E); err != nil {
+		return nil, nil, fmt.Errorf("invalid commitment %d: %s", commitment.Index, err)
+	}
+	return d, e, nil
+}
+
+// commitmentPoint decodes a nonce commitment, rejecting points of small order. The identity has order 1.
+func commitmentPoint(b []byte) (*edwards25519.Point, error) {
+	p, err := edwards25519.NewIdentityPoint().SetBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if edwards25519.NewIdentityPoint().MultByCofactor(p).Equal(edwards25519.NewIdentityPoint()) == 1 {
+		return nil, fmt.Errorf("point of small order")
+	}
+	return p, nil
+}
+
+// check returns an error if the list has less than K signers, or if it is not ordered by index without repetitions.
+func (commitments CommitmentList) check(meta *KeyMeta) error {
+	if len(commitments) < int(meta.K) || len(commitments) > int(meta.L) {
+		return fmt.Errorf("there are %d signers, but there should be between %d and %d", len(commitments), meta.K, meta.L)
+	}
+	for i, commitment := range commitments {
+		if commitment.Index == 0 || commitment.Index > meta.L {
+			return fmt.Errorf("invalid commitment index %d", commitment.Index)
+		}
+		if i > 0 && commitments[i-1].Index >= commitment.Index {
+			return fmt.Errorf("commitments should be ordered by index without repetitions")
+		}
+	}
+	return nil
+}
+
+// groupCommitment returns the R value of the signature and the binding factor of each signer, by index. As in RFC 9591,
+// section 4.4, the binding factors depend on the group public key, the message and every commitment, so commitments
+// cannot be reused with other keys or messages.
+func groupCommitment(msg []byte, commitments CommitmentList, meta *KeyMeta) (*edwards25519.Point, map[uint8]*edwards25519.Scalar, error) {
+	if err := commitments.check(meta); err != nil {
+		return nil, nil, err
+	}
+	encoded := make([]byte, 0, len(commitments)*65)
+	for _, commitment := range commitments {
+		encoded = append(encoded, commitment.Index)
+		encoded = append(encoded, commitment.D...)
+		encoded = append(encoded, commitment.E...)
+	}
+	msgHash := sha512.Sum512(msg)
+	r := edwards25519.NewIdentityPoint()
+	rhos := make(map[uint8]*edwards25519.Scalar)
+	for _, commitment := range commitments {
+		h := sha512.New()
+		h.Write([]byte("tceddsa rho"))
+		h.Write([]byte{commitment.Index})
+		h.Write(meta.PublicKey)
+		h.Write(msgHash[:])
+		h.Write(encoded)
+		rho, err := edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+		if err != nil {
+			return nil, nil, err
+		}
+		d, e, err := commitment.points()
+		if err != nil {
+			return nil, nil, err
+		}
+		r.Add(r, d)
+		r.Add(r, edwards25519.NewIdentityPoint().ScalarMult(rho, e))
+		rhos[commitment.Index] = rho
+	}
+	return r, rhos, nil
+}
+
+// challenge returns the Ed25519 challenge, SHA-512(R || A || M) reduced modulo the group order.
+func challenge(r *edwards25519.Point, publicKey, msg []byte) (*edwards25519.Scalar, error) {
+	h := sha512.New()
+	h.Write(r.Bytes())
+	h.Write(publicKey)
+	h.Write(msg)
+	return edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+}
+
+// lagrange returns the Lagrange coefficient of the signer with the index provided, evaluated at zero.
+func lagrange(index uint8, commitments CommitmentList) (*edwards25519.Scalar, error) {
+	num := big.NewInt(1)
+	den := big.NewInt(1)
+	for _, commitment := range commitments {
+		if commitment.Index == index {
+			continue
+		}
+		j := big.NewInt(int64(commitment.Index))
+		num.Mul(num, j)
+		den.Mul(den, j.Sub(j, big.NewInt(int64(index))))
+	}
+	den.Mod(den, order)
+	if den.ModInverse(den, order) == nil {
+		return nil, fmt.Errorf("cannot compute the lagrange coefficient of signer %d", index)
+	}
+	num.Mul(num, den)
+	num.Mod(num, order)
+	return bigToScalar(num)
+}
+
+// indexScalar returns the scalar representing the index of a key share.
+func indexScalar(index uint8) *edwards25519.Scalar {
+	s, _ := bigToScalar(big.NewInt(int64(index)))
+	return s
+}
+
+// bigToScalar transforms a number lower than the group order into a scalar.
+func bigToScalar(n *big.Int) (*edwards25519.Scalar, error) {
+	b := n.Bytes()
+	le := make([]byte, 32)
+	for i := range b {
+		le[i] = b[len(b)-1-i]
+	}
+	return edwards25519.NewScalar().SetCanonicalBytes(le)
+}
+
+// randomScalar returns a uniformly random scalar.
+func randomScalar() (*edwards25519.Scalar, error) {
+	b := make([]byte, 64)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	return edwards25519.NewScalar().SetUniformBytes(b)
+}
@@ -0,0 +1,274 @@
+package tceddsa
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+// sign runs the two rounds of the protocol with the signers provided, verifying each signature share, and returns the
+// joined signature.
+func sign(t *testing.T, shares []*KeyShare, meta *KeyMeta, signers []uint8, msg []byte) []byte {
+	sessions := make([]*SigSession, len(signers))
+	commitments := make(CommitmentList, len(signers))
+	for i, index := range signers {
+		var err error
+		if sessions[i], commitments[i], err = shares[index-1].NewSigSession(meta); err != nil {
+			t.Fatalf("signer %d cannot start a session: %s", index, err)
+		}
+	}
+	sigShares := make(SigShareList, len(signers))
+	for i, session := range sessions {
+		var err error
+		if sigShares[i], err = session.Sign(msg, commitments); err != nil {
+			t.Fatalf("signer %d cannot sign: %s", signers[i], err)
+		}
+		if err := sigShares[i].Verify(msg, commitments, meta); err != nil {
+			t.Fatalf("signature share of signer %d does not verify: %s", signers[i], err)
+		}
+	}
+	sig, err := sigShares.Join(msg, commitments, meta)
+	if err != nil {
+		t.Fatalf("cannot join signature shares: %s", err)
+	}
+	return sig
+}
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		l, k    uint8
+		signers []uint8
+	}{
+		{1, 1, []uint8{1}},
+		{3, 2, []uint8{1, 2}},
+		{3, 2, []uint8{2, 3}},
+		{3, 2, []uint8{1, 3}},
+		{3, 2, []uint8{1, 2, 3}},
+		{5, 3, []uint8{1, 3, 5}},
+		{5, 3, []uint8{1, 2, 3, 4, 5}},
+	}
+	msg := []byte("tceddsa test")
+	for _, test := range tests {
+		shares, meta, err := NewKey(test.l, test.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, share := range shares {
+			if err := share.Verify(meta); err != nil {
+				t.Errorf("%d-of-%d: key share %d does not verify: %s", test.k, test.l, share.Index, err)
+			}
+		}
+		sig := sign(t, shares, meta, test.signers, msg)
+		if !ed25519.Verify(meta.PublicKey, msg, sig) {
+			t.Errorf("%d-of-%d signature with signers %v does not verify with crypto/ed25519", test.k, test.l, test.signers)
+		}
+		if ed25519.Verify(meta.PublicKey, []byte("other message"), sig) {
+			t.Errorf("%d-of-%d signature verifies with another message", test.k, test.l)
+		}
+	}
+}
+
+// TestBindingFactorsDependOnKey checks that the same commitments and message get other binding factors with another
+// group public key.
+func TestBindingFactorsDependOnKey(t *testing.T) {
+	shares, meta, err := NewKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherMeta, err := NewKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitments := make(CommitmentList, 2)
+	for i := range commitments {
+		if _, commitments[i], err = shares[i].NewSigSession(meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := []byte("tceddsa test")
+	_, rhos, err := groupCommitment(msg, commitments, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherRhos, err := groupCommitment(msg, commitments, otherMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, commitment := range commitments {
+		if rhos[commitment.Index].Equal(otherRhos[commitment.Index]) == 1 {
+			t.Errorf("signer %d has the same binding factor with two keys", commitment.Index)
+		}
+	}
+}
+
+func TestNewKeyThreshold(t *testing.T) {
+	for _, k := range []uint8{0, 4} {
+		if _, _, err := NewKey(3, k); err == nil {
+			t.Errorf("key with threshold %d of 3 created", k)
+		}
+	}
+}
+
+func TestKeyShareVerify(t *testing.T) {
+	shares, meta, err := NewKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		share *KeyShare
+	}{
+		{"wrong index", &KeyShare{Index: 2, Secret: shares[0].Secret}},
+		{"index zero", &KeyShare{Index: 0, Secret: shares[0].Secret}},
+		{"index too big", &KeyShare{Index: 4, Secret: shares[0].Secret}},
+		{"non canonical secret", &KeyShare{Index: 1, Secret: make([]byte, 31)}},
+	}
+	for _, test := range tests {
+		if err := test.share.Verify(meta); err == nil {
+			t.Errorf("%s: invalid key share verified", test.name)
+		}
+	}
+}
+
+// smallOrderPoints are encodings of points of small order: the identity, and points of order 2 and 4.
+var smallOrderPoints = map[string][]byte{
+	"identity": append([]byte{1}, make([]byte, 31)...),
+	"order 2":  append(append([]byte{0xec}, repeat(0xff, 30)...), 0x7f),
+	"order 4":  make([]byte, 32),
+}
+
+func repeat(b byte, n int) []byte {
+	bytes := make([]byte, n)
+	for i := range bytes {
+		bytes[i] = b
+	}
+	return bytes
+}
+
+func TestRejectSmallOrderCommitments(t *testing.T) {
+	shares, meta, err := NewKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("tceddsa test")
+	for name, point := range smallOrderPoints {
+		for _, field := range []string{"D", "E"} {
+			session, own, err := shares[0].NewSigSession(meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, other, err := shares[1].NewSigSession(meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bad := &Commitment{Index: other.Index, D: other.D, E: other.E}
+			if field == "D" {
+				bad.D = point
+			} else {
+				bad.E = point
+			}
+			if _, err := session.Sign(msg, CommitmentList{own, bad}); err == nil {
+				t.Errorf("commitment %s with %s accepted", field, name)
+			}
+		}
+	}
+}
+
+func TestRejectBadSigShare(t *testing.T) {
+	shares, meta, err := NewKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("tceddsa test")
+	s1, c1, err := shares[0].NewSigSession(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, c2, err := shares[1].NewSigSession(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitments := CommitmentList{c1, c2}
+	z1, err := s1.Sign(msg, commitments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z2, err := s2.Sign(msg, commitments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := &SigShare{Index: z1.Index, Z: append([]byte(nil), z1.Z...)}
+	tampered.Z[0] ^= 1
+	tests := []struct {
+		name     string
+		sigShare *SigShare
+		msg      []byte
+	}{
+		{"tampered share", tampered, msg},
+		{"share of another signer", &SigShare{Index: z2.Index, Z: z1.Z}, msg},
+		{"other message", z1, []byte("other message")},
+		{"signer without commitment", &SigShare{Index: 3, Z: z1.Z}, msg},
+		{"non canonical share", &SigShare{Index: z1.Index, Z: repeat(0xff, 32)}, msg},
+	}
+	for _, test := range tests {
+		if err := test.sigShare.Verify(test.msg, commitments, meta); err == nil {
+			t.Errorf("%s: invalid signature share verified", test.name)
+		}
+	}
+	sig, err := SigShareList{tampered, z2}.Join(msg, commitments, meta)
+	if err == nil && ed25519.Verify(meta.PublicKey, msg, sig) {
+		t.Errorf("signature with a tampered share verifies")
+	}
+	if _, err := (SigShareList{z1, z1}).Join(msg, commitments, meta); err == nil {
+		t.Errorf("repeated signature share joined")
+	}
+}
+
+func TestSigSession(t *testing.T) {
+	shares, meta, err := NewKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("tceddsa test")
+	s1, c1, err := shares[0].NewSigSession(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, c2, err := shares[1].NewSigSession(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, c3, err := shares[2].NewSigSession(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		commitments CommitmentList
+	}{
+		{"less than K signers", CommitmentList{c1}},
+		{"without own commitment", CommitmentList{c2, c3}},
+		{"not ordered", CommitmentList{c2, c1}},
+		{"repeated signer", CommitmentList{c1, c1}},
+	}
+	for _, test := range tests {
+		// Sessions are erased on the first call to Sign, so each case uses a new one.
+		session, own, err := shares[0].NewSigSession(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, c := range test.commitments {
+			if c == c1 {
+				test.commitments[i] = own
+			}
+		}
+		if _, err := session.Sign(msg, test.commitments); err == nil {
+			t.Errorf("%s: session signed", test.name)
+		}
+	}
+	if _, err := s1.Sign(msg, CommitmentList{c1, c2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s1.Sign(msg, CommitmentList{c1, c2}); err == nil {
+		t.Errorf("session signed twice")
+	}
+}